@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// s3Actions are the iam actions of s3 operations named differently.
+var s3Actions = map[string]string{
+	"HeadObject":              "GetObject",
+	"HeadBucket":              "ListBucket",
+	"ListObjects":             "ListBucket",
+	"ListObjectsV2":           "ListBucket",
+	"CreateMultipartUpload":   "PutObject",
+	"UploadPart":              "PutObject",
+	"CompleteMultipartUpload": "PutObject",
+	"CopyObject":              "PutObject",
+	"DeleteObjects":           "DeleteObject",
+}
+
+func isAccessDeniedCode(code string) bool {
+	switch code {
+	case "AccessDenied", "AccessDeniedException", "Forbidden", "UnauthorizedOperation", "AuthorizationError":
+		return true
+	}
+	return false
+}
+
+// explainAccessDenied adds the api call, its resource, the caller identity
+// and the iam action the call needs to the access denied errors of the
+// requests of a session, keeping their error codes.
+func explainAccessDenied(sess *session.Session) {
+	callers := &callerIdentities{sess: sess, arns: map[*credentials.Credentials]*callerIdentity{}}
+	sess.Handlers.AfterRetry.PushBack(func(r *request.Request) {
+		aerr, ok := r.Error.(awserr.Error)
+		if !ok || r.WillRetry() || !isAccessDeniedCode(aerr.Code()) {
+			return
+		}
+		if r.ClientInfo.ServiceName == "sts" && r.Operation.Name == "GetCallerIdentity" {
+			return
+		}
+		explained := awserr.New(aerr.Code(), callers.explain(r, aerr.Message()), aerr.OrigErr())
+		if rf, ok := r.Error.(awserr.RequestFailure); ok {
+			explained = awserr.NewRequestFailure(explained, rf.StatusCode(), rf.RequestID())
+		}
+		r.Error = explained
+	})
+}
+
+// callerIdentities caches the arns of the credentials of requests, which
+// differ between the copies of a session for other accounts. Failed lookups
+// are cached as well, so that a role without sts access isn't asked again
+// for every denied request.
+type callerIdentities struct {
+	sess *session.Session
+	mu   sync.Mutex
+	arns map[*credentials.Credentials]*callerIdentity
+}
+
+type callerIdentity struct {
+	once sync.Once
+	arn  string
+}
+
+func (c *callerIdentities) arn(creds *credentials.Credentials) string {
+	c.mu.Lock()
+	id, ok := c.arns[creds]
+	if !ok {
+		id = &callerIdentity{}
+		c.arns[creds] = id
+	}
+	c.mu.Unlock()
+	// the lookup runs outside of the lock, requests with other credentials
+	// don't wait for it.
+	id.once.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		out, err := sts.New(c.sess, &aws.Config{Credentials: creds}).GetCallerIdentityWithContext(ctx, nil)
+		if err != nil {
+			debugf("could not get caller identity: %v", err)
+			return
+		}
+		id.arn = aws.StringValue(out.Arn)
+	})
+	return id.arn
+}
+
+func (c *callerIdentities) explain(r *request.Request, msg string) string {
+	action := accessDeniedAction(r)
+	caller := c.arn(r.Config.Credentials)
+	partition, account := "aws", ""
+	if parts := strings.SplitN(caller, ":", 6); len(parts) == 6 {
+		partition, account = parts[1], parts[4]
+	}
+	resource := accessDeniedResource(r, partition, account)
+
+	call := r.ClientInfo.ServiceName + "." + r.Operation.Name
+	if resource != "" {
+		call += " on " + resource
+	}
+	if caller != "" {
+		call += " as " + caller
+	}
+	return fmt.Sprintf("%s: %s was denied, it needs the iam permission %s", msg, call, action)
+}
+
+// accessDeniedAction returns the iam action of the operation of a request.
+func accessDeniedAction(r *request.Request) string {
+	prefix := r.ClientInfo.SigningName
+	if prefix == "" {
+		prefix = r.ClientInfo.ServiceName
+	}
+	op := r.Operation.Name
+	if prefix == "s3" && s3Actions[op] != "" {
+		op = s3Actions[op]
+	}
+	return prefix + ":" + op
+}
+
+// accessDeniedResource returns the arn of the resource of a request, if its
+// parameters name one.
+func accessDeniedResource(r *request.Request, partition, account string) string {
+	region := aws.StringValue(r.Config.Region)
+	prefix := "arn:" + partition + ":"
+	switch r.ClientInfo.ServiceName {
+	case "s3":
+		bucket, key := paramString(r.Params, "Bucket"), paramString(r.Params, "Key")
+		if bucket == "" {
+			return ""
+		}
+		if key == "" || s3Actions[r.Operation.Name] == "ListBucket" {
+			return prefix + "s3:::" + bucket
+		}
+		return prefix + "s3:::" + bucket + "/" + key
+	case "athena":
+		if wg := paramString(r.Params, "WorkGroup"); wg != "" {
+			return prefix + "athena:" + region + ":" + account + ":workgroup/" + wg
+		}
+	case "glue":
+		db := paramString(r.Params, "DatabaseName")
+		switch {
+		case db != "" && paramString(r.Params, "Name") != "":
+			return prefix + "glue:" + region + ":" + account + ":table/" + db + "/" + paramString(r.Params, "Name")
+		case db != "" && paramString(r.Params, "TableName") != "":
+			return prefix + "glue:" + region + ":" + account + ":table/" + db + "/" + paramString(r.Params, "TableName")
+		case db != "":
+			return prefix + "glue:" + region + ":" + account + ":database/" + db
+		}
+	case "kms":
+		if key := paramString(r.Params, "KeyId"); key != "" {
+			return key
+		}
+	}
+	return ""
+}
+
+// paramString returns the string field name of the input of a request.
+func paramString(params interface{}, name string) string {
+	v := reflect.ValueOf(params)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	f := v.FieldByName(name)
+	if f.Kind() == reflect.Ptr && !f.IsNil() {
+		f = f.Elem()
+	}
+	if f.Kind() != reflect.String {
+		return ""
+	}
+	return f.String()
+}
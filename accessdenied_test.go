@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestExplainAccessDenied(t *testing.T) {
+	f := newFakeAWS(t)
+	defer f.Close()
+	f.denyS3 = true
+	sess := f.session()
+	explainAccessDenied(sess)
+	svc := s3.New(sess)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		_, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String("results"), Key: aws.String("q/1.csv")})
+		expected := "Access Denied: s3.GetObject on arn:aws:s3:::results/q/1.csv as arn:aws:iam::123456789012:user/test was denied, it needs the iam permission s3:GetObject"
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("error = %v, expected %q", err, expected)
+		}
+		if !isAccessDenied(err) || classifyError(err) != errorPermission {
+			t.Errorf("%v lost its error code", err)
+		}
+		if rf, ok := err.(awserr.RequestFailure); !ok || rf.StatusCode() != 403 {
+			t.Errorf("%v lost its status code", err)
+		}
+	}
+	if n := f.count("GetCallerIdentity"); n != 1 {
+		t.Errorf("GetCallerIdentity called %d times, expected once", n)
+	}
+}
+
+func TestAccessDeniedActionAndResource(t *testing.T) {
+	for _, tc := range []struct {
+		service, signingName, op string
+		params                   interface{}
+		action, resource         string
+	}{
+		{"s3", "s3", "HeadObject", &s3.HeadObjectInput{Bucket: aws.String("b"), Key: aws.String("k")}, "s3:GetObject", "arn:aws-us-gov:s3:::b/k"},
+		{"s3", "s3", "ListObjectsV2", &s3.ListObjectsV2Input{Bucket: aws.String("b"), Prefix: aws.String("p/")}, "s3:ListBucket", "arn:aws-us-gov:s3:::b"},
+		{"athena", "athena", "StartQueryExecution", &struct{ WorkGroup *string }{aws.String("etl")}, "athena:StartQueryExecution", "arn:aws-us-gov:athena:us-gov-west-1:123456789012:workgroup/etl"},
+		{"athena", "athena", "GetQueryExecution", &struct{ QueryExecutionId *string }{aws.String("q")}, "athena:GetQueryExecution", ""},
+		{"glue", "glue", "GetTable", &struct{ DatabaseName, Name *string }{aws.String("db"), aws.String("t")}, "glue:GetTable", "arn:aws-us-gov:glue:us-gov-west-1:123456789012:table/db/t"},
+		{"glue", "glue", "GetTables", &struct{ DatabaseName *string }{aws.String("db")}, "glue:GetTables", "arn:aws-us-gov:glue:us-gov-west-1:123456789012:database/db"},
+		{"email", "ses", "SendRawEmail", nil, "ses:SendRawEmail", ""},
+	} {
+		r := &request.Request{Params: tc.params, Config: aws.Config{Region: aws.String("us-gov-west-1")}, Operation: &request.Operation{Name: tc.op}}
+		r.ClientInfo.ServiceName, r.ClientInfo.SigningName = tc.service, tc.signingName
+		if action := accessDeniedAction(r); action != tc.action {
+			t.Errorf("%s.%s: action %q, expected %q", tc.service, tc.op, action, tc.action)
+		}
+		if resource := accessDeniedResource(r, "aws-us-gov", "123456789012"); resource != tc.resource {
+			t.Errorf("%s.%s: resource %q, expected %q", tc.service, tc.op, resource, tc.resource)
+		}
+	}
+}
+
+func TestCallerIdentityFailureCached(t *testing.T) {
+	var mu sync.Mutex
+	lookups := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusForbidden)
+		if strings.Contains(string(body), "Action=GetCallerIdentity") {
+			mu.Lock()
+			lookups++
+			mu.Unlock()
+			fmt.Fprint(w, `<ErrorResponse><Error><Code>AccessDenied</Code><Message>not allowed</Message></Error></ErrorResponse>`)
+			return
+		}
+		fmt.Fprint(w, `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+	}))
+	defer srv.Close()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:           aws.String("eu-central-1"),
+		Endpoint:         aws.String(srv.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:       aws.Int(0),
+	}))
+	explainAccessDenied(sess)
+	svc := s3.New(sess)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String("results"), Key: aws.String("q/1.csv")})
+			if err == nil || !strings.Contains(err.Error(), "s3.GetObject on arn:aws:s3:::results/q/1.csv was denied") {
+				t.Errorf("error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if lookups != 1 {
+		t.Errorf("GetCallerIdentity called %d times, expected once", lookups)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/pkg/errors"
+)
+
+// accountsCmd runs the same queries in several accounts, one assumed role
+// per account, and merges the results with an account_id column.
+func accountsCmd(args []string) error {
+	fs := flag.NewFlagSet("accounts", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: athenaq accounts [flags] <role arn>...")
+		fs.PrintDefaults()
+	}
+	var (
+		awsFlags    = addAWSFlags(fs)
+		inputFile   = fs.String("f", "", `input file (""== STDIN | file://... | s3://... | https://... | git+https://repo.git//path?ref=...)`)
+		output      = fs.String("out", "", `output path ("" == STDOUT | file://... | s3://...)`)
+		rolesFile   = fs.String("roles", "", "file with one role arn per line, in addition to the arguments")
+		concurrency = fs.Int("concurrency", 4, "accounts queried at the same time")
+		templates   = addTemplateFlags(fs)
+	)
+	fs.Parse(args)
+	roles := fs.Args()
+	if *rolesFile != "" {
+		more, err := readRoles(*rolesFile)
+		if err != nil {
+			return errors.Wrap(err, "could not read roles")
+		}
+		roles = append(roles, more...)
+	}
+	if len(roles) == 0 || *concurrency < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	accounts := make([]*awsCli, len(roles))
+	awsCli, err := newAWS(awsFlags)
+	if err != nil {
+		return errors.Wrap(err, "could not initialize aws client")
+	}
+	queries, err := awsCli.readInput(*inputFile, templates)
+	if err != nil {
+		return errors.Wrap(err, "could not read queries")
+	}
+	setup, queries, teardown, transactions, err := sections(queries)
+	if err != nil {
+		return err
+	}
+	if len(setup) > 0 || len(teardown) > 0 || hasTransactions(transactions) {
+		return errors.New("-- setup, -- teardown and -- transaction sections are not supported across accounts")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *awsFlags.timeout)
+	defer cancel()
+
+	for i, role := range roles {
+		accounts[i], err = awsCli.assumeRole(role, awsFlags)
+		if err != nil {
+			return errors.Wrapf(err, "could not assume %s", role)
+		}
+	}
+
+	var buf bytes.Buffer
+	for i, query := range queries {
+		results, err := execInAccounts(ctx, accounts, query, *concurrency)
+		if err != nil {
+			return errors.Wrapf(err, "query %d", i+1)
+		}
+		header := true
+		for j, result := range results {
+			if len(result) == 0 {
+				continue
+			}
+			account, _ := accounts[j].AccountID()
+			buf.Write(appendColumn(result, "account_id", account, header))
+			header = false
+		}
+	}
+
+	if *output == "" {
+		_, err = io.Copy(os.Stdout, &buf)
+		return err
+	}
+	return awsCli.writeOut(bytes.NewReader(buf.Bytes()), *output)
+}
+
+func readRoles(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var roles []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			roles = append(roles, line)
+		}
+	}
+	return roles, scanner.Err()
+}
+
+// assumeRole returns a client for the account of role. Query results go to
+// the temp path of that account.
+func (awsCli *awsCli) assumeRole(role string, flags *awsFlags) (*awsCli, error) {
+	sess := awsCli.session.Copy(&aws.Config{Credentials: stscreds.NewCredentials(awsCli.session, role)})
+	account, err := newAWSFromSession(sess, flags)
+	if err != nil {
+		return nil, err
+	}
+	id, err := account.AccountID()
+	if err != nil {
+		return nil, err
+	}
+	debugf("assumed %s in account %s", role, id)
+	account.runID = awsCli.runID
+	return account, nil
+}
+
+// execInAccounts runs query in every account and returns the results in the
+// order of accounts.
+func execInAccounts(ctx context.Context, accounts []*awsCli, query string, concurrency int) ([][]byte, error) {
+	var (
+		results = make([][]byte, len(accounts))
+		errs    = make([]error, len(accounts))
+		sem     = make(chan struct{}, concurrency)
+		wg      sync.WaitGroup
+	)
+	for i, account := range accounts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, account *awsCli) {
+			defer func() { <-sem; wg.Done() }()
+			id, _ := account.AccountID()
+			var buf bytes.Buffer
+			queryExecution, err := account.execQuery(ctx, query, &buf)
+			if err != nil {
+				errs[i] = errors.Wrapf(err, "account %s", id)
+				return
+			}
+			infof("account %s: query %s succeeded", id, aws.StringValue(queryExecution.QueryExecutionId))
+			results[i] = buf.Bytes()
+		}(i, account)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// appendColumn appends a column to every record of an athena csv result,
+// leaving the existing fields untouched so that NULLs (empty) and empty
+// strings ("") stay distinguishable. The header is dropped unless withHeader
+// is set.
+func appendColumn(csv []byte, name, value string, withHeader bool) []byte {
+	var (
+		out      bytes.Buffer
+		inQuotes bool
+		header   = true
+		start    int
+	)
+	quote := func(s string) string { return `"` + strings.Replace(s, `"`, `""`, -1) + `"` }
+	emit := func(record []byte) {
+		switch {
+		case !header:
+			out.Write(record)
+			out.WriteString("," + quote(value) + "\n")
+		case withHeader:
+			out.Write(record)
+			out.WriteString("," + quote(name) + "\n")
+		}
+		header = false
+	}
+	for i, c := range csv {
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == '\n' && !inQuotes:
+			emit(csv[start:i])
+			start = i + 1
+		}
+	}
+	if start < len(csv) {
+		emit(csv[start:])
+	}
+	return out.Bytes()
+}
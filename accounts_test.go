@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+func TestAppendColumn(t *testing.T) {
+	result := "\"id\",\"note\"\n\"1\",\n\"2\",\"two\nlines\"\n\"3\",\"\"\"quoted\"\"\"\n"
+	want := "\"1\",,\"123\"\n\"2\",\"two\nlines\",\"123\"\n\"3\",\"\"\"quoted\"\"\",\"123\"\n"
+	if got := string(appendColumn([]byte(result), "account_id", "123", true)); got != "\"id\",\"note\",\"account_id\"\n"+want {
+		t.Errorf("appendColumn with header =\n%s", got)
+	}
+	if got := string(appendColumn([]byte(result), "account_id", "123", false)); got != want {
+		t.Errorf("appendColumn without header =\n%s", got)
+	}
+	if got := string(appendColumn([]byte("\"id\"\n\"1\""), "account_id", "123", false)); got != "\"1\",\"123\"\n" {
+		t.Errorf("appendColumn without trailing newline = %q", got)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// newRunID returns an id unique to this invocation, sortable by start time.
+func newRunID(now time.Time) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return now.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
+
+type auditRecord struct {
+	RunID                 string            `json:"run_id"`
+	Tags                  map[string]string `json:"tags,omitempty"`
+	QueryIndex            int               `json:"query_index"`
+	QueryName             string            `json:"query_name,omitempty"`
+	QueryExecutionID      string            `json:"query_execution_id,omitempty"`
+	CallerARN             string            `json:"caller_arn"`
+	Account               string            `json:"account"`
+	Query                 string            `json:"query"`
+	State                 string            `json:"state"`
+	Error                 string            `json:"error,omitempty"`
+	Submitted             *time.Time        `json:"submitted,omitempty"`
+	Completed             *time.Time        `json:"completed,omitempty"`
+	DataScannedBytes      int64             `json:"data_scanned_bytes"`
+	EngineExecutionMillis int64             `json:"engine_execution_ms"`
+	Recorded              time.Time         `json:"recorded"`
+}
+
+// auditLog records every executed query either as one JSON document per
+// query below an s3/file prefix or as one item per query in a dynamodb table
+// (partition key run_id, sort key query_index).
+type auditLog struct {
+	awsCli   *awsCli
+	runID    string
+	prefix   string
+	table    string
+	dynamodb *dynamodb.DynamoDB
+}
+
+func (awsCli *awsCli) newAuditLog(target, runID string) (*auditLog, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	a := &auditLog{awsCli: awsCli, runID: runID}
+	switch u.Scheme {
+	case "dynamodb":
+		a.table = u.Host
+		a.dynamodb = dynamodb.New(awsCli.session)
+	case "", "file", "s3":
+		a.prefix = strings.TrimRight(target, "/")
+	default:
+		return nil, fmt.Errorf("UNKNOWN: schema %q", target)
+	}
+	if _, err := awsCli.callerIdentity(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *auditLog) record(ctx context.Context, index int, query string, queryExecution *athena.QueryExecution, queryErr error) error {
+	if a == nil {
+		return nil
+	}
+	identity, err := a.awsCli.callerIdentity()
+	if err != nil {
+		return err
+	}
+	r := &auditRecord{
+		RunID:      a.runID,
+		Tags:       a.awsCli.tags,
+		QueryIndex: index + 1,
+		QueryName:  queryName(query),
+		CallerARN:  aws.StringValue(identity.Arn),
+		Account:    aws.StringValue(identity.Account),
+		Query:      secrets.redact(query),
+		State:      "FAILED",
+		Recorded:   time.Now().UTC(),
+	}
+	if queryExecution != nil {
+		r.QueryExecutionID = aws.StringValue(queryExecution.QueryExecutionId)
+		if s := queryExecution.Status; s != nil {
+			r.State = aws.StringValue(s.State)
+			r.Submitted, r.Completed = s.SubmissionDateTime, s.CompletionDateTime
+		}
+		if s := queryExecution.Statistics; s != nil {
+			r.DataScannedBytes = aws.Int64Value(s.DataScannedInBytes)
+			r.EngineExecutionMillis = aws.Int64Value(s.EngineExecutionTimeInMillis)
+		}
+	}
+	if queryErr != nil {
+		r.Error = secrets.redact(queryErr.Error())
+	}
+
+	if a.dynamodb == nil {
+		return a.awsCli.writeJSON(r, fmt.Sprintf("%s/%s/%04d.json", a.prefix, a.runID, r.QueryIndex))
+	}
+	item := map[string]*dynamodb.AttributeValue{
+		"run_id":              {S: aws.String(r.RunID)},
+		"query_index":         {N: aws.String(strconv.Itoa(r.QueryIndex))},
+		"caller_arn":          {S: aws.String(r.CallerARN)},
+		"account":             {S: aws.String(r.Account)},
+		"query":               {S: aws.String(r.Query)},
+		"state":               {S: aws.String(r.State)},
+		"data_scanned_bytes":  {N: aws.String(strconv.FormatInt(r.DataScannedBytes, 10))},
+		"engine_execution_ms": {N: aws.String(strconv.FormatInt(r.EngineExecutionMillis, 10))},
+		"recorded":            {S: aws.String(r.Recorded.Format(time.RFC3339Nano))},
+	}
+	if r.QueryExecutionID != "" {
+		item["query_execution_id"] = &dynamodb.AttributeValue{S: aws.String(r.QueryExecutionID)}
+	}
+	if len(r.Tags) > 0 {
+		tags := map[string]*dynamodb.AttributeValue{}
+		for k, v := range r.Tags {
+			tags[k] = &dynamodb.AttributeValue{S: aws.String(v)}
+		}
+		item["tags"] = &dynamodb.AttributeValue{M: tags}
+	}
+	if r.Error != "" {
+		item["error"] = &dynamodb.AttributeValue{S: aws.String(r.Error)}
+	}
+	_, err = a.dynamodb.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(a.table),
+		Item:      item,
+	})
+	return err
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+func TestNewRunID(t *testing.T) {
+	id := newRunID(time.Date(2018, 3, 1, 12, 30, 0, 0, time.UTC))
+	if !regexp.MustCompile(`^20180301T123000Z-[0-9a-f]{8}$`).MatchString(id) {
+		t.Errorf("got run id %q", id)
+	}
+}
+
+func testAuditCli(endpoint string) *awsCli {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Endpoint:    aws.String(endpoint),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	awsCli := newBareAWS(sess)
+	// sts answers as alice without a request.
+	svc := awsCli.client.STS()
+	svc.Handlers.Send.Clear()
+	svc.Handlers.ValidateResponse.Clear()
+	svc.Handlers.UnmarshalMeta.Clear()
+	svc.Handlers.Unmarshal.Clear()
+	svc.Handlers.Send.PushBack(func(r *request.Request) {
+		*r.Data.(*sts.GetCallerIdentityOutput) = sts.GetCallerIdentityOutput{Arn: aws.String("arn:aws:iam::123456789012:user/alice"), Account: aws.String("123456789012")}
+	})
+	return awsCli
+}
+
+func TestAuditLogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a, err := testAuditCli("http://127.0.0.1:0").newAuditLog(dir+"/", "run-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.record(context.Background(), 0, "select 1", &athena.QueryExecution{
+		QueryExecutionId: aws.String("q-1"),
+		Status:           &athena.QueryExecutionStatus{State: aws.String("SUCCEEDED")},
+		Statistics:       &athena.QueryExecutionStatistics{DataScannedInBytes: aws.Int64(5)},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.record(context.Background(), 1, "select x", nil, errors.New("could not start query execution")); err != nil {
+		t.Fatal(err)
+	}
+
+	var records []auditRecord
+	for _, name := range []string{"0001.json", "0002.json"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "run-1", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r auditRecord
+		if err := json.Unmarshal(data, &r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	if r := records[0]; r.RunID != "run-1" || r.QueryIndex != 1 || r.QueryExecutionID != "q-1" || r.State != "SUCCEEDED" ||
+		r.DataScannedBytes != 5 || r.CallerARN != "arn:aws:iam::123456789012:user/alice" || r.Account != "123456789012" || r.Error != "" {
+		t.Errorf("got %+v", r)
+	}
+	if r := records[1]; r.QueryIndex != 2 || r.State != "FAILED" || r.QueryExecutionID != "" || r.Error != "could not start query execution" {
+		t.Errorf("got %+v", r)
+	}
+
+	var nilLog *auditLog
+	if err := nilLog.record(context.Background(), 0, "select 1", nil, nil); err != nil {
+		t.Errorf("nil audit log: %v", err)
+	}
+}
+
+func TestAuditLogDynamoDB(t *testing.T) {
+	var item map[string]map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target := r.Header.Get("X-Amz-Target"); target != "DynamoDB_20120810.PutItem" {
+			t.Errorf("unexpected call %s", target)
+		}
+		var in struct {
+			TableName string
+			Item      map[string]map[string]interface{}
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			t.Error(err)
+		}
+		if in.TableName != "athena-audit" {
+			t.Errorf("got table %q", in.TableName)
+		}
+		item = in.Item
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	awsCli := testAuditCli(srv.URL)
+	awsCli.tags = map[string]string{"team": "data"}
+	a, err := awsCli.newAuditLog("dynamodb://athena-audit", "run-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.record(context.Background(), 2, "select 1", nil, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]map[string]interface{}{
+		"run_id":      {"S": "run-1"},
+		"query_index": {"N": "3"},
+		"state":       {"S": "FAILED"},
+		"error":       {"S": "boom"},
+		"account":     {"S": "123456789012"},
+		"tags":        {"M": map[string]interface{}{"team": map[string]interface{}{"S": "data"}}},
+	} {
+		if got := item[key]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %v, want %v", key, got, want)
+		}
+	}
+	if _, ok := item["query_execution_id"]; ok {
+		t.Error("query_execution_id must be omitted when the query was not started")
+	}
+}
+
+func TestAuditLogUnknownScheme(t *testing.T) {
+	if _, err := testAuditCli("http://127.0.0.1:0").newAuditLog("ftp://host/audit", "run-1"); err == nil {
+		t.Error("expected error for unknown scheme")
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// azblobEndpoint is the blob service of a storage account, replaced in
+// tests.
+var azblobEndpoint = func(account string) string {
+	return "https://" + account + ".blob.core.windows.net"
+}
+
+const azblobVersion = "2020-10-02"
+
+func init() {
+	registerOutputWriter(outputWriterFunc(writeAzblob), "azblob")
+}
+
+// writeAzblob uploads an output to azblob://container/blob in the storage
+// account AZURE_STORAGE_ACCOUNT, authorized by AZURE_STORAGE_SAS_TOKEN or
+// signed with AZURE_STORAGE_KEY, like the az cli.
+func writeAzblob(awsCli *awsCli, r io.ReadSeeker, u *url.URL) error {
+	container, blob := u.Host, strings.TrimLeft(u.Path, "/")
+	if container == "" || blob == "" {
+		return fmt.Errorf("azure container or blob empty in %q", u)
+	}
+	account, key, sas := os.Getenv("AZURE_STORAGE_ACCOUNT"), os.Getenv("AZURE_STORAGE_KEY"), os.Getenv("AZURE_STORAGE_SAS_TOKEN")
+	if account == "" || key == "" && sas == "" {
+		return errors.New("azure outputs need AZURE_STORAGE_ACCOUNT and AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN")
+	}
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	secrets.add(sas)
+	path := "/" + container + "/" + (&url.URL{Path: blob}).EscapedPath()
+	endpoint := azblobEndpoint(account) + path
+	if sas != "" {
+		endpoint += "?" + strings.TrimPrefix(sas, "?")
+	}
+	req, err := http.NewRequest("PUT", endpoint, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType(blob))
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azblobVersion)
+	if sas == "" {
+		sig, err := azblobSignature(key, account, path, req.Header, size)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "SharedKey "+account+":"+sig)
+	}
+	if err := sendCloud(req); err != nil {
+		return errors.Wrap(err, "could not upload result to azure blob storage")
+	}
+	return nil
+}
+
+// azblobSignature signs a Put Blob request with the shared key of the
+// storage account.
+func azblobSignature(key, account, path string, header http.Header, size int64) (string, error) {
+	secret, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid AZURE_STORAGE_KEY")
+	}
+	length := ""
+	if size > 0 {
+		length = fmt.Sprint(size)
+	}
+	var msHeaders []string
+	for k := range header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-ms-") {
+			msHeaders = append(msHeaders, k+":"+header.Get(k))
+		}
+	}
+	sort.Strings(msHeaders)
+	// verb, content encoding, language, length, md5, type, date, the
+	// conditional headers and range.
+	toSign := strings.Join([]string{"PUT", "", "", length, "", header.Get("Content-Type"), "", "", "", "", "", ""}, "\n") + "\n" +
+		strings.Join(msHeaders, "\n") + "\n" +
+		"/" + account + path
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(toSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWriteAzblob(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte("account key"))
+	var got *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+	defer func(endpoint func(string) string) { azblobEndpoint = endpoint }(azblobEndpoint)
+	azblobEndpoint = func(account string) string { return srv.URL }
+	for k, v := range map[string]string{"AZURE_STORAGE_ACCOUNT": "reports", "AZURE_STORAGE_KEY": key, "AZURE_STORAGE_SAS_TOKEN": ""} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+
+	if err := (*awsCli)(nil).writeOut(strings.NewReader("a,b\n"), "azblob://exports/daily/2024 01 01.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if got.Method != "PUT" || got.URL.EscapedPath() != "/exports/daily/2024%2001%2001.csv" || string(body) != "a,b\n" {
+		t.Errorf("got %s %s %q", got.Method, got.URL.EscapedPath(), body)
+	}
+	if got.Header.Get("x-ms-blob-type") != "BlockBlob" {
+		t.Errorf("got blob type %q", got.Header.Get("x-ms-blob-type"))
+	}
+	toSign := "PUT\n\n\n4\n\ntext/csv\n\n\n\n\n\n\n" +
+		"x-ms-blob-type:BlockBlob\nx-ms-date:" + got.Header.Get("x-ms-date") + "\nx-ms-version:" + azblobVersion + "\n" +
+		"/reports/exports/daily/2024%2001%2001.csv"
+	mac := hmac.New(sha256.New, []byte("account key"))
+	mac.Write([]byte(toSign))
+	if want := "SharedKey reports:" + base64.StdEncoding.EncodeToString(mac.Sum(nil)); got.Header.Get("Authorization") != want {
+		t.Errorf("got authorization %q, want %q", got.Header.Get("Authorization"), want)
+	}
+
+	os.Setenv("AZURE_STORAGE_SAS_TOKEN", "?sv=2020-10-02&sig=secret")
+	if err := (*awsCli)(nil).writeOut(strings.NewReader("a,b\n"), "azblob://exports/daily.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if got.URL.RawQuery != "sv=2020-10-02&sig=secret" || got.Header.Get("Authorization") != "" {
+		t.Errorf("got query %q and authorization %q, want the sas token only", got.URL.RawQuery, got.Header.Get("Authorization"))
+	}
+}
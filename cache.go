@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// resultCache stores query results in a local directory, keyed by the
+// fingerprint of the query, the settings it ran with and the region,
+// workgroup and catalog it ran in.
+type resultCache struct {
+	dir   string
+	ttl   time.Duration
+	scope string
+}
+
+func (c *resultCache) path(query, settings string) string {
+	scope := c.scope
+	if settings != "" {
+		scope += "/" + settings
+	}
+	sum := sha256.Sum256([]byte(scope + "\n" + fingerprint(query)))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".csv")
+}
+
+// get returns the cached result of query if it is younger than the ttl.
+func (c *resultCache) get(query, settings string, now time.Time) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	path := c.path(query, settings)
+	info, err := os.Stat(path)
+	if err != nil || now.Sub(info.ModTime()) > c.ttl {
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		debugf("could not read cached result %s: %v", path, err)
+		return nil, false
+	}
+	return data, true
+}
+
+func (c *resultCache) put(query, settings string, data []byte) error {
+	if c == nil || len(data) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(c.dir, ".result")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.path(query, settings))
+}
+
+// cacheable reports whether the result of query may be cached: only
+// statements that read.
+func cacheable(query string) bool {
+	return statementClass(tokenize(query)) == "select"
+}
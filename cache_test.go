@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestResultCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &resultCache{dir: dir, ttl: time.Hour, scope: "eu-west-1/primary/"}
+	now := time.Now()
+	if _, ok := c.get("select 1", "", now); ok {
+		t.Fatal("hit in an empty cache")
+	}
+	if err := c.put("select 1", "", []byte("\"_col0\"\n\"1\"\n")); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := c.get("SELECT  1 -- again", "", now); !ok || string(data) != "\"_col0\"\n\"1\"\n" {
+		t.Errorf("got %q, %v", data, ok)
+	}
+	if _, ok := c.get("select 1", "head=1", now); ok {
+		t.Error("hit with other settings")
+	}
+	if _, ok := c.get("select 1", "", now.Add(2*time.Hour)); ok {
+		t.Error("hit after the ttl")
+	}
+	other := &resultCache{dir: dir, ttl: time.Hour, scope: "us-east-1/primary/"}
+	if _, ok := other.get("select 1", "", now); ok {
+		t.Error("hit in another region")
+	}
+	if cacheable("insert into t select 1") || !cacheable("with a as (select 1) select * from a") {
+		t.Error("wrong cacheable statements")
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// calendar knows the business days and fiscal periods for the calendar
+// functions of go templates. Without a -calendar file every day but
+// Saturday and Sunday is a business day.
+type calendar struct {
+	holidays map[string]bool
+	weekend  map[time.Weekday]bool
+	// fiscalStart is the first month of the fiscal year.
+	fiscalStart time.Month
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// newCalendar returns the calendar without holidays. A fiscalStart of 0,
+// e.g. of a templater without flags, starts the fiscal year in January.
+func newCalendar(fiscalStart int) (*calendar, error) {
+	if fiscalStart == 0 {
+		fiscalStart = 1
+	}
+	if fiscalStart < 1 || fiscalStart > 12 {
+		return nil, fmt.Errorf("invalid -fiscal-year-start %d, expected a month from 1 to 12", fiscalStart)
+	}
+	return &calendar{
+		holidays:    map[string]bool{},
+		weekend:     map[time.Weekday]bool{time.Saturday: true, time.Sunday: true},
+		fiscalStart: time.Month(fiscalStart),
+	}, nil
+}
+
+// loadCalendar reads a calendar file: a holiday as 2006-01-02 per line,
+// optionally followed by its name, and an optional "weekend: fri,sat" line.
+// Lines starting with # are comments.
+func loadCalendar(path string, fiscalStart int) (*calendar, error) {
+	c, err := newCalendar(fiscalStart)
+	if err != nil || path == "" {
+		return c, err
+	}
+	data, err := (*awsCli)(nil).readFrom(context.Background(), path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read calendar")
+	}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "weekend:"):
+			c.weekend = map[time.Weekday]bool{}
+			for _, day := range strings.Split(strings.TrimPrefix(line, "weekend:"), ",") {
+				wd, ok := weekdays[strings.ToLower(strings.TrimSpace(day))]
+				if !ok {
+					return nil, fmt.Errorf("calendar line %d: invalid weekday %q, expected mon, tue, ...", n, strings.TrimSpace(day))
+				}
+				c.weekend[wd] = true
+			}
+		default:
+			day := strings.Fields(line)[0]
+			if _, err := time.Parse("2006-01-02", day); err != nil {
+				return nil, fmt.Errorf("calendar line %d: invalid holiday %q, expected 2006-01-02", n, day)
+			}
+			c.holidays[day] = true
+		}
+	}
+	return c, s.Err()
+}
+
+func (c *calendar) isBusinessDay(day time.Time) bool {
+	return !c.weekend[day.Weekday()] && !c.holidays[day.Format("2006-01-02")]
+}
+
+// shift returns the nth business day after day, or before it for negative n.
+func (c *calendar) shift(day time.Time, n int) time.Time {
+	step := 1
+	if n < 0 {
+		step, n = -1, -n
+	}
+	for n > 0 {
+		day = day.AddDate(0, 0, step)
+		if c.isBusinessDay(day) {
+			n--
+		}
+	}
+	return day
+}
+
+// fiscalYearStart returns the first day of the fiscal year day is in.
+func (c *calendar) fiscalYearStart(day time.Time) time.Time {
+	year := day.Year()
+	if day.Month() < c.fiscalStart {
+		year--
+	}
+	return time.Date(year, c.fiscalStart, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// fiscalMonth returns the month of the fiscal year day is in, from 0.
+func (c *calendar) fiscalMonth(day time.Time) int {
+	return (int(day.Month()) - int(c.fiscalStart) + 12) % 12
+}
+
+func (c *calendar) fiscalQuarterStart(day time.Time) time.Time {
+	return c.fiscalYearStart(day).AddDate(0, c.fiscalMonth(day)/3*3, 0)
+}
+
+// funcs are the calendar functions of go templates. They take an optional
+// day, a string in 2006-01-02 or a time (default today, UTC), and return
+// days as 2006-01-02.
+func (c *calendar) funcs() map[string]interface{} {
+	format := func(t time.Time) string { return t.Format("2006-01-02") }
+	day := func(name string, args []interface{}) (time.Time, error) {
+		switch len(args) {
+		case 0:
+			return time.Now().UTC().Truncate(24 * time.Hour), nil
+		case 1:
+			t, err := parseDay(args[0], "2006-01-02")
+			return t, errors.Wrap(err, name)
+		}
+		return time.Time{}, fmt.Errorf("%s: %d days, expected at most 1", name, len(args))
+	}
+	dayFunc := func(name string, f func(time.Time) time.Time) func(...interface{}) (string, error) {
+		return func(args ...interface{}) (string, error) {
+			t, err := day(name, args)
+			if err != nil {
+				return "", err
+			}
+			return format(f(t)), nil
+		}
+	}
+	monthStart := func(t time.Time) time.Time { return t.AddDate(0, 0, 1-t.Day()) }
+	return map[string]interface{}{
+		"isBusinessDay": func(args ...interface{}) (bool, error) {
+			t, err := day("isBusinessDay", args)
+			return err == nil && c.isBusinessDay(t), err
+		},
+		"addBusinessDays": func(n int, args ...interface{}) (string, error) {
+			t, err := day("addBusinessDays", args)
+			if err != nil {
+				return "", err
+			}
+			return format(c.shift(t, n)), nil
+		},
+		"prevBusinessDay":    dayFunc("prevBusinessDay", func(t time.Time) time.Time { return c.shift(t, -1) }),
+		"nextBusinessDay":    dayFunc("nextBusinessDay", func(t time.Time) time.Time { return c.shift(t, 1) }),
+		"monthStart":         dayFunc("monthStart", monthStart),
+		"monthEnd":           dayFunc("monthEnd", func(t time.Time) time.Time { return monthStart(t).AddDate(0, 1, -1) }),
+		"prevMonthEnd":       dayFunc("prevMonthEnd", func(t time.Time) time.Time { return monthStart(t).AddDate(0, 0, -1) }),
+		"fiscalYearStart":    dayFunc("fiscalYearStart", c.fiscalYearStart),
+		"fiscalYearEnd":      dayFunc("fiscalYearEnd", func(t time.Time) time.Time { return c.fiscalYearStart(t).AddDate(1, 0, -1) }),
+		"fiscalQuarterStart": dayFunc("fiscalQuarterStart", c.fiscalQuarterStart),
+		"fiscalQuarterEnd":   dayFunc("fiscalQuarterEnd", func(t time.Time) time.Time { return c.fiscalQuarterStart(t).AddDate(0, 3, -1) }),
+		"fiscalYear": func(args ...interface{}) (int, error) {
+			t, err := day("fiscalYear", args)
+			if err != nil {
+				return 0, err
+			}
+			// fiscal years are named after the year they end in.
+			return c.fiscalYearStart(t).AddDate(1, 0, -1).Year(), nil
+		},
+		"fiscalQuarter": func(args ...interface{}) (int, error) {
+			t, err := day("fiscalQuarter", args)
+			if err != nil {
+				return 0, err
+			}
+			return c.fiscalMonth(t)/3 + 1, nil
+		},
+	}
+}
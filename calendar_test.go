@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCalendarFuncs(t *testing.T) {
+	f, err := ioutil.TempFile("", "athenaq-calendar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# holidays\n2024-03-29 Good Friday\n2024-04-01 Easter Monday\n")
+	f.Close()
+	c, err := loadCalendar(f.Name(), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		tmpl string
+		want string
+	}{
+		{`{{ prevBusinessDay "2024-04-02" }}`, "2024-03-28"},
+		{`{{ nextBusinessDay "2024-03-28" }}`, "2024-04-02"},
+		{`{{ addBusinessDays -3 "2024-04-03" }}`, "2024-03-27"},
+		{`{{ isBusinessDay "2024-03-30" }} {{ isBusinessDay "2024-04-02" }}`, "false true"},
+		{`{{ monthStart "2024-02-10" }} {{ monthEnd "2024-02-10" }} {{ prevMonthEnd "2024-03-31" }}`, "2024-02-01 2024-02-29 2024-02-29"},
+		{`{{ fiscalYearStart "2024-02-10" }} {{ fiscalYearEnd "2024-02-10" }} {{ fiscalYear "2024-02-10" }}`, "2023-04-01 2024-03-31 2024"},
+		{`{{ fiscalQuarterStart "2024-02-10" }} {{ fiscalQuarterEnd "2024-02-10" }} {{ fiscalQuarter "2024-02-10" }}`, "2024-01-01 2024-03-31 4"},
+		{`{{ fiscalQuarter "2024-04-01" }}`, "1"},
+	} {
+		got, err := execTemplate(tt.tmpl, c.funcs(), nil)
+		if err != nil || got != tt.want {
+			t.Errorf("%s = %q, %v, want %q", tt.tmpl, got, err, tt.want)
+		}
+	}
+}
+
+func TestLoadCalendarWeekend(t *testing.T) {
+	f, err := ioutil.TempFile("", "athenaq-calendar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("weekend: fri,sat\n")
+	f.Close()
+	c, err := loadCalendar(f.Name(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := execTemplate(`{{ nextBusinessDay "2024-04-04" }}`, c.funcs(), nil); err != nil || got != "2024-04-07" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	if _, err := loadCalendar("", 13); err == nil {
+		t.Error("no error for fiscal year start 13")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// checkWritable writes a canary object next to the outputs of a run and
+// deletes it again, so that a run without permission to write them fails
+// before its queries instead of after them. The athena temp path is checked
+// with the credentials of the run, which athena writes the results with.
+func (awsCli *awsCli) checkWritable(ctx context.Context, output, outDir string) error {
+	tempPath, err := awsCli.resultPath()
+	if err != nil {
+		return err
+	}
+	dirs := []string{tempPath}
+	switch {
+	case outDir != "":
+		dirs = append(dirs, outDir)
+	case output != "" && output != "-":
+		dir := "."
+		if i := strings.LastIndex(output, "/"); i >= 0 {
+			dir = output[:i+1]
+		}
+		dirs = append(dirs, dir)
+	}
+	for _, dir := range dirs {
+		if err := awsCli.writeCanary(ctx, dir); err != nil {
+			return errors.Wrapf(err, "could not write to %s", dir)
+		}
+	}
+	return nil
+}
+
+func (awsCli *awsCli) writeCanary(ctx context.Context, dir string) error {
+	location := strings.TrimRight(dir, "/") + "/.athenaq-canary-" + awsCli.runID
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "s3", "", "file":
+	default:
+		// http outputs would send the canary.
+		debugf("not checking %s, only s3 and file outputs are checked", dir)
+		return nil
+	}
+	if err := awsCli.writeOut(strings.NewReader("athenaq canary\n"), location); err != nil {
+		return err
+	}
+	if u.Scheme == "s3" {
+		key := strings.TrimLeft(u.Path, "/")
+		_, err = awsCli.s3For(u.Host).DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: aws.String(u.Host), Key: &key})
+	} else {
+		err = os.Remove(path.Join(u.Host, u.Path))
+	}
+	if err != nil {
+		infof("could not delete the canary %s: %v", location, err)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckWritable(t *testing.T) {
+	f := newFakeAWS(t)
+	defer f.Close()
+	awsCli := f.client()
+	dir, err := ioutil.TempDir("", "athenaq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := awsCli.checkWritable(context.Background(), "file://"+dir+"/out/result.csv", ""); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.count("PUTObject"); n != 1 {
+		t.Errorf("%d PUTObject calls, want 1", n)
+	}
+	if n := f.count("DELETEObject"); n != 1 {
+		t.Errorf("%d DELETEObject calls, want 1", n)
+	}
+	if len(f.objects) != 0 {
+		t.Errorf("canaries left in s3: %v", f.objects)
+	}
+	if files, _ := ioutil.ReadDir(filepath.Join(dir, "out")); len(files) != 0 {
+		t.Errorf("canaries left in %s: %d files", dir, len(files))
+	}
+
+	// a directory can't be created below a file.
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = awsCli.checkWritable(context.Background(), "", "file://"+dir+"/file/out")
+	if err == nil || !strings.Contains(err.Error(), "could not write to file://"+dir+"/file/out") {
+		t.Errorf("got error %v, want a write error", err)
+	}
+}
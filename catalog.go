@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/pkg/errors"
+)
+
+type dataCatalogSummary struct {
+	CatalogName *string
+	Type        *string
+}
+
+func catalogsCmd(args []string) error {
+	fs := flag.NewFlagSet("catalogs", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: athenaq catalogs [flags] list")
+		fs.PrintDefaults()
+	}
+	awsRegion := fs.String("region", "", regionFlagUsage)
+	addLogFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 || fs.Arg(0) != "list" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	sess, err := newSession(*awsRegion)
+	if err != nil {
+		return err
+	}
+	svc := athena.New(sess)
+	ctx := context.Background()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTYPE")
+	var next *string
+	for {
+		out := struct {
+			DataCatalogsSummary []*dataCatalogSummary
+			NextToken           *string
+		}{}
+		err := sendAthena(ctx, svc, "ListDataCatalogs", &struct{ NextToken *string }{next}, &out)
+		if err != nil {
+			return errors.Wrap(err, "could not list data catalogs")
+		}
+		for _, c := range out.DataCatalogsSummary {
+			fmt.Fprintf(w, "%s\t%s\n", aws.StringValue(c.CatalogName), aws.StringValue(c.Type))
+		}
+		if out.NextToken == nil {
+			break
+		}
+		next = out.NextToken
+	}
+	return w.Flush()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+func TestIsGlueCatalog(t *testing.T) {
+	for catalog, want := range map[string]bool{
+		"":               true,
+		"AwsDataCatalog": true,
+		"awsdatacatalog": true,
+		"mysql":          false,
+	} {
+		if got := isGlueCatalog(catalog); got != want {
+			t.Errorf("isGlueCatalog(%q) = %v, want %v", catalog, got, want)
+		}
+	}
+}
+
+func TestFederatedCatalogSkipsPartitionFilters(t *testing.T) {
+	keys := newPartitionKeys(nil, "default")
+	missing, err := keys.missingPartitionFilters(context.Background(), tokenize("select * from mysql.shop.orders"))
+	if err != nil || len(missing) != 0 {
+		t.Errorf("got %v, %v for a federated table", missing, err)
+	}
+	awsCli := &awsCli{catalog: "mysql"}
+	if err := awsCli.checkPartitionFilters(context.Background(), []string{"select * from orders"}, nil, "fail"); err != nil {
+		t.Errorf("partition filters checked on a federated catalog: %v", err)
+	}
+}
+
+func TestExecuteQueryCatalog(t *testing.T) {
+	var started struct {
+		QueryString           string
+		WorkGroup             string
+		QueryExecutionContext struct{ Catalog, Database string }
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonAthena.StartQueryExecution":
+			json.NewDecoder(r.Body).Decode(&started)
+			w.Write([]byte(`{"QueryExecutionId": "q-1"}`))
+		case "AmazonAthena.BatchGetQueryExecution":
+			w.Write([]byte(`{"QueryExecutions": [{"QueryExecutionId": "q-1", "Status": {"State": "SUCCEEDED"}}]}`))
+		}
+	}))
+	defer srv.Close()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Endpoint:    aws.String(srv.URL),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	awsCli := newBareAWS(sess)
+	awsCli.poller, awsCli.workGroup, awsCli.catalog = newStatusPoller(awsCli.athena), "etl", "mysql"
+	awsCli.athenaPathOnce.Do(func() {})
+	if _, err := awsCli.executeQuery(context.Background(), "select 1"); err != nil {
+		t.Fatal(err)
+	}
+	if started.QueryString != "select 1" || started.WorkGroup != "etl" || started.QueryExecutionContext.Catalog != "mysql" || started.QueryExecutionContext.Database != "" {
+		t.Errorf("got %+v", started)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// catalogCacheTTL is how long partition keys and completion listings are
+// reused from the catalog cache instead of asking glue and athena again.
+var catalogCacheTTL = 15 * time.Minute
+
+// maxCatalogCacheAge drops entries no invocation reuses anymore from the
+// cache file.
+const maxCatalogCacheAge = 7 * 24 * time.Hour
+
+func addCatalogCacheFlag(fs *flag.FlagSet) {
+	fs.DurationVar(&catalogCacheTTL, "catalog-cache-ttl", catalogCacheTTL, "reuse partition keys and completion listings of the local catalog cache for this long (0 == off)")
+}
+
+type catalogCacheEntry struct {
+	Time   time.Time `json:"time"`
+	Values []string  `json:"values"`
+	Help   []string  `json:"help,omitempty"`
+}
+
+// catalogCache is a json file of catalog metadata shared by all
+// invocations. Failing to read or write it only costs the api calls it
+// saves, so its errors are logged, not returned.
+type catalogCache struct {
+	path    string
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]catalogCacheEntry
+}
+
+// catalogCachePath is athenaq/catalog.json in the user cache directory,
+// $XDG_CACHE_HOME or ~/.cache on linux.
+func catalogCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.Wrap(err, "could not find the cache directory")
+	}
+	return filepath.Join(dir, "athenaq", "catalog.json"), nil
+}
+
+// newCatalogCache returns nil, a cache that never hits, for a ttl of 0.
+func newCatalogCache(ttl time.Duration) *catalogCache {
+	if ttl <= 0 {
+		return nil
+	}
+	path, err := catalogCachePath()
+	if err != nil {
+		debugf("%v", err)
+		return nil
+	}
+	return &catalogCache{path: path, ttl: ttl}
+}
+
+func (c *catalogCache) read() map[string]catalogCacheEntry {
+	entries := map[string]catalogCacheEntry{}
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			debugf("could not read catalog cache: %v", err)
+		}
+		return entries
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		debugf("ignoring invalid catalog cache %s: %v", c.path, err)
+		return map[string]catalogCacheEntry{}
+	}
+	return entries
+}
+
+func (c *catalogCache) get(key string) (catalogCacheEntry, bool) {
+	if c == nil {
+		return catalogCacheEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = c.read()
+	}
+	e, ok := c.entries[key]
+	if !ok || time.Since(e.Time) > c.ttl {
+		return catalogCacheEntry{}, false
+	}
+	debugf("catalog cache hit %s", key)
+	return e, true
+}
+
+// put stores an entry. The file is read again first to keep the entries
+// other invocations stored in the meantime.
+func (c *catalogCache) put(key string, e catalogCacheEntry) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = c.read()
+	e.Time = time.Now().UTC()
+	c.entries[key] = e
+	for k, old := range c.entries {
+		if time.Since(old.Time) > maxCatalogCacheAge {
+			delete(c.entries, k)
+		}
+	}
+	if err := c.write(); err != nil {
+		debugf("could not write catalog cache: %v", err)
+	}
+}
+
+// write replaces the file by renaming a temp file, so that concurrent
+// invocations never read half of it.
+func (c *catalogCache) write() error {
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(c.path), ".catalog-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.path)
+}
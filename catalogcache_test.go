@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCatalogCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq-catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("XDG_CACHE_HOME", os.Getenv("XDG_CACHE_HOME"))
+	os.Setenv("XDG_CACHE_HOME", dir)
+
+	if c := newCatalogCache(0); c != nil {
+		t.Fatalf("cache with ttl 0 %+v, want nil", c)
+	}
+	var off *catalogCache
+	off.put("partitions/eu-west-1/db.events", catalogCacheEntry{Values: []string{"dt"}})
+	if _, ok := off.get("partitions/eu-west-1/db.events"); ok {
+		t.Error("disabled cache hit")
+	}
+
+	c := newCatalogCache(time.Hour)
+	if want := filepath.Join(dir, "athenaq", "catalog.json"); c.path != want {
+		t.Fatalf("path %q, want %q", c.path, want)
+	}
+	if _, ok := c.get("partitions/eu-west-1/db.events"); ok {
+		t.Error("hit in an empty cache")
+	}
+	c.put("partitions/eu-west-1/db.events", catalogCacheEntry{Values: []string{"dt", "hour"}})
+	// another invocation sees the entry and keeps it when storing its own.
+	other := newCatalogCache(time.Hour)
+	other.put("tables/eu-west-1/AwsDataCatalog/db", catalogCacheEntry{Values: []string{"db.events"}, Help: []string{"EXTERNAL_TABLE"}})
+	for _, cache := range []*catalogCache{newCatalogCache(time.Hour), other} {
+		e, ok := cache.get("partitions/eu-west-1/db.events")
+		if !ok || !reflect.DeepEqual(e.Values, []string{"dt", "hour"}) {
+			t.Errorf("partitions %+v, %v", e, ok)
+		}
+	}
+	if e, ok := newCatalogCache(time.Hour).get("tables/eu-west-1/AwsDataCatalog/db"); !ok || e.Help[0] != "EXTERNAL_TABLE" {
+		t.Errorf("tables %+v, %v", e, ok)
+	}
+	if info, err := os.Stat(c.path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("cache file %v, %v", info, err)
+	}
+
+	if _, ok := newCatalogCache(time.Nanosecond).get("partitions/eu-west-1/db.events"); ok {
+		t.Error("hit of an expired entry")
+	}
+
+	if err := ioutil.WriteFile(c.path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := newCatalogCache(time.Hour).get("partitions/eu-west-1/db.events"); ok {
+		t.Error("hit in an invalid cache file")
+	}
+	c.put("partitions/eu-west-1/db.events", catalogCacheEntry{})
+	if _, ok := newCatalogCache(time.Hour).get("partitions/eu-west-1/db.events"); !ok {
+		t.Error("invalid cache file not replaced")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sha256Base64 returns the checksum of r in the form of the
+// x-amz-checksum-sha256 header and rewinds r.
+func sha256Base64(r io.ReadSeeker) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyChecksum compares data to the checksum s3 returned with it.
+func verifyChecksum(header http.Header, data []byte) error {
+	v := newChecksumVerifier(header)
+	v.Write(data)
+	return v.verify()
+}
+
+// checksumVerifier hashes an s3 object as it is read to compare it to the
+// checksum s3 returned with it: the sha256 checksum if the object has one,
+// else the ETag if it is the md5 of the object, i.e. for single part uploads
+// without kms encryption.
+type checksumVerifier struct {
+	hash      hash.Hash
+	want      string
+	encodeSum func([]byte) string
+}
+
+func newChecksumVerifier(header http.Header) *checksumVerifier {
+	if want := header.Get("X-Amz-Checksum-Sha256"); want != "" {
+		return &checksumVerifier{sha256.New(), want, base64.StdEncoding.EncodeToString}
+	}
+	etag := strings.Trim(header.Get("ETag"), `"`)
+	if len(etag) != 2*md5.Size || strings.Contains(etag, "-") || strings.HasPrefix(header.Get("X-Amz-Server-Side-Encryption"), "aws:kms") {
+		return &checksumVerifier{}
+	}
+	return &checksumVerifier{md5.New(), etag, hex.EncodeToString}
+}
+
+func (v *checksumVerifier) Write(p []byte) (int, error) {
+	if v.hash == nil {
+		return len(p), nil
+	}
+	return v.hash.Write(p)
+}
+
+func (v *checksumVerifier) verify() error {
+	if v.hash == nil {
+		return nil
+	}
+	if got := v.encodeSum(v.hash.Sum(nil)); got != v.want {
+		return fmt.Errorf("checksum mismatch: got %s, want %s", got, v.want)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestVerifyChecksum(t *testing.T) {
+	data := []byte("a,b\n1,2\n")
+	for _, tt := range []struct {
+		name   string
+		header map[string]string
+		ok     bool
+	}{
+		{"no checksum", nil, true},
+		{"sha256", map[string]string{"X-Amz-Checksum-Sha256": "SS1epJYFbxpqZZIkEDL6t2TDIVljF5MLT6Dh6Lw7dHA="}, true},
+		{"sha256 mismatch", map[string]string{"X-Amz-Checksum-Sha256": "AAAA"}, false},
+		{"etag mismatch", map[string]string{"ETag": `"3c7f4ab3b9d6d1e5d8e2d2b3b2a8e0cc"`}, false},
+		{"etag md5", map[string]string{"ETag": `"e5ebd4c02cefbe7955977c67ada242b7"`}, true},
+		{"multipart etag", map[string]string{"ETag": `"3c7f4ab3b9d6d1e5d8e2d2b3b2a8e0cc-2"`}, true},
+		{"kms etag", map[string]string{"ETag": `"3c7f4ab3b9d6d1e5d8e2d2b3b2a8e0cc"`, "X-Amz-Server-Side-Encryption": "aws:kms"}, true},
+	} {
+		header := http.Header{}
+		for k, v := range tt.header {
+			header.Set(k, v)
+		}
+		if err := verifyChecksum(header, data); (err == nil) != tt.ok {
+			t.Errorf("%s: verifyChecksum = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestSHA256Base64Rewinds(t *testing.T) {
+	r := strings.NewReader("a,b\n1,2\n")
+	sum, err := sha256Base64(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != "SS1epJYFbxpqZZIkEDL6t2TDIVljF5MLT6Dh6Lw7dHA=" {
+		t.Errorf("sha256Base64 = %s", sum)
+	}
+	if r.Len() != 8 {
+		t.Errorf("reader not rewound, %d bytes left", r.Len())
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/advincze/s3path"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// createdTables are the tables a run created with CREATE TABLE AS or as
+// iceberg tables. With -drop-data their s3 data is deleted when the run
+// drops them, instead of being left behind by athena.
+type createdTables struct {
+	mu     sync.Mutex
+	tables map[string]bool
+}
+
+func newCreatedTables() *createdTables {
+	return &createdTables{tables: map[string]bool{}}
+}
+
+// glueTableName returns the database and table of ref in the glue catalog,
+// or false for tables of other catalogs. Unqualified tables are in the
+// database the query runs in.
+func glueTableName(ctx context.Context, ref tableRef, catalog string) (string, string, bool) {
+	switch len(ref.parts) {
+	case 1:
+		database := queryDatabase(ctx)
+		if database == "" {
+			database = "default"
+		}
+		return database, ref.parts[0], isGlueCatalog(catalog)
+	case 2:
+		return ref.parts[0], ref.parts[1], isGlueCatalog(catalog)
+	}
+	return ref.parts[len(ref.parts)-2], ref.parts[len(ref.parts)-1], isGlueCatalog(ref.parts[len(ref.parts)-3])
+}
+
+// tableStatement returns the table created or dropped by the statement, and
+// whether a created table's data is written by athena: CREATE TABLE AS and
+// iceberg tables.
+func tableStatement(toks []token) (verb string, ref tableRef, owned bool) {
+	targets := targetTables(toks)
+	if len(targets) != 1 {
+		return "", tableRef{}, false
+	}
+	ref = targets[0]
+	var words []token
+	for _, t := range toks[:ref.start] {
+		if t.significant() {
+			words = append(words, t)
+		}
+	}
+	switch verb = firstKeyword(toks); verb {
+	case "DROP":
+		if !words[len(words)-1].is("TABLE", "EXISTS") {
+			return "", tableRef{}, false
+		}
+		return verb, ref, false
+	case "CREATE":
+		for _, t := range words {
+			if t.is("EXTERNAL", "VIEW") {
+				return "", tableRef{}, false
+			}
+		}
+	default:
+		return "", tableRef{}, false
+	}
+	rest := toks[ref.end:]
+	for i, t := range rest {
+		switch {
+		case t.is("AS"):
+			if j := nextToken(rest, i+1); j < len(rest) && (rest[j].is("SELECT", "WITH") || rest[j].text == "(") {
+				owned = true
+			}
+		case t.kind == tokenString && strings.EqualFold(strings.Trim(t.text, "'"), "ICEBERG"):
+			owned = true
+		}
+	}
+	return verb, ref, owned
+}
+
+// tableLocation returns the s3 location of a table created by the run that
+// query drops, or "".
+func (awsCli *awsCli) tableLocation(ctx context.Context, query string) string {
+	if awsCli.created == nil {
+		return ""
+	}
+	verb, ref, _ := tableStatement(tokenize(query))
+	if verb != "DROP" {
+		return ""
+	}
+	database, table, ok := glueTableName(ctx, ref, awsCli.catalog)
+	if !ok {
+		return ""
+	}
+	awsCli.created.mu.Lock()
+	created := awsCli.created.tables[database+"."+table]
+	awsCli.created.mu.Unlock()
+	if !created {
+		return ""
+	}
+	t, err := awsCli.glue.getTable(ctx, database, table)
+	if err != nil || t == nil || t.StorageDescriptor == nil {
+		infof("could not get the location of %s.%s, not deleting its data: %v", database, table, err)
+		return ""
+	}
+	return aws.StringValue(t.StorageDescriptor.Location)
+}
+
+// tableChanged records the tables created by query and deletes the data at
+// location once the table is dropped.
+func (awsCli *awsCli) tableChanged(ctx context.Context, query, location string) error {
+	if awsCli.created == nil {
+		return nil
+	}
+	verb, ref, owned := tableStatement(tokenize(query))
+	database, table, ok := glueTableName(ctx, ref, awsCli.catalog)
+	if verb == "" || !ok {
+		return nil
+	}
+	awsCli.created.mu.Lock()
+	switch {
+	case verb == "CREATE" && owned:
+		awsCli.created.tables[database+"."+table] = true
+	case verb == "DROP":
+		delete(awsCli.created.tables, database+"."+table)
+	}
+	awsCli.created.mu.Unlock()
+	if location == "" {
+		return nil
+	}
+	n, err := awsCli.deleteS3Prefix(ctx, location)
+	if err != nil {
+		return errors.Wrapf(err, "could not delete the data of %s.%s", database, table)
+	}
+	infof("deleted %d objects of %s.%s in %s", n, database, table, location)
+	return nil
+}
+
+// deleteS3Prefix deletes all objects below location. It refuses to empty
+// whole buckets.
+func (awsCli *awsCli) deleteS3Prefix(ctx context.Context, location string) (int, error) {
+	p, err := s3path.Parse(location)
+	if err != nil {
+		return 0, err
+	}
+	prefix := strings.TrimPrefix(p.Key, "/")
+	if strings.Trim(prefix, "/") == "" {
+		return 0, errors.Errorf("refusing to delete all objects of bucket %s", p.Bucket)
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	svc := awsCli.s3For(p.Bucket)
+	deleted := 0
+	var deleteErr error
+	err = svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{Bucket: &p.Bucket, Prefix: &prefix}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		if len(page.Contents) == 0 {
+			return true
+		}
+		var objects []*s3.ObjectIdentifier
+		for _, o := range page.Contents {
+			objects = append(objects, &s3.ObjectIdentifier{Key: o.Key})
+		}
+		out, err := svc.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: &p.Bucket,
+			Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err == nil && len(out.Errors) > 0 {
+			err = errors.Errorf("%s: %s", aws.StringValue(out.Errors[0].Key), aws.StringValue(out.Errors[0].Message))
+		}
+		if err != nil {
+			deleteErr = err
+			return false
+		}
+		deleted += len(objects)
+		return true
+	})
+	if err == nil {
+		err = deleteErr
+	}
+	return deleted, err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTableStatement(t *testing.T) {
+	tests := []struct {
+		query string
+		verb  string
+		table string
+		owned bool
+	}{
+		{"CREATE TABLE tmp AS SELECT * FROM logs", "CREATE", "tmp", true},
+		{"create table db.tmp with (format = 'PARQUET') as (select 1)", "CREATE", "db.tmp", true},
+		{"CREATE TABLE ice (id int) LOCATION 's3://b/ice/' TBLPROPERTIES ('table_type' = 'ICEBERG')", "CREATE", "ice", true},
+		{"CREATE TABLE plain (id int)", "CREATE", "plain", false},
+		{"CREATE EXTERNAL TABLE logs (id int) LOCATION 's3://b/logs/'", "", "", false},
+		{"CREATE VIEW v AS SELECT 1", "", "", false},
+		{"DROP TABLE IF EXISTS db.tmp", "DROP", "db.tmp", false},
+		{"DROP VIEW v", "", "", false},
+		{"SELECT * FROM tmp", "", "", false},
+	}
+	for _, tt := range tests {
+		verb, ref, owned := tableStatement(tokenize(tt.query))
+		if verb != tt.verb || (verb != "" && ref.name() != tt.table) || owned != tt.owned {
+			t.Errorf("%q: got %q %q %v, want %q %q %v", tt.query, verb, ref.name(), owned, tt.verb, tt.table, tt.owned)
+		}
+	}
+}
+
+func TestDeleteS3PrefixRefusesBuckets(t *testing.T) {
+	awsCli := &awsCli{}
+	for _, location := range []string{"s3://bucket", "s3://bucket/"} {
+		if _, err := awsCli.deleteS3Prefix(context.Background(), location); err == nil {
+			t.Errorf("%s: no error", location)
+		}
+	}
+}
+
+func TestDropDataInSetDatabase(t *testing.T) {
+	f := newFakeAWS(t)
+	defer f.Close()
+	f.table("analytics", "tmp", "s3://data/analytics/tmp/")
+	f.table("default", "tmp", "s3://data/default/tmp/")
+	f.buckets["data"] = true
+	f.objects["data/analytics/tmp/part-0"] = []byte("1")
+	f.objects["data/default/tmp/part-0"] = []byte("1")
+	awsCli := f.client()
+	awsCli.created = newCreatedTables()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	queries, _, settings, err := applySettings([]string{
+		"SET athenaq.database = 'analytics'",
+		"CREATE TABLE tmp AS SELECT 1",
+		"DROP TABLE tmp",
+	}, make([]int, 3), querySettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, query := range queries {
+		if _, err := awsCli.execQuery(queryContext(ctx, settings, i), query, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := f.objects["data/analytics/tmp/part-0"]; ok {
+		t.Error("data of analytics.tmp was not deleted")
+	}
+	if _, ok := f.objects["data/default/tmp/part-0"]; !ok {
+		t.Error("data of default.tmp was deleted")
+	}
+}
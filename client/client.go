@@ -0,0 +1,380 @@
+// Package client runs athena queries for Go programs, one at a time, row by
+// row or as a batch of executions running in the background. The athenaq
+// command is built on it.
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/advincze/s3path"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/pkg/errors"
+)
+
+// Client runs athena queries and reads their results. It is safe for
+// concurrent use.
+type Client struct {
+	session *session.Session
+	athena  *athena.Athena
+	sts     *sts.STS
+	s3      *s3.S3
+
+	workGroup      string
+	catalog        string
+	outputLocation string
+	bucketOwner    string
+	pollInterval   time.Duration
+	sem            chan struct{}
+	limiter        RateLimiter
+
+	// mu guards identity and s3Regions, which are filled on first use, and
+	// s3Lookups, the region lookups in flight.
+	mu        sync.Mutex
+	identity  *sts.GetCallerIdentityOutput
+	s3Regions map[string]*s3.S3
+	s3Lookups map[string]chan struct{}
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithWorkGroup runs queries in the workgroup name instead of primary.
+func WithWorkGroup(name string) Option {
+	return func(c *Client) { c.workGroup = name }
+}
+
+// WithCatalog runs queries in the data catalog name instead of
+// AwsDataCatalog.
+func WithCatalog(name string) Option {
+	return func(c *Client) { c.catalog = name }
+}
+
+// WithOutputLocation has athena write query results below the s3 path,
+// e.g. s3://bucket/prefix/. Without it the workgroup must have one.
+func WithOutputLocation(path string) Option {
+	return func(c *Client) { c.outputLocation = path }
+}
+
+// WithExpectedBucketOwner has s3 and athena refuse to read or write objects
+// of buckets not owned by the account.
+func WithExpectedBucketOwner(account string) Option {
+	return func(c *Client) { c.bucketOwner = account }
+}
+
+// WithConcurrency runs at most n queries of SubmitBatch at a time (default
+// 1).
+func WithConcurrency(n int) Option {
+	return func(c *Client) {
+		if n > 0 {
+			c.sem = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithPollInterval polls the state of running queries this often (default
+// 500ms).
+func WithPollInterval(d time.Duration) Option {
+	return func(c *Client) { c.pollInterval = d }
+}
+
+// New returns a client making its calls with sess.
+func New(sess *session.Session, opts ...Option) *Client {
+	c := &Client{
+		session:      sess,
+		athena:       athena.New(sess),
+		sts:          sts.New(sess),
+		pollInterval: 500 * time.Millisecond,
+		sem:          make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.limiter != nil {
+		limitCalls(&c.athena.Handlers, c.limiter)
+	}
+	c.s3 = c.NewS3()
+	return c
+}
+
+// Session returns the session the client makes its calls with.
+func (c *Client) Session() *session.Session { return c.session }
+
+// Athena returns the athena client of the client.
+func (c *Client) Athena() *athena.Athena { return c.athena }
+
+// STS returns the sts client of the client.
+func (c *Client) STS() *sts.STS { return c.sts }
+
+// S3 returns the s3 client of the region of the session.
+func (c *Client) S3() *s3.S3 { return c.s3 }
+
+// NewS3 returns an s3 client that sends the expected bucket owner, if set,
+// with every object read and write.
+func (c *Client) NewS3(cfgs ...*aws.Config) *s3.S3 {
+	svc := s3.New(c.session, cfgs...)
+	if owner := c.bucketOwner; owner != "" {
+		svc.Handlers.Build.PushBack(func(r *request.Request) {
+			switch r.Operation.Name {
+			case "GetObject", "PutObject":
+				r.HTTPRequest.Header.Set("X-Amz-Expected-Bucket-Owner", owner)
+			}
+		})
+	}
+	return svc
+}
+
+// S3For returns an s3 client for the region of bucket, so that outputs and
+// results can live in another region than the queries run in. The region is
+// looked up once per bucket, without holding mu during the call.
+func (c *Client) S3For(bucket string) *s3.S3 {
+	c.mu.Lock()
+	for {
+		if svc, ok := c.s3Regions[bucket]; ok {
+			c.mu.Unlock()
+			return svc
+		}
+		lookup, ok := c.s3Lookups[bucket]
+		if !ok {
+			break
+		}
+		c.mu.Unlock()
+		<-lookup
+		c.mu.Lock()
+	}
+	if c.s3Lookups == nil {
+		c.s3Lookups = map[string]chan struct{}{}
+	}
+	done := make(chan struct{})
+	c.s3Lookups[bucket] = done
+	c.mu.Unlock()
+
+	svc := c.s3
+	if region := c.BucketRegion(bucket); region != "" && region != aws.StringValue(c.s3.Config.Region) {
+		svc = c.NewS3(aws.NewConfig().WithRegion(region))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.s3Regions == nil {
+		c.s3Regions = map[string]*s3.S3{}
+	}
+	c.s3Regions[bucket] = svc
+	delete(c.s3Lookups, bucket)
+	close(done)
+	return svc
+}
+
+// BucketRegion returns the region of bucket from the X-Amz-Bucket-Region
+// header s3 sends even when redirecting, or "" if the bucket does not exist.
+func (c *Client) BucketRegion(bucket string) string {
+	req, _ := c.s3.HeadBucketRequest(&s3.HeadBucketInput{Bucket: &bucket})
+	req.Send()
+	if req.HTTPResponse == nil {
+		return ""
+	}
+	return req.HTTPResponse.Header.Get("X-Amz-Bucket-Region")
+}
+
+// CallerIdentity returns the identity the client makes its calls as, asking
+// sts only once.
+func (c *Client) CallerIdentity() (*sts.GetCallerIdentityOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.identity == nil {
+		identity, err := c.sts.GetCallerIdentity(nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not get caller identity")
+		}
+		c.identity = identity
+	}
+	return c.identity, nil
+}
+
+// Query is a query to run. Empty fields take the defaults of the client.
+type Query struct {
+	SQL            string
+	Catalog        string
+	Database       string
+	WorkGroup      string
+	OutputLocation string
+}
+
+// The vendored athena client predates the expected bucket owner and
+// catalogs; the types below mirror the athena API and are sent through the
+// regular athena client.
+
+type startQueryExecutionInput struct {
+	ClientRequestToken    *string `idempotencyToken:"true"`
+	QueryString           *string
+	ResultConfiguration   *resultConfiguration
+	QueryExecutionContext *queryExecutionContext
+	WorkGroup             *string
+}
+
+type resultConfiguration struct {
+	OutputLocation      *string
+	ExpectedBucketOwner *string
+}
+
+type queryExecutionContext struct {
+	Catalog  *string
+	Database *string
+}
+
+// Start submits q and returns the id of its execution.
+func (c *Client) Start(ctx context.Context, q Query) (string, error) {
+	input := &startQueryExecutionInput{QueryString: aws.String(q.SQL)}
+	if location := firstOf(q.OutputLocation, c.outputLocation); location != "" || c.bucketOwner != "" {
+		input.ResultConfiguration = &resultConfiguration{}
+		if location != "" {
+			input.ResultConfiguration.OutputLocation = aws.String(location)
+		}
+		if c.bucketOwner != "" {
+			input.ResultConfiguration.ExpectedBucketOwner = aws.String(c.bucketOwner)
+		}
+	}
+	if workGroup := firstOf(q.WorkGroup, c.workGroup); workGroup != "" {
+		input.WorkGroup = aws.String(workGroup)
+	}
+	catalog := firstOf(q.Catalog, c.catalog)
+	if catalog != "" || q.Database != "" {
+		input.QueryExecutionContext = &queryExecutionContext{}
+		if catalog != "" {
+			input.QueryExecutionContext.Catalog = aws.String(catalog)
+		}
+		if q.Database != "" {
+			input.QueryExecutionContext.Database = aws.String(q.Database)
+		}
+	}
+	out := &athena.StartQueryExecutionOutput{}
+	req := c.athena.NewRequest(&request.Operation{Name: "StartQueryExecution", HTTPMethod: "POST", HTTPPath: "/"}, input, out)
+	req.SetContext(ctx)
+	if err := req.Send(); err != nil {
+		return "", err
+	}
+	return aws.StringValue(out.QueryExecutionId), nil
+}
+
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Wait polls the state of the query id until it finished. A query that
+// failed or was cancelled is returned with an error. The query is stopped
+// when ctx is done first, so that it does not keep running in athena.
+func (c *Client) Wait(ctx context.Context, id string) (*athena.QueryExecution, error) {
+	queryExecution, err := c.wait(ctx, id)
+	if err != nil && ctx.Err() != nil {
+		c.stopDetached(id)
+	}
+	return queryExecution, err
+}
+
+func (c *Client) wait(ctx context.Context, id string) (*athena.QueryExecution, error) {
+	for {
+		out, err := c.athena.GetQueryExecutionWithContext(ctx, &athena.GetQueryExecutionInput{QueryExecutionId: aws.String(id)})
+		if err != nil {
+			return nil, errors.Wrap(err, "could not get query status")
+		}
+		queryExecution := out.QueryExecution
+		switch aws.StringValue(queryExecution.Status.State) {
+		case athena.QueryExecutionStateSucceeded:
+			return queryExecution, nil
+		case athena.QueryExecutionStateFailed, athena.QueryExecutionStateCancelled:
+			return queryExecution, fmt.Errorf("athena query could not finish: %v", aws.StringValue(queryExecution.Status.StateChangeReason))
+		}
+		select {
+		case <-ctx.Done():
+			return queryExecution, ctx.Err()
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
+
+// Stop cancels the query id.
+func (c *Client) Stop(ctx context.Context, id string) error {
+	_, err := c.athena.StopQueryExecutionWithContext(ctx, &athena.StopQueryExecutionInput{QueryExecutionId: aws.String(id)})
+	return errors.Wrapf(err, "could not cancel query %s", id)
+}
+
+// stopTimeout bounds stopping a query whose context is already done.
+const stopTimeout = 10 * time.Second
+
+// stopDetached stops the query id after its context is done, with a context
+// of its own.
+func (c *Client) stopDetached(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	return c.Stop(ctx, id)
+}
+
+// Run submits q and waits for it to finish.
+func (c *Client) Run(ctx context.Context, q Query) (*athena.QueryExecution, error) {
+	id, err := c.Start(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not start query execution")
+	}
+	return c.Wait(ctx, id)
+}
+
+// Result writes the csv athena wrote to s3 for a finished query to w.
+// Statements without a result write nothing.
+func (c *Client) Result(ctx context.Context, queryExecution *athena.QueryExecution, w io.Writer) error {
+	if queryExecution.ResultConfiguration == nil || queryExecution.ResultConfiguration.OutputLocation == nil {
+		return nil
+	}
+	path, err := s3path.Parse(aws.StringValue(queryExecution.ResultConfiguration.OutputLocation))
+	if err != nil {
+		return errors.Wrap(err, "could not parse result location")
+	}
+	out, err := c.S3For(path.Bucket).GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: &path.Bucket, Key: &path.Key})
+	if err != nil {
+		return errors.Wrapf(err, "could not get result from %q", path)
+	}
+	defer out.Body.Close()
+	_, err = io.Copy(w, out.Body)
+	return errors.Wrap(err, "could not read result")
+}
+
+// EachResultPage calls fn with the rows of each page of GetQueryResults of
+// the query id until the last page or fn fails.
+func (c *Client) EachResultPage(ctx context.Context, id string, fn func([]*athena.Row) error) error {
+	return c.eachResultSet(ctx, id, func(rs *athena.ResultSet) error { return fn(rs.Rows) })
+}
+
+func (c *Client) eachResultSet(ctx context.Context, id string, fn func(*athena.ResultSet) error) error {
+	var next *string
+	for {
+		out, err := c.athena.GetQueryResultsWithContext(ctx, &athena.GetQueryResultsInput{
+			QueryExecutionId: aws.String(id),
+			NextToken:        next,
+			MaxResults:       aws.Int64(1000),
+		})
+		if err != nil {
+			return errors.Wrap(err, "could not get query results")
+		}
+		if out.ResultSet != nil {
+			if err := fn(out.ResultSet); err != nil {
+				return err
+			}
+		}
+		if out.NextToken == nil {
+			return nil
+		}
+		next = out.NextToken
+	}
+}
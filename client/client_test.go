@@ -0,0 +1,330 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// fakeAthena is an athena and s3 endpoint answering the queries registered
+// with result. Queries advance one state (QUEUED, RUNNING, SUCCEEDED) per
+// GetQueryExecution, queries without a result fail once running.
+type fakeAthena struct {
+	*httptest.Server
+	mu      sync.Mutex
+	results map[string]string
+	queries map[string]*fakeQuery
+	objects map[string][]byte
+	calls   map[string]int
+	stuck   map[string]bool
+	// columns are the result metadata of queries, none if not set.
+	columns map[string][]string
+	// regions are the regions of buckets, eu-central-1 if not set.
+	regions map[string]string
+	// owners are the expected bucket owners sent, by "<op> <path>", and
+	// signed the regions s3 calls were signed for.
+	owners map[string]string
+	signed map[string]string
+}
+
+type fakeQuery struct {
+	sql, output, state string
+	stuck              bool
+}
+
+func newFakeAthena() *fakeAthena {
+	f := &fakeAthena{
+		results: map[string]string{},
+		queries: map[string]*fakeQuery{},
+		objects: map[string][]byte{},
+		calls:   map[string]int{},
+		stuck:   map[string]bool{},
+		columns: map[string][]string{},
+		regions: map[string]string{},
+		owners:  map[string]string{},
+		signed:  map[string]string{},
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+func (f *fakeAthena) result(sql, csv string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results[sql] = csv
+}
+
+// metadata has GetQueryResults of sql return the columns as result metadata.
+func (f *fakeAthena) metadata(sql string, columns ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.columns[sql] = columns
+}
+
+// queue keeps the next submission of sql QUEUED until it is stopped.
+func (f *fakeAthena) queue(sql string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stuck[sql] = true
+}
+
+func (f *fakeAthena) count(op string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[op]
+}
+
+func (f *fakeAthena) owner(call string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.owners[call]
+}
+
+func (f *fakeAthena) state(id string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.queries[id].state
+}
+
+func (f *fakeAthena) client(opts ...Option) *Client {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:           aws.String("eu-central-1"),
+		Endpoint:         aws.String(f.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:       aws.Int(0),
+	}))
+	return New(sess, append([]Option{WithOutputLocation("s3://results/"), WithPollInterval(time.Millisecond)}, opts...)...)
+}
+
+func (f *fakeAthena) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	target := r.Header.Get("X-Amz-Target")
+	if !strings.HasPrefix(target, "AmazonAthena.") {
+		f.calls[r.Method+"S3"]++
+		path := strings.TrimPrefix(r.URL.Path, "/")
+		f.owners[r.Method+" "+path] = r.Header.Get("X-Amz-Expected-Bucket-Owner")
+		if m := credentialScope.FindStringSubmatch(r.Header.Get("Authorization")); m != nil {
+			f.signed[r.Method+" "+path] = m[1]
+		}
+		switch r.Method {
+		case "HEAD":
+			region := f.regions[path]
+			if region == "" {
+				region = "eu-central-1"
+			}
+			w.Header().Set("X-Amz-Bucket-Region", region)
+			return
+		case "PUT":
+			f.objects[path] = body
+			return
+		}
+		data, ok := f.objects[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+			return
+		}
+		w.Write(data)
+		return
+	}
+	op := strings.TrimPrefix(target, "AmazonAthena.")
+	f.calls[op]++
+	in := struct {
+		QueryString         string
+		QueryExecutionId    string
+		ResultConfiguration struct{ OutputLocation, ExpectedBucketOwner string }
+	}{}
+	json.Unmarshal(body, &in)
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	switch op {
+	case "StartQueryExecution":
+		id := fmt.Sprintf("query-%d", len(f.queries)+1)
+		f.owners[op+" "+in.QueryString] = in.ResultConfiguration.ExpectedBucketOwner
+		f.queries[id] = &fakeQuery{sql: in.QueryString, output: in.ResultConfiguration.OutputLocation + id + ".csv", state: "QUEUED", stuck: f.stuck[in.QueryString]}
+		delete(f.stuck, in.QueryString)
+		json.NewEncoder(w).Encode(map[string]string{"QueryExecutionId": id})
+	case "GetQueryExecution":
+		q := f.queries[in.QueryExecutionId]
+		reason := ""
+		switch {
+		case q.state == "QUEUED" && !q.stuck:
+			q.state = "RUNNING"
+		case q.state == "RUNNING":
+			if csv, ok := f.results[q.sql]; ok {
+				q.state = "SUCCEEDED"
+				f.objects[strings.TrimPrefix(q.output, "s3://")] = []byte(csv)
+			} else {
+				q.state = "FAILED"
+			}
+		}
+		if q.state == "FAILED" {
+			reason = "TABLE_NOT_FOUND: no result registered for the query"
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"QueryExecution": map[string]interface{}{
+			"QueryExecutionId":    in.QueryExecutionId,
+			"ResultConfiguration": map[string]string{"OutputLocation": q.output},
+			"Status":              map[string]string{"State": q.state, "StateChangeReason": reason},
+		}})
+	case "GetQueryResults":
+		q := f.queries[in.QueryExecutionId]
+		records, _ := csv.NewReader(bytes.NewReader(f.objects[strings.TrimPrefix(q.output, "s3://")])).ReadAll()
+		rows := []interface{}{}
+		for _, record := range records {
+			data := []interface{}{}
+			for _, v := range record {
+				data = append(data, map[string]string{"VarCharValue": v})
+			}
+			rows = append(rows, map[string]interface{}{"Data": data})
+		}
+		resultSet := map[string]interface{}{"Rows": rows}
+		if columns, ok := f.columns[q.sql]; ok {
+			info := []interface{}{}
+			for _, c := range columns {
+				info = append(info, map[string]string{"Name": c})
+			}
+			resultSet["ResultSetMetadata"] = map[string]interface{}{"ColumnInfo": info}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"ResultSet": resultSet})
+	case "StopQueryExecution":
+		if q := f.queries[in.QueryExecutionId]; q.state == "QUEUED" || q.state == "RUNNING" {
+			q.state = "CANCELLED"
+		}
+		fmt.Fprint(w, `{}`)
+	}
+}
+
+// credentialScope matches the region of a signature.
+var credentialScope = regexp.MustCompile(`Credential=[^/]*/[^/]*/([^/]*)/`)
+
+func contextWithTimeout() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 10*time.Second)
+}
+
+func TestRun(t *testing.T) {
+	f := newFakeAthena()
+	defer f.Close()
+	f.result("select 1", "\"_col0\"\n\"1\"\n")
+	c := f.client()
+	ctx, cancel := contextWithTimeout()
+	defer cancel()
+
+	queryExecution, err := c.Run(ctx, Query{SQL: "select 1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := c.Result(ctx, queryExecution, &buf); err != nil || buf.String() != "\"_col0\"\n\"1\"\n" {
+		t.Errorf("got result %q, %v", buf.String(), err)
+	}
+	if _, err := c.Run(ctx, Query{SQL: "select * from missing"}); err == nil || !strings.Contains(err.Error(), "TABLE_NOT_FOUND") {
+		t.Errorf("got error %v, want TABLE_NOT_FOUND", err)
+	}
+}
+
+func TestS3For(t *testing.T) {
+	f := newFakeAthena()
+	defer f.Close()
+	c := f.client()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.S3For(fmt.Sprintf("bucket-%d", i%2))
+		}(i)
+	}
+	wg.Wait()
+	if n := f.count("HEADS3"); n != 2 {
+		t.Errorf("%d HeadBucket calls, want one per bucket", n)
+	}
+}
+
+func TestExpectedBucketOwner(t *testing.T) {
+	f := newFakeAthena()
+	defer f.Close()
+	f.result("select 1", "\"_col0\"\n\"1\"\n")
+	f.regions["reports-us"] = "us-east-1"
+	c := f.client(WithExpectedBucketOwner("111122223333"))
+	ctx, cancel := contextWithTimeout()
+	defer cancel()
+
+	queryExecution, err := c.Run(ctx, Query{SQL: "select 1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Result(ctx, queryExecution, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.S3For("reports-us").PutObjectWithContext(ctx, &s3.PutObjectInput{Bucket: aws.String("reports-us"), Key: aws.String("out.csv"), Body: strings.NewReader("x")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, call := range []string{"StartQueryExecution select 1", "GET results/query-1.csv", "PUT reports-us/out.csv"} {
+		if owner := f.owner(call); owner != "111122223333" {
+			t.Errorf("%s: got expected bucket owner %q", call, owner)
+		}
+	}
+	if owner := f.owner("HEAD reports-us"); owner != "" {
+		t.Errorf("HeadBucket sent expected bucket owner %q", owner)
+	}
+
+	// without the option none is sent.
+	if _, err := f.client().Run(ctx, Query{SQL: "select 1"}); err != nil {
+		t.Fatal(err)
+	}
+	if owner := f.owner("StartQueryExecution select 1"); owner != "" {
+		t.Errorf("got expected bucket owner %q without the option", owner)
+	}
+}
+
+func TestS3ForCrossRegion(t *testing.T) {
+	f := newFakeAthena()
+	defer f.Close()
+	f.regions["reports-us"] = "us-east-1"
+	f.objects["reports-us/q.csv"] = []byte("\"_col0\"\n\"1\"\n")
+	c := f.client()
+	ctx, cancel := contextWithTimeout()
+	defer cancel()
+
+	if region := aws.StringValue(c.S3For("reports-us").Config.Region); region != "us-east-1" {
+		t.Errorf("got an s3 client of %s for a bucket in us-east-1", region)
+	}
+	if svc := c.S3For("results"); svc != c.S3() {
+		t.Errorf("got a new s3 client of %s for a bucket in the region of the session", aws.StringValue(svc.Config.Region))
+	}
+
+	// results in another region are read with the client of their region.
+	var buf bytes.Buffer
+	queryExecution := &athena.QueryExecution{ResultConfiguration: &athena.ResultConfiguration{OutputLocation: aws.String("s3://reports-us/q.csv")}}
+	if err := c.Result(ctx, queryExecution, &buf); err != nil || buf.String() != "\"_col0\"\n\"1\"\n" {
+		t.Errorf("got result %q, %v", buf.String(), err)
+	}
+	f.mu.Lock()
+	region := f.signed["GET reports-us/q.csv"]
+	f.mu.Unlock()
+	if region != "us-east-1" {
+		t.Errorf("result read with a client of %q, want us-east-1", region)
+	}
+	if n := f.count("HEADS3"); n != 2 {
+		t.Errorf("%d HeadBucket calls, want one per bucket", n)
+	}
+}
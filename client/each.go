@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/pkg/errors"
+)
+
+// ErrStopRows stops QueryEach without an error when returned by its
+// callback.
+var ErrStopRows = errors.New("stop rows")
+
+// Row is a row of a query result. Values are nil for NULL.
+type Row struct {
+	Columns []string
+	Values  []*string
+}
+
+// Value returns the value of column, false when it is NULL or not a column
+// of the row.
+func (r Row) Value(column string) (string, bool) {
+	for i, c := range r.Columns {
+		if c == column && i < len(r.Values) && r.Values[i] != nil {
+			return *r.Values[i], true
+		}
+	}
+	return "", false
+}
+
+// QueryEach runs sql and calls fn with each row of its result as the pages
+// of GetQueryResults arrive, without holding the whole result in memory.
+// The column names are those of the result metadata; the header row athena
+// puts first in SELECT results is skipped. Without metadata the first row is
+// taken as the header.
+func (c *Client) QueryEach(ctx context.Context, sql string, fn func(Row) error) error {
+	queryExecution, err := c.Run(ctx, Query{SQL: sql})
+	if err != nil {
+		return err
+	}
+	var columns []string
+	first := true
+	err = c.eachResultSet(ctx, aws.StringValue(queryExecution.QueryExecutionId), func(rs *athena.ResultSet) error {
+		if first && rs.ResultSetMetadata != nil {
+			for _, info := range rs.ResultSetMetadata.ColumnInfo {
+				columns = append(columns, aws.StringValue(info.Name))
+			}
+		}
+		for _, row := range rs.Rows {
+			values := make([]*string, len(row.Data))
+			for i, d := range row.Data {
+				if d != nil {
+					values[i] = d.VarCharValue
+				}
+			}
+			if first {
+				first = false
+				if columns == nil || reflect.DeepEqual(aws.StringValueSlice(values), columns) {
+					columns = aws.StringValueSlice(values)
+					continue
+				}
+			}
+			if err := fn(Row{Columns: columns, Values: values}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == ErrStopRows {
+		return nil
+	}
+	return err
+}
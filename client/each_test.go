@@ -0,0 +1,76 @@
+package client
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestQueryEach(t *testing.T) {
+	f := newFakeAthena()
+	defer f.Close()
+	f.result("select id, name from users", "\"id\",\"name\"\n\"1\",\"ann\"\n\"2\",\"bob\"\n\"3\",\"cid\"\n")
+	c := f.client()
+	ctx, cancel := contextWithTimeout()
+	defer cancel()
+
+	var names []string
+	err := c.QueryEach(ctx, "select id, name from users", func(row Row) error {
+		if !reflect.DeepEqual(row.Columns, []string{"id", "name"}) {
+			t.Errorf("got columns %v", row.Columns)
+		}
+		name, _ := row.Value("name")
+		names = append(names, name)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(names, []string{"ann", "bob", "cid"}) {
+		t.Errorf("got %v, %v", names, err)
+	}
+
+	names = nil
+	err = c.QueryEach(ctx, "select id, name from users", func(row Row) error {
+		name, _ := row.Value("name")
+		names = append(names, name)
+		if len(names) == 2 {
+			return ErrStopRows
+		}
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(names, []string{"ann", "bob"}) {
+		t.Errorf("stopped: got %v, %v", names, err)
+	}
+
+	failed := errors.New("failed")
+	if err := c.QueryEach(ctx, "select id, name from users", func(Row) error { return failed }); err != failed {
+		t.Errorf("got error %v, want the callback's", err)
+	}
+	// SHOW results have no header row.
+	f.result("show tables", "\"events\"\n\"users\"\n")
+	f.metadata("show tables", "tab_name")
+	var tables []string
+	err = c.QueryEach(ctx, "show tables", func(row Row) error {
+		table, _ := row.Value("tab_name")
+		tables = append(tables, table)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(tables, []string{"events", "users"}) {
+		t.Errorf("show tables: got %v, %v", tables, err)
+	}
+
+	if err := c.QueryEach(ctx, "select * from missing", func(Row) error { return nil }); err == nil {
+		t.Error("no error for a failed query")
+	}
+}
+
+func TestRowValue(t *testing.T) {
+	one := "1"
+	row := Row{Columns: []string{"id", "name"}, Values: []*string{&one, nil}}
+	if v, ok := row.Value("id"); v != "1" || !ok {
+		t.Errorf("id: got %q, %v", v, ok)
+	}
+	for _, column := range []string{"name", "missing"} {
+		if v, ok := row.Value(column); v != "" || ok {
+			t.Errorf("%s: got %q, %v", column, v, ok)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/pkg/errors"
+)
+
+// Execution is a query of a batch running in the background.
+type Execution struct {
+	Query  Query
+	client *Client
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu      sync.Mutex
+	id      string
+	stopped bool
+
+	queryExecution *athena.QueryExecution
+	result         []byte
+	err            error
+}
+
+// SubmitBatch starts the queries and returns their executions right away.
+// They run as many at a time as WithConcurrency allows. Once ctx is done the
+// queries still running are stopped.
+func (c *Client) SubmitBatch(ctx context.Context, queries []Query) []*Execution {
+	executions := make([]*Execution, len(queries))
+	for i, q := range queries {
+		e := &Execution{Query: q, client: c, done: make(chan struct{})}
+		var qctx context.Context
+		qctx, e.cancel = context.WithCancel(ctx)
+		go e.run(qctx)
+		executions[i] = e
+	}
+	return executions
+}
+
+func (e *Execution) run(ctx context.Context) {
+	defer close(e.done)
+	defer e.cancel()
+	select {
+	case e.client.sem <- struct{}{}:
+		defer func() { <-e.client.sem }()
+	case <-ctx.Done():
+		e.err = errors.New("query got cancelled while queued")
+		return
+	}
+	id, err := e.client.Start(ctx, e.Query)
+	if err != nil {
+		e.err = errors.Wrap(err, "could not start query execution")
+		return
+	}
+	if !e.submitted(id) {
+		e.err = errors.New("query got cancelled")
+		return
+	}
+	if e.queryExecution, e.err = e.client.wait(ctx, id); e.err != nil {
+		if ctx.Err() != nil && !e.isStopped() {
+			e.client.stopDetached(id)
+		}
+		return
+	}
+	var buf bytes.Buffer
+	e.err = e.client.Result(ctx, e.queryExecution, &buf)
+	e.result = buf.Bytes()
+}
+
+// submitted records the id of the query, and stops it right away when it
+// was cancelled while being submitted.
+func (e *Execution) submitted(id string) bool {
+	e.mu.Lock()
+	e.id = id
+	stopped := e.stopped
+	e.mu.Unlock()
+	if stopped {
+		e.client.stopDetached(id)
+	}
+	return !stopped
+}
+
+// isStopped reports whether Cancel stopped the query.
+func (e *Execution) isStopped() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.stopped
+}
+
+// Wait blocks until the query finished and returns its execution.
+func (e *Execution) Wait() (*athena.QueryExecution, error) {
+	<-e.done
+	return e.queryExecution, e.err
+}
+
+// Result waits for the query and returns its result as csv.
+func (e *Execution) Result() ([]byte, error) {
+	<-e.done
+	return e.result, e.err
+}
+
+// Cancel stops the query in athena, or keeps it from being submitted.
+func (e *Execution) Cancel() error {
+	select {
+	case <-e.done:
+		return nil
+	default:
+	}
+	e.mu.Lock()
+	e.stopped = true
+	id := e.id
+	e.mu.Unlock()
+	e.cancel()
+	if id == "" {
+		return nil
+	}
+	return e.client.Stop(context.Background(), id)
+}
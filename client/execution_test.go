@@ -0,0 +1,88 @@
+package client
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestSubmitBatch(t *testing.T) {
+	f := newFakeAthena()
+	defer f.Close()
+	f.result("select 1", "\"_col0\"\n\"1\"\n")
+	f.result("select 2", "\"_col0\"\n\"2\"\n")
+	c := f.client(WithConcurrency(2))
+	ctx, cancel := contextWithTimeout()
+	defer cancel()
+
+	executions := c.SubmitBatch(ctx, []Query{{SQL: "select 1"}, {SQL: "select 2"}, {SQL: "select * from missing"}})
+	for i, want := range []string{"\"_col0\"\n\"1\"\n", "\"_col0\"\n\"2\"\n"} {
+		result, err := executions[i].Result()
+		if err != nil || string(result) != want {
+			t.Errorf("query %d: got %q, %v", i+1, result, err)
+		}
+		if queryExecution, _ := executions[i].Wait(); aws.StringValue(queryExecution.Status.State) != "SUCCEEDED" {
+			t.Errorf("query %d: got state %s", i+1, aws.StringValue(queryExecution.Status.State))
+		}
+	}
+	if _, err := executions[2].Wait(); err == nil || !strings.Contains(err.Error(), "TABLE_NOT_FOUND") {
+		t.Errorf("got error %v, want TABLE_NOT_FOUND", err)
+	}
+	if err := executions[0].Cancel(); err != nil || f.count("StopQueryExecution") != 0 {
+		t.Errorf("cancelling a finished query: %v, %d StopQueryExecution calls", err, f.count("StopQueryExecution"))
+	}
+}
+
+func TestExecutionCancel(t *testing.T) {
+	f := newFakeAthena()
+	defer f.Close()
+	f.result("select 1", "\"_col0\"\n\"1\"\n")
+	f.queue("select 1")
+	c := f.client()
+	ctx, cancel := contextWithTimeout()
+	defer cancel()
+
+	e := c.SubmitBatch(ctx, []Query{{SQL: "select 1"}})[0]
+	for f.count("GetQueryExecution") == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := e.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Wait(); err == nil {
+		t.Error("no error for a cancelled query")
+	}
+	if n := f.count("StopQueryExecution"); n != 1 {
+		t.Errorf("%d StopQueryExecution calls, want 1", n)
+	}
+	if state := f.state("query-1"); state != "CANCELLED" {
+		t.Errorf("query is %s, want CANCELLED", state)
+	}
+}
+
+func TestSubmitBatchContextCancel(t *testing.T) {
+	f := newFakeAthena()
+	defer f.Close()
+	f.result("select 1", "\"_col0\"\n\"1\"\n")
+	f.queue("select 1")
+	c := f.client()
+	ctx, cancel := contextWithTimeout()
+	defer cancel()
+
+	e := c.SubmitBatch(ctx, []Query{{SQL: "select 1"}})[0]
+	for f.count("GetQueryExecution") == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if _, err := e.Wait(); err == nil {
+		t.Error("no error for a query of a cancelled context")
+	}
+	if n := f.count("StopQueryExecution"); n != 1 {
+		t.Errorf("%d StopQueryExecution calls, want 1", n)
+	}
+	if state := f.state("query-1"); state != "CANCELLED" {
+		t.Errorf("query is %s, want CANCELLED", state)
+	}
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// RateLimiter spaces api calls. Wait blocks until a call may be made and
+// fails when ctx is done first. A limiter may be shared by several clients,
+// e.g. of the services of an account, to keep them within its athena api
+// quota together.
+type RateLimiter interface {
+	Wait(ctx context.Context) error
+}
+
+// WithRateLimiter has the athena calls of the client, retries included,
+// wait for l.
+func WithRateLimiter(l RateLimiter) Option {
+	return func(c *Client) { c.limiter = l }
+}
+
+// TokenBucket is a RateLimiter spacing calls at a rate per second, with
+// bursts of up to rate calls.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a limiter of rate calls per second.
+func NewTokenBucket(rate float64) *TokenBucket {
+	burst := math.Max(rate, 1)
+	return &TokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve takes a token and returns how long to wait before using it.
+func (l *TokenBucket) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// Wait blocks until a call may be made, returning the token if ctx is done
+// first.
+func (l *TokenBucket) Wait(ctx context.Context) error {
+	wait := l.reserve(time.Now())
+	if wait == 0 {
+		return nil
+	}
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// limitCalls makes the calls of handlers wait for l before every attempt.
+func limitCalls(handlers *request.Handlers, l RateLimiter) {
+	handlers.Sign.PushFront(func(r *request.Request) {
+		if err := l.Wait(r.Context()); err != nil {
+			r.Error = awserr.New(request.CanceledErrorCode, "canceled waiting for the rate limit", err)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	l := NewTokenBucket(2)
+	l.last = now
+	for i, want := range []time.Duration{0, 0, 500 * time.Millisecond, time.Second} {
+		if got := l.reserve(now); got != want {
+			t.Errorf("call %d waits %v, want %v", i+1, got, want)
+		}
+	}
+	// the bucket refills at 2 tokens/s, the 2 calls waiting included.
+	if got := l.reserve(now.Add(2 * time.Second)); got != 0 {
+		t.Errorf("call after 2s waits %v, want 0", got)
+	}
+
+	slow := NewTokenBucket(0.1)
+	if err := slow.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := slow.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("wait = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if slow.tokens < -0.1 {
+		t.Errorf("token of the cancelled call not returned, %v tokens", slow.tokens)
+	}
+}
+
+// countingLimiter counts the calls waiting for it.
+type countingLimiter struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (l *countingLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls++
+	return nil
+}
+
+func TestWithRateLimiter(t *testing.T) {
+	f := newFakeAthena()
+	defer f.Close()
+	f.result("select 1", "\"_col0\"\n\"1\"\n")
+	ctx, cancel := contextWithTimeout()
+	defer cancel()
+
+	shared := &countingLimiter{}
+	for _, c := range []*Client{f.client(WithRateLimiter(shared)), f.client(WithRateLimiter(shared))} {
+		if _, err := c.Run(ctx, Query{SQL: "select 1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls := f.count("StartQueryExecution") + f.count("GetQueryExecution"); shared.calls != calls {
+		t.Errorf("%d calls waited for the shared limiter, want all %d athena calls", shared.calls, calls)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/private/protocol/jsonrpc"
+	"github.com/pkg/errors"
+)
+
+// cloudTrailClient is a minimal AWS CloudTrail client for looking up who
+// started queries, built like glueClient.
+type cloudTrailClient struct {
+	*client.Client
+}
+
+func newCloudTrail(p client.ConfigProvider, cfgs ...*aws.Config) *cloudTrailClient {
+	c := p.ClientConfig("cloudtrail", cfgs...)
+	svc := &cloudTrailClient{
+		Client: client.New(
+			*c.Config,
+			metadata.ClientInfo{
+				ServiceName:   "cloudtrail",
+				SigningName:   c.SigningName,
+				SigningRegion: c.SigningRegion,
+				Endpoint:      c.Endpoint,
+				APIVersion:    "2013-11-01",
+				JSONVersion:   "1.1",
+				TargetPrefix:  "com.amazonaws.cloudtrail.v20131101.CloudTrail_20131101",
+			},
+			c.Handlers,
+		),
+	}
+	svc.Handlers.Sign.PushBackNamed(v4.SignRequestHandler)
+	svc.Handlers.Build.PushBackNamed(jsonrpc.BuildHandler)
+	svc.Handlers.Unmarshal.PushBackNamed(jsonrpc.UnmarshalHandler)
+	svc.Handlers.UnmarshalMeta.PushBackNamed(jsonrpc.UnmarshalMetaHandler)
+	svc.Handlers.UnmarshalError.PushBackNamed(jsonrpc.UnmarshalErrorHandler)
+	return svc
+}
+
+type lookupAttribute struct {
+	AttributeKey   *string
+	AttributeValue *string
+}
+
+type cloudTrailEvent struct {
+	Username        *string
+	CloudTrailEvent *string
+}
+
+// queryCallers returns the principal that started each query execution since
+// a point in time, from the StartQueryExecution events of the last 90 days
+// cloudtrail keeps.
+func (c *cloudTrailClient) queryCallers(ctx context.Context, since time.Time) (map[string]string, error) {
+	callers := map[string]string{}
+	var next *string
+	for {
+		input := &struct {
+			LookupAttributes []*lookupAttribute
+			StartTime        *time.Time
+			NextToken        *string
+		}{
+			[]*lookupAttribute{{aws.String("EventName"), aws.String("StartQueryExecution")}},
+			aws.Time(since),
+			next,
+		}
+		out := struct {
+			Events    []*cloudTrailEvent
+			NextToken *string
+		}{}
+		req := c.NewRequest(&request.Operation{Name: "LookupEvents", HTTPMethod: "POST", HTTPPath: "/"}, input, &out)
+		req.SetContext(ctx)
+		if err := req.Send(); err != nil {
+			return nil, errors.Wrap(err, "could not look up cloudtrail events")
+		}
+		for _, e := range out.Events {
+			id, caller := eventCaller(e)
+			if id != "" {
+				callers[id] = caller
+			}
+		}
+		if out.NextToken == nil {
+			return callers, nil
+		}
+		next = out.NextToken
+	}
+}
+
+// eventCaller returns the query execution id a StartQueryExecution event
+// started and the arn of its caller.
+func eventCaller(e *cloudTrailEvent) (string, string) {
+	var event struct {
+		UserIdentity struct {
+			ARN string `json:"arn"`
+		} `json:"userIdentity"`
+		ResponseElements struct {
+			QueryExecutionID string `json:"queryExecutionId"`
+		} `json:"responseElements"`
+	}
+	if json.Unmarshal([]byte(aws.StringValue(e.CloudTrailEvent)), &event) != nil {
+		return "", ""
+	}
+	caller := event.UserIdentity.ARN
+	if caller == "" {
+		caller = aws.StringValue(e.Username)
+	}
+	return event.ResponseElements.QueryExecutionID, caller
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+)
+
+// queryColumns runs query without rows to get the columns of its result.
+func (awsCli *awsCli) queryColumns(ctx context.Context, query string) ([]columnMeta, error) {
+	switch firstKeyword(tokenize(query)) {
+	case "SELECT", "WITH", "VALUES":
+	default:
+		return nil, errors.New("the query must be a SELECT")
+	}
+	queryExecution, err := awsCli.execQuery(ctx, "SELECT * FROM (\n"+query+"\n) LIMIT 0", nil)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := awsCli.resultColumns(ctx, aws.StringValue(queryExecution.QueryExecutionId))
+	return columns, errors.Wrap(err, "could not get result columns")
+}
+
+// emitSchema returns the type definition of a result with columns. Arrays,
+// maps and rows are strings, as athena writes them to csv results.
+func emitSchema(emit, name string, columns []columnMeta) ([]byte, error) {
+	switch emit {
+	case "go":
+		return goStruct(name, columns)
+	case "jsonschema":
+		return jsonIndent(jsonSchema(name, columns))
+	case "avro":
+		return jsonIndent(avroSchema(name, columns))
+	default:
+		return nil, fmt.Errorf("unknown schema %q", emit)
+	}
+}
+
+func jsonIndent(v interface{}) ([]byte, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	return append(data, '\n'), err
+}
+
+func nullable(c columnMeta) bool {
+	return c.Nullable != "NOT_NULL"
+}
+
+// baseType strips the parameters of a type, e.g. decimal(10,2) or
+// varchar(16).
+func baseType(t string) string {
+	if i := strings.IndexAny(t, "(<"); i >= 0 {
+		t = t[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
+func goStruct(name string, columns []columnMeta) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "type %s struct {\n", identifier(name, true))
+	usesTime := false
+	for _, c := range columns {
+		t := "string"
+		switch baseType(c.Type) {
+		case "boolean":
+			t = "bool"
+		case "tinyint":
+			t = "int8"
+		case "smallint":
+			t = "int16"
+		case "integer", "int":
+			t = "int32"
+		case "bigint":
+			t = "int64"
+		case "real", "float":
+			t = "float32"
+		case "double":
+			t = "float64"
+		case "varbinary":
+			t = "[]byte"
+		case "date", "timestamp", "timestamp with time zone":
+			t = "time.Time"
+			usesTime = true
+		}
+		if nullable(c) && t != "[]byte" {
+			t = "*" + t
+		}
+		fmt.Fprintf(&buf, "%s %s `json:%q`\n", identifier(c.Name, true), t, c.Name)
+	}
+	buf.WriteString("}\n")
+	src := buf.String()
+	if usesTime {
+		src = "import \"time\"\n\n" + src
+	}
+	out, err := format.Source([]byte(src))
+	return out, errors.Wrap(err, "could not format go struct")
+}
+
+// identifier turns a column name into a go (exported) or avro name.
+func identifier(name string, exported bool) string {
+	var b strings.Builder
+	upper := exported
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if !exported {
+				b.WriteRune('_')
+			}
+			upper = exported
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	s := b.String()
+	if s == "" || unicode.IsDigit(rune(s[0])) {
+		s = "X" + s
+	}
+	return s
+}
+
+func jsonSchema(name string, columns []columnMeta) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for _, c := range columns {
+		p := map[string]interface{}{}
+		t := "string"
+		switch baseType(c.Type) {
+		case "boolean":
+			t = "boolean"
+		case "tinyint", "smallint", "integer", "int", "bigint":
+			t = "integer"
+		case "real", "float", "double":
+			t = "number"
+		case "date":
+			p["format"] = "date"
+		case "timestamp", "timestamp with time zone":
+			p["format"] = "date-time"
+		case "varbinary":
+			p["contentEncoding"] = "base64"
+		}
+		p["type"] = t
+		if nullable(c) {
+			p["type"] = []string{t, "null"}
+		} else {
+			required = append(required, c.Name)
+		}
+		properties[c.Name] = p
+	}
+	return map[string]interface{}{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"title":                name,
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+func avroSchema(name string, columns []columnMeta) map[string]interface{} {
+	fields := []map[string]interface{}{}
+	for _, c := range columns {
+		var t interface{} = "string"
+		switch baseType(c.Type) {
+		case "boolean":
+			t = "boolean"
+		case "tinyint", "smallint", "integer", "int":
+			t = "int"
+		case "bigint":
+			t = "long"
+		case "real", "float":
+			t = "float"
+		case "double":
+			t = "double"
+		case "varbinary":
+			t = "bytes"
+		case "date":
+			t = map[string]interface{}{"type": "int", "logicalType": "date"}
+		case "timestamp", "timestamp with time zone":
+			t = map[string]interface{}{"type": "long", "logicalType": "timestamp-millis"}
+		case "decimal":
+			t = map[string]interface{}{"type": "bytes", "logicalType": "decimal", "precision": c.Precision, "scale": c.Scale}
+		}
+		field := map[string]interface{}{"name": identifier(c.Name, false), "type": t}
+		if nullable(c) {
+			field["type"] = []interface{}{"null", t}
+			field["default"] = nil
+		}
+		fields = append(fields, field)
+	}
+	return map[string]interface{}{
+		"type":   "record",
+		"name":   identifier(name, false),
+		"fields": fields,
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+var codegenColumns = []columnMeta{
+	{Name: "user_id", Type: "bigint", Nullable: "NOT_NULL"},
+	{Name: "name", Type: "varchar", Nullable: "UNKNOWN"},
+	{Name: "signed up", Type: "timestamp", Nullable: "NULLABLE"},
+	{Name: "amount", Type: "decimal", Nullable: "UNKNOWN", Precision: 10, Scale: 2},
+	{Name: "tags", Type: "array", Nullable: "NOT_NULL"},
+}
+
+func TestEmitGo(t *testing.T) {
+	got, err := emitSchema("go", "user", codegenColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `import "time"
+
+type User struct {
+	UserId   int64      ` + "`json:\"user_id\"`" + `
+	Name     *string    ` + "`json:\"name\"`" + `
+	SignedUp *time.Time ` + "`json:\"signed up\"`" + `
+	Amount   *string    ` + "`json:\"amount\"`" + `
+	Tags     string     ` + "`json:\"tags\"`" + `
+}
+`
+	if string(got) != want {
+		t.Errorf("emitSchema(go) = %s, want %s", got, want)
+	}
+}
+
+func TestEmitJSON(t *testing.T) {
+	for _, tt := range []struct {
+		emit string
+		path []string
+		want interface{}
+	}{
+		{"jsonschema", []string{"properties", "user_id", "type"}, "integer"},
+		{"jsonschema", []string{"properties", "name", "type"}, []interface{}{"string", "null"}},
+		{"jsonschema", []string{"properties", "signed up", "format"}, "date-time"},
+		{"jsonschema", []string{"required"}, []interface{}{"user_id", "tags"}},
+		{"avro", []string{"name"}, "user"},
+		{"avro", []string{"fields", "0"}, map[string]interface{}{"name": "user_id", "type": "long"}},
+		{"avro", []string{"fields", "2", "name"}, "signed_up"},
+		{"avro", []string{"fields", "3", "type", "1", "precision"}, 10.0},
+		{"avro", []string{"fields", "3", "default"}, nil},
+	} {
+		out, err := emitSchema(tt.emit, "user", codegenColumns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v interface{}
+		if err := json.Unmarshal(out, &v); err != nil {
+			t.Fatalf("%s: %v", tt.emit, err)
+		}
+		for _, p := range tt.path {
+			switch x := v.(type) {
+			case map[string]interface{}:
+				v = x[p]
+			case []interface{}:
+				var i int
+				json.Unmarshal([]byte(p), &i)
+				v = x[i]
+			}
+		}
+		if !reflect.DeepEqual(v, tt.want) {
+			t.Errorf("%s %v = %#v, want %#v", tt.emit, tt.path, v, tt.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// columnList returns the columns of a comma separated list.
+func columnList(s string) []string {
+	var columns []string
+	for _, c := range strings.Split(s, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
+
+// csvFields splits a csv row into its raw fields, quotes included, so that
+// empty (NULL) and quoted empty fields stay apart.
+func csvFields(row []byte) [][]byte {
+	row = trimNewline(row)
+	var fields [][]byte
+	quoted, start := false, 0
+	for i, b := range row {
+		switch {
+		case b == '"':
+			quoted = !quoted
+		case b == ',' && !quoted:
+			fields = append(fields, row[start:i])
+			start = i + 1
+		}
+	}
+	return append(fields, row[start:])
+}
+
+func trimNewline(row []byte) []byte {
+	return bytes.TrimSuffix(bytes.TrimSuffix(row, []byte("\n")), []byte("\r"))
+}
+
+func unquoteField(field []byte) string {
+	s := string(field)
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = strings.Replace(s[1:len(s)-1], `""`, `"`, -1)
+	}
+	return s
+}
+
+// columnsWriter writes the columns of a csv result in the order of names.
+type columnsWriter struct {
+	w       io.Writer
+	names   []string
+	indices []int
+	split   rowSplitter
+}
+
+func (c *columnsWriter) Write(p []byte) (int, error) {
+	return len(p), c.split.write(p, c.writeRow)
+}
+
+func (c *columnsWriter) writeRow(row []byte) error {
+	fields := csvFields(row)
+	if c.indices == nil {
+		indices, err := columnIndices(fields, c.names)
+		if err != nil {
+			return err
+		}
+		c.indices = indices
+	}
+	var buf bytes.Buffer
+	for n, i := range c.indices {
+		if n > 0 {
+			buf.WriteByte(',')
+		}
+		if i < len(fields) {
+			buf.Write(fields[i])
+		}
+	}
+	if bytes.HasSuffix(row, []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+	_, err := c.w.Write(buf.Bytes())
+	return err
+}
+
+// flush writes a last row without a trailing newline.
+func (c *columnsWriter) flush() error {
+	if len(c.split.row) == 0 {
+		return nil
+	}
+	defer func() { c.split.row = nil }()
+	return c.writeRow(c.split.row)
+}
+
+// columnIndices returns the positions of names in the header, matching
+// case insensitively as athena lower cases column names.
+func columnIndices(header [][]byte, names []string) ([]int, error) {
+	var columns []string
+	for _, f := range header {
+		columns = append(columns, unquoteField(f))
+	}
+	indices := make([]int, len(names))
+	for n, name := range names {
+		indices[n] = -1
+		for i, column := range columns {
+			if column == name || indices[n] < 0 && strings.EqualFold(column, name) {
+				indices[n] = i
+			}
+		}
+		if indices[n] < 0 {
+			return nil, fmt.Errorf("unknown column %q, the result has %s", name, strings.Join(columns, ", "))
+		}
+	}
+	return indices, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestColumnsWriter(t *testing.T) {
+	csv := "\"id\",\"Name\",\"note\"\n\"1\",\"a\",\"x, \"\"y\"\"\"\n\"2\",,\"two\nlines\"\n\"3\",\"\",\"z\""
+	tests := []struct {
+		names []string
+		want  string
+		err   bool
+	}{
+		{names: []string{"note", "id"}, want: "\"note\",\"id\"\n\"x, \"\"y\"\"\",\"1\"\n\"two\nlines\",\"2\"\n\"z\",\"3\""},
+		{names: []string{"name"}, want: "\"Name\"\n\"a\"\n\n\"\""},
+		{names: []string{"id", "id"}, want: "\"id\",\"id\"\n\"1\",\"1\"\n\"2\",\"2\"\n\"3\",\"3\""},
+		{names: []string{"missing"}, err: true},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		cw := &columnsWriter{w: &buf, names: tt.names}
+		_, err := cw.Write([]byte(csv))
+		if err == nil {
+			err = cw.flush()
+		}
+		if (err != nil) != tt.err {
+			t.Errorf("%q: got error %v", tt.names, err)
+			continue
+		}
+		if err == nil && buf.String() != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.names, buf.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/pkg/errors"
+)
+
+// the completion command lists the other commands, so it can't be part of
+// the initialization of commands.
+func init() {
+	commands["completion"] = completionCmd
+}
+
+var completionScripts = map[string]string{
+	"bash": `_athenaq() {
+	local IFS=$'\n'
+	COMPREPLY=($(athenaq completion -complete -- "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null | cut -f1))
+	if [[ ${#COMPREPLY[@]} == 1 && ${COMPREPLY[0]} == *. ]]; then
+		compopt -o nospace
+	fi
+}
+complete -o default -F _athenaq athenaq
+`,
+	"zsh": `#compdef athenaq
+_athenaq() {
+	local -a candidates
+	candidates=(${(f)"$(athenaq completion -complete -- "${(@)words[2,CURRENT]}" 2>/dev/null | sed 's/:/\\:/; s/	/:/')"})
+	if (( ! $#candidates )); then
+		_files
+		return
+	fi
+	_describe athenaq candidates
+}
+compdef _athenaq athenaq
+`,
+	"fish": `complete -c athenaq -a '(athenaq completion -complete -- (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null)'
+`,
+}
+
+func completionCmd(args []string) error {
+	fs := flag.NewFlagSet("completion", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: athenaq completion [flags] <bash|zsh|fish>")
+		fs.PrintDefaults()
+	}
+	words := fs.Bool("complete", false, "print the completions of the words after 'athenaq --', the last one is completed, one per line with a tab separated description")
+	addLogFlags(fs)
+	fs.Parse(args)
+	if *words {
+		for _, c := range completeLive(fs.Args()) {
+			if c.help == "" {
+				fmt.Println(c.word)
+				continue
+			}
+			fmt.Printf("%s\t%s\n", c.word, c.help)
+		}
+		return nil
+	}
+	script, ok := completionScripts[fs.Arg(0)]
+	if fs.NArg() != 1 || !ok {
+		fs.Usage()
+		os.Exit(2)
+	}
+	fmt.Print(script)
+	return nil
+}
+
+type candidate struct {
+	word string
+	help string
+}
+
+// commandHelp is what completion knows about a command from its -h output.
+type commandHelp struct {
+	verbs   []candidate
+	tables  bool
+	flags   []candidate
+	values  map[string]bool
+	choices map[string][]string
+}
+
+var (
+	choicesRe = regexp.MustCompile(`\(([\w.-]+(?:\|[\w.-]+)+)\)`)
+	verbRe    = regexp.MustCompile(`^[a-z]+$`)
+)
+
+// parseHelp parses the usage lines and flag defaults a command prints.
+func parseHelp(command, usage string) *commandHelp {
+	h := &commandHelp{values: map[string]bool{}, choices: map[string][]string{}}
+	prefix := strings.TrimSpace("athenaq " + command + " [flags]")
+	lines := strings.Split(usage, "\n")
+	for i, line := range lines {
+		if j := strings.Index(line, prefix); j >= 0 && command != "" {
+			args := strings.Fields(line[j+len(prefix):])
+			if len(args) > 0 {
+				verb := args[0]
+				if strings.HasPrefix(verb, "<") && strings.Contains(verb, "|") {
+					for _, v := range strings.Split(strings.Trim(verb, "<>"), "|") {
+						h.verbs = append(h.verbs, candidate{word: v})
+					}
+				} else if verbRe.MatchString(verb) {
+					h.verbs = append(h.verbs, candidate{word: verb})
+				}
+			}
+			h.tables = h.tables || strings.Contains(line, "db.table")
+			continue
+		}
+		if !strings.HasPrefix(line, "  -") {
+			continue
+		}
+		// flags with a one letter name and no value have their usage on
+		// the same line, others on the next one.
+		def, help := line, ""
+		if k := strings.Index(line, "\t"); k >= 0 {
+			def, help = line[:k], line[k+1:]
+		} else if i+1 < len(lines) {
+			help = strings.TrimSpace(lines[i+1])
+		}
+		fields := strings.Fields(def)
+		name := strings.TrimPrefix(fields[0], "-")
+		h.flags = append(h.flags, candidate{word: "-" + name, help: help})
+		h.values[name] = len(fields) > 1
+		if m := choicesRe.FindAllStringSubmatch(help, -1); m != nil {
+			h.choices[name] = strings.Split(m[len(m)-1][1], "|")
+		}
+	}
+	return h
+}
+
+// complete returns the completions of the last of the words after athenaq.
+// help returns the help of a command, values the candidates of a kind of
+// value (workgroups, catalogs, databases, tables and regions).
+func complete(words []string, help func(command string) *commandHelp, values func(kind, prefix string) []candidate) []candidate {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	args, cur := words[:len(words)-1], words[len(words)-1]
+	command := ""
+	if len(args) > 0 {
+		if _, ok := commands[args[0]]; ok {
+			command, args = args[0], args[1:]
+		}
+	}
+	h := help(command)
+	var positional []string
+	pending := ""
+	for i := 0; i < len(args); i++ {
+		name := flagName(args[i])
+		switch {
+		case name == "":
+			positional = append(positional, args[i])
+		case h.values[name] && !strings.Contains(args[i], "="):
+			if i+1 == len(args) {
+				pending = name
+			}
+			i++
+		}
+	}
+
+	var candidates []candidate
+	prefix := cur
+	switch {
+	case pending != "":
+		candidates = flagValues(h, pending, cur, values)
+	case strings.HasPrefix(cur, "-") && strings.Contains(cur, "="):
+		eq := strings.Index(cur, "=")
+		for _, c := range flagValues(h, flagName(cur), cur[eq+1:], values) {
+			candidates = append(candidates, candidate{word: cur[:eq+1] + c.word, help: c.help})
+		}
+	case strings.HasPrefix(cur, "-"):
+		candidates, prefix = h.flags, "-"+strings.TrimLeft(cur, "-")
+	case command == "" && len(args) == 0:
+		for name := range commands {
+			candidates = append(candidates, candidate{word: name})
+		}
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].word < candidates[j].word })
+	case len(positional) == 0 && len(h.verbs) > 0:
+		candidates = h.verbs
+	case h.tables:
+		candidates = values("tables", cur)
+	}
+	var matching []candidate
+	for _, c := range candidates {
+		if strings.HasPrefix(c.word, prefix) {
+			matching = append(matching, c)
+		}
+	}
+	return matching
+}
+
+var valueKinds = map[string]string{
+	"workgroup": "workgroups",
+	"catalog":   "catalogs",
+	"database":  "databases",
+	"region":    "regions",
+}
+
+func flagValues(h *commandHelp, name, prefix string, values func(kind, prefix string) []candidate) []candidate {
+	if kind, ok := valueKinds[name]; ok {
+		return values(kind, prefix)
+	}
+	var candidates []candidate
+	for _, c := range h.choices[name] {
+		candidates = append(candidates, candidate{word: c})
+	}
+	return candidates
+}
+
+// flagName returns the name of a -flag or -flag=value argument, "" for
+// other arguments.
+func flagName(arg string) string {
+	if !strings.HasPrefix(arg, "-") || arg == "-" || arg == "--" {
+		return ""
+	}
+	name := strings.TrimLeft(arg, "-")
+	if i := strings.Index(name, "="); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// flagValue returns the value of a flag among the words, "" if it is not set.
+func flagValue(words []string, name string) string {
+	for i, w := range words {
+		if flagName(w) != name {
+			continue
+		}
+		if j := strings.Index(w, "="); j >= 0 {
+			return w[j+1:]
+		}
+		if i+1 < len(words) {
+			return words[i+1]
+		}
+	}
+	return ""
+}
+
+// completeLive completes the words with the help athenaq prints and the
+// workgroups, catalogs, databases and tables of the account. Errors are
+// only logged, they must not end up in the shell.
+func completeLive(words []string) []candidate {
+	help := func(command string) *commandHelp {
+		args := []string{"-h"}
+		if command != "" {
+			args = []string{command, "-h"}
+		}
+		exe, err := os.Executable()
+		if err != nil {
+			debugf("completion: %v", err)
+			return parseHelp(command, "")
+		}
+		// -h exits with status 2 or 0 depending on the go version.
+		out, _ := exec.Command(exe, args...).CombinedOutput()
+		return parseHelp(command, string(out))
+	}
+	values := func(kind, prefix string) []candidate {
+		if kind == "regions" {
+			return athenaRegions()
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		sess, err := newSession(flagValue(words, "region"))
+		if err != nil {
+			debugf("completion: %v", err)
+			return nil
+		}
+		catalog := flagValue(words, "catalog")
+		if catalog == "" {
+			catalog = "AwsDataCatalog"
+		}
+		ttl := catalogCacheTTL
+		if v := flagValue(words, "catalog-cache-ttl"); v != "" {
+			if d, err := time.ParseDuration(v); err == nil {
+				ttl = d
+			}
+		}
+		cache := newCatalogCache(ttl)
+		cacheKey := kind + "/" + aws.StringValue(sess.Config.Region) + "/" + catalog
+		if i := strings.Index(prefix, "."); kind == "tables" && i >= 0 {
+			cacheKey += "/" + prefix[:i]
+		}
+		if e, ok := cache.get(cacheKey); ok {
+			candidates := make([]candidate, len(e.Values))
+			for i, word := range e.Values {
+				candidates[i].word = word
+				if i < len(e.Help) {
+					candidates[i].help = e.Help[i]
+				}
+			}
+			return candidates
+		}
+		candidates, err := listValues(ctx, athena.New(sess), catalog, kind, prefix)
+		if err != nil {
+			debugf("completion: %v", err)
+			return candidates
+		}
+		e := catalogCacheEntry{}
+		for _, c := range candidates {
+			e.Values, e.Help = append(e.Values, c.word), append(e.Help, c.help)
+		}
+		cache.put(cacheKey, e)
+		return candidates
+	}
+	return complete(words, help, values)
+}
+
+func athenaRegions() []candidate {
+	var candidates []candidate
+	for id, r := range endpoints.AwsPartition().Regions() {
+		if _, ok := r.Services()["athena"]; ok {
+			candidates = append(candidates, candidate{word: id})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].word < candidates[j].word })
+	return candidates
+}
+
+// listValues lists the workgroups, data catalogs, databases of a catalog or
+// tables of a database as completion candidates. Tables are completed as
+// db.table once a database followed by a dot was typed, otherwise as the
+// databases they are in.
+func listValues(ctx context.Context, svc *athena.Athena, catalog, kind, prefix string) ([]candidate, error) {
+	var candidates []candidate
+	var next *string
+	for {
+		var err error
+		var token *string
+		switch {
+		case kind == "workgroups":
+			out := struct {
+				WorkGroups []*struct{ Name, Description *string }
+				NextToken  *string
+			}{}
+			err = sendAthena(ctx, svc, "ListWorkGroups", &struct{ NextToken *string }{next}, &out)
+			for _, w := range out.WorkGroups {
+				candidates = append(candidates, candidate{word: aws.StringValue(w.Name), help: aws.StringValue(w.Description)})
+			}
+			token = out.NextToken
+		case kind == "catalogs":
+			out := struct {
+				DataCatalogsSummary []*dataCatalogSummary
+				NextToken           *string
+			}{}
+			err = sendAthena(ctx, svc, "ListDataCatalogs", &struct{ NextToken *string }{next}, &out)
+			for _, c := range out.DataCatalogsSummary {
+				candidates = append(candidates, candidate{word: aws.StringValue(c.CatalogName), help: aws.StringValue(c.Type)})
+			}
+			token = out.NextToken
+		case kind == "databases" || kind == "tables" && !strings.Contains(prefix, "."):
+			out := struct {
+				DatabaseList []*struct{ Name, Description *string }
+				NextToken    *string
+			}{}
+			err = sendAthena(ctx, svc, "ListDatabases", &struct{ CatalogName, NextToken *string }{&catalog, next}, &out)
+			for _, d := range out.DatabaseList {
+				c := candidate{word: aws.StringValue(d.Name), help: aws.StringValue(d.Description)}
+				if kind == "tables" {
+					c.word += "."
+				}
+				candidates = append(candidates, c)
+			}
+			token = out.NextToken
+		case kind == "tables":
+			database := prefix[:strings.Index(prefix, ".")]
+			out := struct {
+				TableMetadataList []*struct{ Name, TableType *string }
+				NextToken         *string
+			}{}
+			err = sendAthena(ctx, svc, "ListTableMetadata", &struct{ CatalogName, DatabaseName, NextToken *string }{&catalog, &database, next}, &out)
+			for _, t := range out.TableMetadataList {
+				candidates = append(candidates, candidate{word: database + "." + aws.StringValue(t.Name), help: aws.StringValue(t.TableType)})
+			}
+			token = out.NextToken
+		default:
+			return nil, fmt.Errorf("unknown kind of value %q", kind)
+		}
+		if err != nil {
+			return candidates, errors.Wrapf(err, "could not list %s", kind)
+		}
+		if token == nil {
+			return candidates, nil
+		}
+		next = token
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const schemaHelp = `Usage: athenaq schema [flags] sync db.table schema.json
+       athenaq schema [flags] diff <db|db.table> <db|db.table>
+  -dry
+    	sync: print the ALTER statements instead of executing them
+  -emit value
+    	of: emit a go struct, a json schema or an avro record schema of the result (go|jsonschema|avro)
+  -f string
+    	of: input file with the query
+  -q	quiet
+  -workgroup string
+    	athena workgroup ("" == primary)
+`
+
+func TestParseHelp(t *testing.T) {
+	h := parseHelp("schema", schemaHelp)
+	if want := []candidate{{word: "sync"}, {word: "diff"}}; !reflect.DeepEqual(h.verbs, want) {
+		t.Errorf("got verbs %v, want %v", h.verbs, want)
+	}
+	if !h.tables {
+		t.Error("got no table arguments")
+	}
+	want := map[string]bool{"dry": false, "emit": true, "f": true, "q": false, "workgroup": true}
+	if !reflect.DeepEqual(h.values, want) {
+		t.Errorf("got flags taking values %v, want %v", h.values, want)
+	}
+	if got := h.flags[3]; got != (candidate{"-q", "quiet"}) {
+		t.Errorf("got flag %v", got)
+	}
+	if got := h.choices["emit"]; !reflect.DeepEqual(got, []string{"go", "jsonschema", "avro"}) {
+		t.Errorf("got choices %v", got)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	help := func(command string) *commandHelp {
+		if command == "schema" {
+			return parseHelp(command, schemaHelp)
+		}
+		return parseHelp(command, "  -out string\n    \toutput path\n  -workgroup string\n    \tathena workgroup\n")
+	}
+	values := func(kind, prefix string) []candidate {
+		switch kind {
+		case "workgroups":
+			return []candidate{{word: "primary"}, {word: "reporting"}}
+		case "tables":
+			return []candidate{{word: "sales.orders"}, {word: "sales.items"}}
+		}
+		return nil
+	}
+	tests := []struct {
+		words []string
+		want  []string
+	}{
+		{words: []string{"sch"}, want: []string{"schema"}},
+		{words: []string{"-wo"}, want: []string{"-workgroup"}},
+		{words: []string{"--o"}, want: []string{"-out"}},
+		{words: []string{"-workgroup", "rep"}, want: []string{"reporting"}},
+		{words: []string{"-workgroup=p"}, want: []string{"-workgroup=primary"}},
+		{words: []string{"-out", ""}, want: nil},
+		{words: []string{"schema", "-dry", ""}, want: []string{"sync", "diff"}},
+		{words: []string{"schema", "-f", "q.sql", "sync", "sales.o"}, want: []string{"sales.orders"}},
+		{words: []string{"schema", "-emit", "a"}, want: []string{"avro"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, c := range complete(tt.words, help, values) {
+			got = append(got, c.word)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.words, got, tt.want)
+		}
+	}
+}
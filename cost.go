@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+)
+
+const terabyte = 1 << 40
+
+// costRow is the cost of the queries of a day, user or query fingerprint.
+type costRow struct {
+	Group            string  `json:"group"`
+	Query            string  `json:"query,omitempty"`
+	Queries          int     `json:"queries"`
+	DataScannedBytes int64   `json:"data_scanned_bytes"`
+	BilledBytes      int64   `json:"billed_bytes"`
+	CostUSD          float64 `json:"cost_usd"`
+	// Recommendations are set for -top queries.
+	Recommendations []string `json:"recommendations,omitempty"`
+	example         *historyExecution
+}
+
+func costCmd(args []string) error {
+	fs := flag.NewFlagSet("cost", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: athenaq cost [flags]")
+		fs.PrintDefaults()
+	}
+	var (
+		awsFlags   = addAWSFlags(fs)
+		since      = fs.String("since", "30d", "report queries submitted since a duration ago (30d, 12h) or a date (2024-01-01)")
+		pricePerTB = fs.Float64("price-per-tb", 5, "price in USD per TB scanned")
+		groupBy    = &choiceFlag{value: "day", choices: []string{"day", "user", "query"}}
+		format     = &choiceFlag{value: "table", choices: []string{"table", "csv", "json"}}
+		top        = fs.Int("top", 0, "report the n most expensive queries that ran more than once, with recommendations, instead (implies -group-by query)")
+	)
+	fs.Var(groupBy, "group-by", "aggregate by day, user (from cloudtrail) or query fingerprint (day|user|query)")
+	fs.Var(format, "format", "output format (table|csv|json)")
+	addCatalogCacheFlag(fs)
+	fs.Parse(args)
+	if fs.NArg() != 0 || *top < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	from, err := parseSince(*since, time.Now())
+	if err != nil {
+		return err
+	}
+	awsCli, err := newAWS(awsFlags)
+	if err != nil {
+		return errors.Wrap(err, "could not initialize aws client")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *awsFlags.timeout)
+	defer cancel()
+
+	executions, err := queryHistory(ctx, awsCli.athena, awsCli.workGroup, from)
+	if err != nil {
+		return err
+	}
+	if *top > 0 {
+		groupBy.value = "query"
+	}
+	var callers map[string]string
+	if groupBy.value == "user" {
+		if callers, err = newCloudTrail(awsCli.session).queryCallers(ctx, from); err != nil {
+			return err
+		}
+	}
+	rows := costReport(executions, groupBy.value, callers, *pricePerTB)
+	if *top > 0 {
+		rows = topQueries(rows, *top)
+		a := newAdvisor(awsCli.glue)
+		for _, r := range rows {
+			if r.Recommendations, err = a.recommend(ctx, r.example); err != nil {
+				return errors.Wrap(err, "could not analyze query")
+			}
+			if r.Recommendations == nil {
+				r.Recommendations = []string{}
+			}
+		}
+	}
+	return writeCostReport(os.Stdout, rows, groupBy.value, format.value, *top > 0)
+}
+
+// costReport aggregates the billed bytes of executions. Days are in order,
+// users and queries the most expensive first.
+func costReport(executions []*historyExecution, groupBy string, callers map[string]string, pricePerTB float64) []*costRow {
+	groups := map[string]*costRow{}
+	var rows []*costRow
+	for _, e := range executions {
+		var group, query string
+		switch groupBy {
+		case "day":
+			group = e.submitted().UTC().Format("2006-01-02")
+		case "user":
+			if group = callers[aws.StringValue(e.QueryExecutionId)]; group == "" {
+				group = "unknown"
+			}
+		case "query":
+			group = fingerprint(aws.StringValue(e.Query))[:16]
+			query = strings.Join(strings.Fields(aws.StringValue(e.Query)), " ")
+		}
+		r := groups[group]
+		if r == nil {
+			r = &costRow{Group: group, Query: query, example: e}
+			groups[group] = r
+			rows = append(rows, r)
+		}
+		r.Queries++
+		r.DataScannedBytes += e.dataScanned()
+		r.BilledBytes += e.billedBytes()
+	}
+	for _, r := range rows {
+		r.CostUSD = float64(r.BilledBytes) / terabyte * pricePerTB
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if groupBy != "day" && rows[i].CostUSD != rows[j].CostUSD {
+			return rows[i].CostUSD > rows[j].CostUSD
+		}
+		return rows[i].Group < rows[j].Group
+	})
+	return rows
+}
+
+func writeCostReport(w io.Writer, rows []*costRow, groupBy, format string, recommendations bool) error {
+	switch format {
+	case "json":
+		if rows == nil {
+			rows = []*costRow{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	case "csv":
+		cw := csv.NewWriter(w)
+		header := []string{groupBy, "query", "queries", "data_scanned_bytes", "billed_bytes", "cost_usd"}
+		if recommendations {
+			header = append(header, "recommendations")
+		}
+		cw.Write(header)
+		for _, r := range rows {
+			record := []string{r.Group, r.Query, strconv.Itoa(r.Queries), strconv.FormatInt(r.DataScannedBytes, 10),
+				strconv.FormatInt(r.BilledBytes, 10), strconv.FormatFloat(r.CostUSD, 'f', 4, 64)}
+			if recommendations {
+				record = append(record, strings.Join(r.Recommendations, "; "))
+			}
+			cw.Write(record)
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tQUERIES\tSCANNED\tBILLED\tCOST\n", strings.ToUpper(groupBy))
+	var total costRow
+	for _, r := range rows {
+		group := r.Group
+		if r.Query != "" {
+			group += "  " + abbreviate(r.Query, 60)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t$%.2f\n", group, r.Queries, formatBytes(r.DataScannedBytes), formatBytes(r.BilledBytes), r.CostUSD)
+		// lines without cells don't change the width of the columns
+		for _, rec := range r.Recommendations {
+			fmt.Fprintf(tw, "    - %s\n", rec)
+		}
+		total.Queries += r.Queries
+		total.DataScannedBytes += r.DataScannedBytes
+		total.BilledBytes += r.BilledBytes
+		total.CostUSD += r.CostUSD
+	}
+	fmt.Fprintf(tw, "TOTAL\t%d\t%s\t%s\t$%.2f\n", total.Queries, formatBytes(total.DataScannedBytes), formatBytes(total.BilledBytes), total.CostUSD)
+	return tw.Flush()
+}
+
+func abbreviate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
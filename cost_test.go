@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func execution(id, query, state, statementType string, submitted time.Time, scanned int64) *historyExecution {
+	return &historyExecution{
+		QueryExecutionId: aws.String(id),
+		Query:            aws.String(query),
+		StatementType:    aws.String(statementType),
+		Status:           &historyStatus{State: aws.String(state), SubmissionDateTime: aws.Time(submitted)},
+		Statistics:       &historyStatistics{DataScannedInBytes: aws.Int64(scanned)},
+	}
+}
+
+func TestBilledBytes(t *testing.T) {
+	now := time.Now()
+	for _, tt := range []struct {
+		state, statementType string
+		scanned, want        int64
+	}{
+		{"SUCCEEDED", "DML", 0, 10 << 20},
+		{"SUCCEEDED", "DML", 11<<20 + 1, 12 << 20},
+		{"SUCCEEDED", "DDL", 1 << 30, 0},
+		{"FAILED", "DML", 1 << 30, 0},
+		{"CANCELLED", "DML", 0, 0},
+		{"CANCELLED", "DML", 1, 10 << 20},
+	} {
+		e := execution("1", "select 1", tt.state, tt.statementType, now, tt.scanned)
+		if got := e.billedBytes(); got != tt.want {
+			t.Errorf("billedBytes(%s %s %d) = %d, want %d", tt.state, tt.statementType, tt.scanned, got, tt.want)
+		}
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		since string
+		want  time.Time
+	}{
+		{"30d", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{"12h", time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{"2024-01-01", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"-1d", time.Time{}},
+		{"last month", time.Time{}},
+	} {
+		got, err := parseSince(tt.since, now)
+		if !got.Equal(tt.want) || (err != nil) != tt.want.IsZero() {
+			t.Errorf("parseSince(%q) = %v, %v, want %v", tt.since, got, err, tt.want)
+		}
+	}
+}
+
+func TestCostReport(t *testing.T) {
+	day1 := time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC)
+	day2 := time.Date(2024, 3, 2, 1, 0, 0, 0, time.UTC)
+	executions := []*historyExecution{
+		execution("a", "select * from logs", "SUCCEEDED", "DML", day2, terabyte),
+		execution("b", "SELECT *\n  FROM logs", "SUCCEEDED", "DML", day1, terabyte),
+		execution("c", "select 1", "SUCCEEDED", "DML", day1, 0),
+		execution("d", "create table x (a int)", "SUCCEEDED", "DDL", day1, 0),
+	}
+	callers := map[string]string{"a": "arn:aws:iam::1:user/alice", "b": "arn:aws:iam::1:user/alice", "c": "arn:aws:iam::1:user/bob"}
+
+	byDay := costReport(executions, "day", nil, 5)
+	if len(byDay) != 2 || byDay[0].Group != "2024-03-01" || byDay[0].Queries != 3 || byDay[1].CostUSD != 5 {
+		t.Errorf("costReport(day) = %+v %+v", byDay[0], byDay[1])
+	}
+	byUser := costReport(executions, "user", callers, 5)
+	if len(byUser) != 3 || byUser[0].Group != "arn:aws:iam::1:user/alice" || byUser[0].CostUSD != 10 || byUser[2].Group != "unknown" {
+		t.Errorf("costReport(user) = %+v", byUser)
+	}
+	byQuery := costReport(executions, "query", nil, 5)
+	if len(byQuery) != 3 || byQuery[0].Queries != 2 || byQuery[0].Query != "select * from logs" {
+		t.Errorf("costReport(query) = %+v", byQuery[0])
+	}
+
+	var buf bytes.Buffer
+	if err := writeCostReport(&buf, byDay, "day", "csv", false); err != nil {
+		t.Fatal(err)
+	}
+	want := "day,query,queries,data_scanned_bytes,billed_bytes,cost_usd\n" +
+		"2024-03-01,,3,1099511627776,1099522113536,5.0000\n" +
+		"2024-03-02,,1,1099511627776,1099511627776,5.0000\n"
+	if buf.String() != want {
+		t.Errorf("writeCostReport(csv) = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestEventCaller(t *testing.T) {
+	e := &cloudTrailEvent{
+		Username:        aws.String("alice"),
+		CloudTrailEvent: aws.String(`{"userIdentity":{"arn":"arn:aws:sts::1:assumed-role/analyst/alice"},"responseElements":{"queryExecutionId":"q1"}}`),
+	}
+	if id, caller := eventCaller(e); id != "q1" || caller != "arn:aws:sts::1:assumed-role/analyst/alice" {
+		t.Errorf("eventCaller() = %q, %q", id, caller)
+	}
+	e.CloudTrailEvent = aws.String(`{"responseElements":{"queryExecutionId":"q2"}}`)
+	if id, caller := eventCaller(e); id != "q2" || caller != "alice" {
+		t.Errorf("eventCaller() = %q, %q", id, caller)
+	}
+}
+
+func TestTopQueries(t *testing.T) {
+	rows := []*costRow{{Group: "a", Queries: 3}, {Group: "b", Queries: 1}, {Group: "c", Queries: 2}, {Group: "d", Queries: 5}}
+	var got []string
+	for _, r := range topQueries(rows, 2) {
+		got = append(got, r.Group)
+	}
+	if strings.Join(got, ",") != "a,c" {
+		t.Errorf("topQueries() = %v, want a, c", got)
+	}
+}
+
+func TestRowFormat(t *testing.T) {
+	for format, want := range map[string]string{
+		"org.apache.hadoop.mapred.TextInputFormat":                      "TextInputFormat",
+		"org.apache.hadoop.hive.ql.io.parquet.MapredParquetInputFormat": "",
+		"org.apache.hadoop.hive.ql.io.orc.OrcInputFormat":               "",
+		"org.apache.hadoop.hive.ql.io.avro.AvroContainerInputFormat":    "AvroContainerInputFormat",
+		"": "",
+	} {
+		if got := rowFormat(format); got != want {
+			t.Errorf("rowFormat(%q) = %q, want %q", format, got, want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxDateRange keeps a typo in a year from rendering an IN list of
+// millions of days.
+const maxDateRange = 3660
+
+// dateList are the days of a date range formatted for a partition column.
+// It renders as an IN list, ('2024-01-01', '2024-01-02'), and ranges over
+// the days in templates.
+type dateList []string
+
+func (d dateList) String() string {
+	quoted := make([]string, len(d))
+	for i, day := range d {
+		quoted[i] = "'" + day + "'"
+	}
+	return "(" + strings.Join(quoted, ", ") + ")"
+}
+
+// Between renders the range as BETWEEN '2024-01-01' AND '2024-01-07'.
+func (d dateList) Between() string {
+	return fmt.Sprintf("BETWEEN '%s' AND '%s'", d[0], d[len(d)-1])
+}
+
+// dateRange returns the days from start to end, both included, in layout.
+// start and end are times or strings in layout or 2006-01-02.
+func dateRange(start, end interface{}, layout string) (dateList, error) {
+	from, err := parseDay(start, layout)
+	if err != nil {
+		return nil, fmt.Errorf("dateRange: %v", err)
+	}
+	to, err := parseDay(end, layout)
+	if err != nil {
+		return nil, fmt.Errorf("dateRange: %v", err)
+	}
+	if to.Before(from) {
+		return nil, fmt.Errorf("dateRange: end %s is before start %s", to.Format(layout), from.Format(layout))
+	}
+	if days := int(to.Sub(from).Hours()/24) + 1; days > maxDateRange {
+		return nil, fmt.Errorf("dateRange: %d days are more than %d", days, maxDateRange)
+	}
+	var days dateList
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		days = append(days, day.Format(layout))
+	}
+	return days, nil
+}
+
+// lastNDays returns the n days before today (UTC), oldest first, in layout
+// (default 2006-01-02).
+func lastNDays(n int, layout ...string) (dateList, error) {
+	return lastNDaysFrom(time.Now(), n, layout...)
+}
+
+func lastNDaysFrom(now time.Time, n int, layout ...string) (dateList, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("lastNDays: %d days, expected at least 1", n)
+	}
+	l := "2006-01-02"
+	if len(layout) > 0 {
+		l = layout[0]
+	}
+	today := now.UTC().Truncate(24 * time.Hour)
+	return dateRange(today.AddDate(0, 0, -n), today.AddDate(0, 0, -1), l)
+}
+
+func parseDay(v interface{}, layout string) (time.Time, error) {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UTC().Truncate(24 * time.Hour), nil
+	case string:
+		for _, l := range []string{layout, "2006-01-02", time.RFC3339} {
+			if t, err := time.Parse(l, v); err == nil {
+				return t.Truncate(24 * time.Hour), nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid date %q, expected %s", v, layout)
+	}
+	return time.Time{}, fmt.Errorf("invalid date %v of type %T", v, v)
+}
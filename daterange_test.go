@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDateRange(t *testing.T) {
+	for _, tt := range []struct {
+		start, end interface{}
+		layout     string
+		want       dateList
+	}{
+		{"2024-02-28", "2024-03-01", "2006-01-02", dateList{"2024-02-28", "2024-02-29", "2024-03-01"}},
+		{"20241231", "20250101", "20060102", dateList{"20241231", "20250101"}},
+		{"2024-01-01", "2024-01-01", "20060102", dateList{"20240101"}},
+		{time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC), "2024-01-02", "2006-01-02", dateList{"2024-01-01", "2024-01-02"}},
+		{"2024-01-02", "2024-01-01", "2006-01-02", nil},
+		{"yesterday", "2024-01-01", "2006-01-02", nil},
+		{"2000-01-01", "2024-01-01", "2006-01-02", nil},
+	} {
+		got, err := dateRange(tt.start, tt.end, tt.layout)
+		if (err != nil) != (tt.want == nil) || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("dateRange(%v, %v, %q) = %q, %v, want %q", tt.start, tt.end, tt.layout, got, err, tt.want)
+		}
+	}
+}
+
+func TestLastNDays(t *testing.T) {
+	now := time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC)
+	got, err := lastNDaysFrom(now, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "('2024-02-28', '2024-02-29', '2024-03-01')"; got.String() != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if want := "BETWEEN '2024-02-28' AND '2024-03-01'"; got.Between() != want {
+		t.Errorf("got %s, want %s", got.Between(), want)
+	}
+	if _, err := lastNDaysFrom(now, 0); err == nil {
+		t.Error("no error for 0 days")
+	}
+}
+
+func TestDateRangeTemplate(t *testing.T) {
+	got, err := execTemplate(`dt IN {{ dateRange .Start .End "2006-01-02" }} OR dt {{ (dateRange .Start .End "2006-01-02").Between }}{{ range dateRange .Start .End "20060102" }} {{ . }}{{ end }}`,
+		nil, map[string]string{"Start": "2024-01-01", "End": "2024-01-02"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "dt IN ('2024-01-01', '2024-01-02') OR dt BETWEEN '2024-01-01' AND '2024-01-02' 20240101 20240102"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+)
+
+// ddlDatabase is the line of an export naming the database it was exported
+// from, for -target to replace.
+var ddlDatabase = regexp.MustCompile(`(?m)^-- database: (\S+)$`)
+
+func ddlCmd(args []string) error {
+	fs := flag.NewFlagSet("ddl", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: athenaq ddl [flags] export <database>")
+		fmt.Fprintln(os.Stderr, "       athenaq ddl [flags] apply <file>")
+		fs.PrintDefaults()
+	}
+	var (
+		awsFlags  = addAWSFlags(fs)
+		repair    = fs.Bool("repair", false, "export: load the partitions of partitioned tables without partition projection with MSCK REPAIR TABLE")
+		target    = fs.String("target", "", "apply: create the tables and views in this database instead of the exported one")
+		locations = tagsFlag{}
+		dry       = fs.Bool("dry", false, "apply: print the statements instead of executing them")
+	)
+	fs.Var(locations, "location", "apply: replace the s3 prefix from=to in table locations and properties, e.g. s3://prod-data/=s3://staging-data/ (repeatable)")
+	fs.Parse(args)
+	if fs.NArg() != 2 || fs.Arg(0) != "export" && fs.Arg(0) != "apply" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	awsCli, err := newAWS(awsFlags)
+	if err != nil {
+		return errors.Wrap(err, "could not initialize aws client")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *awsFlags.timeout)
+	defer cancel()
+
+	if fs.Arg(0) == "export" {
+		tables, err := awsCli.glue.getTables(ctx, fs.Arg(1))
+		if err != nil {
+			return errors.Wrapf(err, "could not get the tables of %q", fs.Arg(1))
+		}
+		ddl, err := databaseDDL(fs.Arg(1), tables, *repair)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.WriteString(ddl)
+		return err
+	}
+
+	data, err := awsCli.readFrom(ctx, fs.Arg(1))
+	if err != nil {
+		return errors.Wrap(err, "could not read ddl")
+	}
+	source := ""
+	if m := ddlDatabase.FindStringSubmatch(string(data)); m != nil {
+		source = m[1]
+	}
+	if *target != "" && source == "" {
+		return fmt.Errorf("%s has no '-- database: <name>' line naming the database to replace with -target", fs.Arg(1))
+	}
+	for _, statement := range splitStatements(string(data)) {
+		statement = rewriteDDL(statement, source, *target, locations)
+		if *dry {
+			fmt.Println("execute query:", statement)
+			continue
+		}
+		head := strings.SplitN(statement, "\n", 2)[0]
+		infof("%s", head)
+		if _, err := awsCli.execQuery(ctx, statement, nil); err != nil {
+			return errors.Wrapf(err, "could not apply %s", head)
+		}
+	}
+	return nil
+}
+
+// databaseDDL returns the statements creating the database, its external
+// tables and its views, the views in the order they read each other.
+// Iceberg and other tables aren't exported, their data can't be shared by
+// pointing a table at it.
+func databaseDDL(database string, tables []*glueTable, repair bool) (string, error) {
+	statements := []string{"CREATE DATABASE IF NOT EXISTS " + quoteDDLIdent(database)}
+	views := map[string]*model{}
+	for _, t := range tables {
+		name := aws.StringValue(t.Name)
+		switch {
+		case aws.StringValue(t.TableType) == "VIRTUAL_VIEW":
+			sql, err := viewSQL(t)
+			if err != nil {
+				infof("skipping view %s.%s: %v", database, name, err)
+				continue
+			}
+			views[name] = &model{name: name, source: sql}
+		case aws.StringValue(t.TableType) == "EXTERNAL_TABLE" && !strings.EqualFold(aws.StringValue(t.Parameters["table_type"]), "ICEBERG"):
+			statements = append(statements, tableDDL(database, t))
+			if repair && len(t.PartitionKeys) > 0 && aws.StringValue(t.Parameters["projection.enabled"]) != "true" {
+				statements = append(statements, "MSCK REPAIR TABLE "+quoteDDLIdent(database)+"."+quoteDDLIdent(name))
+			}
+		default:
+			infof("skipping %s.%s, only external tables and views are exported", database, name)
+		}
+	}
+	viewDeps(views, database)
+	order, err := sortModels(views)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range order {
+		statements = append(statements, "CREATE OR REPLACE VIEW "+quoteIdent(database)+"."+quoteIdent(name)+" AS\n"+views[name].source)
+	}
+	return "-- database: " + database + "\n\n" + strings.Join(statements, ";\n\n") + ";\n", nil
+}
+
+// tableDDL returns the CREATE EXTERNAL TABLE statement of a glue table.
+func tableDDL(database string, t *glueTable) string {
+	columns := func(cols []*glueColumn) string {
+		defs := make([]string, len(cols))
+		for i, c := range cols {
+			defs[i] = "  " + quoteDDLIdent(aws.StringValue(c.Name)) + " " + aws.StringValue(c.Type)
+			if c.Comment != nil && *c.Comment != "" {
+				defs[i] += " COMMENT " + quoteDDLString(*c.Comment)
+			}
+		}
+		return "(\n" + strings.Join(defs, ",\n") + ")"
+	}
+	properties := func(params map[string]*string) string {
+		var keys []string
+		for k := range params {
+			// set by glue and athena, not by the statement.
+			if k != "EXTERNAL" && k != "transient_lastDdlTime" {
+				keys = append(keys, k)
+			}
+		}
+		if len(keys) == 0 {
+			return ""
+		}
+		sort.Strings(keys)
+		props := make([]string, len(keys))
+		for i, k := range keys {
+			props[i] = "  " + quoteDDLString(k) + "=" + quoteDDLString(aws.StringValue(params[k]))
+		}
+		return "(\n" + strings.Join(props, ",\n") + ")"
+	}
+
+	var b strings.Builder
+	sd := t.StorageDescriptor
+	if sd == nil {
+		sd = &glueStorageDescriptor{}
+	}
+	b.WriteString("CREATE EXTERNAL TABLE IF NOT EXISTS " + quoteDDLIdent(database) + "." + quoteDDLIdent(aws.StringValue(t.Name)) + columns(sd.Columns))
+	if t.Description != nil && *t.Description != "" {
+		b.WriteString("\nCOMMENT " + quoteDDLString(*t.Description))
+	}
+	if len(t.PartitionKeys) > 0 {
+		b.WriteString("\nPARTITIONED BY " + columns(t.PartitionKeys))
+	}
+	if s := sd.SerdeInfo; s != nil && s.SerializationLibrary != nil {
+		b.WriteString("\nROW FORMAT SERDE " + quoteDDLString(*s.SerializationLibrary))
+		if props := properties(s.Parameters); props != "" {
+			b.WriteString("\nWITH SERDEPROPERTIES " + props)
+		}
+	}
+	if sd.InputFormat != nil && sd.OutputFormat != nil {
+		b.WriteString("\nSTORED AS INPUTFORMAT " + quoteDDLString(*sd.InputFormat) + "\nOUTPUTFORMAT " + quoteDDLString(*sd.OutputFormat))
+	}
+	if sd.Location != nil {
+		b.WriteString("\nLOCATION " + quoteDDLString(*sd.Location))
+	}
+	if props := properties(t.Parameters); props != "" {
+		b.WriteString("\nTBLPROPERTIES " + props)
+	}
+	return b.String()
+}
+
+// splitStatements splits sql at the semicolons outside of strings and
+// comments, dropping the comments before each statement.
+func splitStatements(sql string) []string {
+	var statements []string
+	var current []token
+	flush := func() {
+		if s := strings.TrimSpace(joinTokens(current[nextToken(current, 0):])); s != "" {
+			statements = append(statements, s)
+		}
+		current = nil
+	}
+	for _, t := range tokenize(sql) {
+		if t.kind == tokenSymbol && t.text == ";" {
+			flush()
+			continue
+		}
+		current = append(current, t)
+	}
+	flush()
+	return statements
+}
+
+// rewriteDDL replaces the database source with target in the qualified names
+// and CREATE DATABASE of an exported statement, and the s3 prefixes of
+// locations in its strings, the longest matching prefix first.
+func rewriteDDL(statement, source, target string, locations map[string]string) string {
+	var prefixes []string
+	for from := range locations {
+		prefixes = append(prefixes, from)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+
+	toks := tokenize(statement)
+	prev, prevPrev := -1, -1
+	// afterDatabase is set from CREATE DATABASE up to the name.
+	afterDatabase := false
+	for i, t := range toks {
+		if !t.significant() {
+			continue
+		}
+		switch {
+		case target != "" && (t.kind == tokenWord || t.kind == tokenIdent) && strings.EqualFold(unquoteIdent(t.text), source):
+			next := nextToken(toks, i+1)
+			// db.table, or catalog.db.table with the glue catalog
+			qualifies := next < len(toks) && toks[next].text == "." &&
+				(prev < 0 || toks[prev].text != "." || prevPrev >= 0 && isGlueCatalog(unquoteIdent(toks[prevPrev].text)))
+			if qualifies || afterDatabase {
+				toks[i].text = requoteIdent(t.text, target)
+			}
+		case t.kind == tokenString && len(t.text) >= 2 && t.text[0] == '\'':
+			for _, from := range prefixes {
+				if s := t.text[1 : len(t.text)-1]; strings.HasPrefix(s, from) {
+					toks[i].text = "'" + locations[from] + strings.TrimPrefix(s, from) + "'"
+					break
+				}
+			}
+		}
+		afterDatabase = t.is("DATABASE", "SCHEMA") || afterDatabase && t.is("IF", "NOT", "EXISTS")
+		prev, prevPrev = i, prev
+	}
+	return joinTokens(toks)
+}
+
+// requoteIdent quotes name like the identifier ident it replaces.
+func requoteIdent(ident, name string) string {
+	switch ident[0] {
+	case '"':
+		return quoteIdent(name)
+	case '`':
+		return quoteDDLIdent(name)
+	}
+	return name
+}
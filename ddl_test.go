@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestDatabaseDDL(t *testing.T) {
+	view := func(name, sql string) *glueTable {
+		encoded := base64.StdEncoding.EncodeToString([]byte(`{"originalSql":"` + sql + `"}`))
+		return &glueTable{Name: aws.String(name), TableType: aws.String("VIRTUAL_VIEW"), ViewOriginalText: aws.String("/* Presto View: " + encoded + " */")}
+	}
+	tables := []*glueTable{
+		view("active", "SELECT * FROM analytics.users WHERE active"),
+		{
+			Name:          aws.String("events"),
+			TableType:     aws.String("EXTERNAL_TABLE"),
+			Description:   aws.String("raw events"),
+			PartitionKeys: []*glueColumn{{Name: aws.String("dt"), Type: aws.String("string")}},
+			StorageDescriptor: &glueStorageDescriptor{
+				Columns:      []*glueColumn{{Name: aws.String("id"), Type: aws.String("bigint"), Comment: aws.String("it's the id")}, {Name: aws.String("payload"), Type: aws.String("struct<a:int>")}},
+				Location:     aws.String("s3://prod-data/events/"),
+				InputFormat:  aws.String("org.apache.hadoop.hive.ql.io.parquet.MapredParquetInputFormat"),
+				OutputFormat: aws.String("org.apache.hadoop.hive.ql.io.parquet.MapredParquetOutputFormat"),
+				SerdeInfo: &glueSerdeInfo{
+					SerializationLibrary: aws.String("org.apache.hadoop.hive.ql.io.parquet.serde.ParquetHiveSerDe"),
+					Parameters:           map[string]*string{"serialization.format": aws.String("1")},
+				},
+			},
+			Parameters: map[string]*string{"EXTERNAL": aws.String("TRUE"), "transient_lastDdlTime": aws.String("1700000000"), "parquet.compression": aws.String("SNAPPY")},
+		},
+		{Name: aws.String("snapshots"), TableType: aws.String("EXTERNAL_TABLE"), Parameters: map[string]*string{"table_type": aws.String("ICEBERG")}},
+		view("recent", "SELECT * FROM active WHERE dt > '2024'"),
+		{Name: aws.String("users"), TableType: aws.String("EXTERNAL_TABLE"), StorageDescriptor: &glueStorageDescriptor{Columns: []*glueColumn{{Name: aws.String("id"), Type: aws.String("bigint")}}}},
+	}
+	got, err := databaseDDL("analytics", tables, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "-- database: analytics\n\n" +
+		"CREATE DATABASE IF NOT EXISTS `analytics`;\n\n" +
+		"CREATE EXTERNAL TABLE IF NOT EXISTS `analytics`.`events`(\n  `id` bigint COMMENT 'it\\'s the id',\n  `payload` struct<a:int>)\n" +
+		"COMMENT 'raw events'\n" +
+		"PARTITIONED BY (\n  `dt` string)\n" +
+		"ROW FORMAT SERDE 'org.apache.hadoop.hive.ql.io.parquet.serde.ParquetHiveSerDe'\n" +
+		"WITH SERDEPROPERTIES (\n  'serialization.format'='1')\n" +
+		"STORED AS INPUTFORMAT 'org.apache.hadoop.hive.ql.io.parquet.MapredParquetInputFormat'\n" +
+		"OUTPUTFORMAT 'org.apache.hadoop.hive.ql.io.parquet.MapredParquetOutputFormat'\n" +
+		"LOCATION 's3://prod-data/events/'\n" +
+		"TBLPROPERTIES (\n  'parquet.compression'='SNAPPY');\n\n" +
+		"MSCK REPAIR TABLE `analytics`.`events`;\n\n" +
+		"CREATE EXTERNAL TABLE IF NOT EXISTS `analytics`.`users`(\n  `id` bigint);\n\n" +
+		"CREATE OR REPLACE VIEW \"analytics\".\"active\" AS\nSELECT * FROM analytics.users WHERE active;\n\n" +
+		"CREATE OR REPLACE VIEW \"analytics\".\"recent\" AS\nSELECT * FROM active WHERE dt > '2024';\n"
+	if got != want {
+		t.Errorf("databaseDDL() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	got := splitStatements("-- database: db\n\nCREATE DATABASE db;\n\n-- a view\nCREATE VIEW v AS SELECT ';' AS s /* ; */;\n;\n")
+	want := []string{"CREATE DATABASE db", "CREATE VIEW v AS SELECT ';' AS s /* ; */"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitStatements() = %q, want %q", got, want)
+	}
+}
+
+func TestRewriteDDL(t *testing.T) {
+	locations := map[string]string{"s3://prod/": "s3://staging/", "s3://prod/raw/": "s3://staging-raw/"}
+	for _, tt := range []struct {
+		statement, target, want string
+	}{
+		{"CREATE DATABASE IF NOT EXISTS `analytics`", "dev", "CREATE DATABASE IF NOT EXISTS `dev`"},
+		{"CREATE EXTERNAL TABLE `analytics`.`events`(`analytics` int) LOCATION 's3://prod/events/'", "dev", "CREATE EXTERNAL TABLE `dev`.`events`(`analytics` int) LOCATION 's3://staging/events/'"},
+		{"CREATE EXTERNAL TABLE `analytics`.`raw`(id int) LOCATION 's3://prod/raw/x/'", "", "CREATE EXTERNAL TABLE `analytics`.`raw`(id int) LOCATION 's3://staging-raw/x/'"},
+		{`CREATE OR REPLACE VIEW "analytics"."v" AS SELECT analytics.id FROM analytics.t JOIN awsdatacatalog.Analytics.u ON other.analytics.x = 1`, "dev",
+			`CREATE OR REPLACE VIEW "dev"."v" AS SELECT dev.id FROM dev.t JOIN awsdatacatalog.dev.u ON other.analytics.x = 1`},
+		{"SELECT 'analytics.t' FROM t", "dev", "SELECT 'analytics.t' FROM t"},
+	} {
+		if got := rewriteDDL(tt.statement, "analytics", tt.target, locations); got != tt.want {
+			t.Errorf("rewriteDDL(%q) =\n%q, want\n%q", tt.statement, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// fingerprint hashes the normalized statement: comments and whitespace are
+// dropped and keywords and unquoted identifiers lower cased, so that queries
+// differing only in formatting have the same fingerprint.
+func fingerprint(query string) string {
+	var parts []string
+	for _, t := range tokenize(query) {
+		switch {
+		case !t.significant():
+		case t.kind == tokenWord:
+			parts = append(parts, strings.ToLower(t.text))
+		default:
+			parts = append(parts, t.text)
+		}
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, " ")))
+	return hex.EncodeToString(sum[:])
+}
+
+// duplicates returns for every query the index of the first identical query
+// of the batch, or -1 if it is the first. Queries are only identical if
+// their keys, e.g. of their settings, are too (nil == no keys).
+func duplicates(queries []string, keys []string) []int {
+	first := map[string]int{}
+	dups := make([]int, len(queries))
+	for i, query := range queries {
+		fp := fingerprint(query)
+		if keys != nil {
+			fp = keys[i] + "\n" + fp
+		}
+		if j, ok := first[fp]; ok {
+			dups[i] = j
+			continue
+		}
+		first[fp] = i
+		dups[i] = -1
+	}
+	return dups
+}
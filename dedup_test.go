@@ -0,0 +1,27 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDuplicates(t *testing.T) {
+	queries := []string{
+		"select * from users where id = 1",
+		"-- name: again\nSELECT *\n  FROM users\n WHERE id = 1",
+		"select * from users where id = 2",
+		"select * from users where name = 'Bob'",
+		"select * from users where name = 'bob'",
+		`select * from "Users" where id = 2`,
+		"select  *  from users where id=2",
+	}
+	want := []int{-1, 0, -1, -1, -1, -1, 2}
+	if got := duplicates(queries, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	keys := []string{"", "head=1", "", "", "", "", ""}
+	want = []int{-1, -1, -1, -1, -1, -1, 2}
+	if got := duplicates(queries, keys); !reflect.DeepEqual(got, want) {
+		t.Errorf("with keys: got %v, want %v", got, want)
+	}
+}
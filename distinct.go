@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// distinctKeys is the number of row keys kept in memory before partitions
+// of them are spilled to temp files.
+var distinctKeys = 4 << 20
+
+type rowKey [sha256.Size]byte
+
+// distinctWriter writes the first row of each key of a csv result: the whole
+// row, or with columns the values of these columns. The keys are hashed
+// into 256 partitions. Once they don't fit in memory, the keys of the largest
+// partition are spilled to a temp file, and rows of spilled partitions are
+// decided, and written, on flush.
+type distinctWriter struct {
+	w       io.Writer
+	columns []string
+	indices []int
+	header  bool
+	seen    [256]map[rowKey]bool
+	keys    int
+	spilled [256]*spillPartition
+	split   rowSplitter
+}
+
+// spillPartition holds the spilled keys of a partition followed by the
+// rows of the partition that came after them.
+type spillPartition struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+func (d *distinctWriter) Write(p []byte) (int, error) {
+	return len(p), d.split.write(p, d.add)
+}
+
+func (d *distinctWriter) add(row []byte) error {
+	if !d.header {
+		d.header = true
+		if len(d.columns) > 0 {
+			indices, err := columnIndices(csvFields(row), d.columns)
+			if err != nil {
+				return err
+			}
+			d.indices = indices
+		}
+		_, err := d.w.Write(row)
+		return err
+	}
+	key := d.key(row)
+	if sp := d.spilled[key[0]]; sp != nil {
+		var n [binary.MaxVarintLen64]byte
+		sp.w.Write(key[:])
+		sp.w.Write(n[:binary.PutUvarint(n[:], uint64(len(row)))])
+		_, err := sp.w.Write(row)
+		return errors.Wrap(err, "could not spill row")
+	}
+	if !d.insert(key) {
+		return nil
+	}
+	if d.keys > distinctKeys {
+		if err := d.spill(); err != nil {
+			return err
+		}
+	}
+	_, err := d.w.Write(row)
+	return err
+}
+
+func (d *distinctWriter) key(row []byte) rowKey {
+	if d.indices == nil {
+		return sha256.Sum256(trimNewline(row))
+	}
+	h := sha256.New()
+	fields := csvFields(row)
+	for _, i := range d.indices {
+		var f []byte
+		if i < len(fields) {
+			f = fields[i]
+		}
+		var n [binary.MaxVarintLen64]byte
+		h.Write(n[:binary.PutUvarint(n[:], uint64(len(f)))])
+		h.Write(f)
+	}
+	var key rowKey
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// insert adds key to its partition and reports whether it is new.
+func (d *distinctWriter) insert(key rowKey) bool {
+	p := d.seen[key[0]]
+	if p == nil {
+		p = map[rowKey]bool{}
+		d.seen[key[0]] = p
+	}
+	if p[key] {
+		return false
+	}
+	p[key] = true
+	d.keys++
+	return true
+}
+
+// spill writes the keys of the largest partition in memory to a temp file.
+func (d *distinctWriter) spill() error {
+	largest := 0
+	for i, p := range d.seen {
+		if len(p) > len(d.seen[largest]) {
+			largest = i
+		}
+	}
+	f, err := ioutil.TempFile("", "athenaq-distinct")
+	if err != nil {
+		return errors.Wrap(err, "could not create distinct partition")
+	}
+	sp := &spillPartition{f: f, w: bufio.NewWriter(f)}
+	d.spilled[largest] = sp
+	var n [binary.MaxVarintLen64]byte
+	for key := range d.seen[largest] {
+		sp.w.Write(key[:])
+		// a key without a row
+		sp.w.Write(n[:binary.PutUvarint(n[:], 0)])
+	}
+	d.keys -= len(d.seen[largest])
+	d.seen[largest] = nil
+	return nil
+}
+
+// flush writes the new rows of the spilled partitions, one partition at a
+// time, and a last row without a trailing newline.
+func (d *distinctWriter) flush() error {
+	defer d.cleanup()
+	if len(d.split.row) > 0 {
+		row := d.split.row
+		d.split.row = nil
+		if err := d.add(row); err != nil {
+			return ignoreEnough(err)
+		}
+	}
+	for i, sp := range d.spilled {
+		if sp == nil {
+			continue
+		}
+		d.seen = [256]map[rowKey]bool{}
+		d.keys = 0
+		if err := d.replay(sp); err != nil {
+			return ignoreEnough(err)
+		}
+		d.spilled[i] = nil
+		sp.close()
+	}
+	return nil
+}
+
+func (d *distinctWriter) replay(sp *spillPartition) error {
+	if err := sp.w.Flush(); err != nil {
+		return errors.Wrap(err, "could not write distinct partition")
+	}
+	if _, err := sp.f.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "could not read distinct partition")
+	}
+	r := bufio.NewReader(sp.f)
+	for {
+		var key rowKey
+		if _, err := io.ReadFull(r, key[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "could not read distinct partition")
+		}
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return errors.Wrap(err, "could not read distinct partition")
+		}
+		row := make([]byte, n)
+		if _, err := io.ReadFull(r, row); err != nil {
+			return errors.Wrap(err, "could not read distinct partition")
+		}
+		if d.insert(key) && n > 0 {
+			if _, err := d.w.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// cleanup removes the spilled partitions.
+func (d *distinctWriter) cleanup() {
+	for i, sp := range d.spilled {
+		if sp != nil {
+			sp.close()
+			d.spilled[i] = nil
+		}
+	}
+}
+
+func (sp *spillPartition) close() {
+	sp.f.Close()
+	os.Remove(sp.f.Name())
+}
+
+func ignoreEnough(err error) error {
+	if err == errEnoughRows {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDistinctWriter(t *testing.T) {
+	csv := "\"id\",\"v\"\n\"1\",\"a\"\n\"2\",\"a\"\n\"1\",\"a\"\n\"1\",\n\"1\",\"\"\n\"2\",\"a\""
+	tests := []struct {
+		columns []string
+		want    string
+	}{
+		{nil, "\"id\",\"v\"\n\"1\",\"a\"\n\"2\",\"a\"\n\"1\",\n\"1\",\"\"\n"},
+		{[]string{"id"}, "\"id\",\"v\"\n\"1\",\"a\"\n\"2\",\"a\"\n"},
+		{[]string{"V"}, "\"id\",\"v\"\n\"1\",\"a\"\n\"1\",\n\"1\",\"\"\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		dw := &distinctWriter{w: &buf, columns: tt.columns}
+		if _, err := dw.Write([]byte(csv)); err != nil {
+			t.Fatal(err)
+		}
+		if err := dw.flush(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.columns, buf.String(), tt.want)
+		}
+	}
+}
+
+func TestDistinctWriterSpills(t *testing.T) {
+	defer func(n int) { distinctKeys = n }(distinctKeys)
+	distinctKeys = 50
+
+	var in strings.Builder
+	in.WriteString("\"n\"\n")
+	for round := 0; round < 3; round++ {
+		for n := 0; n < 400; n++ {
+			fmt.Fprintf(&in, "\"%d\"\n", n)
+		}
+	}
+	var buf bytes.Buffer
+	dw := &distinctWriter{w: &buf}
+	dw.Write([]byte(in.String()))
+	spilled := 0
+	for _, sp := range dw.spilled {
+		if sp != nil {
+			spilled++
+		}
+	}
+	if spilled == 0 {
+		t.Fatal("no partition was spilled")
+	}
+	if err := dw.flush(); err != nil {
+		t.Fatal(err)
+	}
+	rows := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	seen := map[string]bool{}
+	for _, row := range rows[1:] {
+		if seen[row] {
+			t.Errorf("duplicate row %s", row)
+		}
+		seen[row] = true
+	}
+	if len(seen) != 400 {
+		t.Errorf("got %d rows, want 400", len(seen))
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/private/protocol/jsonrpc"
+	"github.com/pkg/errors"
+)
+
+// kmsClient is a minimal AWS KMS client for envelope encryption, built the
+// same way as glueClient.
+type kmsClient struct {
+	*client.Client
+}
+
+func newKMS(p client.ConfigProvider, cfgs ...*aws.Config) *kmsClient {
+	c := p.ClientConfig("kms", cfgs...)
+	svc := &kmsClient{
+		Client: client.New(
+			*c.Config,
+			metadata.ClientInfo{
+				ServiceName:   "kms",
+				SigningName:   c.SigningName,
+				SigningRegion: c.SigningRegion,
+				Endpoint:      c.Endpoint,
+				APIVersion:    "2014-11-01",
+				JSONVersion:   "1.1",
+				TargetPrefix:  "TrentService",
+			},
+			c.Handlers,
+		),
+	}
+	svc.Handlers.Sign.PushBackNamed(v4.SignRequestHandler)
+	svc.Handlers.Build.PushBackNamed(jsonrpc.BuildHandler)
+	svc.Handlers.Unmarshal.PushBackNamed(jsonrpc.UnmarshalHandler)
+	svc.Handlers.UnmarshalMeta.PushBackNamed(jsonrpc.UnmarshalMetaHandler)
+	svc.Handlers.UnmarshalError.PushBackNamed(jsonrpc.UnmarshalErrorHandler)
+	return svc
+}
+
+func (c *kmsClient) send(ctx context.Context, op string, input, output interface{}) error {
+	req := c.NewRequest(&request.Operation{Name: op, HTTPMethod: "POST", HTTPPath: "/"}, input, output)
+	req.SetContext(ctx)
+	return req.Send()
+}
+
+func (c *kmsClient) generateDataKey(ctx context.Context, keyID string, encryptionContext map[string]*string) (plaintext, ciphertext []byte, arn string, err error) {
+	out := struct {
+		CiphertextBlob []byte
+		Plaintext      []byte
+		KeyId          *string
+	}{}
+	err = c.send(ctx, "GenerateDataKey", &struct {
+		KeyId             *string
+		KeySpec           *string
+		EncryptionContext map[string]*string
+	}{&keyID, aws.String("AES_256"), encryptionContext}, &out)
+	return out.Plaintext, out.CiphertextBlob, aws.StringValue(out.KeyId), err
+}
+
+func (c *kmsClient) decrypt(ctx context.Context, ciphertext []byte, encryptionContext map[string]*string) ([]byte, error) {
+	out := struct{ Plaintext []byte }{}
+	err := c.send(ctx, "Decrypt", &struct {
+		CiphertextBlob    []byte
+		EncryptionContext map[string]*string
+	}{ciphertext, encryptionContext}, &out)
+	return out.Plaintext, err
+}
+
+// envelope describes how an output was encrypted. It is written next to the
+// output as <out>.key.json and holds everything but the plaintext data key.
+// The encryption context is only informational, open derives it from the
+// path the output is read from, so that a key file cannot vouch for another
+// output.
+type envelope struct {
+	Algorithm         string            `json:"algorithm"`
+	KeyID             string            `json:"kms_key_id"`
+	EncryptedDataKey  []byte            `json:"encrypted_data_key"`
+	Nonce             []byte            `json:"nonce"`
+	EncryptionContext map[string]string `json:"encryption_context"`
+}
+
+const envelopeAlgorithm = "AES-256-GCM"
+
+// encryptionContext binds a data key to the output path.
+func encryptionContext(outPath string) map[string]string {
+	return map[string]string{"athenaq:output": outPath}
+}
+
+// encrypt seals data with a fresh KMS data key. The output path is bound to
+// the data key as encryption context.
+func (c *kmsClient) encrypt(ctx context.Context, keyID, outPath string, data []byte) ([]byte, *envelope, error) {
+	env := &envelope{
+		Algorithm:         envelopeAlgorithm,
+		EncryptionContext: encryptionContext(outPath),
+	}
+	key, encryptedKey, arn, err := c.generateDataKey(ctx, keyID, aws.StringMap(env.EncryptionContext))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not generate data key")
+	}
+	env.KeyID, env.EncryptedDataKey = arn, encryptedKey
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	env.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, env.Nonce); err != nil {
+		return nil, nil, err
+	}
+	return gcm.Seal(nil, env.Nonce, data, nil), env, nil
+}
+
+// open decrypts data, the output encrypted for outPath.
+func (c *kmsClient) open(ctx context.Context, env *envelope, outPath string, data []byte) ([]byte, error) {
+	if env.Algorithm != envelopeAlgorithm {
+		return nil, fmt.Errorf("unsupported algorithm %q", env.Algorithm)
+	}
+	key, err := c.decrypt(ctx, env.EncryptedDataKey, aws.StringMap(encryptionContext(outPath)))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decrypt data key")
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, env.Nonce, data, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func decryptCmd(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: athenaq decrypt [flags] <file://...|s3://...>")
+		fs.PrintDefaults()
+	}
+	awsRegion := fs.String("region", "", regionFlagUsage)
+	encryptedFor := fs.String("encrypted-for", "", `-out path the output was encrypted for, when it was copied or moved since ("" == the path)`)
+	addLogFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	sess, err := newSession(*awsRegion)
+	if err != nil {
+		return err
+	}
+	awsCli := newBareAWS(sess)
+	ctx := context.Background()
+
+	path := fs.Arg(0)
+	data, err := awsCli.readFrom(ctx, path)
+	if err != nil {
+		return errors.Wrapf(err, "could not read %q", path)
+	}
+	keyFile, err := awsCli.readFrom(ctx, path+".key.json")
+	if err != nil {
+		return errors.Wrapf(err, "could not read %q", path+".key.json")
+	}
+	env := &envelope{}
+	if err := json.Unmarshal(keyFile, env); err != nil {
+		return errors.Wrap(err, "could not parse key file")
+	}
+	outPath := path
+	if *encryptedFor != "" {
+		outPath = *encryptedFor
+	}
+	plaintext, err := newKMS(sess).open(ctx, env, outPath, data)
+	if err != nil {
+		return errors.Wrap(err, "could not decrypt")
+	}
+	_, err = os.Stdout.Write(plaintext)
+	return err
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// fakeKMS wraps data keys by inverting them and prefixing them with the key id
+// and the encryption context, which Decrypt checks like kms does.
+type fakeKMS struct {
+	*httptest.Server
+	keys [][]byte
+}
+
+func newFakeKMS(t *testing.T) *fakeKMS {
+	f := &fakeKMS{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		in := struct {
+			KeyId             string
+			KeySpec           string
+			CiphertextBlob    []byte
+			EncryptionContext map[string]string
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			t.Error(err)
+		}
+		wrap := func(keyID string, key []byte) []byte {
+			wrapped := []byte(fmt.Sprintf("%s|%v|", keyID, in.EncryptionContext))
+			for _, b := range key {
+				wrapped = append(wrapped, ^b)
+			}
+			return wrapped
+		}
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.GenerateDataKey":
+			if in.KeySpec != "AES_256" {
+				t.Errorf("got key spec %q", in.KeySpec)
+			}
+			key := bytes.Repeat([]byte{byte(len(f.keys) + 1)}, 32)
+			f.keys = append(f.keys, key)
+			arn := "arn:aws:kms:eu-central-1:123456789012:key/" + in.KeyId
+			json.NewEncoder(w).Encode(map[string]interface{}{"KeyId": arn, "Plaintext": key, "CiphertextBlob": wrap(arn, key)})
+		case "TrentService.Decrypt":
+			for _, key := range f.keys {
+				for _, id := range []string{"alias/results", "alias/other"} {
+					if bytes.Equal(in.CiphertextBlob, wrap("arn:aws:kms:eu-central-1:123456789012:key/"+id, key)) {
+						json.NewEncoder(w).Encode(map[string]interface{}{"Plaintext": key})
+						return
+					}
+				}
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type": "InvalidCiphertextException", "message": "invalid ciphertext"}`)
+		default:
+			t.Errorf("unexpected call %s", r.Header.Get("X-Amz-Target"))
+		}
+	}))
+	return f
+}
+
+func (f *fakeKMS) client() *kmsClient {
+	return newKMS(session.Must(session.NewSession(&aws.Config{
+		Endpoint:    aws.String(f.URL),
+		Region:      aws.String("eu-central-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	})))
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	f := newFakeKMS(t)
+	defer f.Close()
+	kms := f.client()
+	ctx := context.Background()
+
+	plaintext := []byte("\"id\",\"name\"\n\"1\",\"alice\"\n")
+	sealed, env, err := kms.encrypt(ctx, "alias/results", "s3://bucket/out.csv", plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Algorithm != "AES-256-GCM" || env.KeyID != "arn:aws:kms:eu-central-1:123456789012:key/alias/results" ||
+		!reflect.DeepEqual(env.EncryptionContext, map[string]string{"athenaq:output": "s3://bucket/out.csv"}) || len(env.Nonce) != 12 {
+		t.Errorf("got envelope %+v", env)
+	}
+	if bytes.Contains(sealed, plaintext) {
+		t.Error("output contains the plaintext")
+	}
+
+	// the key file holds everything but the plaintext data key.
+	keyFile, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataKey, _ := json.Marshal(f.keys[0])
+	if bytes.Contains(sealed, f.keys[0]) || bytes.Contains(keyFile, f.keys[0]) || bytes.Contains(keyFile, bytes.Trim(dataKey, `"`)) {
+		t.Fatalf("plaintext data key written: %s", keyFile)
+	}
+
+	var decoded envelope
+	if err := json.Unmarshal(keyFile, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	opened, err := kms.open(ctx, &decoded, "s3://bucket/out.csv", sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("got %q, want %q", opened, plaintext)
+	}
+}
+
+func TestDecryptFailures(t *testing.T) {
+	f := newFakeKMS(t)
+	defer f.Close()
+	kms := f.client()
+	ctx := context.Background()
+
+	sealed, env, err := kms.encrypt(ctx, "alias/results", "s3://bucket/out.csv", []byte("secret rows"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := kms.encrypt(ctx, "alias/other", "s3://bucket/out.csv", []byte("other rows"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := append([]byte{}, sealed...)
+	tampered[0] ^= 1
+	wrongKey := *env
+	wrongKey.EncryptedDataKey = other.EncryptedDataKey
+	unknown := *env
+	unknown.Algorithm = "AES-128-CBC"
+
+	// a key file claiming another output does not decrypt the output read
+	// from the copy.
+	forged := *env
+	forged.EncryptionContext = map[string]string{"athenaq:output": "s3://bucket/copy.csv"}
+
+	for name, tt := range map[string]struct {
+		env     *envelope
+		outPath string
+		data    []byte
+		err     string
+	}{
+		"tampered output":    {env, "s3://bucket/out.csv", tampered, "message authentication failed"},
+		"truncated output":   {env, "s3://bucket/out.csv", sealed[:len(sealed)-1], "message authentication failed"},
+		"moved output":       {env, "s3://bucket/copy.csv", sealed, "could not decrypt data key"},
+		"forged key file":    {&forged, "s3://bucket/copy.csv", sealed, "could not decrypt data key"},
+		"wrong data key":     {&wrongKey, "s3://bucket/out.csv", sealed, "message authentication failed"},
+		"unsupported cipher": {&unknown, "s3://bucket/out.csv", sealed, `unsupported algorithm "AES-128-CBC"`},
+	} {
+		if _, err := kms.open(ctx, tt.env, tt.outPath, tt.data); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %v, want %q", name, err, tt.err)
+		}
+	}
+}
+
+func TestEncryptRefusesCache(t *testing.T) {
+	defer func(args []string, fs *flag.FlagSet) { os.Args, flag.CommandLine = args, fs }(os.Args, flag.CommandLine)
+	os.Args = []string{"athenaq", "-dry", "-history=false", "-encrypt.kms-key", "alias/results", "-out", "file://out.csv", "-cache-dir", os.TempDir(), "-query", "select 1"}
+	flag.CommandLine = flag.NewFlagSet("athenaq", flag.ContinueOnError)
+	if err := run(); err == nil || !strings.Contains(err.Error(), "-cache-dir") {
+		t.Errorf("got error %v, want -encrypt.kms-key and -cache-dir to be refused", err)
+	}
+}
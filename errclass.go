@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/pkg/errors"
+)
+
+// error classes tell automation whether retrying a failed run can help.
+const (
+	errorSyntax     = "syntax"
+	errorPermission = "permission"
+	errorResource   = "resource"
+	errorData       = "data"
+	errorTransient  = "transient"
+	errorOther      = "other"
+	errorUsage      = "usage"
+)
+
+// exitStatus is the exit status of a run failing with an error of a class.
+// 2 is the status of invalid command lines, like the flag package's.
+var exitStatus = map[string]int{
+	errorOther:      1,
+	errorUsage:      2,
+	errorSyntax:     3,
+	errorPermission: 4,
+	errorResource:   5,
+	errorData:       6,
+	errorTransient:  7,
+}
+
+// usageError is an invalid command line, e.g. conflicting flags.
+type usageError struct{ error }
+
+// usagef returns a usageError with the message.
+func usagef(format string, args ...interface{}) error {
+	return usageError{fmt.Errorf(format, args...)}
+}
+
+// errorPatterns match the error codes and messages of athena, the state
+// change reasons of failed queries among them, in order: an access denied
+// reading a split is a permission error, not a data error.
+var errorPatterns = []struct {
+	class string
+	re    *regexp.Regexp
+}{
+	{errorPermission, regexp.MustCompile(`(?i)access ?denied|not authorized|insufficient (lake formation )?permissions?|permission denied|is not allowed to`)},
+	{errorTransient, regexp.MustCompile(`(?i)throttl|too ?many ?requests|rate exceeded|slow ?down|service ?unavailable|internal ?(server )?error|please try again|connection reset`)},
+	{errorResource, regexp.MustCompile(`(?i)exceeded_(local_)?memory_limit|exceeded_time_limit|exhausted resources|insufficient_resources|bytes scanned limit|query timeout|exceeded the maximum|too many open partitions|limit exceeded`)},
+	{errorData, regexp.MustCompile(`(?i)hive_bad_data|hive_cursor_error|hive_partition_schema_mismatch|hive_invalid_metadata|hive_cannot_open_split|invalid_cast_argument|invalid_function_argument|division_by_zero|numeric_value_out_of_range|malformed|zero-length file`)},
+	{errorSyntax, regexp.MustCompile(`(?i)syntax_error|mismatched input|extraneous input|no viable alternative|column_not_found|table_not_found|schema_not_found|function_not_found|type_mismatch|not_supported|cannot be resolved`)},
+}
+
+// classifyError returns the class of the error of a failed run or query.
+func classifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+	if _, ok := errors.Cause(err).(usageError); ok {
+		return errorUsage
+	}
+	if aerr, ok := errors.Cause(err).(awserr.Error); ok {
+		switch aerr.Code() {
+		case "AccessDenied", "AccessDeniedException":
+			return errorPermission
+		case "ThrottlingException", "TooManyRequestsException", "SlowDown", "InternalServerException", "ServiceUnavailable":
+			return errorTransient
+		}
+	}
+	msg := err.Error()
+	for _, p := range errorPatterns {
+		if p.re.MatchString(msg) {
+			return p.class
+		}
+	}
+	return errorOther
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/pkg/errors"
+)
+
+func TestClassifyError(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{errors.New("athena query could not finish: SYNTAX_ERROR: line 1:8: Column 'x' cannot be resolved"), errorSyntax},
+		{errors.New("athena query could not finish: line 1:1: mismatched input 'selec'"), errorSyntax},
+		{errors.New("athena query could not finish: HIVE_CANNOT_OPEN_SPLIT: Error opening Hive split s3://b/k: Access Denied"), errorPermission},
+		{errors.New("athena query could not finish: HIVE_BAD_DATA: Error parsing field value '' for field 3"), errorData},
+		{errors.New("athena query could not finish: Query exhausted resources at this scale factor"), errorResource},
+		{errors.New("athena query could not finish: EXCEEDED_TIME_LIMIT: Query exceeded maximum allowed time"), errorResource},
+		{errors.Wrap(awserr.New("TooManyRequestsException", "slow down", nil), "could not start query"), errorTransient},
+		{errors.Wrap(awserr.New("AccessDeniedException", "no", nil), "could not start query"), errorPermission},
+		{errors.New("athena query could not finish: TABLE_NOT_FOUND: line 1:15: Table 'awsdatacatalog.db.t' does not exist"), errorSyntax},
+		{errors.New("athena query could not finish: SCHEMA_NOT_FOUND: line 1:15: Schema 'db' does not exist"), errorSyntax},
+		{errors.Wrap(awserr.New("NoSuchBucket", "The specified bucket does not exist", nil), "could not write output"), errorOther},
+		{usagef("-out and -out-dir are mutually exclusive"), errorUsage},
+		{errors.Wrap(usagef("-parallel must be at least 1"), "invalid flags"), errorUsage},
+		{errors.New("could not write output"), errorOther},
+	} {
+		if got := classifyError(tt.err); got != tt.want {
+			t.Errorf("classifyError(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRunUsageError(t *testing.T) {
+	defer func(args []string, fs *flag.FlagSet) { os.Args, flag.CommandLine = args, fs }(os.Args, flag.CommandLine)
+	os.Args = []string{"athenaq", "-dry", "-history=false", "-out", "file://out.csv", "-out-dir", "file://out", "-query", "select 1"}
+	flag.CommandLine = flag.NewFlagSet("athenaq", flag.ContinueOnError)
+	err := run()
+	if class := classifyError(err); class != errorUsage || exitStatus[class] != 2 {
+		t.Errorf("got %v of class %q, exit status %d, want 2", err, class, exitStatus[class])
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// athenaStateChange is an "Athena Query State Change" event of EventBridge,
+// as delivered to an SQS queue by a rule with the queue as its target.
+type athenaStateChange struct {
+	DetailType string `json:"detail-type"`
+	Source     string `json:"source"`
+	Detail     struct {
+		CurrentState     string `json:"currentState"`
+		QueryExecutionID string `json:"queryExecutionId"`
+	} `json:"detail"`
+}
+
+// stateChangeID returns the query execution id of a state change event, or
+// "" for other messages.
+func stateChangeID(body string) string {
+	var e athenaStateChange
+	if err := json.Unmarshal([]byte(body), &e); err != nil || e.Source != "aws.athena" || e.DetailType != "Athena Query State Change" {
+		return ""
+	}
+	return e.Detail.QueryExecutionID
+}
+
+// listenEvents makes the poller get the state of a query when an event
+// reports that it changed, and poll all queries only every interval, in
+// case events are late or lost.
+func (p *statusPoller) listenEvents(svc *sqs.SQS, queueURL string, interval time.Duration) {
+	p.interval = interval
+	listenQueue(func() error { return p.receiveEvents(svc, queueURL) })
+}
+
+// receiveEvents long polls the queue once and gets the state of the queries
+// the events are of.
+func (p *statusPoller) receiveEvents(svc *sqs.SQS, queueURL string) error {
+	var ids []*string
+	seen := map[string]bool{}
+	err := receiveMessages(svc, queueURL, func(body string) bool {
+		id := stateChangeID(body)
+		if id == "" || !p.isWaiting(id) {
+			return false
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, aws.String(id))
+		}
+		return true
+	})
+	if len(ids) > 0 {
+		p.fetch(ids)
+	}
+	return err
+}
+
+// listenQueue calls receive until the process exits.
+func listenQueue(receive func() error) {
+	go func() {
+		for {
+			if err := receive(); err != nil {
+				debugf("could not receive events: %v", err)
+				time.Sleep(5 * time.Second)
+			}
+		}
+	}()
+}
+
+// receiveMessages long polls an sqs queue once and deletes the messages
+// handle accepts. The others are left in the queue, they may be of another
+// run, or of a query whose wait hasn't started yet.
+func receiveMessages(svc *sqs.SQS, queueURL string, handle func(body string) bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	out, err := svc.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(queueURL),
+		MaxNumberOfMessages: aws.Int64(10),
+		WaitTimeSeconds:     aws.Int64(20),
+	})
+	if err != nil {
+		return err
+	}
+	var handled []*sqs.DeleteMessageBatchRequestEntry
+	for _, m := range out.Messages {
+		if handle(aws.StringValue(m.Body)) {
+			handled = append(handled, &sqs.DeleteMessageBatchRequestEntry{Id: m.MessageId, ReceiptHandle: m.ReceiptHandle})
+		}
+	}
+	if len(handled) == 0 {
+		return nil
+	}
+	_, err = svc.DeleteMessageBatchWithContext(ctx, &sqs.DeleteMessageBatchInput{QueueUrl: aws.String(queueURL), Entries: handled})
+	return err
+}
+
+func (p *statusPoller) isWaiting(id string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.waiting[id]
+	return ok
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func stateChangeEvent(id, state string) string {
+	return fmt.Sprintf(`{"detail-type":"Athena Query State Change","source":"aws.athena","detail":{"currentState":%q,"queryExecutionId":%q,"workgroupName":"primary"}}`, state, id)
+}
+
+func TestStateChangeID(t *testing.T) {
+	for body, want := range map[string]string{
+		stateChangeEvent("a", "SUCCEEDED"): "a",
+		`{"detail-type":"Glue Data Catalog Table State Change","source":"aws.glue","detail":{"queryExecutionId":"b"}}`: "",
+		`not json`: "",
+	} {
+		if got := stateChangeID(body); got != want {
+			t.Errorf("stateChangeID(%s) = %q, want %q", body, got, want)
+		}
+	}
+}
+
+func TestReceiveEvents(t *testing.T) {
+	var fetched, deleted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target := r.Header.Get("X-Amz-Target"); target != "" {
+			fetched = append(fetched, target)
+			fmt.Fprint(w, `{"QueryExecutions": [{"QueryExecutionId": "a", "Status": {"State": "SUCCEEDED"}}]}`)
+			return
+		}
+		r.ParseForm()
+		switch r.Form.Get("Action") {
+		case "ReceiveMessage":
+			var messages string
+			for i, body := range []string{stateChangeEvent("a", "RUNNING"), stateChangeEvent("a", "SUCCEEDED"), stateChangeEvent("other", "SUCCEEDED"), "hello"} {
+				sum := md5.Sum([]byte(body))
+				messages += fmt.Sprintf("<Message><MessageId>m%d</MessageId><ReceiptHandle>r%d</ReceiptHandle><MD5OfBody>%s</MD5OfBody><Body>%s</Body></Message>",
+					i, i, hex.EncodeToString(sum[:]), html.EscapeString(body))
+			}
+			fmt.Fprintf(w, "<ReceiveMessageResponse><ReceiveMessageResult>%s</ReceiveMessageResult></ReceiveMessageResponse>", messages)
+		case "DeleteMessageBatch":
+			for i := 1; r.Form.Get(fmt.Sprintf("DeleteMessageBatchRequestEntry.%d.Id", i)) != ""; i++ {
+				deleted = append(deleted, r.Form.Get(fmt.Sprintf("DeleteMessageBatchRequestEntry.%d.ReceiptHandle", i)))
+			}
+			fmt.Fprint(w, "<DeleteMessageBatchResponse><DeleteMessageBatchResult></DeleteMessageBatchResult></DeleteMessageBatchResponse>")
+		default:
+			t.Errorf("unexpected call %s", r.Form.Get("Action"))
+		}
+	}))
+	defer srv.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("eu-central-1"),
+		Endpoint:    aws.String(srv.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	p := newStatusPoller(athena.New(sess))
+	p.interval = time.Hour
+	updates, unwatch := p.watch("a")
+	defer unwatch()
+	if err := p.receiveEvents(sqs.New(sess), srv.URL+"/queue"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case s := <-updates:
+		if s.err != nil || aws.StringValue(s.queryExecution.Status.State) != "SUCCEEDED" {
+			t.Errorf("update %v %v", s.queryExecution, s.err)
+		}
+	default:
+		t.Fatal("no update")
+	}
+	if len(fetched) != 1 || !strings.HasSuffix(fetched[0], ".BatchGetQueryExecution") {
+		t.Errorf("athena calls %v, want one BatchGetQueryExecution", fetched)
+	}
+	// the events of other queries and other messages are left in the queue.
+	if strings.Join(deleted, ",") != "r0,r1" {
+		t.Errorf("deleted %v, want r0,r1", deleted)
+	}
+}
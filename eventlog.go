@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/pkg/errors"
+)
+
+type queryEvent struct {
+	Time             time.Time `json:"time"`
+	RunID            string    `json:"run_id"`
+	QueryIndex       int       `json:"query_index"`
+	QueryName        string    `json:"query_name,omitempty"`
+	QueryExecutionID string    `json:"query_execution_id,omitempty"`
+	State            string    `json:"state"`
+	PreviousState    string    `json:"previous_state,omitempty"`
+	Reason           string    `json:"reason,omitempty"`
+	Error            string    `json:"error,omitempty"`
+}
+
+// eventLog appends a json line to -events-log for every state change of a
+// query, written as it happens so that the file can be tailed.
+type eventLog struct {
+	f      *os.File
+	runID  string
+	names  []string
+	states map[int]string
+}
+
+func newEventLog(path, runID string, queries []string) (*eventLog, error) {
+	if strings.Contains(path, "://") && !strings.HasPrefix(path, "file://") {
+		return nil, fmt.Errorf("-events-log %s is not a local file", path)
+	}
+	f, err := os.OpenFile(strings.TrimPrefix(path, "file://"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open events log")
+	}
+	l := &eventLog{f: f, runID: runID, states: map[int]string{}}
+	for _, query := range queries {
+		l.names = append(l.names, queryName(query))
+	}
+	return l, nil
+}
+
+func (l *eventLog) start(index int, query string) {
+	l.states[index] = ""
+	l.record(index, &queryEvent{State: "SUBMITTED"})
+}
+
+func (l *eventLog) update(index int, queryExecution *athena.QueryExecution) {
+	e := &queryEvent{QueryExecutionID: aws.StringValue(queryExecution.QueryExecutionId)}
+	if s := queryExecution.Status; s != nil {
+		e.State, e.Reason = aws.StringValue(s.State), aws.StringValue(s.StateChangeReason)
+	}
+	if e.State != "" && e.State != l.states[index] {
+		l.record(index, e)
+	}
+}
+
+func (l *eventLog) done(index int, queryExecution *athena.QueryExecution, err error) {
+	if queryExecution != nil {
+		l.update(index, queryExecution)
+	}
+	// queries failing outside of athena, e.g. on submission or download,
+	// end with a FAILED event of their own.
+	if err != nil && l.states[index] != "FAILED" && l.states[index] != "CANCELLED" {
+		e := &queryEvent{State: "FAILED", Error: secrets.redact(err.Error())}
+		if queryExecution != nil {
+			e.QueryExecutionID = aws.StringValue(queryExecution.QueryExecutionId)
+		}
+		l.record(index, e)
+	}
+}
+
+func (l *eventLog) record(index int, e *queryEvent) {
+	e.Time, e.RunID, e.QueryIndex, e.QueryName = time.Now().UTC(), l.runID, index+1, l.names[index]
+	e.PreviousState, l.states[index] = l.states[index], e.State
+	e.Reason = secrets.redact(e.Reason)
+	line, err := json.Marshal(e)
+	if err != nil {
+		debugf("could not encode event: %v", err)
+		return
+	}
+	if _, err := l.f.Write(append(line, '\n')); err != nil {
+		errorf("could not write events log: %v", err)
+	}
+}
+
+func (l *eventLog) close() error {
+	return l.f.Close()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+func TestEventLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.jsonl")
+	l, err := newEventLog(path, "run-1", []string{"-- name: daily\nselect 1", "select 2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	execution := func(id, state string) *athena.QueryExecution {
+		return &athena.QueryExecution{QueryExecutionId: aws.String(id), Status: &athena.QueryExecutionStatus{State: aws.String(state)}}
+	}
+	l.start(0, "")
+	l.update(0, execution("a", "QUEUED"))
+	l.update(0, execution("a", "QUEUED"))
+	l.update(0, execution("a", "RUNNING"))
+	l.done(0, execution("a", "SUCCEEDED"), nil)
+	l.start(1, "")
+	l.done(1, nil, errors.New("throttled"))
+	if err := l.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var e queryEvent
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.RunID != "run-1" || e.Time.IsZero() {
+			t.Errorf("event %+v", e)
+		}
+		got = append(got, e.QueryName+"/"+e.QueryExecutionID+"/"+e.PreviousState+">"+e.State+"/"+e.Error)
+	}
+	want := []string{
+		"daily//>SUBMITTED/",
+		"daily/a/SUBMITTED>QUEUED/",
+		"daily/a/QUEUED>RUNNING/",
+		"daily/a/RUNNING>SUCCEEDED/",
+		"//>SUBMITTED/",
+		"//SUBMITTED>FAILED/throttled",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got events\n%q\nwant\n%q", got, want)
+	}
+	if _, err := newEventLog("s3://bucket/events.jsonl", "run-1", nil); err == nil {
+		t.Error("no error for an s3 events log")
+	}
+}
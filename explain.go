@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/pkg/errors"
+)
+
+func explainQuery(query, mode, format string) string {
+	stmt := "EXPLAIN"
+	if mode == "analyze" {
+		stmt += " ANALYZE"
+	}
+	return fmt.Sprintf("%s (FORMAT %s)\n%s", stmt, strings.ToUpper(format), query)
+}
+
+// execExplain runs an EXPLAIN query and writes the plan without the CSV
+// quoting athena puts around each line of it.
+func (awsCli *awsCli) execExplain(ctx context.Context, query, format string, w io.Writer) (*athena.QueryExecution, error) {
+	var buf bytes.Buffer
+	queryExecution, err := awsCli.execQuery(ctx, query, &buf)
+	if err != nil {
+		return queryExecution, err
+	}
+	plan, err := readPlan(&buf)
+	if err != nil {
+		return queryExecution, errors.Wrap(err, "could not read query plan")
+	}
+	if format == "json" {
+		var indented bytes.Buffer
+		if json.Indent(&indented, []byte(plan), "", "  ") == nil {
+			plan = indented.String()
+		}
+	}
+	_, err = fmt.Fprintln(w, strings.TrimRight(plan, "\n"))
+	return queryExecution, err
+}
+
+func readPlan(r io.Reader) (string, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for i, record := range records {
+		if i == 0 && len(record) == 1 && record[0] == "Query Plan" {
+			continue
+		}
+		lines = append(lines, strings.Join(record, ","))
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExplainQuery(t *testing.T) {
+	for _, tt := range []struct {
+		mode, format, want string
+	}{
+		{"", "text", "EXPLAIN (FORMAT TEXT)\nselect 1"},
+		{"analyze", "json", "EXPLAIN ANALYZE (FORMAT JSON)\nselect 1"},
+		{"plan", "graphviz", "EXPLAIN (FORMAT GRAPHVIZ)\nselect 1"},
+	} {
+		if got := explainQuery("select 1", tt.mode, tt.format); got != tt.want {
+			t.Errorf("explainQuery(%q, %q) = %q, want %q", tt.mode, tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestReadPlan(t *testing.T) {
+	for _, tt := range []struct {
+		csv, want string
+	}{
+		{"\"Query Plan\"\n\"Fragment 0 [SINGLE]\"\n\"    Output layout: [x, y]\"\n", "Fragment 0 [SINGLE]\n    Output layout: [x, y]"},
+		{"\"Query Plan\"\n\"{\"\"id\"\": \"\"1\"\"}\"\n", `{"id": "1"}`},
+		{"\"- Output[x]\"\n", "- Output[x]"},
+		{"", ""},
+	} {
+		got, err := readPlan(strings.NewReader(tt.csv))
+		if err != nil || got != tt.want {
+			t.Errorf("readPlan(%q) = %q, %v, want %q", tt.csv, got, err, tt.want)
+		}
+	}
+	if _, err := readPlan(strings.NewReader("\"unterminated\n")); err == nil {
+		t.Error("expected error for invalid CSV")
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// sortBufferSize is the size of the rows sorted in memory before they are
+// spilled to a temp file and merged.
+var sortBufferSize = 64 << 20
+
+type sortKey struct {
+	column string
+	desc   bool
+}
+
+// parseSortKeys parses "col1 asc,col2 desc".
+func parseSortKeys(s string) ([]sortKey, error) {
+	var keys []sortKey
+	for _, k := range strings.Split(s, ",") {
+		words := strings.Fields(k)
+		switch {
+		case len(words) == 1:
+			keys = append(keys, sortKey{column: words[0]})
+		case len(words) == 2 && strings.EqualFold(words[1], "asc"):
+			keys = append(keys, sortKey{column: words[0]})
+		case len(words) == 2 && strings.EqualFold(words[1], "desc"):
+			keys = append(keys, sortKey{column: words[0], desc: true})
+		default:
+			return nil, fmt.Errorf("invalid sort key %q, expected column [asc|desc]", strings.TrimSpace(k))
+		}
+	}
+	return keys, nil
+}
+
+type sortRow struct {
+	raw    []byte
+	values []string
+	nulls  []bool
+}
+
+// sortWriter sorts the rows of a csv result by keys and writes them on
+// flush. Rows beyond sortBufferSize are sorted in runs spilled to temp files
+// which are merged.
+type sortWriter struct {
+	w       io.Writer
+	keys    []sortKey
+	indices []int
+	header  []byte
+	rows    []*sortRow
+	size    int
+	runs    []string
+	split   rowSplitter
+}
+
+func (s *sortWriter) Write(p []byte) (int, error) {
+	return len(p), s.split.write(p, s.add)
+}
+
+func (s *sortWriter) add(raw []byte) error {
+	raw = append([]byte{}, raw...)
+	fields := csvFields(raw)
+	if s.header == nil {
+		names := make([]string, len(s.keys))
+		for i, k := range s.keys {
+			names[i] = k.column
+		}
+		indices, err := columnIndices(fields, names)
+		if err != nil {
+			return err
+		}
+		s.header, s.indices = raw, indices
+		return nil
+	}
+	s.rows = append(s.rows, s.row(raw, fields))
+	if s.size += len(raw); s.size >= sortBufferSize {
+		return s.spill()
+	}
+	return nil
+}
+
+func (s *sortWriter) row(raw []byte, fields [][]byte) *sortRow {
+	r := &sortRow{raw: raw, values: make([]string, len(s.indices)), nulls: make([]bool, len(s.indices))}
+	for n, i := range s.indices {
+		if i < len(fields) {
+			r.values[n], r.nulls[n] = unquoteField(fields[i]), len(fields[i]) == 0
+		}
+	}
+	return r
+}
+
+// less orders rows by the keys, NULLs last like athena does.
+func (s *sortWriter) less(a, b *sortRow) bool {
+	for n, k := range s.keys {
+		if a.nulls[n] || b.nulls[n] {
+			if a.nulls[n] != b.nulls[n] {
+				return b.nulls[n]
+			}
+			continue
+		}
+		c := compareValues(a.values[n], b.values[n])
+		if c == 0 {
+			continue
+		}
+		return c < 0 != k.desc
+	}
+	return false
+}
+
+// compareValues compares numbers numerically and anything else as strings.
+func compareValues(a, b string) int {
+	if x, err := strconv.ParseFloat(a, 64); err == nil {
+		if y, err := strconv.ParseFloat(b, 64); err == nil {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+func (s *sortWriter) sortRows() {
+	sort.SliceStable(s.rows, func(i, j int) bool { return s.less(s.rows[i], s.rows[j]) })
+}
+
+// spill writes the sorted rows as a run of length prefixed rows.
+func (s *sortWriter) spill() error {
+	s.sortRows()
+	f, err := ioutil.TempFile("", "athenaq-sort")
+	if err != nil {
+		return errors.Wrap(err, "could not create sort run")
+	}
+	s.runs = append(s.runs, f.Name())
+	w := bufio.NewWriter(f)
+	var n [binary.MaxVarintLen64]byte
+	for _, r := range s.rows {
+		w.Write(n[:binary.PutUvarint(n[:], uint64(len(r.raw)))])
+		w.Write(r.raw)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "could not write sort run")
+	}
+	s.rows, s.size = nil, 0
+	return f.Close()
+}
+
+func (s *sortWriter) flush() error {
+	defer s.cleanup()
+	if len(s.split.row) > 0 {
+		row := append(s.split.row, '\n')
+		s.split.row = nil
+		if err := s.add(row); err != nil {
+			return err
+		}
+	}
+	if s.header == nil {
+		return nil
+	}
+	return ignoreEnough(s.writeSorted())
+}
+
+func (s *sortWriter) writeSorted() error {
+	if _, err := s.w.Write(s.header); err != nil {
+		return err
+	}
+	if len(s.runs) == 0 {
+		s.sortRows()
+		for _, r := range s.rows {
+			if _, err := s.w.Write(r.raw); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(s.rows) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+	m := &runMerge{less: s.less}
+	for _, name := range s.runs {
+		f, err := os.Open(name)
+		if err != nil {
+			return errors.Wrap(err, "could not read sort run")
+		}
+		defer f.Close()
+		if err := m.push(&sortRun{r: bufio.NewReader(f)}, s); err != nil {
+			return err
+		}
+	}
+	for m.Len() > 0 {
+		run := m.runs[0]
+		if _, err := s.w.Write(run.row.raw); err != nil {
+			return err
+		}
+		heap.Pop(m)
+		if err := m.push(run, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanup removes the spilled runs.
+func (s *sortWriter) cleanup() {
+	for _, name := range s.runs {
+		os.Remove(name)
+	}
+	s.runs = nil
+}
+
+type sortRun struct {
+	r   *bufio.Reader
+	row *sortRow
+}
+
+// runMerge is a heap of runs ordered by their current row.
+type runMerge struct {
+	runs []*sortRun
+	less func(a, b *sortRow) bool
+}
+
+// push reads the next row of run and adds it to the heap, unless the run
+// is exhausted.
+func (m *runMerge) push(run *sortRun, s *sortWriter) error {
+	n, err := binary.ReadUvarint(run.r)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not read sort run")
+	}
+	raw := make([]byte, n)
+	if _, err := io.ReadFull(run.r, raw); err != nil {
+		return errors.Wrap(err, "could not read sort run")
+	}
+	run.row = s.row(raw, csvFields(raw))
+	heap.Push(m, run)
+	return nil
+}
+
+func (m *runMerge) Len() int           { return len(m.runs) }
+func (m *runMerge) Less(i, j int) bool { return m.less(m.runs[i].row, m.runs[j].row) }
+func (m *runMerge) Swap(i, j int)      { m.runs[i], m.runs[j] = m.runs[j], m.runs[i] }
+func (m *runMerge) Push(x interface{}) { m.runs = append(m.runs, x.(*sortRun)) }
+func (m *runMerge) Pop() interface{} {
+	run := m.runs[len(m.runs)-1]
+	m.runs = m.runs[:len(m.runs)-1]
+	return run
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestParseSortKeys(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []sortKey
+		err   bool
+	}{
+		{value: "a", want: []sortKey{{column: "a"}}},
+		{value: "a asc, b DESC", want: []sortKey{{column: "a"}, {column: "b", desc: true}}},
+		{value: "a up", err: true},
+		{value: "a,", err: true},
+	}
+	for _, tt := range tests {
+		keys, err := parseSortKeys(tt.value)
+		if (err != nil) != tt.err || fmt.Sprint(keys) != fmt.Sprint(tt.want) {
+			t.Errorf("%q: got %v, %v", tt.value, keys, err)
+		}
+	}
+}
+
+func TestSortWriter(t *testing.T) {
+	csv := "\"name\",\"n\"\n\"b\",\"10\"\n\"a\",\"9\"\n\"c\",\n\"a\",\"10\"\n\"b\",\"2\""
+	tests := []struct {
+		keys string
+		want string
+	}{
+		{"n", "\"name\",\"n\"\n\"b\",\"2\"\n\"a\",\"9\"\n\"b\",\"10\"\n\"a\",\"10\"\n\"c\",\n"},
+		{"n desc", "\"name\",\"n\"\n\"b\",\"10\"\n\"a\",\"10\"\n\"a\",\"9\"\n\"b\",\"2\"\n\"c\",\n"},
+		{"name, n desc", "\"name\",\"n\"\n\"a\",\"10\"\n\"a\",\"9\"\n\"b\",\"10\"\n\"b\",\"2\"\n\"c\",\n"},
+	}
+	for _, tt := range tests {
+		keys, _ := parseSortKeys(tt.keys)
+		var buf bytes.Buffer
+		sw := &sortWriter{w: &buf, keys: keys}
+		sw.Write([]byte(csv))
+		if err := sw.flush(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.keys, buf.String(), tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	sw := &sortWriter{w: &buf, keys: []sortKey{{column: "missing"}}}
+	if _, err := sw.Write([]byte(csv)); err == nil {
+		t.Error("no error for an unknown column")
+	}
+}
+
+func TestSortWriterSpills(t *testing.T) {
+	defer func(size int) { sortBufferSize = size }(sortBufferSize)
+	sortBufferSize = 100
+
+	var in strings.Builder
+	in.WriteString("\"n\"\n")
+	for _, n := range rand.New(rand.NewSource(1)).Perm(500) {
+		fmt.Fprintf(&in, "\"%d\"\n", n)
+	}
+	var buf bytes.Buffer
+	hw := &headWriter{w: &buf, n: 300}
+	sw := &sortWriter{w: hw, keys: []sortKey{{column: "n"}}}
+	sw.Write([]byte(in.String()))
+	if len(sw.runs) < 2 {
+		t.Fatalf("%d runs, want the rows spilled", len(sw.runs))
+	}
+	if err := sw.flush(); err != nil {
+		t.Fatal(err)
+	}
+	var want strings.Builder
+	want.WriteString("\"n\"\n")
+	for n := 0; n < 300; n++ {
+		fmt.Fprintf(&want, "\"%d\"\n", n)
+	}
+	if buf.String() != want.String() {
+		t.Errorf("got %q", buf.String())
+	}
+	if len(sw.runs) > 0 {
+		t.Error("runs were not removed")
+	}
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/pkg/errors"
+)
+
+// fakeAWS is an in-memory athena, s3 and sts endpoint for the tests and
+// -fake. Queries advance one state (QUEUED, RUNNING, SUCCEEDED) per status
+// request; their results are registered with result and written to the
+// output location once they succeed.
+type fakeAWS struct {
+	*httptest.Server
+	mu      sync.Mutex
+	results map[string]string
+	queries map[string]*fakeQuery
+	objects map[string][]byte
+	tables  map[string]string
+	iceberg map[string]bool
+	buckets map[string]bool
+	calls   map[string]int
+	stuck   map[string]int
+	named   map[string]*namedQuery
+	mails   [][]byte
+	// denyS3 answers object downloads with AccessDenied.
+	denyS3 bool
+}
+
+type fakeQuery struct {
+	ID     string
+	SQL    string
+	Output string
+	State  string
+	Reason string
+	Stuck  bool
+	// Database is the database of the query context, default if unset.
+	Database string
+}
+
+// startFakeAWS starts a fake listening on a local port.
+func startFakeAWS() *fakeAWS {
+	f := &fakeAWS{
+		results: map[string]string{},
+		queries: map[string]*fakeQuery{},
+		objects: map[string][]byte{},
+		tables:  map[string]string{},
+		iceberg: map[string]bool{},
+		buckets: map[string]bool{},
+		calls:   map[string]int{},
+		stuck:   map[string]int{},
+		named:   map[string]*namedQuery{},
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+// loadFakeAWS starts a fake answering each <name>.sql query in dir with the
+// result in <name>.csv.
+func loadFakeAWS(dir string) (*fakeAWS, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list fake queries")
+	}
+	if len(files) == 0 {
+		return nil, errors.Errorf("no fake queries (*.sql) in %s", dir)
+	}
+	f := startFakeAWS()
+	for _, file := range files {
+		sql, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrap(err, "could not read fake query")
+		}
+		csv, err := ioutil.ReadFile(strings.TrimSuffix(file, ".sql") + ".csv")
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrap(err, "could not read fake result")
+		}
+		f.result(strings.TrimSuffix(strings.TrimSpace(string(sql)), ";"), string(csv))
+	}
+	return f, nil
+}
+
+// table registers the s3 location of a glue table.
+func (f *fakeAWS) table(database, name, location string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tables[database+"."+name] = location
+}
+
+// icebergTable registers an iceberg table for GetTable.
+func (f *fakeAWS) icebergTable(database, name, location string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tables[database+"."+name] = location
+	f.iceberg[database+"."+name] = true
+}
+
+// result registers the csv result of a query.
+func (f *fakeAWS) result(sql, csv string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results[fingerprint(sql)] = csv
+}
+
+// queue keeps the next n submissions of sql QUEUED until they are stopped.
+func (f *fakeAWS) queue(sql string, n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stuck[fingerprint(sql)] = n
+}
+
+func (f *fakeAWS) count(op string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[op]
+}
+
+func (f *fakeAWS) session() *session.Session {
+	return session.Must(session.NewSession(&aws.Config{
+		Region:           aws.String("eu-central-1"),
+		Endpoint:         aws.String(f.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:       aws.Int(0),
+	}))
+}
+
+func (f *fakeAWS) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch target := r.Header.Get("X-Amz-Target"); {
+	case strings.HasPrefix(target, "AmazonAthena."):
+		op := strings.TrimPrefix(target, "AmazonAthena.")
+		f.calls[op]++
+		f.athena(w, op, body)
+	case strings.HasPrefix(target, "AWSGlue."):
+		op := strings.TrimPrefix(target, "AWSGlue.")
+		f.calls[op]++
+		f.glue(w, op, body)
+	case strings.Contains(string(body), "Action=GetCallerIdentity"):
+		f.calls["GetCallerIdentity"]++
+		fmt.Fprint(w, `<GetCallerIdentityResponse><GetCallerIdentityResult><Arn>arn:aws:iam::123456789012:user/test</Arn><UserId>AIDTEST</UserId><Account>123456789012</Account></GetCallerIdentityResult></GetCallerIdentityResponse>`)
+	case strings.Contains(string(body), "Action=SendRawEmail"):
+		f.calls["SendRawEmail"]++
+		form, _ := url.ParseQuery(string(body))
+		raw, _ := base64.StdEncoding.DecodeString(form.Get("RawMessage.Data"))
+		f.mails = append(f.mails, raw)
+		fmt.Fprint(w, `<SendRawEmailResponse><SendRawEmailResult><MessageId>mail-1</MessageId></SendRawEmailResult></SendRawEmailResponse>`)
+	default:
+		f.s3(w, r, body)
+	}
+}
+
+func (f *fakeAWS) athena(w http.ResponseWriter, op string, body []byte) {
+	in := struct {
+		QueryString           string
+		QueryExecutionId      string
+		QueryExecutionIds     []string
+		NamedQueryId          string
+		NamedQueryIds         []string
+		ResultConfiguration   struct{ OutputLocation string }
+		QueryExecutionContext struct{ Database string }
+	}{}
+	json.Unmarshal(body, &in)
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	switch op {
+	case "StartQueryExecution":
+		id := fmt.Sprintf("query-%d", len(f.queries)+1)
+		f.queries[id] = &fakeQuery{ID: id, SQL: in.QueryString, Output: strings.TrimRight(in.ResultConfiguration.OutputLocation, "/") + "/" + id + ".csv", State: "QUEUED", Database: in.QueryExecutionContext.Database}
+		if f.stuck[fingerprint(in.QueryString)] > 0 {
+			f.stuck[fingerprint(in.QueryString)]--
+			f.queries[id].Stuck = true
+		}
+		json.NewEncoder(w).Encode(map[string]string{"QueryExecutionId": id})
+	case "GetQueryExecution":
+		q, ok := f.queries[in.QueryExecutionId]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"__type": "InvalidRequestException", "message": "unknown query %s"}`, in.QueryExecutionId)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"QueryExecution": f.advance(q)})
+	case "BatchGetQueryExecution":
+		executions := []interface{}{}
+		for _, id := range in.QueryExecutionIds {
+			if q, ok := f.queries[id]; ok {
+				executions = append(executions, f.advance(q))
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"QueryExecutions": executions})
+	case "GetQueryResults":
+		q, ok := f.queries[in.QueryExecutionId]
+		if !ok || q.State != "SUCCEEDED" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"__type": "InvalidRequestException", "message": "query %s has no results"}`, in.QueryExecutionId)
+			return
+		}
+		records, _ := csv.NewReader(bytes.NewReader(f.objects[strings.TrimPrefix(q.Output, "s3://")])).ReadAll()
+		rows := []interface{}{}
+		for _, record := range records {
+			data := []interface{}{}
+			for _, v := range record {
+				data = append(data, map[string]string{"VarCharValue": v})
+			}
+			rows = append(rows, map[string]interface{}{"Data": data})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"ResultSet": map[string]interface{}{"Rows": rows}})
+	case "ListNamedQueries":
+		ids := []string{}
+		for id := range f.named {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		json.NewEncoder(w).Encode(map[string]interface{}{"NamedQueryIds": ids})
+	case "BatchGetNamedQuery":
+		named := []*namedQuery{}
+		for _, id := range in.NamedQueryIds {
+			named = append(named, f.named[id])
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"NamedQueries": named})
+	case "GetNamedQuery":
+		q, ok := f.named[in.NamedQueryId]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"__type": "InvalidRequestException", "message": "unknown named query %s"}`, in.NamedQueryId)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"NamedQuery": q})
+	case "StopQueryExecution":
+		if q, ok := f.queries[in.QueryExecutionId]; ok && q.State != "SUCCEEDED" && q.State != "FAILED" {
+			q.State = "CANCELLED"
+		}
+		fmt.Fprint(w, `{}`)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"__type": "InvalidRequestException", "message": "%s is not supported by the fake"}`, op)
+	}
+}
+
+// advance moves a running query to its next state and returns its execution.
+func (f *fakeAWS) advance(q *fakeQuery) map[string]interface{} {
+	switch q.State {
+	case "QUEUED":
+		if !q.Stuck {
+			q.State = "RUNNING"
+		}
+	case "RUNNING":
+		csv, ok := f.results[fingerprint(q.SQL)]
+		switch {
+		case ok:
+			q.State = "SUCCEEDED"
+			f.objects[strings.TrimPrefix(q.Output, "s3://")] = []byte(csv)
+			f.objects[strings.TrimPrefix(q.Output, "s3://")+".metadata"] = []byte("metadata of " + q.ID)
+		case statementClass(tokenize(q.SQL)) == "select":
+			q.State, q.Reason = "FAILED", "TABLE_NOT_FOUND: no result registered for the query"
+		default:
+			q.State, q.Reason = "SUCCEEDED", f.ddl(q)
+			if q.Reason != "" {
+				q.State = "FAILED"
+			}
+			f.objects[strings.TrimPrefix(q.Output, "s3://")] = nil
+		}
+	}
+	stmtType := "DML"
+	if statementClass(tokenize(q.SQL)) == "ddl" {
+		stmtType = "DDL"
+	}
+	return map[string]interface{}{
+		"QueryExecutionId":    q.ID,
+		"Query":               q.SQL,
+		"StatementType":       stmtType,
+		"ResultConfiguration": map[string]string{"OutputLocation": q.Output},
+		"Status":              map[string]interface{}{"State": q.State, "StateChangeReason": q.Reason, "SubmissionDateTime": time.Now().Unix()},
+		"Statistics":          map[string]int64{"DataScannedInBytes": int64(len(f.results[fingerprint(q.SQL)]))},
+	}
+}
+
+var externalLocation = regexp.MustCompile(`(?i)external_location\s*=\s*'([^']*)'`)
+
+// ddl applies CREATE TABLE AS and DROP TABLE to the registered tables and
+// returns why the statement failed, or "". A CREATE TABLE AS writes a data
+// object to its external_location, which must be empty, as athena does.
+func (f *fakeAWS) ddl(q *fakeQuery) string {
+	verb, ref, owned := tableStatement(tokenize(q.SQL))
+	if verb == "" {
+		return ""
+	}
+	database := q.Database
+	if database == "" {
+		database = "default"
+	}
+	name := database + "." + ref.parts[len(ref.parts)-1]
+	if len(ref.parts) > 1 {
+		name = strings.Join(ref.parts[len(ref.parts)-2:], ".")
+	}
+	switch {
+	case verb == "DROP":
+		delete(f.tables, name)
+		delete(f.iceberg, name)
+	case owned:
+		if _, ok := f.tables[name]; ok {
+			// keep the location the test registered
+			return ""
+		}
+		location := strings.TrimSuffix(q.Output, ".csv") + "-table/"
+		if m := externalLocation.FindStringSubmatch(q.SQL); m != nil {
+			location = m[1]
+		}
+		prefix := strings.TrimSuffix(strings.TrimPrefix(location, "s3://"), "/") + "/"
+		for key := range f.objects {
+			if strings.HasPrefix(key, prefix) {
+				return "HIVE_PATH_ALREADY_EXISTS: Target directory for table '" + name + "' already exists: " + location
+			}
+		}
+		f.tables[name] = location
+		f.objects[prefix+q.ID] = []byte(q.SQL)
+	}
+	return ""
+}
+
+// glue serves GetTable of the tables registered with table, and creates,
+// updates and deletes them.
+func (f *fakeAWS) glue(w http.ResponseWriter, op string, body []byte) {
+	in := struct {
+		DatabaseName, Name string
+		TableInput         struct {
+			Name              string
+			StorageDescriptor struct{ Location string }
+		}
+	}{}
+	json.Unmarshal(body, &in)
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	if op == "CreateTable" || op == "UpdateTable" {
+		in.Name = in.TableInput.Name
+	}
+	location, ok := f.tables[in.DatabaseName+"."+in.Name]
+	switch {
+	case op == "CreateTable" && ok:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"__type": "AlreadyExistsException", "message": "table %s.%s already exists"}`, in.DatabaseName, in.Name)
+		return
+	case op == "CreateTable" || op == "UpdateTable" && ok:
+		f.tables[in.DatabaseName+"."+in.Name] = in.TableInput.StorageDescriptor.Location
+		fmt.Fprint(w, `{}`)
+		return
+	case op == "DeleteTable" && ok:
+		delete(f.tables, in.DatabaseName+"."+in.Name)
+		delete(f.iceberg, in.DatabaseName+"."+in.Name)
+		fmt.Fprint(w, `{}`)
+		return
+	}
+	params := map[string]string{}
+	if f.iceberg[in.DatabaseName+"."+in.Name] {
+		params["table_type"] = "ICEBERG"
+	}
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"__type": "EntityNotFoundException", "message": "table %s.%s not found"}`, in.DatabaseName, in.Name)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"Table": map[string]interface{}{
+		"Name":              in.Name,
+		"DatabaseName":      in.DatabaseName,
+		"StorageDescriptor": map[string]string{"Location": location},
+		"Parameters":        params,
+	}})
+}
+
+// s3 serves path style bucket and object requests.
+func (f *fakeAWS) s3(w http.ResponseWriter, r *http.Request, body []byte) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket := strings.SplitN(path, "/", 2)[0]
+	if path == bucket {
+		f.calls[r.Method+"Bucket"]++
+		switch r.Method {
+		case "HEAD":
+			if !f.buckets[bucket] {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("X-Amz-Bucket-Region", "eu-central-1")
+		case "PUT":
+			f.buckets[bucket] = true
+		case "GET":
+			prefix := bucket + "/" + r.URL.Query().Get("prefix")
+			var keys []string
+			for key := range f.objects {
+				if strings.HasPrefix(key, prefix) {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			fmt.Fprintf(w, `<ListBucketResult><Name>%s</Name><KeyCount>%d</KeyCount><IsTruncated>false</IsTruncated>`, bucket, len(keys))
+			for _, key := range keys {
+				fmt.Fprintf(w, `<Contents><Key>%s</Key><Size>%d</Size></Contents>`, strings.TrimPrefix(key, bucket+"/"), len(f.objects[key]))
+			}
+			fmt.Fprint(w, `</ListBucketResult>`)
+		case "POST":
+			in := struct {
+				Objects []struct{ Key string } `xml:"Object"`
+			}{}
+			xml.Unmarshal(body, &in)
+			fmt.Fprint(w, `<DeleteResult>`)
+			for _, o := range in.Objects {
+				delete(f.objects, bucket+"/"+o.Key)
+				fmt.Fprintf(w, `<Deleted><Key>%s</Key></Deleted>`, o.Key)
+			}
+			fmt.Fprint(w, `</DeleteResult>`)
+		}
+		return
+	}
+	f.calls[r.Method+"Object"]++
+	switch r.Method {
+	case "PUT":
+		f.buckets[bucket] = true
+		f.objects[path] = body
+		w.Header().Set("ETag", etag(body))
+	case "DELETE":
+		delete(f.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+	case "GET", "HEAD":
+		if f.denyS3 {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+			return
+		}
+		data, ok := f.objects[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+			return
+		}
+		w.Header().Set("ETag", etag(data))
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil {
+			f.calls["RangeObject"]++
+			if start >= len(data) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				fmt.Fprint(w, `<Error><Code>InvalidRange</Code><Message>The requested range is not satisfiable</Message></Error>`)
+				return
+			}
+			if end >= len(data) {
+				end = len(data) - 1
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			w.Header().Set("Content-Length", fmt.Sprint(end-start+1))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[start : end+1])
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		if r.Method == "GET" {
+			w.Write(data)
+		}
+	}
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
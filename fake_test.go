@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// testFake is a fake failing the test when no client can be created for it.
+type testFake struct {
+	*fakeAWS
+	t *testing.T
+}
+
+func newFakeAWS(t *testing.T) *testFake {
+	return &testFake{startFakeAWS(), t}
+}
+
+func (f *testFake) client() *awsCli {
+	awsCli, err := newAWSFromSession(f.session(), addAWSFlags(flag.NewFlagSet("fake", flag.ContinueOnError)))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return awsCli
+}
+
+func TestFakeQueryLifecycle(t *testing.T) {
+	f := newFakeAWS(t)
+	defer f.Close()
+	f.result("select id from users", "\"id\"\n\"1\"\n\"2\"\n")
+	awsCli := f.client()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var buf bytes.Buffer
+	queryExecution, err := awsCli.execQuery(ctx, "SELECT id FROM users", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "\"id\"\n\"1\"\n\"2\"\n" {
+		t.Errorf("got result %q", got)
+	}
+	if state := aws.StringValue(queryExecution.Status.State); state != "SUCCEEDED" {
+		t.Errorf("got state %s", state)
+	}
+	if n := f.count("GetCallerIdentity"); n != 1 {
+		t.Errorf("%d GetCallerIdentity calls, want 1", n)
+	}
+	if n := f.count("PUTBucket"); n != 1 {
+		t.Errorf("%d CreateBucket calls, want 1", n)
+	}
+
+	buf.Reset()
+	ddl, err := awsCli.execQuery(ctx, "create table t (id int)", &buf)
+	if err != nil || buf.Len() > 0 {
+		t.Errorf("ddl: %v, result %q", err, buf.String())
+	}
+	if n := f.count("GETObject"); n != 1 {
+		t.Errorf("%d GetObject calls, want 1 as ddl results are not downloaded", n)
+	}
+
+	if _, err := awsCli.execQuery(ctx, "select * from missing", &buf); err == nil || !strings.Contains(err.Error(), "TABLE_NOT_FOUND") {
+		t.Errorf("got error %v, want TABLE_NOT_FOUND", err)
+	}
+
+	if data, err := awsCli.resultMetadata(ctx, queryExecution); err != nil || string(data) != "metadata of query-1" {
+		t.Errorf("got result metadata %q, %v", data, err)
+	}
+	if data, err := awsCli.resultMetadata(ctx, ddl); err != nil || data != nil {
+		t.Errorf("got ddl result metadata %q, %v", data, err)
+	}
+}
+
+func TestFakeFlag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq-fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs := flag.NewFlagSet("fake", flag.ContinueOnError)
+	flags := addAWSFlags(fs)
+	if err := fs.Parse([]string{"-fake", dir}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newAWS(flags); err == nil || !strings.Contains(err.Error(), "no fake queries") {
+		t.Errorf("got error %v for an empty directory", err)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "users.sql"), []byte("SELECT id\nFROM users;\n"), 0644)
+	if _, err := newAWS(flags); err == nil || !strings.Contains(err.Error(), "could not read fake result") {
+		t.Errorf("got error %v for a query without result", err)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "users.csv"), []byte("\"id\"\n\"1\"\n"), 0644)
+	awsCli, err := newAWS(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var buf bytes.Buffer
+	if _, err := awsCli.execQuery(ctx, "select id from users", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "\"id\"\n\"1\"\n" {
+		t.Errorf("got result %q", got)
+	}
+}
+
+func TestMaxQueueTime(t *testing.T) {
+	f := newFakeAWS(t)
+	defer f.Close()
+	f.result("select 1", "\"_col0\"\n\"1\"\n")
+	f.queue("select 1", 1)
+	awsCli := f.client()
+	awsCli.maxQueueTime, awsCli.queueRetries = time.Millisecond, 1
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var buf bytes.Buffer
+	if _, err := awsCli.execQuery(ctx, "select 1", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.count("StartQueryExecution"); n != 2 {
+		t.Errorf("%d StartQueryExecution calls, want 2", n)
+	}
+	if n := f.count("StopQueryExecution"); n != 1 {
+		t.Errorf("%d StopQueryExecution calls, want 1", n)
+	}
+
+	f.queue("select 1", 1)
+	awsCli.queueRetries = 0
+	if _, err := awsCli.execQuery(ctx, "select 1", &buf); err == nil || !strings.Contains(err.Error(), errQueuedTooLong.Error()) {
+		t.Errorf("got error %v, want %v", err, errQueuedTooLong)
+	}
+}
+
+func TestFetchFallback(t *testing.T) {
+	f := newFakeAWS(t)
+	defer f.Close()
+	f.result("select id, name from users", "\"id\",\"name\"\n\"1\",\"Bob \"\"B\"\"\"\n")
+	f.denyS3 = true
+	awsCli := f.client()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var buf bytes.Buffer
+	if _, err := awsCli.execQuery(ctx, "select id, name from users", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "\"id\",\"name\"\n\"1\",\"Bob \"\"B\"\"\"\n"; got != want {
+		t.Errorf("got result %q, want %q", got, want)
+	}
+	if n := f.count("GetQueryResults"); n != 1 {
+		t.Errorf("%d GetQueryResults calls, want 1", n)
+	}
+
+	f.denyS3 = false
+	awsCli.fetch = "api"
+	buf.Reset()
+	if _, err := awsCli.execQuery(ctx, "select id, name from users", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.count("GETObject"); n != 1 {
+		t.Errorf("%d GetObject calls, want only the denied one", n)
+	}
+}
+
+func TestRunResultPaths(t *testing.T) {
+	f := newFakeAWS(t)
+	defer f.Close()
+	f.result("select 1", "\"_col0\"\n\"1\"\n")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	seen := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		awsCli := f.client()
+		queryExecution, err := awsCli.execQuery(ctx, "select 1", &bytes.Buffer{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		location := aws.StringValue(queryExecution.ResultConfiguration.OutputLocation)
+		if !strings.Contains(location, "/"+awsCli.runID+"/") {
+			t.Errorf("result %s is not below the run id %s", location, awsCli.runID)
+		}
+		seen[path.Dir(location)] = true
+		out, err := awsCli.renderPath("s3://results/{{ .RunID }}/out.csv", nil)
+		if err != nil || out != "s3://results/"+awsCli.runID+"/out.csv" {
+			t.Errorf("rendered output path %q, %v", out, err)
+		}
+	}
+	if len(seen) != 2 {
+		t.Errorf("runs share result paths %v", seen)
+	}
+}
+
+func TestRecordScrubsSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq-fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { recordDir, fixtureRecorder = "", nil }()
+	const secretKey, sessionToken = "wJalrXUtnFEMIK7MDENGbPxRfiCYSECRETKEY", "FwoGZXIvYXdzSESSIONTOKEN"
+	plaintext := []byte("0123456789abcdef0123456789abcdef")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") == "TrentService.GenerateDataKey" {
+			w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+			fmt.Fprintf(w, `{"KeyId": "arn:aws:kms:eu-central-1:123456789012:key/k", "Plaintext": %q, "CiphertextBlob": %q}`,
+				base64.StdEncoding.EncodeToString(plaintext), base64.StdEncoding.EncodeToString([]byte("ciphertext")))
+			return
+		}
+		fmt.Fprintf(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials>
+<AccessKeyId>ASIAEXAMPLE</AccessKeyId><SecretAccessKey>%s</SecretAccessKey><SessionToken>%s</SessionToken>
+<Expiration>2030-01-01T00:00:00Z</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>`, secretKey, sessionToken)
+	}))
+	defer srv.Close()
+
+	recordDir = filepath.Join(dir, "fixtures")
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("eu-central-1"),
+		Endpoint:    aws.String(srv.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	if err := useFixtures(sess); err != nil {
+		t.Fatal(err)
+	}
+	out, err := sts.New(sess).AssumeRole(&sts.AssumeRoleInput{RoleArn: aws.String("arn:aws:iam::123456789012:role/r"), RoleSessionName: aws.String("athenaq")})
+	if err != nil || aws.StringValue(out.Credentials.SecretAccessKey) != secretKey {
+		t.Fatalf("AssumeRole: %v, %v", out, err)
+	}
+	key, _, _, err := newKMS(sess).generateDataKey(context.Background(), "k", nil)
+	if err != nil || !bytes.Equal(key, plaintext) {
+		t.Fatalf("GenerateDataKey: %q, %v", key, err)
+	}
+
+	if fi, err := os.Stat(recordDir); err != nil || fi.Mode().Perm() != 0700 {
+		t.Errorf("fixture directory: %v, %v", fi.Mode(), err)
+	}
+	files, _ := filepath.Glob(filepath.Join(recordDir, "*.json"))
+	if len(files) != 2 {
+		t.Fatalf("recorded %d calls, want 2", len(files))
+	}
+	for _, file := range files {
+		data, _ := ioutil.ReadFile(file)
+		for _, secret := range []string{secretKey, sessionToken, "ASIAEXAMPLE", base64.StdEncoding.EncodeToString(plaintext), string(plaintext)} {
+			if strings.Contains(string(data), secret) {
+				t.Errorf("%s contains %q", file, secret)
+			}
+		}
+		if fi, _ := os.Stat(file); fi.Mode().Perm() != 0600 {
+			t.Errorf("%s has mode %v, want 0600", file, fi.Mode())
+		}
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq-fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { recordDir, replayDir, fixtureRecorder, fixtureReplayer = "", "", nil, nil }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	f := newFakeAWS(t)
+	f.result("select id from users", "\"id\"\n\"1\"\n")
+
+	recordDir = dir
+	sess := f.session()
+	if err := useFixtures(sess); err != nil {
+		t.Fatal(err)
+	}
+	awsCli, err := newAWSFromSession(sess, addAWSFlags(flag.NewFlagSet("record", flag.ContinueOnError)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := awsCli.execQuery(ctx, "select id from users", &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	recordDir, replayDir = "", dir
+	sess = f.session()
+	if err := useFixtures(sess); err != nil {
+		t.Fatal(err)
+	}
+	awsCli, err = newAWSFromSession(sess, addAWSFlags(flag.NewFlagSet("replay", flag.ContinueOnError)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := awsCli.execQuery(ctx, "select id from users", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "\"id\"\n\"1\"\n" {
+		t.Errorf("replayed result %q", got)
+	}
+}
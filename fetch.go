@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/pkg/errors"
+)
+
+// fetchResult writes the result of a query to w, from the csv athena wrote
+// to s3 or from the pages of GetQueryResults as awsCli.fetch prefers. When
+// access to one is denied before anything was written the other is used,
+// e.g. for roles that may call athena but not read the result bucket.
+func (awsCli *awsCli) fetchResult(ctx context.Context, queryExecution *athena.QueryExecution, w io.Writer) error {
+	strategies := []string{"s3", "api"}
+	if awsCli.fetch == "api" {
+		strategies = []string{"api", "s3"}
+	}
+	id := aws.StringValue(queryExecution.QueryExecutionId)
+	cw := &countWriter{w: w}
+	for i, strategy := range strategies {
+		var err error
+		switch strategy {
+		case "s3":
+			err = awsCli.copyS3Contents(ctx, aws.StringValue(queryExecution.ResultConfiguration.OutputLocation), cw)
+		case "api":
+			err = awsCli.copyQueryResults(ctx, id, cw)
+		}
+		if err == nil {
+			debugf("%s: fetched the result %s", id, fetchedFrom(strategy))
+			return nil
+		}
+		if i > 0 || cw.n > 0 || !isAccessDenied(err) {
+			return err
+		}
+		infof("query %d: %v, fetching the result %s instead", queryIndex(ctx)+1, err, fetchedFrom(strategies[1]))
+	}
+	return nil
+}
+
+func fetchedFrom(strategy string) string {
+	if strategy == "api" {
+		return "with GetQueryResults"
+	}
+	return "from s3"
+}
+
+func isAccessDenied(err error) bool {
+	if aerr, ok := errors.Cause(err).(awserr.Error); ok {
+		return aerr.Code() == "AccessDenied" || aerr.Code() == "AccessDeniedException"
+	}
+	return false
+}
+
+// copyQueryResults writes the pages of GetQueryResults to w as csv like the
+// one athena writes to s3: every value quoted, NULL as an empty field.
+func (awsCli *awsCli) copyQueryResults(ctx context.Context, queryExecutionID string, w io.Writer) error {
+	err := awsCli.eachResultPage(ctx, queryExecutionID, func(rows []*athena.Row) error {
+		var buf bytes.Buffer
+		for _, row := range rows {
+			writeCSVRow(&buf, row.Data)
+		}
+		_, err := w.Write(buf.Bytes())
+		if err != nil && err != errEnoughRows {
+			return errors.Wrap(err, "could not write query results")
+		}
+		return err
+	})
+	if err == errEnoughRows {
+		return nil
+	}
+	return err
+}
+
+// eachResultPage calls fn with the rows of each page of GetQueryResults until
+// the last page or fn fails.
+func (awsCli *awsCli) eachResultPage(ctx context.Context, queryExecutionID string, fn func([]*athena.Row) error) error {
+	return awsCli.client.EachResultPage(ctx, queryExecutionID, fn)
+}
+
+func writeCSVRow(buf *bytes.Buffer, data []*athena.Datum) {
+	for i, d := range data {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if d == nil || d.VarCharValue == nil {
+			continue
+		}
+		buf.WriteByte('"')
+		buf.WriteString(strings.Replace(*d.VarCharValue, `"`, `""`, -1))
+		buf.WriteByte('"')
+	}
+	buf.WriteByte('\n')
+}
+
+// countWriter counts the bytes written through it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
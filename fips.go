@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+// useFIPS and useDualStack are set by the -fips and -dual-stack flags.
+var useFIPS, useDualStack bool
+
+func addEndpointFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&useFIPS, "fips", false, "use the FIPS endpoints of athena, s3 and sts, e.g. in GovCloud (also AWS_USE_FIPS_ENDPOINT=true or use_fips_endpoint = true in the profile)")
+	fs.BoolVar(&useDualStack, "dual-stack", false, "use the dual-stack (ipv6) endpoints of athena, s3 and sts (also AWS_USE_DUALSTACK_ENDPOINT=true or use_dualstack_endpoint = true in the profile)")
+}
+
+// endpointVariants returns whether the flags, the environment or the
+// profile of the shared config enable the FIPS or dual-stack endpoints.
+func endpointVariants() (fips, dualStack bool) {
+	fips, dualStack = useFIPS, useDualStack
+	enabled := func(env, key string) bool {
+		if v, err := strconv.ParseBool(os.Getenv(env)); err == nil {
+			return v
+		}
+		cfg, err := loadSharedConfig()
+		if err != nil {
+			return false
+		}
+		s, err := cfg.profile(awsProfile())
+		if err != nil {
+			return false
+		}
+		v, _ := s.Key(key).Bool()
+		return v
+	}
+	if !fips {
+		fips = enabled("AWS_USE_FIPS_ENDPOINT", "use_fips_endpoint")
+	}
+	if !dualStack {
+		dualStack = enabled("AWS_USE_DUALSTACK_ENDPOINT", "use_dualstack_endpoint")
+	}
+	return fips, dualStack
+}
+
+// endpointResolver resolves the FIPS or dual-stack endpoints of athena, s3
+// and sts, and the default endpoints of other services.
+func endpointResolver(fips, dualStack bool) endpoints.Resolver {
+	return endpoints.ResolverFunc(func(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		e, err := endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+		host := endpointHost(service, region, fips, dualStack)
+		if host == "" {
+			return e, err
+		}
+		if err != nil {
+			// the endpoints of the sdk don't know athena in every partition.
+			e = endpoints.ResolvedEndpoint{SigningName: service, SigningMethod: "v4"}
+		}
+		// the global sts endpoint is signed for us-east-1.
+		e.URL, e.SigningRegion = "https://"+host, region
+		return e, nil
+	})
+}
+
+func endpointHost(service, region string, fips, dualStack bool) string {
+	suffix, dualStackSuffix := "amazonaws.com", "api.aws"
+	if strings.HasPrefix(region, "cn-") {
+		suffix, dualStackSuffix = "amazonaws.com.cn", "api.amazonwebservices.com.cn"
+	}
+	switch service {
+	case "athena", "sts":
+		name := service
+		// the sts endpoints of GovCloud are FIPS endpoints.
+		if fips && !(service == "sts" && strings.HasPrefix(region, "us-gov-")) {
+			name += "-fips"
+		}
+		if dualStack {
+			return name + "." + region + "." + dualStackSuffix
+		}
+		if fips {
+			return name + "." + region + "." + suffix
+		}
+	case "s3":
+		name := "s3"
+		if fips {
+			name += "-fips"
+		}
+		if dualStack {
+			name += ".dualstack"
+		}
+		if fips || dualStack {
+			return name + "." + region + "." + suffix
+		}
+	}
+	return ""
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEndpointResolver(t *testing.T) {
+	for _, tc := range []struct {
+		service, region    string
+		fips, dualStack    bool
+		url, signingRegion string
+	}{
+		{"athena", "us-east-1", true, false, "https://athena-fips.us-east-1.amazonaws.com", "us-east-1"},
+		{"athena", "us-gov-west-1", true, true, "https://athena-fips.us-gov-west-1.api.aws", "us-gov-west-1"},
+		{"athena", "eu-west-1", false, true, "https://athena.eu-west-1.api.aws", "eu-west-1"},
+		{"s3", "us-west-2", true, false, "https://s3-fips.us-west-2.amazonaws.com", "us-west-2"},
+		{"s3", "us-west-2", true, true, "https://s3-fips.dualstack.us-west-2.amazonaws.com", "us-west-2"},
+		{"s3", "cn-north-1", false, true, "https://s3.dualstack.cn-north-1.amazonaws.com.cn", "cn-north-1"},
+		{"sts", "us-west-2", true, false, "https://sts-fips.us-west-2.amazonaws.com", "us-west-2"},
+		{"sts", "us-gov-east-1", true, false, "https://sts.us-gov-east-1.amazonaws.com", "us-gov-east-1"},
+		{"sts", "eu-central-1", false, true, "https://sts.eu-central-1.api.aws", "eu-central-1"},
+		{"glue", "us-east-1", true, true, "https://glue.us-east-1.amazonaws.com", "us-east-1"},
+	} {
+		e, err := endpointResolver(tc.fips, tc.dualStack).EndpointFor(tc.service, tc.region)
+		if err != nil {
+			t.Errorf("%s %s: %v", tc.service, tc.region, err)
+			continue
+		}
+		if e.URL != tc.url || (e.SigningRegion != "" && e.SigningRegion != tc.signingRegion) {
+			t.Errorf("%s %s fips=%v dual-stack=%v: %s signed for %s, expected %s signed for %s",
+				tc.service, tc.region, tc.fips, tc.dualStack, e.URL, e.SigningRegion, tc.url, tc.signingRegion)
+		}
+	}
+}
+
+func TestEndpointVariants(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := filepath.Join(dir, "config")
+	ioutil.WriteFile(config, []byte("[profile gov]\nuse_fips_endpoint = true\n"), 0600)
+
+	env := []string{"AWS_CONFIG_FILE", "AWS_PROFILE", "AWS_USE_FIPS_ENDPOINT", "AWS_USE_DUALSTACK_ENDPOINT"}
+	for _, k := range env {
+		defer os.Setenv(k, os.Getenv(k))
+	}
+	defer func(fips, dualStack bool) { useFIPS, useDualStack = fips, dualStack }(useFIPS, useDualStack)
+	for _, tc := range []struct {
+		profile, fipsEnv, dualStackEnv string
+		fipsFlag                       bool
+		fips, dualStack                bool
+	}{
+		{"default", "", "", false, false, false},
+		{"default", "", "", true, true, false},
+		{"default", "true", "1", false, true, true},
+		{"gov", "", "", false, true, false},
+		{"gov", "false", "", false, false, false},
+	} {
+		os.Setenv("AWS_CONFIG_FILE", config)
+		os.Setenv("AWS_PROFILE", tc.profile)
+		os.Setenv("AWS_USE_FIPS_ENDPOINT", tc.fipsEnv)
+		os.Setenv("AWS_USE_DUALSTACK_ENDPOINT", tc.dualStackEnv)
+		useFIPS, useDualStack = tc.fipsFlag, false
+		if fips, dualStack := endpointVariants(); fips != tc.fips || dualStack != tc.dualStack {
+			t.Errorf("%+v: fips=%v dual-stack=%v", tc, fips, dualStack)
+		}
+	}
+}
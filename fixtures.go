@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/pkg/errors"
+)
+
+// recordDir and replayDir are set by the -record and -replay flags.
+var (
+	recordDir string
+	replayDir string
+)
+
+// exchange is a recorded aws api call.
+type exchange struct {
+	Request struct {
+		// Operation identifies the api call: method, path, query and target.
+		Operation string `json:"operation"`
+		// BodySHA256 is the hash of the body, without idempotency tokens.
+		BodySHA256 string `json:"body_sha256"`
+	} `json:"request"`
+	Response struct {
+		Status int         `json:"status"`
+		Header http.Header `json:"header"`
+		Body   string      `json:"body"`
+	} `json:"response"`
+}
+
+var (
+	fixtureMu       sync.Mutex
+	fixtureRecorder *recorder
+	fixtureReplayer *replayer
+)
+
+// useFixtures makes the session record its api calls to recordDir or serve
+// them from replayDir.
+func useFixtures(sess *session.Session) error {
+	if recordDir == "" && replayDir == "" {
+		return nil
+	}
+	if recordDir != "" && replayDir != "" {
+		return errors.New("-record and -replay are mutually exclusive")
+	}
+	fixtureMu.Lock()
+	defer fixtureMu.Unlock()
+	client := http.Client{}
+	if sess.Config.HTTPClient != nil {
+		client = *sess.Config.HTTPClient
+	}
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if recordDir != "" {
+		if fixtureRecorder == nil {
+			if err := os.MkdirAll(recordDir, 0700); err != nil {
+				return errors.Wrap(err, "could not create fixture directory")
+			}
+			fixtureRecorder = &recorder{dir: recordDir, next: next}
+		}
+		client.Transport = fixtureRecorder
+	} else {
+		if fixtureReplayer == nil {
+			r, err := loadFixtures(replayDir)
+			if err != nil {
+				return errors.Wrap(err, "could not load fixtures")
+			}
+			fixtureReplayer = r
+		}
+		client.Transport = fixtureReplayer
+		sess.Config.Credentials = credentials.NewStaticCredentials("replay", "replay", "")
+	}
+	sess.Config.HTTPClient = &client
+	return nil
+}
+
+// requestKey returns the operation of req and the hash of its body, which
+// it leaves readable.
+func requestKey(req *http.Request) (string, string, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return "", "", err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	op := req.Method + " " + req.URL.Path
+	if q := req.URL.Query(); len(q) > 0 {
+		op += "?" + q.Encode()
+	}
+	if target := req.Header.Get("X-Amz-Target"); target != "" {
+		op += " " + target
+	}
+	normalized := body
+	var doc map[string]interface{}
+	if json.Unmarshal(body, &doc) == nil {
+		delete(doc, "ClientRequestToken")
+		normalized, _ = json.Marshal(doc)
+	} else if form, err := url.ParseQuery(string(body)); err == nil && form.Get("Action") != "" {
+		op += " " + form.Get("Action")
+		normalized = []byte(form.Encode())
+	}
+	sum := sha256.Sum256(normalized)
+	return op, hex.EncodeToString(sum[:]), nil
+}
+
+type recorder struct {
+	dir  string
+	next http.RoundTripper
+	mu   sync.Mutex
+	n    int
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	op, sum, err := requestKey(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	e := &exchange{}
+	e.Request.Operation, e.Request.BodySHA256 = op, sum
+	e.Response.Status, e.Response.Header, e.Response.Body = resp.StatusCode, resp.Header, scrubSecrets(body)
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.n++
+	if err := ioutil.WriteFile(filepath.Join(r.dir, fmt.Sprintf("%05d.json", r.n)), data, 0600); err != nil {
+		return nil, errors.Wrap(err, "could not record api call")
+	}
+	return resp, nil
+}
+
+// scrubbedFields are the response fields holding credentials or data keys:
+// of sts, sso, sso-oidc and roles anywhere credentials and of kms data keys.
+// Their values are replaced before a response is recorded, as fixtures are
+// meant to be committed.
+var scrubbedFields = map[string]bool{
+	"accesskeyid":     true,
+	"secretaccesskey": true,
+	"sessiontoken":    true,
+	"accesstoken":     true,
+	"refreshtoken":    true,
+	"idtoken":         true,
+	"clientsecret":    true,
+	"plaintext":       true,
+	"ciphertextblob":  true,
+}
+
+// scrubbed is the value of a scrubbed field, valid base64 so that replayed
+// blobs still decode.
+const scrubbed = "UkVEQUNURUQ="
+
+var xmlField = regexp.MustCompile(`<(\w+)>[^<]*</(\w+)>`)
+
+// scrubSecrets returns the response body with the values of scrubbedFields
+// replaced, in json and in the xml of the query protocol of sts.
+func scrubSecrets(body []byte) string {
+	var doc interface{}
+	if json.Unmarshal(body, &doc) == nil {
+		if scrubJSON(doc) {
+			data, _ := json.Marshal(doc)
+			return string(data)
+		}
+		return string(body)
+	}
+	return xmlField.ReplaceAllStringFunc(string(body), func(field string) string {
+		m := xmlField.FindStringSubmatch(field)
+		if m[1] != m[2] || !scrubbedFields[strings.ToLower(m[1])] {
+			return field
+		}
+		return "<" + m[1] + ">" + scrubbed + "</" + m[1] + ">"
+	})
+}
+
+// scrubJSON replaces the values of scrubbedFields in doc and reports
+// whether it found any.
+func scrubJSON(doc interface{}) bool {
+	found := false
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if _, ok := value.(string); ok && scrubbedFields[strings.ToLower(key)] {
+				v[key], found = scrubbed, true
+				continue
+			}
+			found = scrubJSON(value) || found
+		}
+	case []interface{}:
+		for _, value := range v {
+			found = scrubJSON(value) || found
+		}
+	}
+	return found
+}
+
+// replayer answers api calls with the recorded responses of the same
+// request, in the order they were recorded. Polling calls whose bodies
+// depend on timing fall back to the responses of the same operation. The
+// last response of a request is repeated once the others are used up.
+type replayer struct {
+	mu          sync.Mutex
+	byRequest   map[string][]*exchange
+	byOperation map[string][]*exchange
+}
+
+func loadFixtures(dir string) (*replayer, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no fixtures in %s", dir)
+	}
+	sort.Strings(files)
+	r := &replayer{byRequest: map[string][]*exchange{}, byOperation: map[string][]*exchange{}}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		e := &exchange{}
+		if err := json.Unmarshal(data, e); err != nil {
+			return nil, errors.Wrapf(err, "could not parse %s", file)
+		}
+		key := e.Request.Operation + " " + e.Request.BodySHA256
+		r.byRequest[key] = append(r.byRequest[key], e)
+		r.byOperation[e.Request.Operation] = append(r.byOperation[e.Request.Operation], e)
+	}
+	return r, nil
+}
+
+func (r *replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	op, sum, err := requestKey(req)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := nextExchange(r.byRequest, op+" "+sum)
+	if e == nil {
+		e = nextExchange(r.byOperation, op)
+	}
+	if e == nil {
+		return nil, fmt.Errorf("no recorded response for %s", op)
+	}
+	return &http.Response{
+		Status:        http.StatusText(e.Response.Status),
+		StatusCode:    e.Response.Status,
+		Header:        e.Response.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(e.Response.Body))),
+		ContentLength: int64(len(e.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+// nextExchange pops the first exchange recorded for key, keeping the last
+// one.
+func nextExchange(exchanges map[string][]*exchange, key string) *exchange {
+	queue := exchanges[key]
+	switch len(queue) {
+	case 0:
+		return nil
+	case 1:
+		return queue[0]
+	}
+	exchanges[key] = queue[1:]
+	return queue[0]
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const formatIndent = "  "
+
+var sqlKeywords = map[string]bool{}
+
+func init() {
+	for _, k := range strings.Fields(`
+		ADD ALL ALTER ANALYZE AND ANY ARRAY AS ASC BERNOULLI BETWEEN BY CASE CAST
+		COLUMN COLUMNS CREATE CROSS CUBE CURRENT DATABASE DELETE DESC DESCRIBE
+		DISTINCT DROP ELSE END ESCAPE EXCEPT EXISTS EXPLAIN EXTERNAL FALSE FETCH
+		FILTER FIRST FOLLOWING FOR FROM FULL GROUP GROUPING HAVING IF
+		IGNORE ILIKE IN INNER INSERT INTERSECT INTERVAL INTO IS JOIN LAST LATERAL
+		LEFT LIKE LIMIT LOCATION MAP MATCHED MERGE MSCK NATURAL NOT NULL NULLS
+		OF OFFSET ON OR ORDER OUTER OVER PARTITION PARTITIONED PRECEDING
+		RANGE RECURSIVE REPAIR REPLACE RESPECT RIGHT ROLLUP ROW ROWS SCHEMA
+		SELECT SERDE SET SETS SHOW STORED SYSTEM TABLE TABLESAMPLE TBLPROPERTIES
+		THEN TIES TO TRUE TRY_CAST UNBOUNDED UNION UNLOAD UNNEST UPDATE USING
+		VALUES VERSION VIEW WHEN WHERE WINDOW WITH`) {
+		sqlKeywords[k] = true
+	}
+}
+
+func fmtCmd(args []string) error {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: athenaq fmt [flags] [path ...]")
+		fs.PrintDefaults()
+	}
+	var (
+		write  = fs.Bool("w", false, "write result to (source) file instead of stdout")
+		check  = fs.Bool("check", false, "list files whose formatting differs and exit with a non-zero status")
+		delims delimsFlag
+	)
+	fs.Var(&delims, "template-delims", `left,right delimiters of template expressions to keep as they are, e.g. "[[,]]" ("" == "{{,}}")`)
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		in, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return errors.Wrap(err, "could not read input")
+		}
+		formatted := formatSQLDelims(string(in), delims)
+		if *check {
+			if formatted != string(in) {
+				return errors.New("<standard input> is not formatted")
+			}
+			return nil
+		}
+		_, err = os.Stdout.WriteString(formatted)
+		return err
+	}
+
+	var unformatted []string
+	err := walkSQLFiles(fs.Args(), func(path string, info os.FileInfo) error {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		formatted := formatSQLDelims(string(data), delims)
+		switch {
+		case *check:
+			if formatted != string(data) {
+				fmt.Println(path)
+				unformatted = append(unformatted, path)
+			}
+		case *write:
+			if formatted != string(data) {
+				return ioutil.WriteFile(path, []byte(formatted), info.Mode())
+			}
+		default:
+			_, err = os.Stdout.WriteString(formatted)
+		}
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not format")
+	}
+	if len(unformatted) > 0 {
+		return fmt.Errorf("%d file(s) not formatted", len(unformatted))
+	}
+	return nil
+}
+
+// walkSQLFiles calls fn for every path in roots and every *.sql file below
+// the directories among them.
+func walkSQLFiles(roots []string, fn func(path string, info os.FileInfo) error) error {
+	for _, root := range roots {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || (path != root && filepath.Ext(path) != ".sql") {
+				return nil
+			}
+			return fn(path, info)
+		})
+		if err != nil {
+			return errors.Wrapf(err, "%q", root)
+		}
+	}
+	return nil
+}
+
+type formatFrame struct {
+	block  bool
+	level  int
+	clause string
+}
+
+type formatter struct {
+	buf     bytes.Buffer
+	frames  []*formatFrame
+	space   bool
+	brk     int
+	indent  int
+	between bool
+}
+
+// formatSQL reformats sql: keywords are upper cased, every clause starts on
+// its own line and subqueries are indented. Comments and template actions are
+// kept as they are.
+func formatSQL(sql string) string {
+	return formatSQLDelims(sql, delimsFlag{})
+}
+
+// formatSQLDelims is formatSQL keeping the template actions between the
+// -template-delims delims as they are too.
+func formatSQLDelims(sql string, delims delimsFlag) string {
+	f := &formatter{frames: []*formatFrame{{block: true}}, brk: -1}
+	toks := tokenizeDelims(sql, delims)
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		fr := f.frames[len(f.frames)-1]
+		switch t.kind {
+		case tokenSpace:
+			f.space = true
+			if f.buf.Len() > 0 && strings.Count(t.text, "\n") > 1 && len(f.frames) == 1 && fr.clause == "" {
+				f.blankLine()
+			}
+			continue
+		case tokenComment:
+			ownLine := i > 0 && toks[i-1].kind == tokenSpace && strings.Contains(toks[i-1].text, "\n")
+			if !ownLine && f.buf.Len() > 0 && !bytes.HasSuffix(f.buf.Bytes(), []byte("\n")) {
+				// keep end of line comments where they are and break
+				// after them as the preceding token would have.
+				pending := f.brk
+				f.brk = -1
+				f.space = true
+				f.emit(t.text)
+				if pending < 0 && strings.HasPrefix(t.text, "--") {
+					pending = f.indent
+				}
+				f.brk = pending
+				continue
+			}
+			if ownLine && f.brk < 0 {
+				f.lineBreak(f.indent)
+			}
+			f.emit(t.text)
+			if strings.HasPrefix(t.text, "--") {
+				f.lineBreak(f.indent)
+			}
+			continue
+		}
+
+		text := t.text
+		if t.kind == tokenWord && sqlKeywords[strings.ToUpper(text)] && !qualified(toks, i) {
+			text = strings.ToUpper(text)
+		}
+
+		switch {
+		case text == ";" && len(f.frames) == 1:
+			f.brk = -1
+			f.space = false
+			f.emit(";")
+			fr.clause = ""
+			f.blankLine()
+		case text == "(":
+			j := nextToken(toks, i+1)
+			sub := j < len(toks) && toks[j].is("SELECT", "WITH")
+			f.emit("(")
+			level := fr.level
+			if sub {
+				level++
+			}
+			f.frames = append(f.frames, &formatFrame{block: sub, level: level})
+		case text == ")" && len(f.frames) > 1:
+			f.frames = f.frames[:len(f.frames)-1]
+			if fr.block {
+				f.lineBreak(fr.level - 1)
+			}
+			f.emit(")")
+		case fr.block && f.isClause(toks, i):
+			f.lineBreak(fr.level)
+			f.emit(text)
+			fr.clause = text
+			if text == "SELECT" {
+				if j := nextToken(toks, i+1); j < len(toks) && toks[j].is("DISTINCT", "ALL") {
+					i = j
+					f.space = true
+					f.emit(strings.ToUpper(toks[j].text))
+				}
+				f.lineBreak(fr.level + 1)
+			}
+		case text == "," && fr.block && (fr.clause == "SELECT" || fr.clause == "WITH"):
+			f.emit(",")
+			if fr.clause == "SELECT" {
+				f.lineBreak(fr.level + 1)
+			} else {
+				f.lineBreak(fr.level)
+			}
+		case (text == "AND" || text == "OR") && fr.block && (fr.clause == "WHERE" || fr.clause == "HAVING"):
+			if text == "AND" && f.between {
+				f.between = false
+				f.emit(text)
+				break
+			}
+			f.lineBreak(fr.level + 1)
+			f.emit(text)
+		default:
+			if text == "BETWEEN" {
+				f.between = true
+			}
+			f.emit(text)
+		}
+	}
+	out := strings.TrimSpace(f.buf.String())
+	if out == "" {
+		return ""
+	}
+	return out + "\n"
+}
+
+// qualified reports whether the word at i is part of a dotted name, e.g. a
+// column called filter in x.filter, and hence not a keyword.
+func qualified(toks []token, i int) bool {
+	return (i > 0 && toks[i-1].text == ".") || (i+1 < len(toks) && toks[i+1].text == ".")
+}
+
+func (f *formatter) isClause(toks []token, i int) bool {
+	t := toks[i]
+	switch {
+	case t.is("SELECT", "FROM", "WHERE", "GROUP", "HAVING", "ORDER", "LIMIT", "OFFSET", "FETCH", "WINDOW", "UNION", "INTERSECT", "EXCEPT", "VALUES"):
+		return true
+	case t.is("WITH"):
+		j := nextToken(toks, i+1)
+		return j < len(toks) && toks[j].text != "("
+	case t.is("LEFT", "RIGHT", "FULL", "INNER", "CROSS", "NATURAL"):
+		j := nextToken(toks, i+1)
+		return j < len(toks) && toks[j].is("JOIN", "OUTER")
+	case t.is("JOIN"):
+		j := i - 1
+		for j >= 0 && !toks[j].significant() {
+			j--
+		}
+		return j < 0 || !toks[j].is("LEFT", "RIGHT", "FULL", "INNER", "CROSS", "NATURAL", "OUTER")
+	}
+	return false
+}
+
+func (f *formatter) lineBreak(level int) {
+	if f.buf.Len() > 0 {
+		f.brk = level
+	}
+}
+
+func (f *formatter) blankLine() {
+	if !bytes.HasSuffix(f.buf.Bytes(), []byte("\n")) {
+		f.buf.WriteString("\n")
+	}
+	f.brk = 0
+	f.space = false
+}
+
+func (f *formatter) emit(text string) {
+	switch {
+	case f.brk >= 0:
+		b := f.buf.Bytes()
+		f.buf.Truncate(len(bytes.TrimRight(b, " ")))
+		f.buf.WriteString("\n" + strings.Repeat(formatIndent, f.brk))
+		f.indent = f.brk
+	case f.space && f.buf.Len() > 0:
+		if !strings.ContainsAny(text[:1], ",).;") && !bytes.HasSuffix(f.buf.Bytes(), []byte("(")) {
+			f.buf.WriteString(" ")
+		}
+	}
+	f.buf.WriteString(text)
+	f.brk = -1
+	f.space = false
+}
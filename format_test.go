@@ -0,0 +1,119 @@
+package main
+
+import "testing"
+
+var formatTests = []struct {
+	name, in, want string
+}{
+	{"empty", "  \n", ""},
+	{
+		"clauses",
+		"select a, b from t where x = 1 and y = 2 group by a order by b limit 5",
+		"SELECT\n  a,\n  b\nFROM t\nWHERE x = 1\n  AND y = 2\nGROUP BY a\nORDER BY b\nLIMIT 5\n",
+	},
+	{
+		"distinct",
+		"select distinct a from t",
+		"SELECT DISTINCT\n  a\nFROM t\n",
+	},
+	{
+		"between",
+		"select a from t where dt between '1' and '2' and x = 1",
+		"SELECT\n  a\nFROM t\nWHERE dt BETWEEN '1' AND '2'\n  AND x = 1\n",
+	},
+	{
+		"joins",
+		"select * from a left join b on a.id = b.id join c using (id)",
+		"SELECT\n  *\nFROM a\nLEFT JOIN b ON a.id = b.id\nJOIN c USING (id)\n",
+	},
+	{
+		"subquery",
+		"select * from (select a from t) s",
+		"SELECT\n  *\nFROM (\n  SELECT\n    a\n  FROM t\n) s\n",
+	},
+	{
+		"cte",
+		"with x as (select 1), y as (select 2) select * from x, y",
+		"WITH x AS (\n  SELECT\n    1\n),\ny AS (\n  SELECT\n    2\n)\nSELECT\n  *\nFROM x, y\n",
+	},
+	{
+		"ctas properties",
+		"create table t with (format = 'PARQUET') as select 1",
+		"CREATE TABLE t WITH (format = 'PARQUET') AS\nSELECT\n  1\n",
+	},
+	{
+		"function calls",
+		"select count(*), max(a) from t",
+		"SELECT\n  count(*),\n  max(a)\nFROM t\n",
+	},
+	{
+		"qualified names keep their case",
+		"select x.filter, first.location from t",
+		"SELECT\n  x.filter,\n  first.location\nFROM t\n",
+	},
+	{
+		"end of line comment",
+		"select\n a, -- first\n b from t",
+		"SELECT\n  a, -- first\n  b\nFROM t\n",
+	},
+	{
+		"end of line comment in condition",
+		"select a from t where x = 1 -- x\n and y = 2",
+		"SELECT\n  a\nFROM t\nWHERE x = 1 -- x\n  AND y = 2\n",
+	},
+	{
+		"own line comment",
+		"-- head\nselect a,\n  -- b\n  b\nfrom t",
+		"-- head\nSELECT\n  a,\n  -- b\n  b\nFROM t\n",
+	},
+	{
+		"block comment",
+		"select a /* x */, b from t",
+		"SELECT\n  a /* x */,\n  b\nFROM t\n",
+	},
+	{
+		"statements",
+		"select 1;\n\n\nselect 2;",
+		"SELECT\n  1;\n\nSELECT\n  2;\n",
+	},
+	{
+		"template actions",
+		"select * from {{ .TABLE }} where dt = '{{ .DT }}'",
+		"SELECT\n  *\nFROM {{ .TABLE }}\nWHERE dt = '{{ .DT }}'\n",
+	},
+	{
+		"jinja tags",
+		"{# daily #}select * from t where {% for d in days %}dt = '{{ d }}' or {% endfor %} false",
+		"{# daily #}\nSELECT\n  *\nFROM t\nWHERE {% for d in days %}dt = '{{ d }}'\n  OR {% endfor %} FALSE\n",
+	},
+}
+
+func TestFormatSQL(t *testing.T) {
+	for _, tt := range formatTests {
+		if got := formatSQL(tt.in); got != tt.want {
+			t.Errorf("%s: formatSQL(%q) =\n%s\nwant\n%s", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatSQLIdempotent(t *testing.T) {
+	for _, tt := range formatTests {
+		once := formatSQL(tt.in)
+		if twice := formatSQL(once); twice != once {
+			t.Errorf("%s: formatting twice changed\n%s\nto\n%s", tt.name, once, twice)
+		}
+	}
+}
+
+func TestFormatSQLDelims(t *testing.T) {
+	delims := delimsFlag{"[[", "]]"}
+	in := "select * from [[ .table ]] where [[ if .x ]]dt = '[[ .dt ]]' and [[ end ]] true"
+	want := "SELECT\n  *\nFROM [[ .table ]]\nWHERE [[ if .x ]]dt = '[[ .dt ]]'\n  AND [[ end ]] TRUE\n"
+	once := formatSQLDelims(in, delims)
+	if once != want {
+		t.Errorf("formatSQLDelims(%q) =\n%s\nwant\n%s", in, once, want)
+	}
+	if twice := formatSQLDelims(once, delims); twice != once {
+		t.Errorf("formatting twice changed\n%s\nto\n%s", once, twice)
+	}
+}
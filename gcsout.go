@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// gcsEndpoint is the google cloud storage api, replaced in tests.
+var gcsEndpoint = "https://storage.googleapis.com"
+
+var cloudClient = &http.Client{Timeout: 5 * time.Minute}
+
+func init() {
+	registerOutputWriter(outputWriterFunc(writeGCS), "gs")
+}
+
+// writeGCS uploads an output to gs://bucket/object with the application
+// default credentials of google cloud.
+func writeGCS(awsCli *awsCli, r io.ReadSeeker, u *url.URL) error {
+	bucket, object := u.Host, strings.TrimLeft(u.Path, "/")
+	if bucket == "" || object == "" {
+		return fmt.Errorf("gcs bucket or object empty in %q", u)
+	}
+	token, err := gcsCredentials.token()
+	if err != nil {
+		return errors.Wrap(err, "could not get google cloud credentials")
+	}
+	endpoint := gcsEndpoint + "/upload/storage/v1/b/" + url.PathEscape(bucket) + "/o?uploadType=media&name=" + url.QueryEscape(object)
+	req, err := http.NewRequest("POST", endpoint, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType(object))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if err := sendCloud(req); err != nil {
+		return errors.Wrap(err, "could not upload result to gcs")
+	}
+	return nil
+}
+
+// sendCloud sends a request to the api of another cloud, failing on
+// responses other than 2xx.
+func sendCloud(req *http.Request) error {
+	resp, err := cloudClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	debugf("%s %s: %s", req.Method, req.URL.Redacted(), resp.Status)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+var gcsCredentials = &googleToken{}
+
+// googleToken is an access token of the application default credentials:
+// GOOGLE_OAUTH_ACCESS_TOKEN, or the service account or user credentials in
+// GOOGLE_APPLICATION_CREDENTIALS or of gcloud auth application-default
+// login. It is refreshed when it expires.
+type googleToken struct {
+	mu      sync.Mutex
+	value   string
+	expires time.Time
+}
+
+// googleCredentials is a credentials file of google cloud.
+type googleCredentials struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (t *googleToken) token() (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.value != "" && time.Until(t.expires) > time.Minute {
+		return t.value, nil
+	}
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(dir, "gcloud", "application_default_credentials.json")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrap(err, "could not read credentials, set GOOGLE_APPLICATION_CREDENTIALS or GOOGLE_OAUTH_ACCESS_TOKEN")
+	}
+	var creds googleCredentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return "", errors.Wrapf(err, "could not parse %s", path)
+	}
+	if creds.TokenURI == "" {
+		creds.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	form := url.Values{}
+	switch creds.Type {
+	case "service_account":
+		assertion, err := creds.assertion(time.Now())
+		if err != nil {
+			return "", err
+		}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+	case "authorized_user":
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", creds.ClientID)
+		form.Set("client_secret", creds.ClientSecret)
+		form.Set("refresh_token", creds.RefreshToken)
+	default:
+		return "", fmt.Errorf("unsupported credentials type %q in %s", creds.Type, path)
+	}
+	resp, err := cloudClient.PostForm(creds.TokenURI, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		Error       string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil || resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not get an access token: %s %s", resp.Status, out.Error)
+	}
+	secrets.add(out.AccessToken)
+	t.value, t.expires = out.AccessToken, time.Now().Add(time.Duration(out.ExpiresIn)*time.Second)
+	return t.value, nil
+}
+
+// assertion is the signed JWT a service account exchanges for an access
+// token.
+func (c *googleCredentials) assertion(now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(c.PrivateKey))
+	if block == nil {
+		return "", errors.New("no private key in the service account credentials")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", errors.Wrap(err, "could not parse the private key of the service account")
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("the private key of the service account is not an RSA key")
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   c.ClientEmail,
+		"scope": "https://www.googleapis.com/auth/devstorage.read_write",
+		"aud":   c.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteGCS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uploads []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			parts := strings.Split(r.FormValue("assertion"), ".")
+			sig, _ := base64.RawURLEncoding.DecodeString(parts[len(parts)-1])
+			sum := sha256.Sum256([]byte(strings.Join(parts[:2], ".")))
+			if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error_description":"invalid signature"}`)
+				return
+			}
+			fmt.Fprint(w, `{"access_token":"token-1","expires_in":3600}`)
+		case "/upload/storage/v1/b/exports/o":
+			body, _ := ioutil.ReadAll(r.Body)
+			uploads = append(uploads, r.Header.Get("Authorization")+" "+r.URL.Query().Get("name")+" "+string(body))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	defer func(endpoint string) { gcsEndpoint = endpoint }(gcsEndpoint)
+	gcsEndpoint = srv.URL
+
+	dir, err := ioutil.TempDir("", "athenaq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	creds, _ := json.Marshal(googleCredentials{
+		Type:        "service_account",
+		ClientEmail: "exporter@project.iam.gserviceaccount.com",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		TokenURI:    srv.URL + "/token",
+	})
+	path := filepath.Join(dir, "credentials.json")
+	if err := ioutil.WriteFile(path, creds, 0600); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{"GOOGLE_APPLICATION_CREDENTIALS": path, "GOOGLE_OAUTH_ACCESS_TOKEN": ""} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+	defer func() { gcsCredentials = &googleToken{} }()
+
+	for _, object := range []string{"daily/a.csv", "daily/b.csv"} {
+		if err := (*awsCli)(nil).writeOut(strings.NewReader("a,b\n"), "gs://exports/"+object); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"Bearer token-1 daily/a.csv a,b\n", "Bearer token-1 daily/b.csv a,b\n"}
+	if fmt.Sprint(uploads) != fmt.Sprint(want) {
+		t.Errorf("got uploads %q, want %q", uploads, want)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/private/protocol/jsonrpc"
+	"github.com/pkg/errors"
+)
+
+// glueClient is a minimal AWS Glue client covering the catalog calls athenaq
+// needs, built the same way as the generated aws-sdk-go service clients.
+type glueClient struct {
+	*client.Client
+}
+
+func newGlue(p client.ConfigProvider, cfgs ...*aws.Config) *glueClient {
+	c := p.ClientConfig("glue", cfgs...)
+	svc := &glueClient{
+		Client: client.New(
+			*c.Config,
+			metadata.ClientInfo{
+				ServiceName:   "glue",
+				SigningName:   c.SigningName,
+				SigningRegion: c.SigningRegion,
+				Endpoint:      c.Endpoint,
+				APIVersion:    "2017-03-31",
+				JSONVersion:   "1.1",
+				TargetPrefix:  "AWSGlue",
+			},
+			c.Handlers,
+		),
+	}
+	svc.Handlers.Sign.PushBackNamed(v4.SignRequestHandler)
+	svc.Handlers.Build.PushBackNamed(jsonrpc.BuildHandler)
+	svc.Handlers.Unmarshal.PushBackNamed(jsonrpc.UnmarshalHandler)
+	svc.Handlers.UnmarshalMeta.PushBackNamed(jsonrpc.UnmarshalMetaHandler)
+	svc.Handlers.UnmarshalError.PushBackNamed(jsonrpc.UnmarshalErrorHandler)
+	return svc
+}
+
+func (c *glueClient) send(ctx context.Context, op string, input, output interface{}) error {
+	req := c.NewRequest(&request.Operation{Name: op, HTTPMethod: "POST", HTTPPath: "/"}, input, output)
+	req.SetContext(ctx)
+	return req.Send()
+}
+
+type glueColumn struct {
+	Name    *string
+	Type    *string
+	Comment *string
+}
+
+type glueStorageDescriptor struct {
+	Columns      []*glueColumn
+	Location     *string
+	InputFormat  *string
+	OutputFormat *string
+	SerdeInfo    *glueSerdeInfo
+}
+
+type glueSerdeInfo struct {
+	SerializationLibrary *string
+	Parameters           map[string]*string
+}
+
+type glueTable struct {
+	Name              *string
+	DatabaseName      *string
+	Description       *string
+	TableType         *string
+	PartitionKeys     []*glueColumn
+	StorageDescriptor *glueStorageDescriptor
+	Parameters        map[string]*string
+	ViewOriginalText  *string
+	UpdateTime        *time.Time
+}
+
+func (c *glueClient) getTable(ctx context.Context, database, name string) (*glueTable, error) {
+	out := struct{ Table *glueTable }{}
+	err := c.send(ctx, "GetTable", &struct{ DatabaseName, Name *string }{&database, &name}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return out.Table, nil
+}
+
+func (c *glueClient) getTables(ctx context.Context, database string) ([]*glueTable, error) {
+	var tables []*glueTable
+	var next *string
+	for {
+		out := struct {
+			TableList []*glueTable
+			NextToken *string
+		}{}
+		err := c.send(ctx, "GetTables", &struct{ DatabaseName, NextToken *string }{&database, next}, &out)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, out.TableList...)
+		if out.NextToken == nil {
+			return tables, nil
+		}
+		next = out.NextToken
+	}
+}
+
+// sendRaw makes a glue call with body as json and returns the json response,
+// for calls whose shapes glueTable only mirrors in part.
+func (c *glueClient) sendRaw(ctx context.Context, op string, body interface{}) ([]byte, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	var out []byte
+	req := c.NewRequest(&request.Operation{Name: op, HTTPMethod: "POST", HTTPPath: "/"}, nil, nil)
+	req.SetContext(ctx)
+	req.Handlers.Build.PushBack(func(r *request.Request) { r.SetBufferBody(data) })
+	req.Handlers.Unmarshal.PushFront(func(r *request.Request) {
+		if out, err = ioutil.ReadAll(r.HTTPResponse.Body); err != nil {
+			r.Error = err
+		}
+	})
+	return out, req.Send()
+}
+
+// tableInputFields are the fields of a glue Table that are also fields of a
+// TableInput.
+var tableInputFields = []string{"Description", "Owner", "LastAccessTime", "LastAnalyzedTime", "Retention",
+	"StorageDescriptor", "PartitionKeys", "ViewOriginalText", "ViewExpandedText", "TableType", "Parameters", "TargetTable"}
+
+// swapTable replaces the table name with the table from, which it removes,
+// in one glue call so that readers see either table complete. It returns the
+// location of the replaced table, "" if there was none.
+func (c *glueClient) swapTable(ctx context.Context, database, from, name string) (string, error) {
+	data, err := c.sendRaw(ctx, "GetTable", map[string]string{"DatabaseName": database, "Name": from})
+	if err != nil {
+		return "", errors.Wrapf(err, "could not get table %s.%s", database, from)
+	}
+	out := struct{ Table map[string]json.RawMessage }{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return "", errors.Wrapf(err, "could not parse table %s.%s", database, from)
+	}
+	input := map[string]interface{}{"Name": name}
+	for _, field := range tableInputFields {
+		if v, ok := out.Table[field]; ok {
+			input[field] = v
+		}
+	}
+	old, err := c.getTable(ctx, database, name)
+	if err != nil && !isNotFound(err) {
+		return "", errors.Wrapf(err, "could not get table %s.%s", database, name)
+	}
+	op := "CreateTable"
+	if old != nil {
+		op = "UpdateTable"
+	}
+	if _, err := c.sendRaw(ctx, op, map[string]interface{}{"DatabaseName": database, "TableInput": input}); err != nil {
+		return "", errors.Wrapf(err, "could not replace table %s.%s", database, name)
+	}
+	if _, err := c.sendRaw(ctx, "DeleteTable", map[string]string{"DatabaseName": database, "Name": from}); err != nil {
+		return "", errors.Wrapf(err, "could not delete table %s.%s", database, from)
+	}
+	if old == nil || old.StorageDescriptor == nil {
+		return "", nil
+	}
+	return aws.StringValue(old.StorageDescriptor.Location), nil
+}
+
+func isNotFound(err error) bool {
+	if awsErr, ok := errors.Cause(err).(awserr.Error); ok {
+		return awsErr.Code() == "EntityNotFoundException"
+	}
+	return false
+}
+
+// partitionKeys looks up and caches the partition keys of tables, resolving
+// unqualified table names against the database of the query or a default.
+type partitionKeys struct {
+	glue     *glueClient
+	database string
+	keys     map[string][]string
+	cache    *catalogCache
+}
+
+func newPartitionKeys(glue *glueClient, database string) *partitionKeys {
+	return &partitionKeys{glue: glue, database: database, keys: map[string][]string{}, cache: newCatalogCache(catalogCacheTTL)}
+}
+
+func (p *partitionKeys) lookup(ctx context.Context, ref tableRef) ([]string, error) {
+	if len(ref.parts) > 2 && !isGlueCatalog(ref.parts[len(ref.parts)-3]) {
+		return nil, nil
+	}
+	database, table := queryDatabase(ctx), ref.parts[len(ref.parts)-1]
+	if database == "" {
+		database = p.database
+	}
+	if len(ref.parts) > 1 {
+		database = ref.parts[len(ref.parts)-2]
+	}
+	name := database + "." + table
+	if keys, ok := p.keys[name]; ok {
+		return keys, nil
+	}
+	cacheKey := "partitions/" + aws.StringValue(p.glue.Config.Region) + "/" + name
+	if e, ok := p.cache.get(cacheKey); ok {
+		p.keys[name] = e.Values
+		return e.Values, nil
+	}
+	t, err := p.glue.getTable(ctx, database, table)
+	if err != nil && !isNotFound(err) {
+		return nil, errors.Wrapf(err, "could not get table %q", name)
+	}
+	var keys []string
+	if t != nil {
+		for _, c := range t.PartitionKeys {
+			keys = append(keys, strings.ToLower(aws.StringValue(c.Name)))
+		}
+		// missing tables aren't cached, a query of the batch may create
+		// them partitioned.
+		p.cache.put(cacheKey, catalogCacheEntry{Values: keys})
+	}
+	p.keys[name] = keys
+	return keys, nil
+}
+
+// missingPartitionFilters returns the partitioned tables read by the
+// statement without a predicate on any of their partition keys.
+func (p *partitionKeys) missingPartitionFilters(ctx context.Context, toks []token) ([]string, error) {
+	predicates := predicateColumns(toks)
+	var missing []string
+	for _, ref := range sourceTables(toks) {
+		keys, err := p.lookup(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		filtered := len(keys) == 0
+		for _, k := range keys {
+			filtered = filtered || predicates[ref.scope][k]
+		}
+		if !filtered {
+			missing = append(missing, ref.name()+" ("+strings.Join(keys, ", ")+")")
+		}
+	}
+	return missing, nil
+}
+
+// isGlueCatalog reports whether catalog is the glue backed default catalog.
+func isGlueCatalog(catalog string) bool {
+	return catalog == "" || strings.EqualFold(catalog, "AwsDataCatalog")
+}
+
+// checkPartitionFilters inspects all queries before any of them is submitted.
+func (awsCli *awsCli) checkPartitionFilters(ctx context.Context, queries []string, settings []querySettings, mode string) error {
+	if !isGlueCatalog(awsCli.catalog) {
+		infof("skipping partition filter check, catalog %s is not the glue catalog", awsCli.catalog)
+		return nil
+	}
+	keys := newPartitionKeys(awsCli.glue, "default")
+	report := infof
+	if mode == "fail" {
+		report = errorf
+	}
+	failed := false
+	for i, query := range queries {
+		missing, err := keys.missingPartitionFilters(queryContext(ctx, settings, i), tokenize(query))
+		if err != nil {
+			return errors.Wrap(err, "could not check partition filters")
+		}
+		for _, table := range missing {
+			report("query %d: %s is partitioned but not filtered on its partition keys", i+1, table)
+			failed = true
+		}
+	}
+	if failed && mode == "fail" {
+		return errors.New("refusing to run queries without partition filter")
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestPartitionFilterFlag(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want string
+		err  bool
+	}{
+		{nil, "", false},
+		{[]string{"-require-partition-filter=fail"}, "fail", false},
+		{[]string{"-require-partition-filter=warn"}, "warn", false},
+		{[]string{"-require-partition-filter=maybe"}, "", true},
+	} {
+		mode := &choiceFlag{choices: []string{"fail", "warn"}}
+		fs := flag.NewFlagSet("athenaq", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		fs.Var(mode, "require-partition-filter", "")
+		err := fs.Parse(tt.args)
+		if (err != nil) != tt.err || mode.value != tt.want {
+			t.Errorf("%v: got %q, %v, want %q", tt.args, mode.value, err, tt.want)
+		}
+	}
+}
+
+func TestMissingPartitionFilters(t *testing.T) {
+	keys := &partitionKeys{database: "default", keys: map[string][]string{
+		"default.events": {"dt", "hour"},
+		"logs.access":    {"day"},
+		"default.users":  nil,
+	}}
+	for _, tt := range []struct {
+		sql  string
+		want []string
+	}{
+		{"select * from events", []string{"events (dt, hour)"}},
+		{"select * from events where hour = 3", nil},
+		{"select * from events e where e.dt > '2018'", nil},
+		{"select * from users join logs.access a on a.user = users.id", []string{"logs.access (day)"}},
+		{"select * from users join logs.access a on a.user = users.id where a.day = 1", nil},
+		{"select * from users", nil},
+	} {
+		missing, err := keys.missingPartitionFilters(context.Background(), tokenize(tt.sql))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(missing, tt.want) {
+			t.Errorf("missingPartitionFilters(%q) = %q, want %q", tt.sql, missing, tt.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// outputGuard limits the data rows and bytes of a csv result. Past a limit
+// it either fails or writes a marker row and stops the download.
+type outputGuard struct {
+	w         io.Writer
+	maxRows   int
+	maxBytes  int64
+	truncate  bool
+	header    bool
+	rows      int
+	bytes     int64
+	truncated bool
+	split     rowSplitter
+}
+
+func (g *outputGuard) Write(p []byte) (int, error) {
+	if g.truncated {
+		return len(p), nil
+	}
+	return len(p), g.split.write(p, g.writeRow)
+}
+
+func (g *outputGuard) writeRow(row []byte) error {
+	if g.header {
+		var exceeded string
+		switch {
+		case g.maxRows > 0 && g.rows >= g.maxRows:
+			exceeded = fmt.Sprintf("-max-output-rows %d", g.maxRows)
+		case g.maxBytes > 0 && g.bytes+int64(len(row)) > g.maxBytes:
+			exceeded = fmt.Sprintf("-max-output-bytes %d", g.maxBytes)
+		}
+		if exceeded != "" {
+			if !g.truncate {
+				return fmt.Errorf("result exceeds %s", exceeded)
+			}
+			g.truncated = true
+			if _, err := fmt.Fprintf(g.w, "\"... truncated after %d rows, the result exceeds %s\"\n", g.rows, exceeded); err != nil {
+				return err
+			}
+			return errEnoughRows
+		}
+		g.rows++
+	}
+	g.header = true
+	g.bytes += int64(len(row))
+	_, err := g.w.Write(row)
+	return err
+}
+
+// flush writes a last row without a trailing newline.
+func (g *outputGuard) flush() error {
+	if len(g.split.row) == 0 || g.truncated {
+		return nil
+	}
+	row := g.split.row
+	g.split.row = nil
+	return ignoreEnough(g.writeRow(row))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestOutputGuard(t *testing.T) {
+	csv := "\"id\"\n\"1\"\n\"2\"\n\"3\""
+	tests := []struct {
+		rows     int
+		bytes    int64
+		truncate bool
+		want     string
+		err      bool
+	}{
+		{rows: 3, want: csv},
+		{rows: 2, truncate: true, want: "\"id\"\n\"1\"\n\"2\"\n\"... truncated after 2 rows, the result exceeds -max-output-rows 2\"\n"},
+		{bytes: 10, truncate: true, want: "\"id\"\n\"1\"\n\"... truncated after 1 rows, the result exceeds -max-output-bytes 10\"\n"},
+		{rows: 1, err: true},
+		{bytes: 100, want: csv},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		g := &outputGuard{w: &buf, maxRows: tt.rows, maxBytes: tt.bytes, truncate: tt.truncate}
+		_, err := g.Write([]byte(csv))
+		if err == errEnoughRows {
+			err = nil
+		}
+		if err == nil {
+			err = g.flush()
+		}
+		if (err != nil) != tt.err {
+			t.Errorf("%+v: got error %v", tt, err)
+			continue
+		}
+		if err == nil && buf.String() != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt, buf.String(), tt.want)
+		}
+		if g.truncated != (tt.truncate && tt.want != csv) {
+			t.Errorf("%+v: truncated %v", tt, g.truncated)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/advincze/s3path"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// errEnoughRows stops the download of a result once -head has its rows.
+var errEnoughRows = errors.New("enough rows")
+
+// headChunk is the size of the ranged requests results are read with for
+// -head.
+const headChunk = 256 << 10
+
+// rowSplitter splits a csv stream into rows, newlines in quoted fields don't
+// end a row.
+type rowSplitter struct {
+	quoted bool
+	row    []byte
+}
+
+// write passes the rows completed by p to emit, which must not keep them.
+func (s *rowSplitter) write(p []byte, emit func(row []byte) error) error {
+	for len(p) > 0 {
+		i := 0
+		for ; i < len(p); i++ {
+			if p[i] == '"' {
+				s.quoted = !s.quoted
+			} else if p[i] == '\n' && !s.quoted {
+				break
+			}
+		}
+		if i == len(p) {
+			s.row = append(s.row, p...)
+			return nil
+		}
+		s.row = append(s.row, p[:i+1]...)
+		if err := emit(s.row); err != nil {
+			return err
+		}
+		s.row = s.row[:0]
+		p = p[i+1:]
+	}
+	return nil
+}
+
+// headWriter writes the header and the first n rows of a csv result.
+type headWriter struct {
+	w     io.Writer
+	n     int
+	lines int
+	split rowSplitter
+}
+
+func (h *headWriter) Write(p []byte) (int, error) {
+	err := h.split.write(p, func(row []byte) error {
+		if _, err := h.w.Write(row); err != nil {
+			return err
+		}
+		if h.lines++; h.lines > h.n {
+			return errEnoughRows
+		}
+		return nil
+	})
+	return len(p), err
+}
+
+// flush writes a last row without a trailing newline.
+func (h *headWriter) flush() error {
+	if len(h.split.row) == 0 || h.lines > h.n {
+		return nil
+	}
+	_, err := h.w.Write(h.split.row)
+	return err
+}
+
+// tailWriter keeps the header and the last n rows of a csv result and writes
+// them on flush.
+type tailWriter struct {
+	w      io.Writer
+	header []byte
+	rows   [][]byte
+	next   int
+	split  rowSplitter
+}
+
+func newTailWriter(w io.Writer, n int) *tailWriter {
+	return &tailWriter{w: w, rows: make([][]byte, 0, n)}
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.split.write(p, func(row []byte) error {
+		t.add(row)
+		return nil
+	})
+	return len(p), nil
+}
+
+func (t *tailWriter) add(row []byte) {
+	switch {
+	case t.header == nil:
+		t.header = append([]byte{}, row...)
+	case cap(t.rows) == 0:
+	case len(t.rows) < cap(t.rows):
+		t.rows = append(t.rows, append([]byte{}, row...))
+	default:
+		t.rows[t.next] = append(t.rows[t.next][:0], row...)
+		t.next = (t.next + 1) % len(t.rows)
+	}
+}
+
+func (t *tailWriter) flush() error {
+	if len(t.split.row) > 0 {
+		t.add(t.split.row)
+		t.split.row = nil
+	}
+	if t.header == nil {
+		return nil
+	}
+	if _, err := t.w.Write(t.header); err != nil {
+		return err
+	}
+	for i := range t.rows {
+		if _, err := t.w.Write(t.rows[(t.next+i)%len(t.rows)]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type rangedKey struct{}
+
+// withRangedDownload makes results download in ranged requests, which stop
+// once the writer has enough rows.
+func withRangedDownload(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rangedKey{}, true)
+}
+
+func isRangedDownload(ctx context.Context) bool {
+	v, _ := ctx.Value(rangedKey{}).(bool)
+	return v
+}
+
+// copyS3Ranges copies an s3 object to w in ranged requests of headChunk
+// bytes until w returns errEnoughRows or the object ends. The checksum of
+// the object can't be verified.
+func (awsCli *awsCli) copyS3Ranges(ctx context.Context, s3Path *s3path.S3Path, w io.Writer) error {
+	svc := awsCli.s3For(s3Path.Bucket)
+	for start := int64(0); ; start += headChunk {
+		out, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: &s3Path.Bucket,
+			Key:    &s3Path.Key,
+			Range:  aws.String(fmt.Sprintf("bytes=%d-%d", start, start+headChunk-1)),
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidRange" {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "could not get result from %q", s3Path)
+		}
+		_, err = io.Copy(w, out.Body)
+		out.Body.Close()
+		switch {
+		case err == errEnoughRows:
+			return nil
+		case err != nil:
+			return fmt.Errorf("could not read result form s3: %v", err)
+		}
+		// without a Content-Range the whole object was sent.
+		total := contentRangeTotal(aws.StringValue(out.ContentRange))
+		if total < 0 || start+headChunk >= total {
+			return nil
+		}
+	}
+}
+
+// contentRangeTotal returns the size from a "bytes 0-99/1234" Content-Range,
+// or -1.
+func contentRangeTotal(contentRange string) int64 {
+	i := strings.LastIndex(contentRange, "/")
+	if i < 0 {
+		return -1
+	}
+	total, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return total
+}
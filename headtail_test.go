@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeadTailWriter(t *testing.T) {
+	csv := "\"id\",\"note\"\n\"1\",\"a\"\n\"2\",\"two\nlines\"\n\"3\",\"c\"\n\"4\",\"d\""
+	tests := []struct {
+		head, tail int
+		want       string
+	}{
+		{head: 1, want: "\"id\",\"note\"\n\"1\",\"a\"\n"},
+		{head: 2, want: "\"id\",\"note\"\n\"1\",\"a\"\n\"2\",\"two\nlines\"\n"},
+		{head: 10, want: csv},
+		{tail: 2, want: "\"id\",\"note\"\n\"3\",\"c\"\n\"4\",\"d\""},
+		{tail: 3, want: "\"id\",\"note\"\n\"2\",\"two\nlines\"\n\"3\",\"c\"\n\"4\",\"d\""},
+		{tail: 10, want: csv},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		var err error
+		if tt.head > 0 {
+			hw := &headWriter{w: &buf, n: tt.head}
+			for i := 0; i < len(csv) && err == nil; i += 5 {
+				_, err = hw.Write([]byte(csv[i:chunkEnd(i+5, len(csv))]))
+			}
+			if err == nil {
+				err = hw.flush()
+			} else if err == errEnoughRows {
+				err = nil
+			}
+		} else {
+			tw := newTailWriter(&buf, tt.tail)
+			for i := 0; i < len(csv); i += 5 {
+				tw.Write([]byte(csv[i:chunkEnd(i+5, len(csv))]))
+			}
+			err = tw.flush()
+		}
+		if err != nil || buf.String() != tt.want {
+			t.Errorf("head %d tail %d: got %q, %v, want %q", tt.head, tt.tail, buf.String(), err, tt.want)
+		}
+	}
+}
+
+func chunkEnd(end, n int) int {
+	if end > n {
+		return n
+	}
+	return end
+}
+
+func TestHeadDownloadsRanges(t *testing.T) {
+	f := newFakeAWS(t)
+	defer f.Close()
+	var csv strings.Builder
+	csv.WriteString("\"id\"\n")
+	for i := 0; csv.Len() < 3*headChunk; i++ {
+		fmt.Fprintf(&csv, "\"%d\"\n", i)
+	}
+	f.result("select id from big", csv.String())
+	awsCli := f.client()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var buf bytes.Buffer
+	if _, err := awsCli.execQuery(withRangedDownload(ctx), "select id from big", &headWriter{w: &buf, n: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "\"id\"\n\"0\"\n\"1\"\n" {
+		t.Errorf("got %q", got)
+	}
+	if n := f.count("RangeObject"); n != 1 {
+		t.Errorf("%d ranged requests, want 1", n)
+	}
+
+	buf.Reset()
+	if _, err := awsCli.execQuery(withRangedDownload(ctx), "select id from big", &headWriter{w: &buf, n: 1 << 30}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != csv.String() {
+		t.Errorf("got %d bytes, want the %d bytes of the result", buf.Len(), csv.Len())
+	}
+}
+
+func TestContentRangeTotal(t *testing.T) {
+	for s, want := range map[string]int64{"bytes 0-99/1234": 1234, "": -1, "bytes 0-1/*": -1} {
+		if got := contentRangeTotal(s); got != want {
+			t.Errorf("%q: got %d, want %d", s, got, want)
+		}
+	}
+}
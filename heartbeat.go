@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+// heartbeat prints a line about the running queries every interval, for CI
+// runners that kill jobs without output for a while.
+type heartbeat struct {
+	w       io.Writer
+	queries []string
+	mu      sync.Mutex
+	running map[int]*heartbeatQuery
+	stop    chan struct{}
+}
+
+type heartbeatQuery struct {
+	begin   time.Time
+	state   string
+	scanned int64
+}
+
+func newHeartbeat(w io.Writer, queries []string, interval time.Duration) *heartbeat {
+	h := &heartbeat{w: w, queries: queries, running: map[int]*heartbeatQuery{}, stop: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-h.stop:
+				return
+			case now := <-ticker.C:
+				h.beat(now)
+			}
+		}
+	}()
+	return h
+}
+
+func (h *heartbeat) close() {
+	close(h.stop)
+}
+
+func (h *heartbeat) start(index int, query string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running[index] = &heartbeatQuery{begin: time.Now(), state: "SUBMITTED"}
+}
+
+func (h *heartbeat) update(index int, queryExecution *athena.QueryExecution) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	q, ok := h.running[index]
+	if !ok {
+		return
+	}
+	q.state = aws.StringValue(queryExecution.Status.State)
+	if queryExecution.Statistics != nil && queryExecution.Statistics.DataScannedInBytes != nil {
+		q.scanned = *queryExecution.Statistics.DataScannedInBytes
+	}
+}
+
+func (h *heartbeat) done(index int, queryExecution *athena.QueryExecution, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.running, index)
+}
+
+// beat prints the state of the running queries, or that the run is busy
+// between them, e.g. writing a result.
+func (h *heartbeat) beat(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var indexes []int
+	for i := range h.running {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	var states []string
+	for _, i := range indexes {
+		q := h.running[i]
+		state := fmt.Sprintf("%s %s %v", queryLabel(i, h.queries[i]), q.state, now.Sub(q.begin).Truncate(time.Second))
+		if q.scanned > 0 {
+			state += ", scanned " + formatBytes(q.scanned)
+		}
+		states = append(states, state)
+	}
+	if len(states) == 0 {
+		states = []string{"no query running"}
+	}
+	fmt.Fprintf(h.w, "%s heartbeat: %s\n", now.UTC().Format(time.RFC3339), strings.Join(states, "; "))
+}
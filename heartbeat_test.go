@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+func TestHeartbeat(t *testing.T) {
+	var buf bytes.Buffer
+	h := newHeartbeat(&buf, []string{"-- name: events\nSELECT 1", "SELECT 2"}, time.Hour)
+	defer h.close()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	h.beat(now)
+	h.start(0, "")
+	h.start(1, "")
+	h.running[0].begin = now.Add(-90 * time.Second)
+	h.running[1].begin = now.Add(-time.Second)
+	h.update(0, &athena.QueryExecution{
+		Status:     &athena.QueryExecutionStatus{State: aws.String("RUNNING")},
+		Statistics: &athena.QueryExecutionStatistics{DataScannedInBytes: aws.Int64(2048)},
+	})
+	h.beat(now)
+	h.done(0, nil, nil)
+	h.beat(now)
+
+	want := "2024-01-01T12:00:00Z heartbeat: no query running\n" +
+		"2024-01-01T12:00:00Z heartbeat: query events RUNNING 1m30s, scanned 2.0 KiB; query 2 SUBMITTED 1s\n" +
+		"2024-01-01T12:00:00Z heartbeat: query 2 SUBMITTED 1s\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
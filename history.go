@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/pkg/errors"
+)
+
+// The historyExecution types mirror the parts of the athena QueryExecution
+// the vendored sdk doesn't know, like the statement type.
+
+type historyExecution struct {
+	QueryExecutionId      *string
+	Query                 *string
+	QueryExecutionContext *historyContext
+	StatementType         *string
+	WorkGroup             *string
+	Status                *historyStatus
+	Statistics            *historyStatistics
+}
+
+type historyContext struct {
+	Database *string
+	Catalog  *string
+}
+
+type historyStatus struct {
+	State              *string
+	SubmissionDateTime *time.Time
+	CompletionDateTime *time.Time
+}
+
+type historyStatistics struct {
+	DataScannedInBytes          *int64
+	EngineExecutionTimeInMillis *int64
+}
+
+func (e *historyExecution) submitted() time.Time {
+	if e.Status == nil {
+		return time.Time{}
+	}
+	return aws.TimeValue(e.Status.SubmissionDateTime)
+}
+
+func (e *historyExecution) dataScanned() int64 {
+	if e.Statistics == nil {
+		return 0
+	}
+	return aws.Int64Value(e.Statistics.DataScannedInBytes)
+}
+
+// billedBytes is the data athena bills a query for. DDL statements and
+// failed queries are free, others are rounded up to a megabyte with a
+// minimum of 10MB per query.
+func (e *historyExecution) billedBytes() int64 {
+	const mb = 1 << 20
+	if aws.StringValue(e.StatementType) == "DDL" || e.Status == nil {
+		return 0
+	}
+	bytes := e.dataScanned()
+	switch aws.StringValue(e.Status.State) {
+	case "SUCCEEDED":
+	case "CANCELLED":
+		if bytes == 0 {
+			return 0
+		}
+	default:
+		return 0
+	}
+	if bytes < 10*mb {
+		return 10 * mb
+	}
+	return (bytes + mb - 1) / mb * mb
+}
+
+// queryHistory returns the query executions of a workgroup submitted after
+// since, newest first.
+func queryHistory(ctx context.Context, svc *athena.Athena, workGroup string, since time.Time) ([]*historyExecution, error) {
+	var executions []*historyExecution
+	var next *string
+	for {
+		list := struct {
+			QueryExecutionIds []*string
+			NextToken         *string
+		}{}
+		input := &struct {
+			WorkGroup  *string
+			NextToken  *string
+			MaxResults *int64
+		}{aws.String(workGroup), next, aws.Int64(50)}
+		if workGroup == "" {
+			input.WorkGroup = nil
+		}
+		if err := sendAthena(ctx, svc, "ListQueryExecutions", input, &list); err != nil {
+			return nil, errors.Wrap(err, "could not list query executions")
+		}
+		if len(list.QueryExecutionIds) == 0 {
+			return executions, nil
+		}
+		batch := struct{ QueryExecutions []*historyExecution }{}
+		err := sendAthena(ctx, svc, "BatchGetQueryExecution", &struct{ QueryExecutionIds []*string }{list.QueryExecutionIds}, &batch)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not get query executions")
+		}
+		older := false
+		for _, e := range batch.QueryExecutions {
+			if e.submitted().Before(since) {
+				older = true
+				continue
+			}
+			executions = append(executions, e)
+		}
+		debugf("query history: %d executions", len(executions))
+		if older || list.NextToken == nil {
+			return executions, nil
+		}
+		next = list.NextToken
+	}
+}
+
+// parseSince parses a duration ago, with a "d" suffix for days, or a date.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if strings.HasSuffix(s, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil && days >= 0 {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid -since %q, expected e.g. 30d, 12h or 2024-01-01", s)
+}
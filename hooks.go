@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/pkg/errors"
+)
+
+// hook is a shell command, a statement with a "sql:" prefix or an http(s)
+// webhook url run around the batch or its queries.
+type hook struct {
+	sql     string
+	shell   string
+	webhook string
+}
+
+func (h hook) String() string {
+	switch {
+	case h.sql != "":
+		return "sql:" + h.sql
+	case h.webhook != "":
+		return h.webhook
+	}
+	return "sh:" + h.shell
+}
+
+// secretQueryParam matches the query parameters of webhooks carrying a
+// secret, e.g. token, api_key or sig.
+var secretQueryParam = regexp.MustCompile(`(?i)token|key|secret|sig|password|auth|code`)
+
+// hooksFlag collects the hooks of one point of the run, in order.
+type hooksFlag []hook
+
+func (f *hooksFlag) String() string {
+	var s []string
+	for _, h := range *f {
+		s = append(s, h.String())
+	}
+	return strings.Join(s, "; ")
+}
+
+func (f *hooksFlag) Set(s string) error {
+	var h hook
+	switch {
+	case strings.HasPrefix(s, "sql:"):
+		h.sql = strings.TrimSpace(strings.TrimPrefix(s, "sql:"))
+	case strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://"):
+		u, err := url.Parse(s)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid webhook %q", s)
+		}
+		// webhooks with a path or query often are a secret as a whole, e.g.
+		// slack's, or carry a password or token. Their paths alone are not
+		// redacted, common paths would be redacted all over the logs.
+		if len(u.EscapedPath()) > 1 || u.RawQuery != "" || u.User != nil {
+			secrets.add(s)
+		}
+		if password, ok := u.User.Password(); ok {
+			secrets.add(password)
+		}
+		for name, values := range u.Query() {
+			if secretQueryParam.MatchString(name) {
+				for _, v := range values {
+					secrets.add(v)
+				}
+			}
+		}
+		h.webhook = s
+	default:
+		h.shell = strings.TrimSpace(strings.TrimPrefix(s, "sh:"))
+	}
+	if h.sql == "" && h.shell == "" && h.webhook == "" {
+		return fmt.Errorf("empty hook %q", s)
+	}
+	*f = append(*f, h)
+	return nil
+}
+
+type hooks struct {
+	beforeBatch hooksFlag
+	afterBatch  hooksFlag
+	beforeQuery hooksFlag
+	afterQuery  hooksFlag
+	onWarning   hooksFlag
+	payload     webhookPayload
+}
+
+func addHookFlags(fs *flag.FlagSet) *hooks {
+	h := &hooks{}
+	fs.Var(&h.beforeBatch, "hook.before-batch", `shell command, "sql:<statement>" or webhook url to run before the first query, failing it fails the run (repeatable)`)
+	fs.Var(&h.afterBatch, "hook.after-batch", `shell command, "sql:<statement>" or webhook url to run after the batch, also when it failed (repeatable)`)
+	fs.Var(&h.beforeQuery, "hook.before-query", `shell command, "sql:<statement>" or webhook url to run before each query, failing it fails the query (repeatable)`)
+	fs.Var(&h.afterQuery, "hook.after-query", `shell command, "sql:<statement>" or webhook url to run after each query, also when it failed (repeatable)`)
+	fs.Var(&h.onWarning, "hook.on-warning", `shell command, "sql:<statement>" or webhook url to run after a query exceeded -warn-scanned-bytes or -warn-duration, with ATHENAQ_WARNING set (repeatable)`)
+	fs.StringVar(&h.payload.path, "hook.payload", "", "go template of the json body of webhook hooks, with the fields of the hook environment and the run summary as .Run (file://... | s3://... | https://...)")
+	return h
+}
+
+// render renders the sql hooks like the queries of the input.
+func (h *hooks) render(t *templater) error {
+	for _, hs := range []hooksFlag{h.beforeBatch, h.afterBatch, h.beforeQuery, h.afterQuery, h.onWarning} {
+		for i := range hs {
+			if hs[i].sql == "" {
+				continue
+			}
+			sql, err := t.render(hs[i].sql)
+			if err != nil {
+				return errors.Wrapf(err, "could not render hook %q", hs[i].sql)
+			}
+			hs[i].sql = sql
+		}
+	}
+	return nil
+}
+
+// hookEnv returns the environment of shell hooks: the hook point, the run
+// and, for query hooks, the position, execution id, state and error of the
+// query.
+func hookEnv(point, runID string, i int, name string, queryExecution *athena.QueryExecution, err error) []string {
+	env := []string{"ATHENAQ_HOOK=" + point, "ATHENAQ_RUN_ID=" + runID}
+	if i >= 0 {
+		env = append(env, fmt.Sprintf("ATHENAQ_QUERY_INDEX=%d", i+1))
+	}
+	if name != "" {
+		env = append(env, "ATHENAQ_QUERY_NAME="+name)
+	}
+	if queryExecution != nil {
+		env = append(env, "ATHENAQ_QUERY_EXECUTION_ID="+aws.StringValue(queryExecution.QueryExecutionId))
+		if queryExecution.Status != nil {
+			env = append(env, "ATHENAQ_QUERY_STATE="+aws.StringValue(queryExecution.Status.State))
+		}
+	}
+	if err != nil {
+		env = append(env, "ATHENAQ_ERROR="+secrets.redact(err.Error()))
+	}
+	return env
+}
+
+// runHooks runs hs in order and stops at the first that fails. Shell hooks
+// write to STDERR so that they don't mix with the results on STDOUT.
+func (awsCli *awsCli) runHooks(ctx context.Context, hs hooksFlag, env []string) error {
+	for _, h := range hs {
+		debugf("running hook %s", h)
+		if h.sql != "" {
+			if _, err := awsCli.execQuery(unwatched(ctx), h.sql, nil); err != nil {
+				return errors.Wrapf(err, "hook %s failed", h)
+			}
+			continue
+		}
+		if h.webhook != "" {
+			if err := awsCli.sendWebhook(h.webhook, env); err != nil {
+				return errors.Wrapf(err, "hook %s failed", h)
+			}
+			continue
+		}
+		cmd := exec.CommandContext(ctx, "sh", "-c", h.shell)
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return errors.Wrapf(err, "hook %s failed", h)
+		}
+	}
+	return nil
+}
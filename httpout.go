@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// headerFlag collects repeated "Name: value" flags.
+type headerFlag http.Header
+
+func (f headerFlag) String() string {
+	var lines []string
+	for k, vs := range f {
+		for _, v := range vs {
+			lines = append(lines, k+": "+v)
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, ", ")
+}
+
+func (f headerFlag) Set(s string) error {
+	pair := strings.SplitN(s, ":", 2)
+	if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" {
+		return fmt.Errorf("invalid header %q, expected Name: value", s)
+	}
+	http.Header(f).Add(strings.TrimSpace(pair[0]), strings.TrimSpace(pair[1]))
+	return nil
+}
+
+// httpTarget delivers outputs to http(s) urls.
+type httpTarget struct {
+	method   string
+	header   http.Header
+	tokenEnv string
+	retries  int
+	backoff  time.Duration
+	client   *http.Client
+}
+
+func addHTTPFlags(fs *flag.FlagSet) *httpTarget {
+	t := &httpTarget{header: http.Header{}, backoff: time.Second, client: &http.Client{Timeout: 5 * time.Minute}}
+	fs.StringVar(&t.method, "out.method", "POST", "http method for http(s) outputs (POST|PUT)")
+	fs.Var(headerFlag(t.header), "out.header", `"Name: value" header for http(s) outputs (repeatable)`)
+	fs.StringVar(&t.tokenEnv, "out.token-env", "", "environment variable holding a bearer token for http(s) outputs")
+	fs.IntVar(&t.retries, "out.retries", 3, "retries of http(s) outputs on connection errors, 429 and 5xx responses")
+	return t
+}
+
+func (t *httpTarget) validate() error {
+	switch t.method {
+	case "POST", "PUT":
+	default:
+		return fmt.Errorf("invalid -out.method %q, expected POST or PUT", t.method)
+	}
+	if t.tokenEnv != "" && os.Getenv(t.tokenEnv) == "" {
+		return fmt.Errorf("environment variable %s of -out.token-env is empty", t.tokenEnv)
+	}
+	secrets.add(os.Getenv(t.tokenEnv))
+	return nil
+}
+
+// send uploads r to url, retrying with exponential backoff.
+func (t *httpTarget) send(r io.ReadSeeker, url string) error {
+	backoff := t.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := t.try(r, url)
+		if err == nil || !retry || attempt >= t.retries {
+			return err
+		}
+		infof("%s %s failed, retrying in %s: %v", t.method, url, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+}
+
+func (t *httpTarget) try(r io.Reader, url string) (retry bool, err error) {
+	req, err := http.NewRequest(t.method, url, r)
+	if err != nil {
+		return false, err
+	}
+	for k, vs := range t.header {
+		req.Header[k] = vs
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", contentType(url))
+	}
+	if t.tokenEnv != "" {
+		req.Header.Set("Authorization", "Bearer "+os.Getenv(t.tokenEnv))
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	debugf("%s %s: %s", t.method, url, resp.Status)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+func contentType(url string) string {
+	switch {
+	case strings.HasSuffix(url, ".json"):
+		return "application/json"
+	case strings.HasSuffix(url, ".csv"):
+		return "text/csv"
+	}
+	return "application/octet-stream"
+}
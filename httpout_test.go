@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHTTPTargetRetries(t *testing.T) {
+	var attempts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != "a,b\n" {
+			t.Errorf("attempt %d: body %q", attempts, body)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q", got)
+		}
+		if got := r.Header.Get("X-Source"); got != "athenaq" {
+			t.Errorf("X-Source = %q", got)
+		}
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	os.Setenv("ATHENAQ_TEST_TOKEN", "secret")
+	defer os.Unsetenv("ATHENAQ_TEST_TOKEN")
+	target := &httpTarget{method: "PUT", header: http.Header{}, tokenEnv: "ATHENAQ_TEST_TOKEN", retries: 2, client: srv.Client()}
+	headerFlag(target.header).Set("X-Source: athenaq")
+
+	if err := target.send(strings.NewReader("a,b\n"), srv.URL+"/out.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+
+	attempts = 0
+	target.retries = 1
+	if err := target.send(strings.NewReader("a,b\n"), srv.URL+"/out.csv"); err == nil {
+		t.Error("expected an error after the retries are exhausted")
+	}
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+}
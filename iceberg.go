@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+)
+
+var icebergMetadataTables = []string{"snapshots", "history", "files", "manifests", "partitions", "refs"}
+
+func icebergCmd(args []string) error {
+	fs := flag.NewFlagSet("iceberg", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: athenaq iceberg [flags] <%s> db.table\n", strings.Join(icebergMetadataTables, "|"))
+		fs.PrintDefaults()
+	}
+	awsFlags := addAWSFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	query, err := icebergMetadataQuery(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	awsCli, err := newAWS(awsFlags)
+	if err != nil {
+		return errors.Wrap(err, "could not initialize aws client")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *awsFlags.timeout)
+	defer cancel()
+
+	_, err = awsCli.execQuery(ctx, query, os.Stdout)
+	return err
+}
+
+func icebergMetadataQuery(kind, table string) (string, error) {
+	known := false
+	for _, k := range icebergMetadataTables {
+		known = known || k == kind
+	}
+	if !known {
+		return "", fmt.Errorf("unknown iceberg metadata table %q", kind)
+	}
+
+	parts := strings.Split(table, ".")
+	if len(parts) > 2 {
+		return "", fmt.Errorf("invalid table name %q, expected db.table", table)
+	}
+	for _, p := range parts {
+		if p == "" {
+			return "", fmt.Errorf("invalid table name %q, expected db.table", table)
+		}
+	}
+	parts[len(parts)-1] += "$" + kind
+	for i, p := range parts {
+		parts[i] = quoteIdent(p)
+	}
+	return "SELECT * FROM " + strings.Join(parts, "."), nil
+}
+
+var snapshotID = regexp.MustCompile(`^[0-9]+$`)
+
+// asOfClause turns the -as-of flag value into a FOR ... AS OF clause. The value
+// is either a snapshot id, a timestamp or a duration relative to now. All digit
+// values that are a valid YYYYMMDD or YYYYMMDDhhmmss date are taken as such,
+// snapshot ids are much longer.
+func asOfClause(asOf string, now time.Time) (string, error) {
+	for _, layout := range []string{"20060102", "20060102150405"} {
+		if len(asOf) != len(layout) {
+			continue
+		}
+		if t, err := time.Parse(layout, asOf); err == nil {
+			return formatAsOfTimestamp(t), nil
+		}
+	}
+	if snapshotID.MatchString(asOf) {
+		return "FOR VERSION AS OF " + asOf, nil
+	}
+	if d, err := time.ParseDuration(asOf); err == nil {
+		if d > 0 {
+			d = -d
+		}
+		return formatAsOfTimestamp(now.Add(d)), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, asOf); err == nil {
+			return formatAsOfTimestamp(t), nil
+		}
+	}
+	return "", fmt.Errorf("invalid as-of value %q: expected snapshot id, timestamp or duration", asOf)
+}
+
+func formatAsOfTimestamp(t time.Time) string {
+	return fmt.Sprintf("FOR TIMESTAMP AS OF TIMESTAMP '%s UTC'", t.UTC().Format("2006-01-02 15:04:05.000"))
+}
+
+// timeTravel appends clause to the tables read by query for which travels
+// returns true.
+func timeTravel(query, clause string, travels func(tableRef) bool) string {
+	toks := tokenize(query)
+	refs := sourceTables(toks)
+	var out []token
+	last := 0
+	for _, ref := range refs {
+		if strings.Contains(ref.parts[len(ref.parts)-1], "$") || !travels(ref) {
+			continue
+		}
+		if j := nextToken(toks, ref.end); j < len(toks) && toks[j].is("FOR") {
+			continue
+		}
+		out = append(out, toks[last:ref.end]...)
+		out = append(out, token{kind: tokenSpace, text: " "}, token{kind: tokenWord, text: clause})
+		last = ref.end
+	}
+	out = append(out, toks[last:]...)
+	return joinTokens(out)
+}
+
+// icebergTables looks up in glue which tables read by queries are iceberg
+// tables, the only ones athena can time travel. Views, other tables and names
+// glue doesn't know are left alone.
+type icebergTables struct {
+	glue    *glueClient
+	catalog string
+	known   map[string]bool
+}
+
+// of returns whether a table read by query, run in ctx, is an iceberg table.
+func (it *icebergTables) of(ctx context.Context, query string) (func(tableRef) bool, error) {
+	iceberg := map[string]bool{}
+	for _, ref := range sourceTables(tokenize(query)) {
+		database, table, ok := glueTableName(ctx, ref, it.catalog)
+		if !ok || strings.Contains(table, "$") {
+			continue
+		}
+		name := strings.ToLower(database + "." + table)
+		if _, ok := it.known[name]; !ok {
+			t, err := it.glue.getTable(ctx, database, table)
+			if err != nil && !isNotFound(err) {
+				return nil, errors.Wrapf(err, "could not get table %s.%s", database, table)
+			}
+			it.known[name] = t != nil && strings.EqualFold(aws.StringValue(t.Parameters["table_type"]), "ICEBERG")
+		}
+		iceberg[strings.ToLower(ref.name())] = it.known[name]
+	}
+	return func(ref tableRef) bool { return iceberg[strings.ToLower(ref.name())] }, nil
+}
+
+func matchesTable(ref tableRef, tables []string) bool {
+	for _, table := range tables {
+		if strings.EqualFold(table, ref.name()) || strings.EqualFold(table, ref.parts[len(ref.parts)-1]) {
+			return true
+		}
+	}
+	return false
+}
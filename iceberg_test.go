@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIcebergMetadataQuery(t *testing.T) {
+	for _, tt := range []struct {
+		kind, table, want string
+	}{
+		{"snapshots", "db.events", `SELECT * FROM "db"."events$snapshots"`},
+		{"files", "events", `SELECT * FROM "events$files"`},
+		{"unknown", "db.events", ""},
+		{"snapshots", ".events", ""},
+		{"snapshots", "db.", ""},
+		{"snapshots", "a.b.c", ""},
+	} {
+		got, err := icebergMetadataQuery(tt.kind, tt.table)
+		if (err != nil) != (tt.want == "") || got != tt.want {
+			t.Errorf("icebergMetadataQuery(%q, %q) = %q, %v, want %q", tt.kind, tt.table, got, err, tt.want)
+		}
+	}
+}
+
+func TestAsOfClause(t *testing.T) {
+	now := time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		asOf, want string
+	}{
+		{"8270633197658268308", "FOR VERSION AS OF 8270633197658268308"},
+		{"24h", "FOR TIMESTAMP AS OF TIMESTAMP '2024-03-01 12:00:00.000 UTC'"},
+		{"-1h", "FOR TIMESTAMP AS OF TIMESTAMP '2024-03-02 11:00:00.000 UTC'"},
+		{"2018-03-01", "FOR TIMESTAMP AS OF TIMESTAMP '2018-03-01 00:00:00.000 UTC'"},
+		{"2018-03-01 12:30:00", "FOR TIMESTAMP AS OF TIMESTAMP '2018-03-01 12:30:00.000 UTC'"},
+		{"2018-03-01T12:30:00+02:00", "FOR TIMESTAMP AS OF TIMESTAMP '2018-03-01 10:30:00.000 UTC'"},
+		{"20240101", "FOR TIMESTAMP AS OF TIMESTAMP '2024-01-01 00:00:00.000 UTC'"},
+		{"20240101123000", "FOR TIMESTAMP AS OF TIMESTAMP '2024-01-01 12:30:00.000 UTC'"},
+		{"20241399", "FOR VERSION AS OF 20241399"},
+		{"yesterday", ""},
+	} {
+		got, err := asOfClause(tt.asOf, now)
+		if (err != nil) != (tt.want == "") || got != tt.want {
+			t.Errorf("asOfClause(%q) = %q, %v, want %q", tt.asOf, got, err, tt.want)
+		}
+	}
+}
+
+func TestTimeTravel(t *testing.T) {
+	const clause = "FOR VERSION AS OF 1"
+	for _, tt := range []struct {
+		sql    string
+		tables []string
+		want   string
+	}{
+		{"select * from db.events", nil, "select * from db.events FOR VERSION AS OF 1"},
+		{"select * from a e join b on e.id = b.id", nil, "select * from a FOR VERSION AS OF 1 e join b FOR VERSION AS OF 1 on e.id = b.id"},
+		{"select * from a e join db.b on e.id = b.id", []string{"b"}, "select * from a e join db.b FOR VERSION AS OF 1 on e.id = b.id"},
+		{"select * from a e join db.b on e.id = b.id", []string{"DB.A"}, "select * from a e join db.b on e.id = b.id"},
+		{`select * from "db"."events$snapshots"`, nil, `select * from "db"."events$snapshots"`},
+		{"select * from t for version as of 2", nil, "select * from t for version as of 2"},
+		{"with x as (select * from t) select * from x", nil, "with x as (select * from t FOR VERSION AS OF 1) select * from x"},
+	} {
+		travels := func(tableRef) bool { return true }
+		if tt.tables != nil {
+			travels = func(ref tableRef) bool { return matchesTable(ref, tt.tables) }
+		}
+		if got := timeTravel(tt.sql, clause, travels); got != tt.want {
+			t.Errorf("timeTravel(%q, %v) = %q, want %q", tt.sql, tt.tables, got, tt.want)
+		}
+	}
+}
+
+func TestIcebergTables(t *testing.T) {
+	f := newFakeAWS(t)
+	defer f.Close()
+	f.icebergTable("mydb", "events", "s3://bucket/events/")
+	f.table("mydb", "users", "s3://bucket/users/")
+	awsCli := f.client()
+	iceberg := &icebergTables{glue: awsCli.glue, catalog: awsCli.catalog, known: map[string]bool{}}
+	const clause = "FOR VERSION AS OF 1"
+	for _, tt := range []struct {
+		sql, want string
+	}{
+		{
+			"select * from mydb.events e join mydb.users u on e.user_id = u.id join mydb.missing m on m.id = u.id",
+			"select * from mydb.events FOR VERSION AS OF 1 e join mydb.users u on e.user_id = u.id join mydb.missing m on m.id = u.id",
+		},
+		{"select * from MYDB.EVENTS", "select * from MYDB.EVENTS FOR VERSION AS OF 1"},
+	} {
+		travels, err := iceberg.of(context.Background(), tt.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := timeTravel(tt.sql, clause, travels); got != tt.want {
+			t.Errorf("timeTravel(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+	if n := f.count("GetTable"); n != 3 {
+		t.Errorf("%d GetTable calls, want 3", n)
+	}
+}
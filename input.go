@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// inputReader reads the input at u. awsCli is nil where no aws client was
+// initialized, e.g. in offline subcommands.
+type inputReader func(ctx context.Context, awsCli *awsCli, u *url.URL) ([]byte, error)
+
+// inputReaders are the sources of query files, policies and encrypted
+// results by url scheme. Inputs without one of these schemes are local files.
+var inputReaders = map[string]inputReader{
+	"file":      readFile,
+	"s3":        readS3,
+	"http":      readHTTP,
+	"https":     readHTTP,
+	"git+https": readGit,
+	"git+ssh":   readGit,
+	"git+file":  readGit,
+}
+
+// urlScheme matches the scheme of urls.
+var urlScheme = regexp.MustCompile(`^([a-z][a-z0-9+.-]*)://`)
+
+func (awsCli *awsCli) readFrom(ctx context.Context, inPath string) ([]byte, error) {
+	m := urlScheme.FindStringSubmatch(inPath)
+	if m == nil {
+		// local paths are not urls, they may contain %, ? and #.
+		return ioutil.ReadFile(inPath)
+	}
+	read, ok := inputReaders[m[1]]
+	if !ok {
+		return nil, fmt.Errorf("UNKNOWN: schema %q", inPath)
+	}
+	p, err := url.Parse(inPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid input %q", inPath)
+	}
+	return read(ctx, awsCli, p)
+}
+
+func readFile(ctx context.Context, awsCli *awsCli, u *url.URL) ([]byte, error) {
+	return ioutil.ReadFile(path.Join(u.Host, u.Path))
+}
+
+func readS3(ctx context.Context, cli *awsCli, u *url.URL) ([]byte, error) {
+	if cli == nil {
+		sess, err := newSession("")
+		if err != nil {
+			return nil, err
+		}
+		cli = newBareAWS(sess)
+	}
+	return cli.getS3Contents(ctx, u.String())
+}
+
+var inputClient = &http.Client{Timeout: time.Minute}
+
+func readHTTP(ctx context.Context, awsCli *awsCli, u *url.URL) ([]byte, error) {
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := inputClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("GET %s: %s", u.Redacted(), resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// readGit reads a file from a git repository, addressed like
+// git+https://github.com/org/repo.git//queries/daily.sql?ref=main.
+func readGit(ctx context.Context, awsCli *awsCli, u *url.URL) ([]byte, error) {
+	repo := *u
+	repo.Scheme = strings.TrimPrefix(u.Scheme, "git+")
+	repo.RawQuery = ""
+	parts := strings.SplitN(repo.Path, "//", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, fmt.Errorf("missing //path/in/repository in %q", u)
+	}
+	repo.Path = parts[0]
+
+	dir, err := ioutil.TempDir("", "athenaq-git")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, filepath.FromSlash(parts[1]))
+	if err := inDir(dir, path); err != nil {
+		return nil, err
+	}
+	args := []string{"clone", "--quiet", "--depth", "1"}
+	if ref := u.Query().Get("ref"); ref != "" {
+		args = append(args, "--branch", ref)
+	}
+	cmd := exec.CommandContext(ctx, "git", append(args, repo.String(), dir)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("could not clone %s: %v: %s", repo.Redacted(), err, strings.TrimSpace(string(out)))
+	}
+	// a symlink in the repository may point outside of it as well
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, err
+	}
+	if root, err := filepath.EvalSymlinks(dir); err != nil {
+		return nil, err
+	} else if err := inDir(root, resolved); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(resolved)
+}
+
+// inDir fails unless path is below dir.
+func inDir(dir, path string) error {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s is outside of the repository", path)
+	}
+	return nil
+}
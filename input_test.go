@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadInputHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/daily.sql" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "select 1;\nselect 2;")
+	}))
+	defer srv.Close()
+
+	queries, err := (*awsCli)(nil).readInput(srv.URL+"/daily.sql", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 2 || queries[1] != "select 2" {
+		t.Errorf("got queries %q", queries)
+	}
+	if _, err := (*awsCli)(nil).readInput(srv.URL+"/missing.sql", nil); err == nil {
+		t.Error("no error for a 404")
+	}
+}
+
+func TestReadInputLocalPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"100%.sql", "what?.sql", "#1.sql", "a%20b.sql"} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte("select 1"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if queries, err := (*awsCli)(nil).readInput(path, nil); err != nil || len(queries) != 1 {
+			t.Errorf("%s: got %q, %v", name, queries, err)
+		}
+	}
+	if _, err := (*awsCli)(nil).readFrom(context.Background(), "ftp://host/q.sql"); err == nil {
+		t.Error("no error for an unknown scheme")
+	}
+}
+
+func TestReadInputS3(t *testing.T) {
+	f := newFakeAWS(t)
+	defer f.Close()
+	f.objects["queries/daily.sql"] = []byte("select 1;\nselect 2")
+	awsCli := f.client()
+
+	queries, err := awsCli.readInput("s3://queries/daily.sql", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 2 || f.count("GETObject") != 1 {
+		t.Errorf("got queries %q with %d GetObject calls of the client", queries, f.count("GETObject"))
+	}
+}
+
+func TestReadInputGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "athenaq-repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "daily"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "daily", "report.sql"), []byte("select 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc/passwd", filepath.Join(dir, "daily", "escape.sql")); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "--quiet", "--initial-branch", "main"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "init"},
+		{"tag", "v1"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+
+	data, err := (*awsCli)(nil).readFrom(context.Background(), "git+file://"+dir+"//daily/report.sql?ref=v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "select 1" {
+		t.Errorf("got %q", data)
+	}
+	if _, err := (*awsCli)(nil).readFrom(context.Background(), "git+file://"+dir); err == nil {
+		t.Error("no error without a path in the repository")
+	}
+	for _, path := range []string{"//../../etc/passwd", "//daily/../..", "//daily/escape.sql"} {
+		if _, err := (*awsCli)(nil).readFrom(context.Background(), "git+file://"+dir+path); err == nil || !strings.Contains(err.Error(), "outside of the repository") {
+			t.Errorf("%s: got error %v, want outside of the repository", path, err)
+		}
+	}
+}
+
+func TestQueriesFlag(t *testing.T) {
+	var f queriesFlag
+	for _, q := range []string{"select 1; select 2;", "-- name: three\nselect 3"} {
+		if err := f.Set(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Set(" "); err == nil {
+		t.Error("no error for an empty query")
+	}
+	queries, err := f.read(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 3 || queries[2] != "-- name: three\nselect 3" {
+		t.Errorf("got queries %q", queries)
+	}
+}
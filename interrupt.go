@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// cancelOnSignal cancels the run when athenaq is interrupted, e.g. with
+// Ctrl-C, so that running queries are stopped and the teardown and deferred
+// cleanup run as for a failed batch. A second signal gives up on the cleanup:
+// exit restores the terminal and athenaq exits right away.
+func cancelOnSignal(cancel func(), exit func()) (stop func()) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go cancelOn(signals, done, cancel, func(sig os.Signal) {
+		exit()
+		fmt.Fprintf(os.Stderr, "athenaq: %v\n", sig)
+		os.Exit(128 + int(sig.(syscall.Signal)))
+	})
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+func cancelOn(signals <-chan os.Signal, done <-chan struct{}, cancel func(), exit func(os.Signal)) {
+	select {
+	case <-done:
+		return
+	case sig := <-signals:
+		infof("%v, stopping the running queries", sig)
+		cancel()
+	}
+	select {
+	case <-done:
+	case sig := <-signals:
+		exit(sig)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCancelOn(t *testing.T) {
+	signals := make(chan os.Signal, 2)
+	done := make(chan struct{})
+	cancelled, exited := make(chan struct{}), make(chan os.Signal, 1)
+	go cancelOn(signals, done, func() { close(cancelled) }, func(sig os.Signal) { exited <- sig })
+
+	signals <- os.Interrupt
+	<-cancelled
+	select {
+	case <-exited:
+		t.Fatal("exited on the first signal")
+	case <-time.After(10 * time.Millisecond):
+	}
+	signals <- os.Interrupt
+	if sig := <-exited; sig != os.Interrupt {
+		t.Errorf("exited on %v", sig)
+	}
+}
+
+func TestInterruptStopsQuery(t *testing.T) {
+	f := newFakeAWS(t)
+	defer f.Close()
+	f.result("select 1", "\"_col0\"\n\"1\"\n")
+	f.queue("select 1", 1)
+	awsCli := f.client()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for f.count("BatchGetQueryExecution") == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+	}()
+
+	var buf bytes.Buffer
+	if _, err := awsCli.execQuery(ctx, "select 1", &buf); err == nil {
+		t.Error("no error for an interrupted query")
+	}
+	if n := f.count("StopQueryExecution"); n != 1 {
+		t.Errorf("%d StopQueryExecution calls, want 1", n)
+	}
+}
@@ -0,0 +1,1207 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// The jinja-subset template engine is a small implementation of the part of
+// jinja that templated Airflow and dbt SQL uses, not a jinja port:
+//
+//   - {{ expressions }} with literals, arithmetic, comparisons, and, or, not,
+//     in, ~ and the tests defined, undefined, none, string and number
+//   - the filters default (d), upper, lower, trim (strip), replace, split,
+//     join, length (count), int and string, the string methods of the same
+//     names and startswith and endswith
+//   - {% if %}/{% elif %}/{% else %}, {% for %} with loop.index, loop.first
+//     and loop.last, {% set %} and {% macro %} with default and keyword
+//     arguments
+//   - {# comments #} and whitespace control with "-"
+//
+// Other tags, e.g. {% include %}, {% extends %} and {% call %}, and other
+// filters fail with an error naming them.
+
+type jinjaUndefined struct{ name string }
+
+type jinjaScope struct {
+	vars   map[string]interface{}
+	parent *jinjaScope
+	// denied are the environment variables -env-allow leaves out.
+	denied map[string]bool
+}
+
+func (s *jinjaScope) lookup(name string) (interface{}, error) {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v, nil
+		}
+		if s.denied[name] {
+			return nil, deniedEnvError(name)
+		}
+	}
+	return jinjaUndefined{name}, nil
+}
+
+// execJinja renders tmpl with the expression delimiters left and right
+// ("" == "{{" and "}}"). Referencing a denied variable fails.
+func execJinja(tmpl string, values map[string]string, denied map[string]bool, left, right string) (string, error) {
+	segs, err := jinjaSegments(tmpl, left, right)
+	if err != nil {
+		return "", err
+	}
+	p := &jinjaParser{segs: segs}
+	nodes, end, err := p.parseNodes()
+	if err != nil {
+		return "", err
+	}
+	if end != "" {
+		return "", fmt.Errorf("unexpected {%% %s %%}", end)
+	}
+	vars := map[string]interface{}{}
+	for k, v := range values {
+		vars[k] = v
+	}
+	var buf bytes.Buffer
+	if err := renderJinja(&buf, nodes, &jinjaScope{vars: vars, denied: denied}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// jinjaSegment is text (kind 0), an expression ('{') or a statement ('%').
+type jinjaSegment struct {
+	kind byte
+	text string
+}
+
+func jinjaSegments(tmpl, left, right string) ([]jinjaSegment, error) {
+	if left == "" {
+		left, right = "{{", "}}"
+	}
+	delims := []struct {
+		kind        byte
+		open, close string
+	}{{'{', left, right}, {'%', "{%", "%}"}, {'#', "{#", "#}"}}
+	var segs []jinjaSegment
+	trimNext := false
+	for len(tmpl) > 0 {
+		start, d := len(tmpl), -1
+		for i, delim := range delims {
+			if j := strings.Index(tmpl, delim.open); j >= 0 && j < start {
+				start, d = j, i
+			}
+		}
+		text := tmpl[:start]
+		if trimNext {
+			text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		}
+		tmpl = tmpl[start:]
+		if d < 0 {
+			segs = append(segs, jinjaSegment{text: text})
+			break
+		}
+		kind, open, closing := delims[d].kind, delims[d].open, delims[d].close
+		end := strings.Index(tmpl[len(open):], closing)
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed %s", open)
+		}
+		inner := tmpl[len(open) : len(open)+end]
+		tmpl = tmpl[len(open)+end+len(closing):]
+		if strings.HasPrefix(inner, "-") {
+			text = strings.TrimRightFunc(text, unicode.IsSpace)
+			inner = inner[1:]
+		}
+		trimNext = strings.HasSuffix(inner, "-")
+		if trimNext {
+			inner = inner[:len(inner)-1]
+		}
+		segs = append(segs, jinjaSegment{text: text})
+		if kind != '#' {
+			segs = append(segs, jinjaSegment{kind: kind, text: strings.TrimSpace(inner)})
+		}
+	}
+	return segs, nil
+}
+
+type jinjaNode interface{}
+
+type (
+	jinjaText   string
+	jinjaOutput struct{ expr jinjaExpr }
+	jinjaIf     struct {
+		conds  []jinjaExpr
+		bodies [][]jinjaNode
+		els    []jinjaNode
+	}
+	jinjaFor struct {
+		names []string
+		iter  jinjaExpr
+		body  []jinjaNode
+	}
+	jinjaSet struct {
+		name string
+		expr jinjaExpr
+	}
+	jinjaMacroDef struct {
+		name     string
+		params   []string
+		defaults map[string]jinjaExpr
+		body     []jinjaNode
+	}
+)
+
+// jinjaMacro is a macro defined in scope.
+type jinjaMacro struct {
+	def   jinjaMacroDef
+	scope *jinjaScope
+}
+
+// jinjaTags are the tags the engine supports, for the error on others.
+const jinjaTags = "if, for, set and macro"
+
+type jinjaParser struct {
+	segs []jinjaSegment
+	pos  int
+}
+
+// parseNodes parses until the end of the template or an elif, else or end
+// tag, which is returned.
+func (p *jinjaParser) parseNodes() ([]jinjaNode, string, error) {
+	var nodes []jinjaNode
+	for p.pos < len(p.segs) {
+		seg := p.segs[p.pos]
+		p.pos++
+		switch seg.kind {
+		case 0:
+			if seg.text != "" {
+				nodes = append(nodes, jinjaText(seg.text))
+			}
+		case '{':
+			expr, err := parseJinjaExpr(seg.text)
+			if err != nil {
+				return nil, "", err
+			}
+			nodes = append(nodes, jinjaOutput{expr})
+		case '%':
+			keyword, rest := seg.text, ""
+			if i := strings.IndexFunc(seg.text, unicode.IsSpace); i >= 0 {
+				keyword, rest = seg.text[:i], strings.TrimSpace(seg.text[i:])
+			}
+			switch keyword {
+			case "if":
+				node, err := p.parseIf(rest)
+				if err != nil {
+					return nil, "", err
+				}
+				nodes = append(nodes, node)
+			case "for":
+				node, err := p.parseFor(rest)
+				if err != nil {
+					return nil, "", err
+				}
+				nodes = append(nodes, node)
+			case "set":
+				eq := strings.Index(rest, "=")
+				if eq < 0 {
+					return nil, "", fmt.Errorf("invalid {%% set %s %%}", rest)
+				}
+				expr, err := parseJinjaExpr(rest[eq+1:])
+				if err != nil {
+					return nil, "", err
+				}
+				nodes = append(nodes, jinjaSet{strings.TrimSpace(rest[:eq]), expr})
+			case "macro":
+				node, err := p.parseMacro(rest)
+				if err != nil {
+					return nil, "", err
+				}
+				nodes = append(nodes, node)
+			case "elif", "else", "endif", "endfor", "endmacro":
+				return nodes, seg.text, nil
+			default:
+				return nil, "", fmt.Errorf("unsupported tag {%% %s %%}, the jinja-subset engine supports %s", keyword, jinjaTags)
+			}
+		}
+	}
+	return nodes, "", nil
+}
+
+func (p *jinjaParser) parseIf(cond string) (jinjaNode, error) {
+	node := jinjaIf{}
+	for {
+		expr, err := parseJinjaExpr(cond)
+		if err != nil {
+			return nil, err
+		}
+		body, end, err := p.parseNodes()
+		if err != nil {
+			return nil, err
+		}
+		node.conds = append(node.conds, expr)
+		node.bodies = append(node.bodies, body)
+		switch {
+		case strings.HasPrefix(end, "elif "):
+			cond = strings.TrimSpace(end[len("elif "):])
+			continue
+		case end == "else":
+			node.els, end, err = p.parseNodes()
+			if err != nil {
+				return nil, err
+			}
+		}
+		if end != "endif" {
+			return nil, fmt.Errorf("{%% if %%} closed by %q", end)
+		}
+		return node, nil
+	}
+}
+
+func (p *jinjaParser) parseFor(clause string) (jinjaNode, error) {
+	in := strings.Index(clause, " in ")
+	if in < 0 {
+		return nil, fmt.Errorf("invalid {%% for %s %%}", clause)
+	}
+	node := jinjaFor{}
+	for _, name := range strings.Split(clause[:in], ",") {
+		node.names = append(node.names, strings.TrimSpace(name))
+	}
+	var err error
+	if node.iter, err = parseJinjaExpr(clause[in+4:]); err != nil {
+		return nil, err
+	}
+	body, end, err := p.parseNodes()
+	if err != nil {
+		return nil, err
+	}
+	if end != "endfor" {
+		return nil, fmt.Errorf("{%% for %%} closed by %q", end)
+	}
+	node.body = body
+	return node, nil
+}
+
+// parseMacro parses the signature, e.g. "where(col, days=7)", and the body
+// of a macro.
+func (p *jinjaParser) parseMacro(signature string) (jinjaNode, error) {
+	open := strings.Index(signature, "(")
+	if open < 0 || !strings.HasSuffix(signature, ")") {
+		return nil, fmt.Errorf("invalid {%% macro %s %%}", signature)
+	}
+	node := jinjaMacroDef{name: strings.TrimSpace(signature[:open]), defaults: map[string]jinjaExpr{}}
+	if params := strings.TrimSpace(signature[open+1 : len(signature)-1]); params != "" {
+		for _, param := range strings.Split(params, ",") {
+			name := param
+			if eq := strings.Index(param, "="); eq >= 0 {
+				expr, err := parseJinjaExpr(param[eq+1:])
+				if err != nil {
+					return nil, err
+				}
+				name = param[:eq]
+				node.defaults[strings.TrimSpace(name)] = expr
+			}
+			node.params = append(node.params, strings.TrimSpace(name))
+		}
+	}
+	body, end, err := p.parseNodes()
+	if err != nil {
+		return nil, err
+	}
+	if end != "endmacro" {
+		return nil, fmt.Errorf("{%% macro %s %%} closed by %q", node.name, end)
+	}
+	node.body = body
+	return node, nil
+}
+
+func renderJinja(buf *bytes.Buffer, nodes []jinjaNode, scope *jinjaScope) error {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case jinjaText:
+			buf.WriteString(string(n))
+		case jinjaOutput:
+			v, err := n.expr.eval(scope)
+			if err != nil {
+				return err
+			}
+			buf.WriteString(jinjaString(v))
+		case jinjaSet:
+			v, err := n.expr.eval(scope)
+			if err != nil {
+				return err
+			}
+			scope.vars[n.name] = v
+		case jinjaMacroDef:
+			scope.vars[n.name] = &jinjaMacro{def: n, scope: scope}
+		case jinjaIf:
+			body := n.els
+			for i, cond := range n.conds {
+				v, err := cond.eval(scope)
+				if err != nil {
+					return err
+				}
+				if jinjaTrue(v) {
+					body = n.bodies[i]
+					break
+				}
+			}
+			if err := renderJinja(buf, body, scope); err != nil {
+				return err
+			}
+		case jinjaFor:
+			v, err := n.iter.eval(scope)
+			if err != nil {
+				return err
+			}
+			items, err := jinjaItems(v)
+			if err != nil {
+				return err
+			}
+			for i, item := range items {
+				vars := map[string]interface{}{"loop": map[string]interface{}{
+					"index": i + 1, "index0": i, "first": i == 0, "last": i == len(items)-1, "length": len(items),
+				}}
+				if len(n.names) == 1 {
+					vars[n.names[0]] = item
+				} else {
+					values, ok := item.([]interface{})
+					if !ok || len(values) != len(n.names) {
+						return fmt.Errorf("cannot unpack %s into %d names", jinjaString(item), len(n.names))
+					}
+					for j, name := range n.names {
+						vars[name] = values[j]
+					}
+				}
+				if err := renderJinja(buf, n.body, &jinjaScope{vars: vars, parent: scope}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func jinjaItems(v interface{}) ([]interface{}, error) {
+	switch v := v.(type) {
+	case []interface{}:
+		return v, nil
+	case string:
+		var items []interface{}
+		for _, r := range v {
+			items = append(items, string(r))
+		}
+		return items, nil
+	case map[string]interface{}:
+		var keys []string
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		items := make([]interface{}, len(keys))
+		for i, k := range keys {
+			items[i] = k
+		}
+		return items, nil
+	case jinjaUndefined:
+		return nil, fmt.Errorf("%s is undefined", v.name)
+	}
+	return nil, fmt.Errorf("cannot iterate over %s", jinjaString(v))
+}
+
+func jinjaString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "None"
+	case jinjaUndefined:
+		return ""
+	case string:
+		return v
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			if s, ok := item.(string); ok {
+				parts[i] = "'" + s + "'"
+			} else {
+				parts[i] = jinjaString(item)
+			}
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	}
+	return fmt.Sprint(v)
+}
+
+func jinjaTrue(v interface{}) bool {
+	switch v := v.(type) {
+	case nil, jinjaUndefined:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case int:
+		return v != 0
+	case float64:
+		return v != 0
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	}
+	return true
+}
+
+func jinjaNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+type jinjaExpr interface {
+	eval(scope *jinjaScope) (interface{}, error)
+}
+
+type (
+	jinjaLiteral struct{ v interface{} }
+	jinjaName    string
+	jinjaList    []jinjaExpr
+	jinjaNot     struct{ x jinjaExpr }
+	jinjaBinary  struct {
+		op   string
+		l, r jinjaExpr
+	}
+	jinjaAttr struct {
+		x    jinjaExpr
+		name string
+	}
+	jinjaIndex struct{ x, i jinjaExpr }
+	jinjaCall  struct {
+		x    jinjaExpr
+		name string
+		args []jinjaExpr
+		// filter applies name as a filter instead of a method of x.
+		filter bool
+	}
+	jinjaTest struct {
+		x    jinjaExpr
+		name string
+		not  bool
+	}
+	// jinjaInvoke calls a macro with positional and keyword arguments.
+	jinjaInvoke struct {
+		x      jinjaExpr
+		args   []jinjaExpr
+		kwargs map[string]jinjaExpr
+	}
+)
+
+func (e jinjaLiteral) eval(*jinjaScope) (interface{}, error) { return e.v, nil }
+
+func (e jinjaName) eval(scope *jinjaScope) (interface{}, error) { return scope.lookup(string(e)) }
+
+func (e jinjaList) eval(scope *jinjaScope) (interface{}, error) {
+	items := make([]interface{}, len(e))
+	for i, x := range e {
+		v, err := x.eval(scope)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = v
+	}
+	return items, nil
+}
+
+func (e jinjaNot) eval(scope *jinjaScope) (interface{}, error) {
+	v, err := e.x.eval(scope)
+	return !jinjaTrue(v), err
+}
+
+func (e jinjaBinary) eval(scope *jinjaScope) (interface{}, error) {
+	l, err := e.l.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case e.op == "and" && !jinjaTrue(l), e.op == "or" && jinjaTrue(l):
+		return l, nil
+	}
+	r, err := e.r.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	ln, lnum := jinjaNumber(l)
+	rn, rnum := jinjaNumber(r)
+	switch e.op {
+	case "and", "or":
+		return r, nil
+	case "~":
+		return jinjaString(l) + jinjaString(r), nil
+	case "==":
+		return jinjaEqual(l, r), nil
+	case "!=":
+		return !jinjaEqual(l, r), nil
+	case "in", "not in":
+		in, err := jinjaContains(r, l)
+		return in == (e.op == "in"), err
+	case "+":
+		if lnum && rnum {
+			return ln + rn, nil
+		}
+		if ll, ok := l.([]interface{}); ok {
+			if rl, ok := r.([]interface{}); ok {
+				return append(append([]interface{}{}, ll...), rl...), nil
+			}
+		}
+		ls, lok := l.(string)
+		rs, rok := r.(string)
+		if lok && rok {
+			return ls + rs, nil
+		}
+		return nil, fmt.Errorf("cannot add %s and %s", jinjaString(l), jinjaString(r))
+	case "-", "*", "/":
+		if !lnum || !rnum {
+			return nil, fmt.Errorf("unsupported operand for %s: %s and %s", e.op, jinjaString(l), jinjaString(r))
+		}
+		switch e.op {
+		case "-":
+			return ln - rn, nil
+		case "*":
+			return ln * rn, nil
+		}
+		if rn == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return ln / rn, nil
+	}
+	var cmp int
+	switch {
+	case lnum && rnum:
+		cmp = map[bool]int{true: -1, false: 1}[ln < rn]
+		if ln == rn {
+			cmp = 0
+		}
+	default:
+		ls, lok := l.(string)
+		rs, rok := r.(string)
+		if !lok || !rok {
+			return nil, fmt.Errorf("cannot compare %s and %s", jinjaString(l), jinjaString(r))
+		}
+		cmp = strings.Compare(ls, rs)
+	}
+	switch e.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	}
+	return cmp >= 0, nil
+}
+
+func jinjaEqual(l, r interface{}) bool {
+	ln, lnum := jinjaNumber(l)
+	rn, rnum := jinjaNumber(r)
+	if lnum && rnum {
+		return ln == rn
+	}
+	if lnum != rnum {
+		return false
+	}
+	return fmt.Sprintf("%T%s", l, jinjaString(l)) == fmt.Sprintf("%T%s", r, jinjaString(r))
+}
+
+func jinjaContains(container, item interface{}) (bool, error) {
+	switch c := container.(type) {
+	case string:
+		s, ok := item.(string)
+		if !ok {
+			return false, fmt.Errorf("cannot look for %s in a string", jinjaString(item))
+		}
+		return strings.Contains(c, s), nil
+	case []interface{}:
+		for _, v := range c {
+			if jinjaEqual(v, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]interface{}:
+		_, ok := c[jinjaString(item)]
+		return ok, nil
+	case jinjaUndefined:
+		return false, fmt.Errorf("%s is undefined", c.name)
+	}
+	return false, fmt.Errorf("cannot look for %s in %s", jinjaString(item), jinjaString(container))
+}
+
+func (e jinjaAttr) eval(scope *jinjaScope) (interface{}, error) {
+	v, err := e.x.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if a, ok := v[e.name]; ok {
+			return a, nil
+		}
+	case jinjaUndefined:
+		return nil, fmt.Errorf("%s is undefined", v.name)
+	}
+	return jinjaUndefined{e.name}, nil
+}
+
+func (e jinjaIndex) eval(scope *jinjaScope) (interface{}, error) {
+	v, err := e.x.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	i, err := e.i.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if a, ok := v[jinjaString(i)]; ok {
+			return a, nil
+		}
+		return jinjaUndefined{jinjaString(i)}, nil
+	case []interface{}:
+		n, ok := jinjaNumber(i)
+		if !ok {
+			return nil, fmt.Errorf("list index %s is not a number", jinjaString(i))
+		}
+		idx := int(n)
+		if idx < 0 {
+			idx += len(v)
+		}
+		if idx < 0 || idx >= len(v) {
+			return nil, fmt.Errorf("list index %d out of range", int(n))
+		}
+		return v[idx], nil
+	case jinjaUndefined:
+		return nil, fmt.Errorf("%s is undefined", v.name)
+	}
+	return nil, fmt.Errorf("cannot index %s", jinjaString(v))
+}
+
+func (e jinjaTest) eval(scope *jinjaScope) (interface{}, error) {
+	v, err := e.x.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	var ok bool
+	switch e.name {
+	case "defined":
+		_, undefined := v.(jinjaUndefined)
+		ok = !undefined
+	case "undefined":
+		_, ok = v.(jinjaUndefined)
+	case "none":
+		ok = v == nil
+	case "string":
+		_, ok = v.(string)
+	case "number":
+		_, ok = jinjaNumber(v)
+	default:
+		return nil, fmt.Errorf("unsupported test %q", e.name)
+	}
+	return ok != e.not, nil
+}
+
+func (e jinjaCall) eval(scope *jinjaScope) (interface{}, error) {
+	v, err := e.x.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]interface{}, len(e.args))
+	for i, a := range e.args {
+		if args[i], err = a.eval(scope); err != nil {
+			return nil, err
+		}
+	}
+	if u, ok := v.(jinjaUndefined); ok && !(e.filter && (e.name == "default" || e.name == "d")) {
+		return nil, fmt.Errorf("%s is undefined", u.name)
+	}
+	arg := func(i int, def string) string {
+		if i < len(args) {
+			return jinjaString(args[i])
+		}
+		return def
+	}
+	s := jinjaString(v)
+	switch e.name {
+	case "default", "d":
+		if !e.filter {
+			break
+		}
+		_, undefined := v.(jinjaUndefined)
+		if undefined || len(args) > 1 && jinjaTrue(args[1]) && !jinjaTrue(v) {
+			if len(args) == 0 {
+				return "", nil
+			}
+			return args[0], nil
+		}
+		return v, nil
+	case "upper":
+		return strings.ToUpper(s), nil
+	case "lower":
+		return strings.ToLower(s), nil
+	case "trim", "strip":
+		return strings.TrimSpace(s), nil
+	case "replace":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("replace expects 2 arguments")
+		}
+		return strings.Replace(s, arg(0, ""), arg(1, ""), -1), nil
+	case "split":
+		var parts []string
+		if len(args) == 0 {
+			parts = strings.Fields(s)
+		} else {
+			parts = strings.Split(s, arg(0, ""))
+		}
+		items := make([]interface{}, len(parts))
+		for i, p := range parts {
+			items[i] = p
+		}
+		return items, nil
+	case "startswith":
+		return strings.HasPrefix(s, arg(0, "")), nil
+	case "endswith":
+		return strings.HasSuffix(s, arg(0, "")), nil
+	case "join":
+		items, err := jinjaItems(v)
+		if err != nil {
+			return nil, err
+		}
+		parts := make([]string, len(items))
+		for i, item := range items {
+			parts[i] = jinjaString(item)
+		}
+		return strings.Join(parts, arg(0, "")), nil
+	case "length", "count":
+		if _, ok := v.(string); ok {
+			return len([]rune(s)), nil
+		}
+		items, err := jinjaItems(v)
+		return len(items), err
+	case "int":
+		if n, ok := jinjaNumber(v); ok {
+			return int(n), nil
+		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return 0, nil
+		}
+		return int(n), nil
+	case "string":
+		return s, nil
+	}
+	if e.filter {
+		return nil, fmt.Errorf("unsupported filter %q", e.name)
+	}
+	return nil, fmt.Errorf("unsupported method %q", e.name)
+}
+
+func (e jinjaInvoke) eval(scope *jinjaScope) (interface{}, error) {
+	v, err := e.x.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(*jinjaMacro)
+	if u, undefined := v.(jinjaUndefined); undefined {
+		return nil, fmt.Errorf("macro %s is undefined", u.name)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s is not a macro", jinjaString(v))
+	}
+	if len(e.args) > len(m.def.params) {
+		return nil, fmt.Errorf("macro %s takes %d arguments, got %d", m.def.name, len(m.def.params), len(e.args))
+	}
+	vars := map[string]interface{}{}
+	for i, param := range m.def.params {
+		var arg jinjaExpr
+		argScope := scope
+		switch kwarg, ok := e.kwargs[param]; {
+		case i < len(e.args):
+			arg = e.args[i]
+		case ok:
+			arg = kwarg
+		case m.def.defaults[param] != nil:
+			arg, argScope = m.def.defaults[param], m.scope
+		default:
+			vars[param] = jinjaUndefined{param}
+			continue
+		}
+		if vars[param], err = arg.eval(argScope); err != nil {
+			return nil, err
+		}
+	}
+	for name := range e.kwargs {
+		if _, ok := vars[name]; !ok {
+			return nil, fmt.Errorf("macro %s has no argument %s", m.def.name, name)
+		}
+	}
+	var buf bytes.Buffer
+	if err := renderJinja(&buf, m.def.body, &jinjaScope{vars: vars, parent: m.scope}); err != nil {
+		return nil, errors.Wrapf(err, "macro %s", m.def.name)
+	}
+	return buf.String(), nil
+}
+
+type jinjaToken struct {
+	kind byte // 'n'ame, 's'tring, '0' number or 'o'perator
+	text string
+}
+
+func lexJinja(src string) ([]jinjaToken, error) {
+	var toks []jinjaToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'' || c == '"':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+				}
+				b.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string in %q", src)
+			}
+			toks = append(toks, jinjaToken{'s', b.String()})
+			i = j + 1
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			toks = append(toks, jinjaToken{'0', src[i:j]})
+			i = j
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(src) && (src[j] == '_' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			toks = append(toks, jinjaToken{'n', src[i:j]})
+			i = j
+		default:
+			op := string(c)
+			if i+1 < len(src) {
+				switch two := src[i : i+2]; two {
+				case "==", "!=", "<=", ">=":
+					op = two
+				}
+			}
+			if !strings.Contains("==!=<=>=<>()[]{},.|~+-*/:", op) {
+				return nil, fmt.Errorf("unexpected %q in %q", op, src)
+			}
+			toks = append(toks, jinjaToken{'o', op})
+			i += len(op)
+		}
+	}
+	return toks, nil
+}
+
+type jinjaExprParser struct {
+	toks []jinjaToken
+	pos  int
+}
+
+func parseJinjaExpr(src string) (jinjaExpr, error) {
+	toks, err := lexJinja(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &jinjaExprParser{toks: toks}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q in %q", p.toks[p.pos].text, src)
+	}
+	return e, nil
+}
+
+func (p *jinjaExprParser) peek(kind byte, text string) bool {
+	return p.pos < len(p.toks) && p.toks[p.pos].kind == kind && p.toks[p.pos].text == text
+}
+
+func (p *jinjaExprParser) accept(kind byte, text string) bool {
+	if p.peek(kind, text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *jinjaExprParser) expect(text string) error {
+	if !p.accept('o', text) {
+		return fmt.Errorf("expected %q", text)
+	}
+	return nil
+}
+
+func (p *jinjaExprParser) name() (string, error) {
+	if p.pos >= len(p.toks) || p.toks[p.pos].kind != 'n' {
+		return "", fmt.Errorf("expected a name")
+	}
+	p.pos++
+	return p.toks[p.pos-1].text, nil
+}
+
+func (p *jinjaExprParser) or() (jinjaExpr, error) {
+	l, err := p.and()
+	for err == nil && p.accept('n', "or") {
+		var r jinjaExpr
+		r, err = p.and()
+		l = jinjaBinary{"or", l, r}
+	}
+	return l, err
+}
+
+func (p *jinjaExprParser) and() (jinjaExpr, error) {
+	l, err := p.not()
+	for err == nil && p.accept('n', "and") {
+		var r jinjaExpr
+		r, err = p.not()
+		l = jinjaBinary{"and", l, r}
+	}
+	return l, err
+}
+
+func (p *jinjaExprParser) not() (jinjaExpr, error) {
+	if p.accept('n', "not") {
+		x, err := p.not()
+		return jinjaNot{x}, err
+	}
+	return p.compare()
+}
+
+func (p *jinjaExprParser) compare() (jinjaExpr, error) {
+	l, err := p.concat()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.accept('o', op) {
+			r, err := p.concat()
+			return jinjaBinary{op, l, r}, err
+		}
+	}
+	switch {
+	case p.accept('n', "in"):
+		r, err := p.concat()
+		return jinjaBinary{"in", l, r}, err
+	case p.peek('n', "not") && p.pos+1 < len(p.toks) && p.toks[p.pos+1].text == "in":
+		p.pos += 2
+		r, err := p.concat()
+		return jinjaBinary{"not in", l, r}, err
+	case p.accept('n', "is"):
+		not := p.accept('n', "not")
+		name, err := p.name()
+		return jinjaTest{l, name, not}, err
+	}
+	return l, nil
+}
+
+func (p *jinjaExprParser) concat() (jinjaExpr, error) {
+	l, err := p.sum()
+	for err == nil && p.accept('o', "~") {
+		var r jinjaExpr
+		r, err = p.sum()
+		l = jinjaBinary{"~", l, r}
+	}
+	return l, err
+}
+
+func (p *jinjaExprParser) sum() (jinjaExpr, error) {
+	l, err := p.product()
+	for err == nil && (p.peek('o', "+") || p.peek('o', "-")) {
+		op := p.toks[p.pos].text
+		p.pos++
+		var r jinjaExpr
+		r, err = p.product()
+		l = jinjaBinary{op, l, r}
+	}
+	return l, err
+}
+
+func (p *jinjaExprParser) product() (jinjaExpr, error) {
+	l, err := p.filtered()
+	for err == nil && (p.peek('o', "*") || p.peek('o', "/")) {
+		op := p.toks[p.pos].text
+		p.pos++
+		var r jinjaExpr
+		r, err = p.filtered()
+		l = jinjaBinary{op, l, r}
+	}
+	return l, err
+}
+
+func (p *jinjaExprParser) filtered() (jinjaExpr, error) {
+	x, err := p.postfix()
+	for err == nil && p.accept('o', "|") {
+		var name string
+		if name, err = p.name(); err != nil {
+			break
+		}
+		call := jinjaCall{x: x, name: name, filter: true}
+		if p.accept('o', "(") {
+			call.args, err = p.args(")")
+		}
+		x = call
+	}
+	return x, err
+}
+
+func (p *jinjaExprParser) postfix() (jinjaExpr, error) {
+	x, err := p.primary()
+	for err == nil {
+		switch {
+		case p.accept('o', "."):
+			var name string
+			if name, err = p.name(); err != nil {
+				return nil, err
+			}
+			if p.accept('o', "(") {
+				call := jinjaCall{x: x, name: name}
+				call.args, err = p.args(")")
+				x = call
+			} else {
+				x = jinjaAttr{x, name}
+			}
+		case p.accept('o', "("):
+			var call jinjaInvoke
+			if call, err = p.invokeArgs(); err != nil {
+				return nil, err
+			}
+			call.x = x
+			x = call
+		case p.accept('o', "["):
+			var i jinjaExpr
+			if i, err = p.or(); err == nil {
+				err = p.expect("]")
+			}
+			x = jinjaIndex{x, i}
+		default:
+			return x, nil
+		}
+	}
+	return x, err
+}
+
+// invokeArgs parses the positional and name=value arguments of a macro
+// call up to the closing parenthesis.
+func (p *jinjaExprParser) invokeArgs() (jinjaInvoke, error) {
+	call := jinjaInvoke{kwargs: map[string]jinjaExpr{}}
+	for n := 0; !p.accept('o', ")"); n++ {
+		if n > 0 {
+			if err := p.expect(","); err != nil {
+				return call, err
+			}
+		}
+		if p.pos+1 < len(p.toks) && p.toks[p.pos].kind == 'n' && p.toks[p.pos+1].kind == 'o' && p.toks[p.pos+1].text == "=" {
+			name := p.toks[p.pos].text
+			p.pos += 2
+			a, err := p.or()
+			if err != nil {
+				return call, err
+			}
+			call.kwargs[name] = a
+			continue
+		}
+		if len(call.kwargs) > 0 {
+			return call, fmt.Errorf("positional argument after keyword argument")
+		}
+		a, err := p.or()
+		if err != nil {
+			return call, err
+		}
+		call.args = append(call.args, a)
+	}
+	return call, nil
+}
+
+// args parses comma separated expressions up to the closing token.
+func (p *jinjaExprParser) args(closing string) ([]jinjaExpr, error) {
+	var args []jinjaExpr
+	for !p.accept('o', closing) {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		a, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, a)
+	}
+	return args, nil
+}
+
+func (p *jinjaExprParser) primary() (jinjaExpr, error) {
+	if p.pos >= len(p.toks) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	t := p.toks[p.pos]
+	p.pos++
+	switch t.kind {
+	case 's':
+		return jinjaLiteral{t.text}, nil
+	case '0':
+		if !strings.Contains(t.text, ".") {
+			n, err := strconv.Atoi(t.text)
+			return jinjaLiteral{n}, err
+		}
+		n, err := strconv.ParseFloat(t.text, 64)
+		return jinjaLiteral{n}, err
+	case 'n':
+		switch t.text {
+		case "true", "True":
+			return jinjaLiteral{true}, nil
+		case "false", "False":
+			return jinjaLiteral{false}, nil
+		case "none", "None":
+			return jinjaLiteral{nil}, nil
+		}
+		return jinjaName(t.text), nil
+	}
+	switch t.text {
+	case "(":
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	case "[":
+		items, err := p.args("]")
+		return jinjaList(items), err
+	case "-":
+		x, err := p.postfix()
+		return jinjaBinary{"-", jinjaLiteral{0}, x}, err
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
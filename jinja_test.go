@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestExecJinja(t *testing.T) {
+	values := map[string]string{"ds": "2024-01-31", "TABLES": "users,orders", "ENV": "prod", "LIMIT": "10"}
+	tests := []struct {
+		tmpl string
+		want string
+		err  bool
+	}{
+		{tmpl: "select * from t where dt = '{{ ds }}'", want: "select * from t where dt = '2024-01-31'"},
+		{tmpl: "{{ missing | default('x') }} {{ ENV | upper }} {{ missing }}", want: "x PROD "},
+		{tmpl: "{# comment #}select 1", want: "select 1"},
+		{tmpl: "{% if ENV == 'prod' %}prod{% elif ENV == 'dev' %}dev{% else %}other{% endif %}", want: "prod"},
+		{tmpl: "{% if missing is defined %}a{% else %}b{% endif %}", want: "b"},
+		{tmpl: "{% for t in TABLES.split(',') %}{{ t }}{% if not loop.last %}, {% endif %}{% endfor %}", want: "users, orders"},
+		{tmpl: "{% for t in ['a', 'b'] -%}\n  {{ loop.index }}{{ t }}\n{%- endfor %}", want: "1a2b"},
+		{tmpl: "{% set n = LIMIT | int %}{{ n * 2 }} {{ 'a' ~ n }}", want: "20 a10"},
+		{tmpl: "{{ 'x' in ENV }} {{ 'o' in ENV }}", want: "False True"},
+		{tmpl: "{{ TABLES | replace(',', ' ') }}", want: "users orders"},
+		{tmpl: "{% macro part(col, days=7) %}{{ col }} >= current_date - {{ days }}{% endmacro %}{{ part('dt') }} and {{ part('ts', days=LIMIT) }}", want: "dt >= current_date - 7 and ts >= current_date - 10"},
+		{tmpl: "{% macro q(t) -%}\n  {{ t }}_{{ ENV }}\n{%- endmacro %}{% for t in ['a', 'b'] %}{{ q(t) }} {% endfor %}", want: "a_prod b_prod "},
+		{tmpl: "{{ nope() }}", err: true},
+		{tmpl: "{% macro m(a) %}{% endmacro %}{{ m(b=1) }}", err: true},
+		{tmpl: "{% macro m(a) %}", err: true},
+		{tmpl: "{% include 'x.sql' %}", err: true},
+		{tmpl: "{% if x %}", err: true},
+		{tmpl: "{{ missing.attr }}", err: true},
+		{tmpl: "{{ ds | nope }}", err: true},
+		{tmpl: "{{ ds", err: true},
+	}
+	for _, test := range tests {
+		got, err := execJinja(test.tmpl, values, nil, "", "")
+		if (err != nil) != test.err {
+			t.Errorf("%q: unexpected error %v", test.tmpl, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.tmpl, got, test.want)
+		}
+	}
+}
+
+func TestExecJinjaUnsupportedTag(t *testing.T) {
+	_, err := execJinja("{% extends 'base.sql' %}", nil, nil, "", "")
+	if err == nil || err.Error() != "unsupported tag {% extends %}, the jinja-subset engine supports if, for, set and macro" {
+		t.Errorf("got error %v", err)
+	}
+}
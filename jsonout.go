@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+var outputFormats = []string{"csv", "json"}
+
+// jsonWriter writes a csv result as json lines, one object per row keyed by
+// the columns of the header. Fields are strings as athena writes them, empty
+// unquoted fields (NULL) are null.
+type jsonWriter struct {
+	w       io.Writer
+	columns []string
+	split   rowSplitter
+}
+
+func (j *jsonWriter) Write(p []byte) (int, error) {
+	return len(p), j.split.write(p, j.writeRow)
+}
+
+func (j *jsonWriter) writeRow(row []byte) error {
+	fields := csvFields(row)
+	if j.columns == nil {
+		for _, f := range fields {
+			j.columns = append(j.columns, unquoteField(f))
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, column := range j.columns {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(column)
+		buf.Write(name)
+		buf.WriteByte(':')
+		if i >= len(fields) || len(fields[i]) == 0 {
+			buf.WriteString("null")
+			continue
+		}
+		value, _ := json.Marshal(unquoteField(fields[i]))
+		buf.Write(value)
+	}
+	buf.WriteString("}\n")
+	_, err := j.w.Write(buf.Bytes())
+	return err
+}
+
+// flush writes a last row without a trailing newline.
+func (j *jsonWriter) flush() error {
+	if len(j.split.row) == 0 {
+		return nil
+	}
+	defer func() { j.split.row = nil }()
+	return j.writeRow(j.split.row)
+}
+
+// resultRows returns the number of rows of a result written in format.
+func resultRows(data []byte, format string) int {
+	if format == "json" {
+		return bytes.Count(data, []byte("\n"))
+	}
+	return countRows(data)
+}
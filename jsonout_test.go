@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestJSONWriter(t *testing.T) {
+	var buf bytes.Buffer
+	jw := &jsonWriter{w: &buf}
+	for _, chunk := range []string{"\"id\",\"na", "me\",\"note\"\n\"1\",\"a \"\"b\"\"\",\n\"2\",\"", "multi\nline\",\"\""} {
+		if _, err := jw.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := jw.flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":"1","name":"a \"b\"","note":null}` + "\n" + `{"id":"2","name":"multi\nline","note":""}` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if n := resultRows(buf.Bytes(), "json"); n != 2 {
+		t.Errorf("got %d rows, want 2", n)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+type lineageQuery struct {
+	Index   int      `json:"index"`
+	Sources []string `json:"sources"`
+	Targets []string `json:"targets"`
+}
+
+type lineageEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Query  int    `json:"query"`
+}
+
+type lineage struct {
+	Tables  []string       `json:"tables"`
+	Edges   []lineageEdge  `json:"edges"`
+	Queries []lineageQuery `json:"queries"`
+}
+
+func lineageCmd(args []string) error {
+	fs := flag.NewFlagSet("lineage", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: athenaq lineage [flags]")
+		fs.PrintDefaults()
+	}
+	var (
+		inputFile = fs.String("f", "", `input file (""== STDIN | file://... | s3://... | https://... | git+https://repo.git//path?ref=...)`)
+		format    = fs.String("format", "dot", "output format (dot|json)")
+		templates = addTemplateFlags(fs)
+	)
+	fs.Parse(args)
+
+	queries, err := (*awsCli)(nil).readInput(*inputFile, templates)
+	if err != nil {
+		return err
+	}
+
+	l := buildLineage(queries)
+	switch *format {
+	case "dot":
+		return l.writeDOT(os.Stdout)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(l)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+}
+
+func buildLineage(queries []string) *lineage {
+	l := &lineage{}
+	tables := map[string]bool{}
+	for i, query := range queries {
+		toks := tokenize(query)
+		q := lineageQuery{Index: i + 1, Sources: refNames(sourceTables(toks)), Targets: refNames(targetTables(toks))}
+		for _, target := range q.Targets {
+			tables[target] = true
+			for _, source := range q.Sources {
+				l.Edges = append(l.Edges, lineageEdge{Source: source, Target: target, Query: q.Index})
+			}
+		}
+		for _, source := range q.Sources {
+			tables[source] = true
+		}
+		l.Queries = append(l.Queries, q)
+	}
+	for table := range tables {
+		l.Tables = append(l.Tables, table)
+	}
+	sort.Strings(l.Tables)
+	return l
+}
+
+func refNames(refs []tableRef) []string {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, ref := range refs {
+		if name := ref.name(); !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (l *lineage) writeDOT(w io.Writer) error {
+	fmt.Fprintln(w, "digraph lineage {")
+	for _, table := range l.Tables {
+		fmt.Fprintf(w, "  %q;\n", table)
+	}
+	for _, e := range l.Edges {
+		fmt.Fprintf(w, "  %q -> %q [label=\"%d\"];\n", e.Source, e.Target, e.Query)
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestBuildLineage(t *testing.T) {
+	l := buildLineage([]string{
+		"create table db.clean as select * from db.raw r join db.users u on r.user_id = u.id",
+		"insert into db.report select count(*) from db.clean, db.clean c2",
+		"select * from db.report",
+		"create or replace view db.v as select 1",
+	})
+	if want := []string{"db.clean", "db.raw", "db.report", "db.users", "db.v"}; !reflect.DeepEqual(l.Tables, want) {
+		t.Errorf("tables = %v, want %v", l.Tables, want)
+	}
+	wantEdges := []lineageEdge{
+		{Source: "db.raw", Target: "db.clean", Query: 1},
+		{Source: "db.users", Target: "db.clean", Query: 1},
+		{Source: "db.clean", Target: "db.report", Query: 2},
+	}
+	if !reflect.DeepEqual(l.Edges, wantEdges) {
+		t.Errorf("edges = %v, want %v", l.Edges, wantEdges)
+	}
+	wantQueries := []lineageQuery{
+		{Index: 1, Sources: []string{"db.raw", "db.users"}, Targets: []string{"db.clean"}},
+		{Index: 2, Sources: []string{"db.clean"}, Targets: []string{"db.report"}},
+		{Index: 3, Sources: []string{"db.report"}, Targets: []string{}},
+		{Index: 4, Sources: []string{}, Targets: []string{"db.v"}},
+	}
+	if !reflect.DeepEqual(l.Queries, wantQueries) {
+		t.Errorf("queries = %v, want %v", l.Queries, wantQueries)
+	}
+}
+
+func TestLineageDOT(t *testing.T) {
+	var buf bytes.Buffer
+	if err := buildLineage([]string{"insert into t select * from s"}).writeDOT(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "digraph lineage {\n  \"s\";\n  \"t\";\n  \"s\" -> \"t\" [label=\"1\"];\n}\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
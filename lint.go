@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+type lintFinding struct {
+	query   int
+	rule    string
+	message string
+}
+
+func lintCmd(args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: athenaq lint [flags] [path ...]")
+		fs.PrintDefaults()
+	}
+	var (
+		partitions = fs.Bool("partitions", false, "check for partition predicates using the glue catalog (requires aws credentials)")
+		database   = fs.String("database", "default", "database of unqualified table names")
+		awsRegion  = fs.String("region", "", regionFlagUsage)
+		templates  = addTemplateFlags(fs)
+	)
+	addLogFlags(fs)
+	addCatalogCacheFlag(fs)
+	fs.Parse(args)
+
+	var keys *partitionKeys
+	if *partitions {
+		sess, err := newSession(*awsRegion)
+		if err != nil {
+			return err
+		}
+		keys = newPartitionKeys(newGlue(sess), *database)
+	}
+	ctx := context.Background()
+
+	count := 0
+	lintFile := func(name string, queries []string) error {
+		for i, query := range queries {
+			findings, err := lintQuery(ctx, i+1, query, templates.delims, keys)
+			if err != nil {
+				return err
+			}
+			for _, f := range findings {
+				fmt.Printf("%s:%d: %s: %s\n", name, f.query, f.rule, f.message)
+			}
+			count += len(findings)
+		}
+		return nil
+	}
+
+	if fs.NArg() == 0 {
+		queries, err := (*awsCli)(nil).readInput("", templates)
+		if err != nil {
+			return err
+		}
+		err = lintFile("<standard input>", queries)
+		if err != nil {
+			return err
+		}
+	} else {
+		err := walkSQLFiles(fs.Args(), func(path string, info os.FileInfo) error {
+			queries, err := (*awsCli)(nil).readInput(path, templates)
+			if err != nil {
+				return err
+			}
+			return lintFile(path, queries)
+		})
+		if err != nil {
+			return errors.Wrap(err, "could not lint")
+		}
+	}
+
+	if count > 0 {
+		return fmt.Errorf("%d problem(s) found", count)
+	}
+	return nil
+}
+
+func lintQuery(ctx context.Context, index int, query string, delims delimsFlag, keys *partitionKeys) ([]lintFinding, error) {
+	toks := tokenizeDelims(query, delims)
+	var findings []lintFinding
+	add := func(rule, format string, args ...interface{}) {
+		findings = append(findings, lintFinding{query: index, rule: rule, message: fmt.Sprintf(format, args...)})
+	}
+
+	if selectStar(toks) {
+		add("select-star", "SELECT * reads every column, list the columns you need")
+	}
+	for _, ref := range sourceTables(toks) {
+		if ref.implicit {
+			add("implicit-join", "%s is joined with a comma, use an explicit JOIN ... ON", ref.name())
+		}
+	}
+	if orderWithoutLimit(toks) {
+		add("order-without-limit", "ORDER BY without LIMIT sorts the whole result on a single node")
+	}
+	if keys != nil {
+		missing, err := keys.missingPartitionFilters(ctx, toks)
+		if err != nil {
+			return nil, err
+		}
+		for _, table := range missing {
+			add("partition-filter", "%s is partitioned but not filtered on its partition keys", table)
+		}
+	}
+	return findings, nil
+}
+
+func selectStar(toks []token) bool {
+	prev := token{}
+	for _, t := range toks {
+		if !t.significant() {
+			continue
+		}
+		if t.text == "*" && (prev.is("SELECT", "DISTINCT", "ALL") || prev.text == ".") {
+			return true
+		}
+		prev = t
+	}
+	return false
+}
+
+func orderWithoutLimit(toks []token) bool {
+	depth := 0
+	ordered := false
+	for _, t := range toks {
+		switch {
+		case t.text == "(":
+			depth++
+		case t.text == ")":
+			depth--
+		case depth > 0:
+		case t.is("ORDER"):
+			ordered = true
+		case t.is("LIMIT", "FETCH"):
+			ordered = false
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestLintQuery(t *testing.T) {
+	keys := &partitionKeys{database: "default", keys: map[string][]string{
+		"default.events": {"dt"},
+		"default.users":  nil,
+		"db.users":       nil,
+	}}
+	for _, tt := range []struct {
+		sql   string
+		rules []string
+	}{
+		{"select * from users", []string{"select-star"}},
+		{"select u.* from users u", []string{"select-star"}},
+		{"select count(*) from users", nil},
+		{"select a * 2 from users", nil},
+		{"select a.id from db.users a, db.users b where a.id = b.id", []string{"implicit-join"}},
+		{"select a.id from db.users a join db.users b on a.id = b.id", nil},
+		{"select id from db.users order by id", []string{"order-without-limit"}},
+		{"select id from db.users order by id limit 10", nil},
+		{"select id from (select id from db.users order by id) limit 10", nil},
+		{"select id from events", []string{"partition-filter"}},
+		{"select id from events where dt = '2018-03-01'", nil},
+		{"select id from db.users", nil},
+	} {
+		findings, err := lintQuery(context.Background(), 1, tt.sql, delimsFlag{}, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rules []string
+		for _, f := range findings {
+			rules = append(rules, f.rule)
+		}
+		if !reflect.DeepEqual(rules, tt.rules) {
+			t.Errorf("lintQuery(%q) = %v, want %v", tt.sql, rules, tt.rules)
+		}
+	}
+}
+
+func TestLintQueryWithoutCatalog(t *testing.T) {
+	findings, err := lintQuery(context.Background(), 3, "select id from events", delimsFlag{}, nil)
+	if err != nil || len(findings) != 0 {
+		t.Errorf("got %v, %v without partition checks", findings, err)
+	}
+	findings, _ = lintQuery(context.Background(), 3, "select * from events", delimsFlag{}, nil)
+	if len(findings) != 1 || findings[0].query != 3 || findings[0].message == "" {
+		t.Errorf("got %+v", findings)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/pkg/errors"
+)
+
+// localHistoryEntry is a statement run from this machine, stored as a line
+// of json in the local history file.
+type localHistoryEntry struct {
+	Time             time.Time `json:"time"`
+	QueryExecutionID string    `json:"query_execution_id"`
+	State            string    `json:"state"`
+	Region           string    `json:"region,omitempty"`
+	WorkGroup        string    `json:"workgroup,omitempty"`
+	Catalog          string    `json:"catalog,omitempty"`
+	Database         string    `json:"database,omitempty"`
+	Query            string    `json:"query"`
+	// Redacted is set when secrets were removed from the query.
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// localHistoryPath is $XDG_DATA_HOME/athenaq/history.jsonl, with
+// ~/.local/share as the default data directory.
+func localHistoryPath() (string, error) {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.Wrap(err, "could not find the data directory")
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "athenaq", "history.jsonl"), nil
+}
+
+// localHistory is a watcher appending every finished query to the local
+// history. Failing to record a query doesn't fail it.
+type localHistory struct {
+	path      string
+	region    string
+	workGroup string
+	catalog   string
+}
+
+func newLocalHistory(awsCli *awsCli) *localHistory {
+	path, err := localHistoryPath()
+	if err != nil {
+		debugf("%v", err)
+	}
+	return &localHistory{
+		path:      path,
+		region:    aws.StringValue(awsCli.session.Config.Region),
+		workGroup: awsCli.workGroup,
+		catalog:   awsCli.catalog,
+	}
+}
+
+func (h *localHistory) start(index int, query string) {}
+
+func (h *localHistory) update(index int, queryExecution *athena.QueryExecution) {}
+
+func (h *localHistory) done(index int, queryExecution *athena.QueryExecution, err error) {
+	if h.path == "" || queryExecution == nil {
+		return
+	}
+	query := aws.StringValue(queryExecution.Query)
+	e := &localHistoryEntry{
+		Time:             time.Now(),
+		QueryExecutionID: aws.StringValue(queryExecution.QueryExecutionId),
+		Region:           h.region,
+		WorkGroup:        h.workGroup,
+		Catalog:          h.catalog,
+		Query:            secrets.redact(query),
+	}
+	e.Redacted = e.Query != query
+	if s := queryExecution.Status; s != nil {
+		e.State = aws.StringValue(s.State)
+	}
+	if c := queryExecution.QueryExecutionContext; c != nil {
+		e.Database = aws.StringValue(c.Database)
+	}
+	if err := appendLocalHistory(h.path, e); err != nil {
+		debugf("could not record query %d in the local history: %v", index+1, err)
+	}
+}
+
+func appendLocalHistory(path string, e *localHistoryEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readLocalHistory returns the entries of the local history, oldest first.
+// Their number is their position, starting at 1.
+func readLocalHistory(path string) ([]*localHistoryEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []*localHistoryEntry
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 16<<20)
+	for n := 1; s.Scan(); n++ {
+		e := &localHistoryEntry{}
+		if err := json.Unmarshal(s.Bytes(), e); err != nil {
+			return nil, errors.Wrapf(err, "invalid entry %d in %s", n, path)
+		}
+		entries = append(entries, e)
+	}
+	return entries, s.Err()
+}
+
+func historyCmd(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: athenaq history [flags] local")
+		fs.PrintDefaults()
+	}
+	var (
+		last   = fs.Int("n", 20, "show the last n statements (0 == all)")
+		format = &choiceFlag{value: "table", choices: []string{"table", "json"}}
+	)
+	fs.Var(format, "format", "output format (table|json)")
+	addLogFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 || fs.Arg(0) != "local" || *last < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	path, err := localHistoryPath()
+	if err != nil {
+		return err
+	}
+	entries, err := readLocalHistory(path)
+	if err != nil {
+		return errors.Wrap(err, "could not read the local history")
+	}
+	first := 0
+	if *last > 0 && len(entries) > *last {
+		first = len(entries) - *last
+	}
+	if format.value == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range entries[first:] {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tTIME\tSTATE\tQUERY EXECUTION ID\tQUERY")
+	for i, e := range entries[first:] {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", first+i+1, e.Time.Local().Format("2006-01-02 15:04:05"), e.State, e.QueryExecutionID,
+			abbreviate(strings.Join(strings.Fields(e.Query), " "), 80))
+	}
+	return w.Flush()
+}
+
+func rerunCmd(args []string) error {
+	fs := flag.NewFlagSet("rerun", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: athenaq rerun [flags] <n>")
+		fmt.Fprintln(os.Stderr, "runs statement n of 'athenaq history local' again, in its region, workgroup, catalog and database unless flags override them")
+		fs.PrintDefaults()
+	}
+	var (
+		awsFlags = addAWSFlags(fs)
+		dry      = fs.Bool("dry", false, "print the statement instead of running it")
+	)
+	fs.Parse(args)
+	n, err := strconv.Atoi(fs.Arg(0))
+	if fs.NArg() != 1 || err != nil || n < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	path, err := localHistoryPath()
+	if err != nil {
+		return err
+	}
+	entries, err := readLocalHistory(path)
+	if err != nil {
+		return errors.Wrap(err, "could not read the local history")
+	}
+	if n > len(entries) {
+		return fmt.Errorf("the local history has %d statements, not %d", len(entries), n)
+	}
+	e := entries[n-1]
+	if e.Redacted {
+		return fmt.Errorf("statement %d had secrets, which are not stored in the local history", n)
+	}
+	if *dry {
+		fmt.Println("execute query:", e.Query)
+		return nil
+	}
+	for flagValue, value := range map[*string]string{awsFlags.region: e.Region, awsFlags.workGroup: e.WorkGroup, awsFlags.catalog: e.Catalog} {
+		if *flagValue == "" {
+			*flagValue = value
+		}
+	}
+	awsCli, err := newAWS(awsFlags)
+	if err != nil {
+		return errors.Wrap(err, "could not initialize aws client")
+	}
+	awsCli.watch(newLocalHistory(awsCli))
+
+	ctx, cancel := context.WithTimeout(context.Background(), *awsFlags.timeout)
+	defer cancel()
+	if e.Database != "" {
+		ctx = withDatabase(ctx, e.Database)
+	}
+	_, err = awsCli.execQuery(ctx, e.Query, os.Stdout)
+	return err
+}
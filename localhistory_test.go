@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+func TestLocalHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("XDG_DATA_HOME", os.Getenv("XDG_DATA_HOME"))
+	os.Setenv("XDG_DATA_HOME", dir)
+	defer func() { secrets = &redactor{} }()
+	secrets.add("hunter2")
+
+	path, err := localHistoryPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "athenaq", "history.jsonl"); path != want {
+		t.Fatalf("path %q, want %q", path, want)
+	}
+	h := &localHistory{path: path, region: "eu-west-1", workGroup: "etl"}
+	for i, query := range []string{"SELECT 1", "SELECT 'hunter2'"} {
+		h.done(i, &athena.QueryExecution{
+			QueryExecutionId:      aws.String(string('a' + rune(i))),
+			Query:                 aws.String(query),
+			QueryExecutionContext: &athena.QueryExecutionContext{Database: aws.String("analytics")},
+			Status:                &athena.QueryExecutionStatus{State: aws.String("SUCCEEDED")},
+		}, nil)
+	}
+
+	entries, err := readLocalHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	first := entries[0]
+	if first.QueryExecutionID != "a" || first.Query != "SELECT 1" || first.State != "SUCCEEDED" ||
+		first.Region != "eu-west-1" || first.WorkGroup != "etl" || first.Database != "analytics" || first.Redacted {
+		t.Errorf("first entry %+v", first)
+	}
+	if second := entries[1]; second.Query != "SELECT '[REDACTED]'" || !second.Redacted {
+		t.Errorf("second entry %+v", second)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("history file %v, %v", info, err)
+	}
+}
+
+func TestReadLocalHistoryMissing(t *testing.T) {
+	entries, err := readLocalHistory(filepath.Join(os.TempDir(), "athenaq-no-such-history.jsonl"))
+	if err != nil || entries != nil {
+		t.Errorf("got %v, %v for a missing history", entries, err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+const (
+	levelQuiet = iota
+	levelInfo
+	levelDebug
+	levelTrace
+)
+
+// verbosity is set by the -q, -v and -vv flags.
+var verbosity = levelInfo
+
+type verbosityFlag int
+
+func (f verbosityFlag) String() string { return "" }
+
+func (f verbosityFlag) IsBoolFlag() bool { return true }
+
+func (f verbosityFlag) Set(s string) error {
+	if s == "true" {
+		verbosity = int(f)
+	}
+	return nil
+}
+
+func addLogFlags(fs *flag.FlagSet) {
+	fs.Var(verbosityFlag(levelQuiet), "q", "quiet, only print errors")
+	fs.Var(verbosityFlag(levelDebug), "v", "verbose, log query state changes")
+	fs.Var(verbosityFlag(levelTrace), "vv", "very verbose, also log every aws api call")
+}
+
+func logf(level int, format string, args ...interface{}) {
+	if verbosity >= level {
+		fmt.Fprintln(os.Stderr, secrets.redact(fmt.Sprintf(format, args...)))
+	}
+}
+
+func errorf(format string, args ...interface{}) { logf(levelQuiet, format, args...) }
+
+func infof(format string, args ...interface{}) { logf(levelInfo, format, args...) }
+
+func debugf(format string, args ...interface{}) { logf(levelDebug, format, args...) }
+
+func tracef(format string, args ...interface{}) { logf(levelTrace, format, args...) }
+
+func newSession(region string) (*session.Session, error) {
+	region, err := resolveRegion(region)
+	if err != nil {
+		return nil, err
+	}
+	cfg := aws.NewConfig().WithRegion(region)
+	if fips, dualStack := endpointVariants(); fips || dualStack {
+		cfg = cfg.WithEndpointResolver(endpointResolver(fips, dualStack))
+	}
+	client, err := awsHTTPClient(0)
+	if err != nil {
+		return nil, err
+	}
+	if client != nil {
+		// the sdk sets the roots of AWS_CA_BUNDLE on the transport itself.
+		client.Transport = client.Transport.(*http.Transport).Clone()
+		cfg = cfg.WithHTTPClient(client)
+	}
+	creds, err := rolesAnywhere.credentials()
+	if err == nil && creds == nil {
+		creds, err = roleChainCredentials(cfg)
+	}
+	if err == nil && creds == nil {
+		creds, err = ssoCredentials()
+	}
+	if err != nil {
+		return nil, err
+	}
+	awsSession, err := session.NewSessionWithOptions(session.Options{
+		Config:            *cfg.WithCredentials(creds),
+		SharedConfigState: sharedConfigState(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := useFixtures(awsSession); err != nil {
+		return nil, err
+	}
+	explainAccessDenied(awsSession)
+	if verbosity >= levelTrace {
+		awsSession.Handlers.AfterRetry.PushBack(func(r *request.Request) {
+			if r.WillRetry() {
+				tracef("aws %s.%s retry %d after %v: %v", r.ClientInfo.ServiceName, r.Operation.Name, r.RetryCount, r.RetryDelay, r.Error)
+			}
+		})
+		awsSession.Handlers.Complete.PushBack(func(r *request.Request) {
+			status := 0
+			if r.HTTPResponse != nil {
+				status = r.HTTPResponse.StatusCode
+			}
+			msg := fmt.Sprintf("aws %s.%s status=%d request_id=%s retries=%d latency=%v",
+				r.ClientInfo.ServiceName, r.Operation.Name, status, r.RequestID, r.RetryCount, time.Since(r.Time).Truncate(time.Millisecond))
+			if r.Error != nil {
+				msg += fmt.Sprintf(" error=%q", r.Error)
+			}
+			tracef("%s", msg)
+		})
+	}
+	return awsSession, nil
+}
+
+// queryLog logs the state changes of the queries of a batch.
+type queryLog struct {
+	states map[int]string
+}
+
+func (l *queryLog) start(index int, query string) {
+	if l.states == nil {
+		l.states = map[int]string{}
+	}
+	l.states[index] = ""
+	debugf("query %d: %s", index+1, query)
+}
+
+func (l *queryLog) update(index int, queryExecution *athena.QueryExecution) {
+	if state := aws.StringValue(queryExecution.Status.State); state != l.states[index] {
+		l.states[index] = state
+		debugf("query %d: %s %s", index+1, aws.StringValue(queryExecution.QueryExecutionId), state)
+	}
+}
+
+func (l *queryLog) done(index int, queryExecution *athena.QueryExecution, err error) {
+	if err == nil && queryExecution != nil && queryExecution.ResultConfiguration != nil {
+		debugf("query %d: result %s", index+1, aws.StringValue(queryExecution.ResultConfiguration.OutputLocation))
+	}
+}
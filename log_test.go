@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"testing"
+)
+
+func TestVerbosityFlags(t *testing.T) {
+	defer func(v int) { verbosity = v }(verbosity)
+	for _, tt := range []struct {
+		args []string
+		want int
+	}{
+		{nil, levelInfo},
+		{[]string{"-q"}, levelQuiet},
+		{[]string{"-v"}, levelDebug},
+		{[]string{"-vv"}, levelTrace},
+		{[]string{"-v", "-q"}, levelQuiet},
+		{[]string{"-v=false"}, levelInfo},
+	} {
+		verbosity = levelInfo
+		fs := flag.NewFlagSet("athenaq", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		addLogFlags(fs)
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		if verbosity != tt.want {
+			t.Errorf("%v: verbosity = %d, want %d", tt.args, verbosity, tt.want)
+		}
+	}
+}
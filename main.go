@@ -7,61 +7,590 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net/url"
 	"os"
 	"path"
+	"sort"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
+	"github.com/advincze/athenaq/client"
 	"github.com/advincze/s3path"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/athena"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/pkg/errors"
 )
 
+var commands = map[string]func(args []string) error{
+	"accounts": accountsCmd,
+	"iceberg":  icebergCmd,
+	"fmt":      fmtCmd,
+	"history":  historyCmd,
+	"lineage":  lineageCmd,
+	"lint":     lintCmd,
+	"login":    loginCmd,
+	"capacity": capacityCmd,
+	"catalogs": catalogsCmd,
+	"cost":     costCmd,
+	"ddl":      ddlCmd,
+	"decrypt":  decryptCmd,
+	"models":   modelsCmd,
+	"render":   renderCmd,
+	"rerun":    rerunCmd,
+	"schema":   schemaCmd,
+	"spark":    sparkCmd,
+	"views":    viewsCmd,
+}
+
+type awsFlags struct {
+	region      *string
+	tempPath    *string
+	timeout     *time.Duration
+	workGroup   *string
+	catalog     *string
+	bucketOwner *string
+	fake        *string
+	apiRate     *float64
+	// limiter is built from apiRate for the first client and shared by the
+	// clients of the other accounts.
+	limiter client.RateLimiter
+}
+
+// rateLimiter returns the limiter of -api-rate, nil without a limit.
+func (f *awsFlags) rateLimiter() client.RateLimiter {
+	if f.limiter == nil && f.apiRate != nil && *f.apiRate > 0 {
+		f.limiter = client.NewTokenBucket(*f.apiRate)
+	}
+	return f.limiter
+}
+
+func addAWSFlags(fs *flag.FlagSet) *awsFlags {
+	addLogFlags(fs)
+	fs.StringVar(&recordDir, "record", "", "record every aws api call and its response to this directory")
+	fs.StringVar(&replayDir, "replay", "", "answer aws api calls with the responses recorded by -record in this directory, without credentials or network")
+	addRolesAnywhereFlags(fs)
+	addHTTPTransportFlags(fs)
+	addEndpointFlags(fs)
+	return &awsFlags{
+		timeout:     fs.Duration("timeout", time.Minute*60, "athena query timeout"),
+		tempPath:    fs.String("temp.path", `s3://aws-athena-query-results-{{ Account }}-{{ .Region }}/Unsaved/{{ Now.Format "2006"}}/{{ Now.Format "01" }}/{{ Now.Format "02"}}/{{ .RunID }}`, "athena result path, {{ .RunID }} keeps the results of concurrent runs apart"),
+		region:      fs.String("region", "", regionFlagUsage),
+		workGroup:   fs.String("workgroup", "", `athena workgroup ("" == primary)`),
+		catalog:     fs.String("catalog", "", `athena data catalog, e.g. a federated lambda connector ("" == AwsDataCatalog)`),
+		bucketOwner: fs.String("expected-bucket-owner", "", "account id that must own every s3 bucket results are read from or written to"),
+		fake:        fs.String("fake", "", "run against an in-memory athena, s3 and sts answering the <name>.sql queries in this directory with <name>.csv"),
+		apiRate:     fs.Float64("api-rate", 0, "make at most this many athena api calls per second, shared by all clients of the process, e.g. of several accounts (0 == no limit)"),
+	}
+}
+
+// choiceFlag is a flag taking one of a fixed set of values.
+type choiceFlag struct {
+	value   string
+	choices []string
+}
+
+func (f *choiceFlag) String() string { return f.value }
+
+func (f *choiceFlag) Set(s string) error {
+	for _, c := range f.choices {
+		if s == c {
+			f.value = s
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid value %q, expected one of %s", s, strings.Join(f.choices, ", "))
+}
+
+// tagsFlag collects repeated key=value flags.
+type tagsFlag map[string]string
+
+func (f tagsFlag) String() string {
+	var pairs []string
+	for k, v := range f {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f tagsFlag) Set(s string) error {
+	pair := strings.SplitN(s, "=", 2)
+	if len(pair) != 2 || pair[0] == "" {
+		return fmt.Errorf("invalid value %q, expected key=value", s)
+	}
+	f[pair[0]] = pair[1]
+	return nil
+}
+
+// queriesFlag collects the queries given on the command line.
+type queriesFlag []string
+
+func (f *queriesFlag) String() string { return strings.Join(*f, ";\n") }
+
+func (f *queriesFlag) Set(s string) error {
+	if strings.TrimSpace(s) == "" {
+		return errors.New("empty query")
+	}
+	*f = append(*f, s)
+	return nil
+}
+
+// read splits and renders the queries like the statements of an input file.
+func (f queriesFlag) read(t *templater) ([]string, error) {
+	return readQueries(strings.NewReader(strings.Join(f, ";\n")), t)
+}
+
 func main() {
+	// "athenaq exec [flags]" is the same as "athenaq [flags]".
+	if len(os.Args) > 1 && os.Args[1] == "exec" {
+		os.Args = append(os.Args[:1:1], os.Args[2:]...)
+	}
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[1], secrets.redact(err.Error()))
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, secrets.redact(err.Error()))
+		class := classifyError(err)
+		debugf("%s error, exit status %d", class, exitStatus[class])
+		os.Exit(exitStatus[class])
+	}
+}
+
+func run() (err error) {
 	var (
-		timeout              = flag.Duration("timeout", time.Minute*60, "athena query timeout")
-		athenaS3PathTemplate = flag.String("temp.path", `s3://aws-athena-query-results-{{ Account }}-{{ .Region }}/Unsaved/{{ Now.Format "2006"}}/{{ Now.Format "01" }}/{{ Now.Format "02"}}`, "athena result bucket")
-		awsRegion            = flag.String("region", "eu-central-1", "aws region")
-		output               = flag.String("out", "", `output path ("-" == no output| "" == STDOUT | file://... | s3://...)`)
-		inputFile            = flag.String("f", "", `input file (""== STDIN)`)
-		dry                  = flag.Bool("dry", false, "dry run")
+		awsFlags     = addAWSFlags(flag.CommandLine)
+		output       = flag.String("out", "", `output path ("-" == no output| "" == STDOUT | file://... | s3://... | https://... | gs://... | azblob://... | sftp://... | ses://...)`)
+		httpOut      = addHTTPFlags(flag.CommandLine)
+		sshKey       = flag.String("out.ssh-key", "", `private key file of sftp://user@host/path outputs ("" == the keys of the ssh agent and config)`)
+		outDir       = flag.String("out-dir", "", "write the result of each query to <out-dir>/<name>.csv, named after a '-- name: <name>' line in the query or the input file (file://... | s3://... | gs://... | azblob://... | sftp://... | ses://...)")
+		inputFile    = flag.String("f", "", `input file (""== STDIN | file://... | s3://... | https://... | git+https://repo.git//path?ref=...)`)
+		inline       = queriesFlag{}
+		namedQuery   = flag.String("named-query", "", "run the query saved in athena with this name in the workgroup, or id, in its database")
+		dry          = flag.Bool("dry", false, "dry run")
+		autoRepair   = flag.Bool("auto-repair", false, "run MSCK REPAIR TABLE on the tables of a query failing on stale partitions (HIVE_CURSOR_ERROR, ...) and retry it once")
+		dropData     = flag.Bool("drop-data", false, "delete the s3 data of CREATE TABLE AS and iceberg tables created by the run when the run drops them")
+		engine       = flag.String("engine-version", "", `refuse to run unless the workgroup is pinned to this athena engine version (e.g. "3")`)
+		reservation  = flag.String("capacity-reservation", "", "run queries on this capacity reservation through the workgroup assigned to it")
+		asOf         = flag.String("as-of", "", "iceberg time travel: snapshot id, timestamp or duration ago (e.g. 24h)")
+		asOfTables   = flag.String("as-of.tables", "", `comma separated tables (table or db.table) to time travel ("" == the iceberg tables read)`)
+		parallel     = flag.Int("parallel", 1, "queries run at the same time, fewer while athena rejects queries with TooManyRequestsException")
+		limit        = flag.Int("limit", 0, "append a LIMIT to top level SELECT queries without one (0 == no limit)")
+		head         = flag.Int("head", 0, "write only the header and the first N rows of each result, downloading no more than needed (0 == all rows)")
+		tail         = flag.Int("tail", 0, "write only the header and the last N rows of each result (0 == all rows)")
+		columns      = flag.String("columns", "", "comma separated columns to write, in this order, instead of all columns of the results")
+		distinct     = flag.Bool("distinct", false, "drop duplicate rows from results, keeping the first")
+		dedupeKeys   = flag.String("dedupe-key", "", "comma separated columns, drop rows of results whose values in these columns were seen before")
+		maxOutRows   = flag.Int("max-output-rows", 0, "limit the rows written of each result, see -max-output (0 == no limit)")
+		maxOutBytes  = flag.Int64("max-output-bytes", 0, "limit the bytes written of each result, see -max-output (0 == no limit)")
+		maxOutput    = &choiceFlag{value: "truncate", choices: []string{"truncate", "fail"}}
+		outFormat    = &choiceFlag{value: "csv", choices: outputFormats}
+		sortBy       = flag.String("sort", "", `sort results by "col1 asc,col2 desc" before writing them, in temp files if they don't fit in memory`)
+		failOnEmpty  = flag.Bool("fail-on-empty", false, "fail the run if a SELECT query returns no rows")
+		expectRows   = &rowRange{}
+		partition    = &choiceFlag{choices: []string{"fail", "warn"}}
+		allow        = classesFlag{}
+		policyPath   = flag.String("policy", "", "refuse to run statements that violate the json policy in this file (file://... | s3://...)")
+		explain      = &choiceFlag{choices: []string{"plan", "analyze"}}
+		planFmt      = flag.String("explain.format", "text", "explain output format (text|json)")
+		tui          = flag.Bool("tui", false, "show a dashboard of the batch, cancel queries with 'c', quit with 'q'")
+		checkWrite   = flag.Bool("check-write", false, "write and delete a canary object in the athena temp path and the output location before running the queries, to fail fast without permission to write them")
+		heartbeat    = flag.Duration("heartbeat", 0, "print a line about the running queries to STDERR this often, for CI runners that kill jobs without output (0 == never)")
+		showProgress = flag.Bool("progress", isTerminal(os.Stderr), "show a status line on STDERR while queries run")
+		paged        = flag.Bool("pager", false, "browse the results in a pager with horizontal scrolling when STDOUT is a terminal")
+		localHist    = flag.Bool("history", true, "record the queries in the local history, see athenaq history local")
+		eventsLog    = flag.String("events-log", "", "append a json line for every state change of a query to this file, e.g. to tail it (file://...)")
+		auditPath    = flag.String("audit", "", "record every executed query (s3://bucket/prefix | file://dir | dynamodb://table)")
+		checksum     = &choiceFlag{choices: []string{"sidecar", "header"}}
+		cacheDir     = flag.String("cache-dir", "", "store results of SELECT queries in this directory and reuse them for identical queries within -cache-ttl")
+		cacheTTL     = flag.Duration("cache-ttl", time.Hour, "maximum age of cached results")
+		dedup        = &choiceFlag{value: "warn", choices: []string{"warn", "skip", "reuse"}}
+		encryptKey   = flag.String("encrypt.kms-key", "", "envelope encrypt the output with a data key of this kms key, the encrypted data key is written to <out>.key.json")
+		tags         = tagsFlag{}
+		templates    = addTemplateFlags(flag.CommandLine)
+		batchHooks   = addHookFlags(flag.CommandLine)
+		reportPath   = flag.String("report", "", "write a JSON summary of the run to this path (file://... | s3://...)")
+		withMeta     = flag.Bool("meta", false, "write sql, execution ids, statistics and result schema to <out>.meta.json")
+		withMetadata = flag.Bool("with-metadata", false, "also write the .metadata object athena writes next to csv results, with the column types some loaders read, to <out>.metadata or <out-dir>/<name>.csv.metadata")
+		withStats    = flag.Bool("stats", false, "print runtime statistics of each query to STDERR and write them to <out>.stats.json")
+		warnScanned  = flag.Int64("warn-scanned-bytes", 0, "warn when a single query scans more bytes, also when it succeeds (0 == never)")
+		warnDuration = flag.Duration("warn-duration", 0, "warn when a single query takes longer from submission to completion, also when it succeeds (0 == never)")
+		maxQueueTime = flag.Duration("max-queue-time", 0, "cancel queries still QUEUED this long after submission, see -max-queue-retries (0 == no limit)")
+		queueRetries = flag.Int("max-queue-retries", 0, "submit a query cancelled by -max-queue-time again up to this many times")
+		fetch        = &choiceFlag{value: "s3", choices: []string{"s3", "api"}}
+		waitFor      = &choiceFlag{value: "poll", choices: []string{"poll", "events", "s3"}}
+		waitQueue    = flag.String("wait.queue-url", "", `sqs queue an EventBridge rule sends the "Athena Query State Change" events of the workgroup to (-wait events), or the result bucket its event notifications (-wait s3)`)
+		waitPoll     = flag.Duration("wait.interval", 30*time.Second, "with -wait events, still poll the state of running queries this often in case events are late or lost, with -wait s3, the longest backoff between HeadObject calls")
+		timeouts     = timeoutsFlag{}
 	)
+	flag.Var(timeouts, "statement-timeout", `comma separated timeouts by statement type, e.g. select=10m,ddl=1m,ctas=2h, cancel queries still running this long after submission, a '-- timeout: <duration>' line in a query overrides it`)
+	flag.Var(partition, "require-partition-filter", `refuse ("fail") or warn ("warn") when a partitioned table is queried without a partition predicate`)
+	flag.Var(allow, "allow", `comma separated statement types to allow (ddl,dml,select), other statements fail the run before any query is submitted ("" == all)`)
+	flag.Var(dedup, "dedup", `on identical queries in a batch: run them anyway ("warn"), run only the first ("skip") or run only the first and repeat its result ("reuse")`)
+	flag.Var(checksum, "checksum", `write the sha256 of the output to <out>.sha256 ("sidecar") or have s3 verify it on upload ("header")`)
+	flag.Var(&inline, "query", "run this query instead of the queries of -f or STDIN, split at ; and rendered like them (repeatable)")
+	flag.Var(tags, "tag", "key=value tag for s3 outputs, audit records and the report (repeatable)")
+	flag.Var(outFormat, "output-format", `write results as csv as athena returns them ("csv") or as json lines, an object per row ("json")`)
+	flag.Var(maxOutput, "max-output", `when a result exceeds -max-output-rows or -max-output-bytes: end it with a marker row ("truncate") or fail the run ("fail")`)
+	flag.Var(expectRows, "expect-rows", `fail the run unless every SELECT query returns N or N..M rows, e.g. "1.." or "0..100"`)
+	flag.Var(fetch, "fetch", `download results from the csv athena writes to s3 ("s3") or with GetQueryResults ("api"), falling back to the other when access is denied`)
+	flag.Var(waitFor, "wait", `wait for queries to finish by polling their state ("poll"), by receiving EventBridge events from -wait.queue-url ("events") or by waiting for their result object in s3 without athena:GetQueryExecution ("s3")`)
+	flag.Var(explain, "explain", `show the query plan ("plan") or execute and show the plan with stage statistics ("analyze") instead of the results`)
+	addCatalogCacheFlag(flag.CommandLine)
 	flag.Parse()
+	if flag.NArg() > 0 {
+		return usagef("unexpected arguments %q", flag.Args())
+	}
+	if *parallel < 1 {
+		return usagef("-parallel must be at least 1")
+	}
+	if *head < 0 || *tail < 0 || *head > 0 && *tail > 0 {
+		return usagef("-head and -tail must not be negative and are mutually exclusive")
+	}
+	if waitFor.value == "events" && *waitQueue == "" {
+		return usagef("-wait events requires -wait.queue-url")
+	}
+	if *waitPoll <= 0 {
+		return usagef("-wait.interval must be positive")
+	}
+	columnNames := columnList(*columns)
+	dedupeKey := columnList(*dedupeKeys)
+	var sortKeys []sortKey
+	if *sortBy != "" {
+		if sortKeys, err = parseSortKeys(*sortBy); err != nil {
+			return usageError{err}
+		}
+	}
+	if len(inline) > 0 && *inputFile != "" {
+		return usagef("-query and -f are mutually exclusive")
+	}
+	if *namedQuery != "" && (len(inline) > 0 || *inputFile != "") {
+		return usagef("-named-query, -query and -f are mutually exclusive")
+	}
+	if *outDir != "" && *output != "" {
+		return usagef("-out and -out-dir are mutually exclusive")
+	}
+	if *withMetadata && (*output == "" || *output == "-") && *outDir == "" {
+		return usagef("-with-metadata needs -out file://... or s3://... or -out-dir")
+	}
+	if *encryptKey != "" && (*output == "" || *output == "-") && *outDir == "" {
+		return usagef("-encrypt.kms-key needs -out file://... or s3://... or -out-dir")
+	}
+	if *encryptKey != "" && *cacheDir != "" {
+		// the cache would keep a plaintext copy of the encrypted results.
+		return usagef("-encrypt.kms-key and -cache-dir are mutually exclusive")
+	}
+	if err := httpOut.validate(); err != nil {
+		return usageError{err}
+	}
 
-	awsCli, err := newAWS(*awsRegion, *athenaS3PathTemplate)
+	var awsCli *awsCli
+	if *dry {
+		// a dry run makes no aws calls, not even to look up the region in the
+		// instance metadata.
+		awsCli, err = newAWSFromSession(session.New(aws.NewConfig().WithRegion(*awsFlags.region)), awsFlags)
+	} else {
+		awsCli, err = newAWS(awsFlags)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "could not initialize aws client: %v", err)
-		os.Exit(1)
+		return errors.Wrap(err, "could not initialize aws client")
+	}
+
+	templates.builtins = builtinValues(awsCli.runID, *inputFile)
+	for _, path := range []*string{output, outDir, reportPath, auditPath, eventsLog} {
+		if *path, err = awsCli.renderPath(*path, templates.builtins); err != nil {
+			return errors.Wrap(err, "could not render output path")
+		}
+	}
+
+	awsCli.tags = tags
+	awsCli.throttle = newThrottle(*parallel)
+	awsCli.http = httpOut
+	awsCli.sshKey = *sshKey
+	awsCli.putChecksums = checksum.value == "header"
+	awsCli.maxQueueTime, awsCli.queueRetries = *maxQueueTime, *queueRetries
+	awsCli.fetch = fetch.value
+	awsCli.wait, awsCli.waitInterval = waitFor.value, *waitPoll
+	switch {
+	case *dry:
+	case waitFor.value == "events":
+		awsCli.poller.listenEvents(sqs.New(awsCli.session), *waitQueue, *waitPoll)
+	case waitFor.value == "s3" && *waitQueue != "":
+		awsCli.resultEvents = listenResultEvents(sqs.New(awsCli.session), *waitQueue)
+	}
+	if *dropData {
+		awsCli.created = newCreatedTables()
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), *awsFlags.timeout)
 	defer cancel()
+	var (
+		pg   *pager
+		dash *dashboard
+	)
+	defer cancelOnSignal(cancel, func() {
+		pg.restore()
+		dash.close()
+	})()
 
-	var input io.Reader
-	switch *inputFile {
-	case "":
-		input = os.Stdin
-	default:
-		f, err := os.Open(*inputFile)
+	if !*dry {
+		err = awsCli.selectWorkGroup(ctx, *reservation, *engine)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "could open input file: %v", err)
-			os.Exit(1)
+			return err
 		}
-		defer f.Close()
-		input = f
 	}
 
-	queries, err := readQueries(input)
+	var queries []string
+	switch {
+	case len(inline) > 0:
+		queries, err = inline.read(templates)
+	case *namedQuery != "":
+		queries, err = awsCli.readNamedQuery(ctx, *namedQuery, templates)
+	default:
+		queries, err = awsCli.readInput(*inputFile, templates)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "could not read queries: %v", err)
-		os.Exit(1)
+		return errors.Wrap(err, "could not read queries")
+	}
+	if err := batchHooks.render(templates); err != nil {
+		return err
+	}
+	var setup, teardown []string
+	var transactions []int
+	setup, queries, teardown, transactions, err = sections(queries)
+	if err != nil {
+		return err
+	}
+	defaults := querySettings{limit: *limit, head: *head, tail: *tail, columns: columnNames, maxOutRows: *maxOutRows, outputFormat: outFormat.value}
+	var settings []querySettings
+	queries, transactions, settings, err = applySettings(queries, transactions, defaults)
+	if err != nil {
+		return err
+	}
+	settingKeys := settingsKeys(settings, defaults)
+	if *parallel > 1 && hasTransactions(transactions) {
+		return errors.New("-- transaction groups can't run with -parallel")
+	}
+
+	if len(allow) > 0 {
+		if err := checkAllowed(queries, allow); err != nil {
+			return err
+		}
+		if err := checkAllowed(setup, allow); err != nil {
+			return errors.Wrap(err, "setup")
+		}
+		if err := checkAllowed(teardown, allow); err != nil {
+			return errors.Wrap(err, "teardown")
+		}
+	}
+
+	var pol *policy
+	if *policyPath != "" {
+		pol, err = awsCli.loadPolicy(ctx, *policyPath)
+		if err != nil {
+			return errors.Wrap(err, "could not load policy")
+		}
+		violations := pol.check(queries)
+		for _, v := range pol.check(setup) {
+			violations = append(violations, "setup: "+v)
+		}
+		for _, v := range pol.check(teardown) {
+			violations = append(violations, "teardown: "+v)
+		}
+		if len(violations) > 0 {
+			for _, v := range violations {
+				errorf("%s", v)
+			}
+			return errors.New("refusing to run queries that violate the policy")
+		}
+	}
+
+	priorities := make([]int, len(queries))
+	limits := make([]time.Duration, len(queries))
+	for i, query := range queries {
+		if priorities[i], err = queryPriority(query); err != nil {
+			return errors.Wrapf(err, "query %d", i+1)
+		}
+		if limits[i], err = queryTimeout(query, timeouts); err != nil {
+			return errors.Wrapf(err, "query %d", i+1)
+		}
+	}
+
+	var names []string
+	if *outDir != "" {
+		input := *inputFile
+		if *namedQuery != "" {
+			input = *namedQuery
+		}
+		names, err = outputNames(queries, input)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *asOf != "" {
+		clause, err := asOfClause(*asOf, time.Now())
+		if err != nil {
+			return err
+		}
+		// without -as-of.tables, only the iceberg tables time travel: athena
+		// rejects the clause for views and other tables.
+		iceberg := &icebergTables{glue: awsCli.glue, catalog: awsCli.catalog, known: map[string]bool{}}
+		for i, query := range queries {
+			travels := func(ref tableRef) bool { return matchesTable(ref, strings.Split(*asOfTables, ",")) }
+			if *asOfTables == "" {
+				if travels, err = iceberg.of(queryContext(ctx, settings, i), query); err != nil {
+					return err
+				}
+			}
+			queries[i] = timeTravel(query, clause, travels)
+		}
+	}
+
+	for i, query := range queries {
+		if settings[i].limit > 0 {
+			queries[i] = injectLimit(query, settings[i].limit)
+		}
+	}
+
+	if explain.value != "" {
+		for i, query := range queries {
+			queries[i] = explainQuery(query, explain.value, *planFmt)
+		}
+	}
+
+	dups := duplicates(queries, settingKeys)
+	reused := map[int][]byte{}
+	for i, first := range dups {
+		if first >= 0 {
+			infof("%s is identical to %s", queryLabel(i, queries[i]), queryLabel(first, queries[first]))
+			reused[first] = nil
+		}
+	}
+	skipDup := func(i int) bool { return dups[i] >= 0 && dedup.value != "warn" }
+
+	if partition.value != "" && !*dry {
+		err = awsCli.checkPartitionFilters(ctx, queries, settings, partition.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	runID := awsCli.runID
+
+	if *localHist && !*dry {
+		awsCli.watch(newLocalHistory(awsCli))
+	}
+	if *eventsLog != "" && !*dry {
+		events, err := newEventLog(*eventsLog, runID, queries)
+		if err != nil {
+			return err
+		}
+		defer events.close()
+		awsCli.watch(events)
+	}
+	if *heartbeat > 0 && !*dry {
+		h := newHeartbeat(os.Stderr, queries, *heartbeat)
+		defer h.close()
+		awsCli.watch(h)
+	}
+	if batchHooks.hasWebhooks() && !*dry {
+		if err := batchHooks.payload.load(ctx, awsCli); err != nil {
+			return err
+		}
+		batchHooks.payload.run = newRunReport(runID, tags, queries)
+		awsCli.watch(batchHooks.payload.run)
+		awsCli.webhook = &batchHooks.payload
+	}
+
+	// the report is written last so that it reflects the outcome of writing
+	// the output and its sidecars.
+	var report *runReport
+	if *reportPath != "" && !*dry {
+		report = newRunReport(runID, tags, queries)
+		awsCli.watch(report)
+		defer func() {
+			werr := report.write(awsCli, *reportPath, err)
+			if err == nil && werr != nil {
+				err = errors.Wrap(werr, "could not write report")
+			}
+		}()
+	}
+
+	// the after-batch hooks run once the output and its sidecars are
+	// written, and before the report.
+	batchStarted := false
+	defer func() {
+		if !batchStarted {
+			return
+		}
+		herr := awsCli.runHooks(ctx, batchHooks.afterBatch, hookEnv("after-batch", runID, -1, "", nil, err))
+		if err == nil {
+			err = herr
+		}
+	}()
+
+	// sidecars are written after the output they describe, and not at all if
+	// no query returned a result.
+	noResult := false
+	// the .metadata objects of the queries of -out, numbered if there are
+	// several.
+	var resultMetadata []*queryResultMetadata
+	if *withMetadata && *outDir == "" {
+		defer func() {
+			if err != nil || noResult {
+				return
+			}
+			for _, m := range resultMetadata {
+				path := *output + ".metadata"
+				if name := queryName(queries[m.index]); len(resultMetadata) > 1 && name != "" {
+					path = fmt.Sprintf("%s.%s.metadata", *output, name)
+				} else if len(resultMetadata) > 1 {
+					path = fmt.Sprintf("%s.%d.metadata", *output, m.index+1)
+				}
+				if werr := awsCli.writeOut(bytes.NewReader(m.data), path); werr != nil {
+					err = errors.Wrap(werr, "could not write result metadata")
+					return
+				}
+			}
+		}()
+	}
+	writeMeta := *withMeta && *output != "" && *output != "-"
+	meta := &outputMeta{Output: *output, Queries: []*queryMeta{}}
+	if writeMeta {
+		defer func() {
+			if err != nil || noResult {
+				return
+			}
+			meta.Completed = time.Now()
+			if werr := awsCli.writeJSON(meta, *output+".meta.json"); werr != nil {
+				err = errors.Wrap(werr, "could not write metadata")
+			}
+		}()
+	}
+
+	var stats []*queryStats
+	if *withStats && *output != "" && *output != "-" {
+		defer func() {
+			if err != nil || noResult {
+				return
+			}
+			if werr := awsCli.writeJSON(stats, *output+".stats.json"); werr != nil {
+				err = errors.Wrap(werr, "could not write stats")
+			}
+		}()
 	}
 
 	var out io.Writer
@@ -73,38 +602,431 @@ func main() {
 	default:
 		var buf bytes.Buffer
 		defer func() {
-			err := awsCli.writeOut(bytes.NewReader(buf.Bytes()), *output)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "could write result: %v", err)
-				os.Exit(1)
+				return
+			}
+			if buf.Len() == 0 && !*dry {
+				infof("no query returned a result, not writing %s", *output)
+				noResult = true
+				return
+			}
+			meta.SHA256, err = awsCli.writeResult(ctx, buf.Bytes(), *output, *encryptKey, checksum.value == "sidecar")
+			if err == nil {
+				report.wroteAll(*output)
 			}
 		}()
 		out = &buf
 	}
 
-	for _, query := range queries {
-		if *dry {
-			fmt.Println("execute query:", query)
+	var audit *auditLog
+	if *auditPath != "" && !*dry {
+		audit, err = awsCli.newAuditLog(*auditPath, runID)
+		if err != nil {
+			return errors.Wrap(err, "could not initialize audit log")
+		}
+	}
+
+	if *paged && out == os.Stdout && isTerminal(os.Stdout) && !*dry && !*tui {
+		if pg, err = newPager(); err != nil {
+			return errors.Wrap(err, "could not start pager")
+		}
+		defer pg.close()
+		out = pg
+	}
+
+	switch {
+	case *dry:
+	case *tui:
+		dash, err = newDashboard(awsCli.athena, queries)
+		if err != nil {
+			return errors.Wrap(err, "could not start tui")
+		}
+		awsCli.watch(dash)
+		if out == os.Stdout {
+			var buf bytes.Buffer
+			defer func() {
+				io.Copy(os.Stdout, &buf)
+			}()
+			out = &buf
+		}
+		defer dash.close()
+	case verbosity >= levelDebug:
+		awsCli.watch(&queryLog{})
+	case *showProgress && verbosity >= levelInfo && pg == nil:
+		awsCli.watch(newProgress(os.Stderr, queries))
+	}
+
+	if *dry {
+		for _, h := range batchHooks.beforeBatch {
+			fmt.Println("run hook:", secrets.redact(h.String()))
+		}
+		for _, query := range setup {
+			fmt.Println("execute setup:", secrets.redact(query))
+		}
+		for _, query := range queries {
+			fmt.Println("execute query:", secrets.redact(query))
+		}
+		for _, query := range teardown {
+			fmt.Println("execute teardown:", secrets.redact(query))
+		}
+		for _, h := range batchHooks.afterBatch {
+			fmt.Println("run hook:", secrets.redact(h.String()))
+		}
+		return nil
+	}
+
+	if *checkWrite {
+		if err := awsCli.checkWritable(ctx, *output, *outDir); err != nil {
+			return err
+		}
+	}
+
+	batchStarted = true
+	if err := awsCli.runHooks(ctx, batchHooks.beforeBatch, hookEnv("before-batch", runID, -1, "", nil, nil)); err != nil {
+		return err
+	}
+
+	// the teardown runs once the setup started, also when the batch failed
+	// or ran out of time.
+	if len(setup) > 0 || len(teardown) > 0 {
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), *awsFlags.timeout)
+			defer cancel()
+			if terr := awsCli.runSection(ctx, "teardown", teardown); terr != nil {
+				if err != nil {
+					errorf("%v", terr)
+					return
+				}
+				err = terr
+			}
+		}()
+		if err := awsCli.runSection(ctx, "setup", setup); err != nil {
+			return err
+		}
+	}
+
+	var cache *resultCache
+	cached := map[int][]byte{}
+	if *cacheDir != "" && (out != nil || *outDir != "") {
+		// results are cached as written, after -distinct, -sort, -head, -tail,
+		// -columns, -max-output and -output-format.
+		scope := aws.StringValue(awsCli.session.Config.Region) + "/" + awsCli.workGroup + "/" + awsCli.catalog +
+			fmt.Sprintf("/head=%d/tail=%d/columns=%s/sort=%s/distinct=%t/dedupe-key=%s/max-output=%d,%d,%s/output-format=%s",
+				*head, *tail, strings.Join(columnNames, ","), *sortBy, *distinct, strings.Join(dedupeKey, ","), *maxOutRows, *maxOutBytes, maxOutput.value, outFormat.value)
+		cache = &resultCache{dir: *cacheDir, ttl: *cacheTTL, scope: scope}
+		now := time.Now()
+		for i, query := range queries {
+			if skipDup(i) || !cacheable(query) {
+				continue
+			}
+			if data, ok := cache.get(query, settingKeys[i], now); ok {
+				cached[i] = data
+			}
+		}
+	}
+
+	// the rows of SELECT results are counted as they are written.
+	rowCounts := make([]int, len(queries))
+	checkRowsOf := func(i int) bool {
+		return (*failOnEmpty || expectRows.set) && explain.value == "" && statementClass(tokenize(queries[i])) == "select"
+	}
+
+	// storedResult writes the result of query i taken from the cache or from
+	// an identical query.
+	storedResult := func(i int, data []byte) error {
+		if checkRowsOf(i) {
+			if err := checkRows(queryLabel(i, queries[i]), resultRows(data, settings[i].outputFormat), *failOnEmpty, expectRows); err != nil {
+				return err
+			}
+		}
+		if *outDir != "" {
+			if len(data) == 0 {
+				return nil
+			}
+			path := outDirPath(*outDir, names[i], resultExt(explain.value, *planFmt, settings[i].outputFormat))
+			if err := awsCli.writeQueryOutput(ctx, data, path, *encryptKey, checksum.value == "sidecar", nil, nil); err != nil {
+				return err
+			}
+			report.wrote(i, path)
+			return nil
+		}
+		if out != nil {
+			_, err := out.Write(data)
+			return errors.Wrap(err, "could not write result")
+		}
+		return nil
+	}
+
+	executeQuery := func(ctx context.Context, i int, w io.Writer) (*athena.QueryExecution, error) {
+		awsCli.startQuery(ctx, queries[i])
+		s := settings[i]
+		ctx = queryContext(ctx, settings, i)
+		// the writers between the download and w, flushed from the outermost
+		// in once the result is downloaded.
+		var flushers []func() error
+		if w != nil && s.outputFormat == "json" && explain.value == "" {
+			jw := &jsonWriter{w: w}
+			w, flushers = jw, append([]func() error{jw.flush}, flushers...)
+		}
+		var guard *outputGuard
+		if w != nil && (s.maxOutRows > 0 || *maxOutBytes > 0) && explain.value == "" {
+			guard = &outputGuard{w: w, maxRows: s.maxOutRows, maxBytes: *maxOutBytes, truncate: maxOutput.value == "truncate"}
+			w, flushers = guard, append([]func() error{guard.flush}, flushers...)
+		}
+		if w != nil && len(s.columns) > 0 && explain.value == "" {
+			cw := &columnsWriter{w: w, names: s.columns}
+			w, flushers = cw, append([]func() error{cw.flush}, flushers...)
+		}
+		if checkRowsOf(i) {
+			counter := &rowCounter{w: w}
+			defer func() { rowCounts[i] = counter.rows() }()
+			w = counter
+		}
+		if w != nil && pol != nil && pol.MaxUnlimitedResultBytes > 0 && unlimited(tokenize(queries[i])) {
+			w = &limitWriter{w: w, max: pol.MaxUnlimitedResultBytes}
+		}
+		if explain.value != "" && w != nil {
+			return awsCli.execExplain(ctx, queries[i], *planFmt, w)
+		}
+		switch {
+		case w == nil:
+		case s.head > 0:
+			hw := &headWriter{w: w, n: s.head}
+			w, flushers = hw, append([]func() error{hw.flush}, flushers...)
+			if sortKeys == nil {
+				ctx = withRangedDownload(ctx)
+			}
+		case s.tail > 0:
+			tw := newTailWriter(w, s.tail)
+			w, flushers = tw, append([]func() error{tw.flush}, flushers...)
+		}
+		if w != nil && sortKeys != nil {
+			sw := &sortWriter{w: w, keys: sortKeys}
+			defer sw.cleanup()
+			w, flushers = sw, append([]func() error{sw.flush}, flushers...)
+		}
+		if w != nil && (*distinct || len(dedupeKey) > 0) {
+			dw := &distinctWriter{w: w, columns: dedupeKey}
+			defer dw.cleanup()
+			w, flushers = dw, append([]func() error{dw.flush}, flushers...)
+		}
+		queryExecution, err := awsCli.execQuery(ctx, queries[i], w)
+		if err != nil && *autoRepair && needsRepair(queryExecution) {
+			if rerr := awsCli.repair(ctx, queries[i], queryExecution, pol); rerr != nil {
+				errorf("%s: could not repair the tables: %v", queryLabel(i, queries[i]), rerr)
+			} else {
+				infof("%s: repaired the tables, retrying", queryLabel(i, queries[i]))
+				queryExecution, err = awsCli.execQuery(ctx, queries[i], w)
+			}
+		}
+		for _, flush := range flushers {
+			if err == nil {
+				err = flush()
+			}
+		}
+		if err == nil && guard != nil && guard.truncated {
+			errorf("%s: result truncated after %d rows", queryLabel(i, queries[i]), guard.rows)
+		}
+		return queryExecution, err
+	}
+	thresholds := &queryThresholds{scannedBytes: *warnScanned, duration: *warnDuration}
+	execute := func(i int, w io.Writer) (*athena.QueryExecution, error) {
+		ctx := withQueryTimeout(withQueryIndex(ctx, i), limits[i])
+		if err := awsCli.runHooks(ctx, batchHooks.beforeQuery, hookEnv("before-query", runID, i, queryName(queries[i]), nil, nil)); err != nil {
+			return nil, err
+		}
+		queryExecution, err := executeQuery(ctx, i, w)
+		if warnings := thresholds.check(queryExecution); len(warnings) > 0 {
+			for _, warning := range warnings {
+				errorf("WARNING: %s %s", queryLabel(i, queries[i]), warning)
+			}
+			env := append(hookEnv("on-warning", runID, i, queryName(queries[i]), queryExecution, err), "ATHENAQ_WARNING="+strings.Join(warnings, "; "))
+			if herr := awsCli.runHooks(ctx, batchHooks.onWarning, env); herr != nil {
+				errorf("%s: %v", queryLabel(i, queries[i]), herr)
+			}
+		}
+		herr := awsCli.runHooks(ctx, batchHooks.afterQuery, hookEnv("after-query", runID, i, queryName(queries[i]), queryExecution, err))
+		if err == nil {
+			err = herr
+		}
+		return queryExecution, err
+	}
+
+	// with -parallel the queries run concurrently into buffers of their own,
+	// their results are processed in the order of the batch.
+	var results []chan *queryResult
+	if *parallel > 1 {
+		skip := func(i int) bool {
+			_, hit := cached[i]
+			return dash.cancelled(i) || skipDup(i) || hit
+		}
+		results = awsCli.executeParallel(ctx, priorities, *parallel, out != nil || *outDir != "", skip, execute)
+	}
+
+	// tx are the tables of the running transaction group, which are rolled
+	// back if the run fails before the group completes.
+	var tx []*txTable
+	defer func() {
+		if err == nil || tx == nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), *awsFlags.timeout)
+		defer cancel()
+		if rerr := awsCli.rollback(ctx, tx); rerr != nil {
+			errorf("%v", rerr)
+		}
+	}()
+
+	for i, query := range queries {
+		if group := transactions[i]; group != 0 && (i == 0 || transactions[i-1] != group) {
+			var grouped []string
+			for j := i; j < len(queries) && transactions[j] == group; j++ {
+				grouped = append(grouped, queries[j])
+			}
+			if tx, err = awsCli.beginTransaction(ctx, grouped, settings[i:i+len(grouped)]); err != nil {
+				return errors.Wrapf(err, "query %d", i+1)
+			}
+		}
+		if transactions[i] == 0 {
+			tx = nil
+		}
+		if dash.cancelled(i) {
+			continue
+		}
+		if skipDup(i) {
+			if dedup.value == "reuse" {
+				infof("%s: reusing the result of %s", queryLabel(i, query), queryLabel(dups[i], queries[dups[i]]))
+				if err := storedResult(i, reused[dups[i]]); err != nil {
+					return err
+				}
+			}
 			continue
 		}
-		err = awsCli.execQuery(ctx, query, out)
+		if data, ok := cached[i]; ok {
+			infof("%s: using the cached result", queryLabel(i, query))
+			if _, keep := reused[i]; keep {
+				reused[i] = data
+			}
+			if err := storedResult(i, data); err != nil {
+				return err
+			}
+			continue
+		}
+		r := &queryResult{}
+		if results != nil {
+			r = <-results[i]
+			if r == nil {
+				continue
+			}
+		} else {
+			w := out
+			_, keep := reused[i]
+			keep = keep && dedup.value == "reuse" || cache != nil && cacheable(query)
+			if *outDir != "" || keep && w != nil {
+				w = &r.buf
+			}
+			r.queryExecution, r.err = execute(i, w)
+		}
+		if _, keep := reused[i]; keep {
+			reused[i] = r.buf.Bytes()
+		}
+		if cache != nil && r.err == nil && cacheable(query) {
+			if err := cache.put(query, settingKeys[i], r.buf.Bytes()); err != nil {
+				infof("could not cache the result of %s: %v", queryLabel(i, query), err)
+			}
+		}
+		if out != nil && *outDir == "" && r.err == nil && r.buf.Len() > 0 {
+			if _, err := io.Copy(out, &r.buf); err != nil {
+				return errors.Wrap(err, "could not write result")
+			}
+		}
+		queryExecution, err := r.queryExecution, r.err
+		if aerr := audit.record(ctx, i, query, queryExecution, err); aerr != nil {
+			return errors.Wrap(aerr, "could not write audit record")
+		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "could not execute athena query: %v", err)
-			os.Exit(1)
+			if dash.cancelled(i) {
+				continue
+			}
+			return errors.Wrap(err, queryLabel(i, query))
+		}
+		if checkRowsOf(i) {
+			if err := checkRows(queryLabel(i, query), rowCounts[i], *failOnEmpty, expectRows); err != nil {
+				return err
+			}
+		}
+		var s *queryStats
+		if *withStats {
+			var serr error
+			s, serr = awsCli.queryStats(ctx, queryExecution)
+			s.QueryName = queryName(query)
+			if serr != nil {
+				fmt.Fprintf(os.Stderr, "could not get runtime statistics: %v\n", serr)
+			}
+			if verbosity >= levelInfo {
+				s.print(os.Stderr)
+			}
+			stats = append(stats, s)
 		}
+		var m *queryMeta
+		if writeMeta || *withMeta && *outDir != "" {
+			m, err = awsCli.queryMeta(ctx, query, queryExecution)
+			if err != nil {
+				return errors.Wrap(err, "could not get result metadata")
+			}
+			meta.Queries = append(meta.Queries, m)
+		}
+		if *outDir != "" && r.buf.Len() > 0 {
+			path := outDirPath(*outDir, names[i], resultExt(explain.value, *planFmt, settings[i].outputFormat))
+			err = awsCli.writeQueryOutput(ctx, r.buf.Bytes(), path, *encryptKey, checksum.value == "sidecar", m, s)
+			if err != nil {
+				return err
+			}
+			report.wrote(i, path)
+		}
+		if *withMetadata && explain.value == "" && (*outDir == "" || r.buf.Len() > 0) {
+			data, err := awsCli.resultMetadata(ctx, queryExecution)
+			if err != nil {
+				return errors.Wrap(err, "could not get result metadata object")
+			}
+			switch {
+			case data == nil:
+			case *outDir != "":
+				path := outDirPath(*outDir, names[i], "csv.metadata")
+				if err := awsCli.writeOut(bytes.NewReader(data), path); err != nil {
+					return errors.Wrap(err, "could not write result metadata")
+				}
+			default:
+				resultMetadata = append(resultMetadata, &queryResultMetadata{index: i, data: data})
+			}
+		}
+	}
+	return nil
+}
+
+// readInput reads and renders the queries of inputFile, or STDIN. s3 inputs
+// are read with awsCli, which is nil in the subcommands without aws flags.
+func (awsCli *awsCli) readInput(inputFile string, t *templater) ([]string, error) {
+	if inputFile == "" {
+		return readQueries(os.Stdin, t)
 	}
+	data, err := awsCli.readFrom(context.Background(), inputFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read input file")
+	}
+	return readQueries(bytes.NewReader(data), t)
 }
 
-func readQueries(r io.Reader) ([]string, error) {
+func readQueries(r io.Reader, t *templater) ([]string, error) {
 	in, err := ioutil.ReadAll(r)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "could not read input: %v", err)
-		os.Exit(1)
+		return nil, errors.Wrap(err, "could not read input")
 	}
 	var queries []string
+	var numbers queryNumbers
 	for _, s := range strings.Split(string(in), ";") {
 		if strim := strings.TrimSpace(s); strim != "" {
-			query, err := execTemplate(strim, nil, nil)
+			query, err := t.renderStatement(numbers.next(strim), strim)
 			if err != nil {
 				return nil, errors.Wrap(err, "could not render query")
 			}
@@ -117,101 +1039,245 @@ func readQueries(r io.Reader) ([]string, error) {
 }
 
 type awsCli struct {
-	sts        *sts.STS
-	s3         *s3.S3
-	athena     *athena.Athena
-	athenaPath string
+	session *session.Session
+	// client makes the athena calls of queries and caches the identity and
+	// the s3 clients of the buckets for the queries of -parallel.
+	client    *client.Client
+	s3        *s3.S3
+	athena    *athena.Athena
+	glue      *glueClient
+	workGroup string
+	catalog   string
+	// the athena result path is rendered from tempPath, and its bucket
+	// created, when the first query is submitted.
+	tempPath       string
+	athenaPath     string
+	athenaPathErr  error
+	athenaPathOnce sync.Once
+	// bucketOwner is the account expected to own the buckets objects are
+	// read from and written to.
+	bucketOwner string
+	// putChecksums makes s3 verify a sha256 checksum of every upload.
+	putChecksums bool
+	// created tracks the tables whose data is deleted on DROP (nil == off).
+	created  *createdTables
+	http     *httpTarget
+	sshKey   string
+	webhook  *webhookPayload
+	tags     map[string]string
+	watchers []queryWatcher
+	watchMu  sync.Mutex
+	throttle *throttle
+	poller   *statusPoller
+	// queries still QUEUED maxQueueTime after their submission are
+	// cancelled and submitted again up to queueRetries times.
+	maxQueueTime time.Duration
+	queueRetries int
+	// fetch is where results are downloaded from first, "s3" or "api".
+	fetch string
+	// runID identifies the invocation in result paths, outputs, audit
+	// records and reports.
+	runID string
+	// wait is how queries are waited for, by polling their state ("" or
+	// "poll"), by events ("events") or by their result object ("s3").
+	wait         string
+	waitInterval time.Duration
+	resultEvents *resultEvents
 }
 
-func newAWS(region, athenaPathTemplate string) (*awsCli, error) {
-	awsSession := session.New(aws.NewConfig().WithRegion(region))
-	awsCli := &awsCli{
-		sts:    sts.New(awsSession),
-		s3:     s3.New(awsSession),
-		athena: athena.New(awsSession),
+func newAWS(flags *awsFlags) (*awsCli, error) {
+	if *flags.fake != "" {
+		fake, err := loadFakeAWS(*flags.fake)
+		if err != nil {
+			return nil, err
+		}
+		return newAWSFromSession(fake.session(), flags)
 	}
-
-	athenaS3Path, err := execTemplate(athenaPathTemplate, map[string]interface{}{
-		"Account": awsCli.AccountID,
-		"Now":     time.Now,
-	}, struct{ Region string }{region})
+	awsSession, err := newSession(*flags.region)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not render athena s3 path")
+		return nil, err
 	}
+	return newAWSFromSession(awsSession, flags)
+}
 
-	err = awsCli.CreateBucketIfNotExists(athenaS3Path, region)
-	if err != nil {
-		return nil, errors.Wrap(err, "could not create athena temp bucket")
+func newAWSFromSession(awsSession *session.Session, flags *awsFlags) (*awsCli, error) {
+	opts := []client.Option{client.WithExpectedBucketOwner(*flags.bucketOwner)}
+	if l := flags.rateLimiter(); l != nil {
+		opts = append(opts, client.WithRateLimiter(l))
 	}
-
-	awsCli.athenaPath = athenaS3Path
-
+	c := client.New(awsSession, opts...)
+	awsCli := &awsCli{
+		session:     awsSession,
+		client:      c,
+		s3:          c.S3(),
+		athena:      c.Athena(),
+		glue:        newGlue(awsSession),
+		workGroup:   *flags.workGroup,
+		catalog:     *flags.catalog,
+		bucketOwner: *flags.bucketOwner,
+		tempPath:    *flags.tempPath,
+		throttle:    newThrottle(1),
+		runID:       newRunID(time.Now()),
+	}
+	if roleSessionRunID == "" {
+		roleSessionRunID = awsCli.runID
+	}
+	awsCli.poller = newStatusPoller(awsCli.athena)
 	return awsCli, nil
 }
 
-func (awsCli *awsCli) writeOut(r io.ReadSeeker, outPath string) error {
-	p, _ := url.Parse(outPath)
-	switch p.Scheme {
-	case "", "file":
-		fileName := path.Join(p.Host, p.Path)
-		data, err := ioutil.ReadAll(r)
+// newBareAWS returns a client for the subcommands that only call athena and
+// read and write s3 objects, without the flags of a run.
+func newBareAWS(sess *session.Session) *awsCli {
+	c := client.New(sess)
+	return &awsCli{session: sess, client: c, athena: c.Athena(), s3: c.S3()}
+}
+
+// resultPath returns the s3 path athena writes query results to. Rendering
+// it may need the account id, so it is deferred until a query is submitted
+// and runs without credentials otherwise (-dry).
+func (awsCli *awsCli) resultPath() (string, error) {
+	awsCli.athenaPathOnce.Do(func() {
+		region := aws.StringValue(awsCli.session.Config.Region)
+		athenaS3Path, err := awsCli.renderPath(awsCli.tempPath, nil)
 		if err != nil {
-			return err
+			awsCli.athenaPathErr = errors.Wrap(err, "could not render athena s3 path")
+			return
+		}
+		if err := awsCli.CreateBucketIfNotExists(athenaS3Path, region); err != nil {
+			awsCli.athenaPathErr = errors.Wrap(err, "could not create athena temp bucket")
+			return
 		}
-		return ioutil.WriteFile(fileName, data, 0644)
-	case "s3":
-		bucket := p.Host
-		key := strings.TrimLeft(p.Path, "/")
-		if bucket == "" || key == "" {
-			return fmt.Errorf("s3 bucket or key empty in %q", outPath)
-		}
-		_, err := awsCli.s3.PutObject(&s3.PutObjectInput{
-			Body:   r,
-			Bucket: &bucket,
-			Key:    &key,
-		})
+		awsCli.athenaPath = athenaS3Path
+	})
+	return awsCli.athenaPath, awsCli.athenaPathErr
+}
+
+// renderPath renders the template of the temp path or of an output path,
+// e.g. s3://bucket/daily/{{ .RunID }}/result.csv, with the region, the run
+// id and values.
+func (awsCli *awsCli) renderPath(path string, values map[string]string) (string, error) {
+	data := map[string]string{"Region": aws.StringValue(awsCli.session.Config.Region), "RunID": awsCli.runID}
+	for k, v := range values {
+		data[k] = v
+	}
+	return execTemplate(path, map[string]interface{}{
+		"Account": awsCli.AccountID,
+		"Now":     time.Now,
+	}, data)
+}
+
+// writeResult writes the result of a run to outPath, optionally encrypted
+// and with a checksum sidecar, and returns the sha256 of what was written.
+func (awsCli *awsCli) writeResult(ctx context.Context, data []byte, outPath, encryptKey string, sidecar bool) (string, error) {
+	var env *envelope
+	if encryptKey != "" {
+		var err error
+		data, env, err = newKMS(awsCli.session).encrypt(ctx, encryptKey, outPath, data)
 		if err != nil {
-			return errors.Wrap(err, "could not upload result to s3")
+			return "", errors.Wrap(err, "could not encrypt result")
+		}
+	}
+	sum := sha256Hex(data)
+	debugf("result sha256 %s", sum)
+	if err := awsCli.writeOut(bytes.NewReader(data), outPath); err != nil {
+		return "", errors.Wrap(err, "could not write result")
+	}
+	if sidecar {
+		line := fmt.Sprintf("%s  %s\n", sum, path.Base(outPath))
+		if err := awsCli.writeOut(strings.NewReader(line), outPath+".sha256"); err != nil {
+			return "", errors.Wrap(err, "could not write checksum")
+		}
+	}
+	if env != nil {
+		if err := awsCli.writeJSON(env, outPath+".key.json"); err != nil {
+			return "", errors.Wrap(err, "could not write key file")
+		}
+	}
+	return sum, nil
+}
+
+// writeQueryOutput writes the result of a single query of -out-dir with its
+// own sidecars.
+func (awsCli *awsCli) writeQueryOutput(ctx context.Context, data []byte, outPath, encryptKey string, sidecar bool, m *queryMeta, s *queryStats) error {
+	sum, err := awsCli.writeResult(ctx, data, outPath, encryptKey, sidecar)
+	if err != nil {
+		return err
+	}
+	if m != nil {
+		meta := &outputMeta{Output: outPath, SHA256: sum, Completed: time.Now(), Queries: []*queryMeta{m}}
+		if err := awsCli.writeJSON(meta, outPath+".meta.json"); err != nil {
+			return errors.Wrap(err, "could not write metadata")
+		}
+	}
+	if s != nil {
+		if err := awsCli.writeJSON([]*queryStats{s}, outPath+".stats.json"); err != nil {
+			return errors.Wrap(err, "could not write stats")
 		}
-	default:
-		return fmt.Errorf("UNKNOWN: schema %q", outPath)
 	}
 	return nil
 }
 
-func (awsCli *awsCli) execQuery(ctx context.Context, query string, w io.Writer) error {
+func (awsCli *awsCli) execQuery(ctx context.Context, query string, w io.Writer) (*athena.QueryExecution, error) {
+	location := awsCli.tableLocation(ctx, query)
 	queryExecution, err := awsCli.executeQuery(ctx, query)
+	awsCli.queryDone(ctx, queryExecution, err)
 	if err != nil {
-		return errors.Wrap(err, "could not execute athena query")
+		return queryExecution, errors.Wrap(err, "could not execute athena query")
+	}
+	if err := awsCli.tableChanged(ctx, query, location); err != nil {
+		return queryExecution, err
 	}
 
+	stmtType := resultStatementType(queryExecution)
+	if awsCli.wait != "s3" {
+		stmtType = awsCli.poller.statementType(aws.StringValue(queryExecution.QueryExecutionId))
+	}
 	if w != nil {
-		data, err := awsCli.getS3Contents(ctx, *queryExecution.ResultConfiguration.OutputLocation)
-		if err != nil {
-			return errors.Wrap(err, "could not get s3 contents")
+		if !hasResult(stmtType, query) {
+			debugf("%s: %s statement, skipping result", aws.StringValue(queryExecution.QueryExecutionId), stmtType)
+			return queryExecution, nil
+		}
+		if err := awsCli.fetchResult(ctx, queryExecution, w); err != nil {
+			return queryExecution, errors.Wrap(err, "could not get result")
 		}
-		_, err = io.Copy(w, bytes.NewReader(data))
-		return err
 	}
 
-	return nil
+	return queryExecution, nil
+}
+
+// templateValues are the environment variables, overridden by vars.
+func templateValues(vars map[string]string) map[string]string {
+	m := map[string]string{}
+	for _, e := range os.Environ() {
+		pair := strings.SplitN(e, "=", 2)
+		m[pair[0]] = pair[1]
+	}
+	for k, v := range vars {
+		m[k] = v
+	}
+	return m
 }
 
 func execTemplate(tmpl string, funcs map[string]interface{}, values interface{}) (string, error) {
+	return execTemplateDelims(tmpl, funcs, values, "", "", nil)
+}
+
+// execTemplateDelims renders tmpl with the action delimiters left and right
+// ("" == "{{" and "}}"). Templates referencing a denied key fail, even where
+// the reference is not executed.
+func execTemplateDelims(tmpl string, funcs map[string]interface{}, values interface{}, left, right string, denied map[string]bool) (string, error) {
 	var buf bytes.Buffer
 	if values == nil {
-		m := map[string]string{}
-		for _, e := range os.Environ() {
-			pair := strings.SplitN(e, "=", 2)
-			m[pair[0]] = pair[1]
-		}
-		values = m
+		values = templateValues(nil)
 	}
 	f := template.FuncMap{}
 	for k, v := range funcs {
 		f[k] = v
 	}
 
+	f["dateRange"] = dateRange
+	f["lastNDays"] = lastNDays
 	f["Compare"] = strings.Compare
 	f["Contains"] = strings.Contains
 	f["ContainsAny"] = strings.ContainsAny
@@ -256,9 +1322,19 @@ func execTemplate(tmpl string, funcs map[string]interface{}, values interface{})
 	f["TrimSpace"] = strings.TrimSpace
 	f["TrimSuffix"] = strings.TrimSuffix
 
-	err := template.Must(template.New("").
+	t, err := template.New("").
+		Delims(left, right).
 		Funcs(f).
-		Parse(tmpl)).Execute(&buf, values)
+		Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	for _, tt := range t.Templates() {
+		if key := deniedReference(tt.Root, denied); key != "" {
+			return "", deniedEnvError(key)
+		}
+	}
+	err = t.Execute(&buf, values)
 
 	return buf.String(), err
 }
@@ -269,6 +1345,10 @@ func (awsCli *awsCli) CreateBucketIfNotExists(path, region string) error {
 		return err
 	}
 
+	if awsCli.client.BucketRegion(s3url.Bucket) != "" {
+		return nil
+	}
+
 	_, err = awsCli.s3.CreateBucket(&s3.CreateBucketInput{
 		Bucket: &s3url.Bucket,
 		CreateBucketConfiguration: &s3.CreateBucketConfiguration{
@@ -287,70 +1367,181 @@ func (awsCli *awsCli) CreateBucketIfNotExists(path, region string) error {
 	return nil
 }
 
+// s3For returns an s3 client for the region of bucket, so that outputs and
+// results can live in another region than the queries run in.
+func (awsCli *awsCli) s3For(bucket string) *s3.S3 {
+	return awsCli.client.S3For(bucket)
+}
+
 func (awsCli *awsCli) AccountID() (string, error) {
-	getCallerIdentityOut, err := awsCli.sts.GetCallerIdentity(nil)
+	identity, err := awsCli.callerIdentity()
 	if err != nil {
-		return "", errors.Wrap(err, "could not get caller identity")
+		return "", err
 	}
-	return *getCallerIdentityOut.Account, nil
+	return *identity.Account, nil
+}
+
+func (awsCli *awsCli) callerIdentity() (*sts.GetCallerIdentityOutput, error) {
+	return awsCli.client.CallerIdentity()
 }
 
 func (awsCli *awsCli) executeQuery(ctx context.Context, sql string) (*athena.QueryExecution, error) {
-	startQueryExecutionOut, err := awsCli.athena.StartQueryExecutionWithContext(ctx, &athena.StartQueryExecutionInput{
-		QueryString: aws.String(sql),
-		ResultConfiguration: &athena.ResultConfiguration{
-			OutputLocation: aws.String(awsCli.athenaPath),
-		},
-	})
+	athenaPath, err := awsCli.resultPath()
 	if err != nil {
-		return nil, fmt.Errorf("could not start query execution: %v", err)
+		return nil, err
+	}
+	q := client.Query{SQL: sql, Catalog: awsCli.catalog, Database: queryDatabase(ctx), WorkGroup: awsCli.workGroup, OutputLocation: athenaPath}
+	if err := awsCli.throttle.acquire(ctx); err != nil {
+		return nil, fmt.Errorf("query got cancelled while queued")
 	}
+	defer awsCli.throttle.release()
+	for retry := 0; ; retry++ {
+		id, err := awsCli.submitQuery(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		queryExecution, err := awsCli.waitQuery(ctx, id)
+		if err != errQueuedTooLong || retry >= awsCli.queueRetries {
+			return queryExecution, err
+		}
+		infof("query %d was queued longer than %v, retrying", queryIndex(ctx)+1, awsCli.maxQueueTime)
+	}
+}
+
+// submitQuery submits a query, retrying with backoff while athena throttles.
+func (awsCli *awsCli) submitQuery(ctx context.Context, q client.Query) (string, error) {
+	for backoff := time.Second; ; backoff *= 2 {
+		id, err := awsCli.client.Start(ctx, q)
+		if err == nil {
+			awsCli.throttle.accepted()
+			return id, nil
+		}
+		if !isTooManyRequests(err) {
+			return "", fmt.Errorf("could not start query execution: %v", err)
+		}
+		awsCli.throttle.throttled()
+		if backoff > maxThrottleBackoff {
+			backoff = maxThrottleBackoff
+		}
+		infof("athena rejected query %d: %v, retrying in %v", queryIndex(ctx)+1, err, backoff)
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("query got cancelled while queued")
+		case <-time.After(backoff):
+		}
+	}
+}
+
+var errQueuedTooLong = errors.New("query was queued longer than -max-queue-time")
 
-	t := time.NewTicker(time.Millisecond * 500)
-	defer t.Stop()
+// waitQuery waits for a query to finish. A query still QUEUED after
+// maxQueueTime, or still running after the timeout of ctx, is cancelled.
+func (awsCli *awsCli) waitQuery(ctx context.Context, id string) (*athena.QueryExecution, error) {
+	timeout := queryTimeoutOf(ctx)
+	if timeout <= 0 {
+		queryExecution, err := awsCli.waitQueryState(ctx, id)
+		awsCli.stopInterrupted(ctx, id)
+		return queryExecution, err
+	}
+	queryCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	queryExecution, err := awsCli.waitQueryState(queryCtx, id)
+	if err == nil || queryCtx.Err() != context.DeadlineExceeded || ctx.Err() != nil {
+		awsCli.stopInterrupted(ctx, id)
+		return queryExecution, err
+	}
+	if _, err := awsCli.athena.StopQueryExecutionWithContext(ctx, &athena.StopQueryExecutionInput{QueryExecutionId: aws.String(id)}); err != nil {
+		return queryExecution, errors.Wrap(err, "could not cancel query running longer than its timeout")
+	}
+	return queryExecution, errQueryTimeout(timeout)
+}
+
+// stopInterrupted stops the query once the run got cancelled, e.g. by
+// Ctrl-C, so that it does not keep running and scanning in athena.
+func (awsCli *awsCli) stopInterrupted(ctx context.Context, id string) {
+	if ctx.Err() != context.Canceled {
+		return
+	}
+	if _, err := awsCli.athena.StopQueryExecution(&athena.StopQueryExecutionInput{QueryExecutionId: aws.String(id)}); err != nil {
+		errorf("could not cancel query %s: %v", id, err)
+	}
+}
+
+func (awsCli *awsCli) waitQueryState(ctx context.Context, id string) (*athena.QueryExecution, error) {
+	if awsCli.wait == "s3" {
+		return awsCli.waitResultObject(ctx, id)
+	}
+	submitted := time.Now()
+	updates, unwatch := awsCli.poller.watch(id)
+	defer unwatch()
 	for {
 		select {
 		case <-ctx.Done():
 			return nil, fmt.Errorf("query got cancelled")
-		case <-t.C:
-			getQueryExecutionOut, err := awsCli.athena.GetQueryExecutionWithContext(ctx, &athena.GetQueryExecutionInput{
-				QueryExecutionId: startQueryExecutionOut.QueryExecutionId,
-			})
-			if err != nil {
-				return nil, fmt.Errorf("could not get query status: %v", err)
+		case u := <-updates:
+			if u.err != nil {
+				return nil, fmt.Errorf("could not get query status: %v", u.err)
 			}
-			switch *getQueryExecutionOut.QueryExecution.Status.State {
+			awsCli.updateQuery(ctx, u.queryExecution)
+			switch *u.queryExecution.Status.State {
+			case "QUEUED":
+				if awsCli.maxQueueTime > 0 && time.Since(submitted) > awsCli.maxQueueTime {
+					_, err := awsCli.athena.StopQueryExecutionWithContext(ctx, &athena.StopQueryExecutionInput{QueryExecutionId: aws.String(id)})
+					if err != nil {
+						return u.queryExecution, errors.Wrap(err, "could not cancel query queued longer than -max-queue-time")
+					}
+					return u.queryExecution, errQueuedTooLong
+				}
 			case "FAILED", "CANCELLED":
-				return getQueryExecutionOut.QueryExecution, fmt.Errorf("athena query could not finish: %v", *getQueryExecutionOut.QueryExecution.Status.StateChangeReason)
+				return u.queryExecution, fmt.Errorf("athena query could not finish: %v", aws.StringValue(u.queryExecution.Status.StateChangeReason))
 			case "SUCCEEDED":
-				return getQueryExecutionOut.QueryExecution, nil
-			default:
-				continue
+				return u.queryExecution, nil
 			}
 		}
 	}
 }
 
 func (awsCli *awsCli) getS3Contents(ctx context.Context, path string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := awsCli.copyS3Contents(ctx, path, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// copyS3Contents streams an s3 object to w as it downloads and verifies it
+// once it is complete.
+func (awsCli *awsCli) copyS3Contents(ctx context.Context, path string, w io.Writer) error {
 	s3Path, err := s3path.Parse(path)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing s3 URL: %v", err)
+		return fmt.Errorf("error parsing s3 URL: %v", err)
+	}
+	if isRangedDownload(ctx) {
+		return awsCli.copyS3Ranges(ctx, s3Path, w)
 	}
 
-	getObjOut, err := awsCli.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+	req, getObjOut := awsCli.s3For(s3Path.Bucket).GetObjectRequest(&s3.GetObjectInput{
 		Bucket: &s3Path.Bucket,
 		Key:    &s3Path.Key,
 	})
-	if err != nil {
-		return nil, fmt.Errorf("could not get result from  %q: %v", s3Path, err)
+	req.SetContext(ctx)
+	req.HTTPRequest.Header.Set("X-Amz-Checksum-Mode", "ENABLED")
+	if err := req.Send(); err != nil {
+		return errors.Wrapf(err, "could not get result from %q", s3Path)
 	}
 
 	defer getObjOut.Body.Close()
 
-	data, err := ioutil.ReadAll(getObjOut.Body)
-	if err != nil {
-		return nil, fmt.Errorf("could not read result form s3: %v", err)
+	verifier := newChecksumVerifier(req.HTTPResponse.Header)
+	if _, err := io.Copy(io.MultiWriter(w, verifier), getObjOut.Body); err == errEnoughRows {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("could not read result form s3: %v", err)
 	}
 
-	return data, nil
+	if err := verifier.verify(); err != nil {
+		return fmt.Errorf("could not verify result from %q: %v", s3Path, err)
+	}
+
+	return nil
 }
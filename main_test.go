@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+func TestTagsFlag(t *testing.T) {
+	tags := tagsFlag{}
+	fs := flag.NewFlagSet("athenaq", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.Var(tags, "tag", "")
+	if err := fs.Parse([]string{"-tag", "team=data", "-tag", "job=daily=1", "-tag", "empty="}); err != nil {
+		t.Fatal(err)
+	}
+	if want := (tagsFlag{"team": "data", "job": "daily=1", "empty": ""}); !reflect.DeepEqual(tags, want) {
+		t.Errorf("got %v, want %v", tags, want)
+	}
+	if got, want := tags.String(), "empty=,job=daily=1,team=data"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	for _, s := range []string{"team", "=data"} {
+		if err := tags.Set(s); err == nil {
+			t.Errorf("Set(%q) should fail", s)
+		}
+	}
+}
+
+func TestWriteOutTags(t *testing.T) {
+	var tagging, path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, tagging = r.URL.Path, r.Header.Get("X-Amz-Tagging")
+	}))
+	defer srv.Close()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Endpoint:         aws.String(srv.URL),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		S3ForcePathStyle: aws.Bool(true),
+	}))
+	awsCli := newBareAWS(sess)
+	awsCli.tags = map[string]string{"team": "data", "cost center": "a&b"}
+	if err := awsCli.writeOut(strings.NewReader("a,b\n"), "s3://bucket/out/result.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/bucket/out/result.csv" {
+		t.Errorf("got path %q", path)
+	}
+	values, err := url.ParseQuery(tagging)
+	if err != nil || values.Get("team") != "data" || values.Get("cost center") != "a&b" || len(values) != 2 {
+		t.Errorf("got tagging %q", tagging)
+	}
+}
+
+func TestDryRunOffline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq-dry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "daily.sql")
+	if err := ioutil.WriteFile(input, []byte("select * from events;\nselect 2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout := filepath.Join(dir, "stdout")
+	f, err := os.Create(stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// without a region every aws call fails, so run only succeeds if it
+	// makes none.
+	defer func(args []string, fs *flag.FlagSet, out *os.File) {
+		os.Args, flag.CommandLine, os.Stdout = args, fs, out
+	}(os.Args, flag.CommandLine, os.Stdout)
+	defer setenv(map[string]string{"AWS_REGION": "", "AWS_DEFAULT_REGION": "", "AWS_CONFIG_FILE": filepath.Join(dir, "none")})()
+	os.Args = []string{"athenaq", "-dry", "-f", input, "-engine-version", "3", "-capacity-reservation", "reserved",
+		"-require-partition-filter=fail", "-audit", "dynamodb://audit"}
+	flag.CommandLine = flag.NewFlagSet("athenaq", flag.ContinueOnError)
+	os.Stdout = f
+	if err := run(); err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	data, _ := ioutil.ReadFile(stdout)
+	if want := "execute query: select * from events\nexecute query: select 2\n"; string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+}
+
+func TestExpectedBucketOwnerFlag(t *testing.T) {
+	f := newFakeAWS(t)
+	defer f.Close()
+	f.result("select 1", "\"_col0\"\n\"1\"\n")
+	sess := f.session()
+	var mu sync.Mutex
+	owners := map[string]string{}
+	sess.Handlers.Send.PushFront(func(r *request.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		owners[r.Operation.Name] = r.HTTPRequest.Header.Get("X-Amz-Expected-Bucket-Owner")
+	})
+	fs := flag.NewFlagSet("athenaq", flag.ContinueOnError)
+	flags := addAWSFlags(fs)
+	fs.Parse([]string{"-expected-bucket-owner", "111122223333"})
+	awsCli, err := newAWSFromSession(sess, flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := awsCli.execQuery(ctx, "select 1", ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := awsCli.writeResult(ctx, []byte("x"), "s3://reports/out.csv", "", false); err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range []string{"GetObject", "PutObject"} {
+		if owners[op] != "111122223333" {
+			t.Errorf("%s: got expected bucket owner %q", op, owners[op])
+		}
+	}
+}
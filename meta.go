@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/pkg/errors"
+)
+
+type columnMeta struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable string `json:"nullable,omitempty"`
+	// Precision and Scale are set for decimals.
+	Precision int64 `json:"-"`
+	Scale     int64 `json:"-"`
+}
+
+type queryMeta struct {
+	QueryExecutionID      string       `json:"query_execution_id"`
+	Name                  string       `json:"name,omitempty"`
+	Query                 string       `json:"query"`
+	Submitted             *time.Time   `json:"submitted,omitempty"`
+	Completed             *time.Time   `json:"completed,omitempty"`
+	DataScannedBytes      int64        `json:"data_scanned_bytes"`
+	EngineExecutionMillis int64        `json:"engine_execution_ms"`
+	ResultLocation        string       `json:"result_location,omitempty"`
+	Columns               []columnMeta `json:"columns"`
+}
+
+// outputMeta is written next to an output as <output>.meta.json so that
+// consumers can check where the data came from and how fresh it is.
+type outputMeta struct {
+	Output    string       `json:"output"`
+	SHA256    string       `json:"sha256,omitempty"`
+	Completed time.Time    `json:"completed"`
+	Queries   []*queryMeta `json:"queries"`
+}
+
+func (awsCli *awsCli) queryMeta(ctx context.Context, query string, queryExecution *athena.QueryExecution) (*queryMeta, error) {
+	m := &queryMeta{
+		QueryExecutionID: aws.StringValue(queryExecution.QueryExecutionId),
+		Name:             queryName(query),
+		Query:            secrets.redact(query),
+		Columns:          []columnMeta{},
+	}
+	if s := queryExecution.Status; s != nil {
+		m.Submitted, m.Completed = s.SubmissionDateTime, s.CompletionDateTime
+	}
+	if s := queryExecution.Statistics; s != nil {
+		m.DataScannedBytes = aws.Int64Value(s.DataScannedInBytes)
+		m.EngineExecutionMillis = aws.Int64Value(s.EngineExecutionTimeInMillis)
+	}
+	if c := queryExecution.ResultConfiguration; c != nil {
+		m.ResultLocation = aws.StringValue(c.OutputLocation)
+	}
+	columns, err := awsCli.resultColumns(ctx, m.QueryExecutionID)
+	if err != nil {
+		return m, err
+	}
+	m.Columns = columns
+	return m, nil
+}
+
+func (awsCli *awsCli) resultColumns(ctx context.Context, queryExecutionID string) ([]columnMeta, error) {
+	out, err := awsCli.athena.GetQueryResultsWithContext(ctx, &athena.GetQueryResultsInput{
+		QueryExecutionId: aws.String(queryExecutionID),
+		MaxResults:       aws.Int64(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	columns := []columnMeta{}
+	if out.ResultSet != nil && out.ResultSet.ResultSetMetadata != nil {
+		for _, c := range out.ResultSet.ResultSetMetadata.ColumnInfo {
+			columns = append(columns, columnMeta{
+				Name:      aws.StringValue(c.Name),
+				Type:      aws.StringValue(c.Type),
+				Nullable:  aws.StringValue(c.Nullable),
+				Precision: aws.Int64Value(c.Precision),
+				Scale:     aws.Int64Value(c.Scale),
+			})
+		}
+	}
+	return columns, nil
+}
+
+func (awsCli *awsCli) writeJSON(v interface{}, path string) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return awsCli.writeOut(bytes.NewReader(data), path)
+}
+
+// queryResultMetadata is the .metadata object of the result of a query.
+type queryResultMetadata struct {
+	index int
+	data  []byte
+}
+
+// resultMetadata returns the .metadata object athena writes next to a csv
+// result, nil if the query wrote no csv.
+func (awsCli *awsCli) resultMetadata(ctx context.Context, queryExecution *athena.QueryExecution) ([]byte, error) {
+	location := ""
+	if c := queryExecution.ResultConfiguration; c != nil {
+		location = aws.StringValue(c.OutputLocation)
+	}
+	if !strings.HasSuffix(location, ".csv") {
+		return nil, nil
+	}
+	data, err := awsCli.getS3Contents(ctx, location+".metadata")
+	if aerr, ok := errors.Cause(err).(awserr.Error); ok && aerr.Code() == "NoSuchKey" {
+		debugf("%s: no result metadata", aws.StringValue(queryExecution.QueryExecutionId))
+		return nil, nil
+	}
+	return data, err
+}
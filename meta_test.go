@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+func TestQueryMeta(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target := r.Header.Get("X-Amz-Target"); target != "AmazonAthena.GetQueryResults" {
+			t.Errorf("unexpected call %s", target)
+		}
+		var in struct {
+			QueryExecutionId string
+			MaxResults       int
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &in)
+		if in.QueryExecutionId != "q-1" || in.MaxResults != 1 {
+			t.Errorf("got request %s", body)
+		}
+		w.Write([]byte(`{"ResultSet": {"ResultSetMetadata": {"ColumnInfo": [
+			{"Name": "id", "Type": "bigint", "Nullable": "NOT_NULL"},
+			{"Name": "name", "Type": "varchar"}
+		]}, "Rows": []}}`))
+	}))
+	defer srv.Close()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Endpoint:    aws.String(srv.URL),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	awsCli := &awsCli{athena: athena.New(sess)}
+
+	submitted, completed := time.Unix(100, 0), time.Unix(160, 0)
+	m, err := awsCli.queryMeta(context.Background(), "select id, name from users", &athena.QueryExecution{
+		QueryExecutionId:    aws.String("q-1"),
+		Status:              &athena.QueryExecutionStatus{SubmissionDateTime: &submitted, CompletionDateTime: &completed},
+		Statistics:          &athena.QueryExecutionStatistics{DataScannedInBytes: aws.Int64(1024), EngineExecutionTimeInMillis: aws.Int64(300)},
+		ResultConfiguration: &athena.ResultConfiguration{OutputLocation: aws.String("s3://b/q-1.csv")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &queryMeta{
+		QueryExecutionID:      "q-1",
+		Query:                 "select id, name from users",
+		Submitted:             &submitted,
+		Completed:             &completed,
+		DataScannedBytes:      1024,
+		EngineExecutionMillis: 300,
+		ResultLocation:        "s3://b/q-1.csv",
+		Columns:               []columnMeta{{Name: "id", Type: "bigint", Nullable: "NOT_NULL"}, {Name: "name", Type: "varchar"}},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("got %+v, want %+v", m, want)
+	}
+}
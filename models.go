@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type model struct {
+	name   string
+	source string
+	deps   []string
+	config map[string]string
+}
+
+func (m *model) materialized() string {
+	if mat := m.config["materialized"]; mat != "" {
+		return mat
+	}
+	return "view"
+}
+
+// rebuilt reports whether the model is built from scratch. It is then built
+// into the table <name>__athenaq_tmp, which replaces the model table only once
+// it is complete, so that a failing build keeps the previous table.
+func (m *model) rebuilt(incremental bool) bool {
+	return m.materialized() == "table" || m.materialized() == "incremental" && !incremental
+}
+
+func (m *model) tmpName() string {
+	return m.name + "__athenaq_tmp"
+}
+
+// location returns the external_location of the build version, a directory
+// below the configured external_location: athena only writes a table into an
+// empty location, and the previous version is still read until the swap.
+func (m *model) location(version string) string {
+	base := m.config["external_location"]
+	if base == "" {
+		return ""
+	}
+	return strings.TrimRight(base, "/") + "/" + version + "/"
+}
+
+func modelsCmd(args []string) error {
+	fs := flag.NewFlagSet("models", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: athenaq models [flags]")
+		fs.PrintDefaults()
+	}
+	var (
+		awsFlags    = addAWSFlags(fs)
+		dir         = fs.String("dir", "models", "models directory")
+		database    = fs.String("database", "", "target database")
+		fullRefresh = fs.Bool("full-refresh", false, "rebuild incremental models from scratch")
+		dry         = fs.Bool("dry", false, "dry run")
+	)
+	fs.Parse(args)
+	if *database == "" {
+		return errors.New("-database is required")
+	}
+
+	models, err := loadModels(*dir, *database)
+	if err != nil {
+		return err
+	}
+	order, err := sortModels(models)
+	if err != nil {
+		return err
+	}
+
+	// a dry run only prints the statements of a full build and does not
+	// talk to aws at all.
+	var awsCli *awsCli
+	if !*dry {
+		awsCli, err = newAWS(awsFlags)
+		if err != nil {
+			return errors.Wrap(err, "could not initialize aws client")
+		}
+		awsCli.workGroup = *awsFlags.workGroup
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *awsFlags.timeout)
+	defer cancel()
+
+	version := time.Now().UTC().Format("20060102T150405Z")
+	for _, name := range order {
+		m := models[name]
+		incremental := false
+		if m.materialized() == "incremental" && !*fullRefresh && !*dry {
+			incremental, err = awsCli.tableExists(ctx, *database, m.name)
+			if err != nil {
+				return errors.Wrapf(err, "could not check if model %q exists", m.name)
+			}
+		}
+		statements, err := m.statements(*database, models, incremental, version)
+		if err != nil {
+			return errors.Wrapf(err, "could not build model %q", m.name)
+		}
+		if *dry {
+			for _, statement := range statements {
+				fmt.Println("execute query:", statement)
+			}
+			if m.rebuilt(incremental) {
+				fmt.Printf("replace table: %s.%s with %s.%s\n", *database, m.name, *database, m.tmpName())
+			}
+			continue
+		}
+		infof("model %s (%s)", m.name, m.materialized())
+		if err := awsCli.materialize(ctx, *database, m, statements, incremental); err != nil {
+			return errors.Wrapf(err, "could not materialize model %q", m.name)
+		}
+	}
+	return nil
+}
+
+// materialize runs the statements of m and swaps a rebuilt table into place.
+func (awsCli *awsCli) materialize(ctx context.Context, database string, m *model, statements []string, incremental bool) error {
+	for _, statement := range statements {
+		if _, err := awsCli.execQuery(ctx, statement, nil); err != nil {
+			return err
+		}
+	}
+	if !m.rebuilt(incremental) {
+		return nil
+	}
+	return awsCli.replaceModel(ctx, database, m)
+}
+
+// replaceModel swaps the finished build of m into place and deletes the data
+// of the previous version, if it was written below external_location.
+func (awsCli *awsCli) replaceModel(ctx context.Context, database string, m *model) error {
+	old, err := awsCli.glue.swapTable(ctx, database, m.tmpName(), m.name)
+	if err != nil {
+		return err
+	}
+	base := m.config["external_location"]
+	if base == "" || !strings.HasPrefix(old, strings.TrimRight(base, "/")+"/") {
+		return nil
+	}
+	n, err := awsCli.deleteS3Prefix(ctx, old)
+	if err != nil {
+		return errors.Wrapf(err, "could not delete the previous version in %s", old)
+	}
+	infof("deleted %d objects of the previous version of %s.%s in %s", n, database, m.name, old)
+	return nil
+}
+
+// loadModels reads every *.sql file in dir and renders it, both as a full
+// build and incrementally, to discover its refs and config.
+func loadModels(dir, database string) (map[string]*model, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+	models := map[string]*model{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read model")
+		}
+		name := strings.TrimSuffix(filepath.Base(file), ".sql")
+		models[name] = &model{name: name, source: string(data)}
+	}
+	for _, m := range models {
+		var deps []string
+		for _, incremental := range []bool{true, false} {
+			_, err := m.render(database, models, incremental)
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not render model %q", m.name)
+			}
+			deps = append(deps, m.deps...)
+		}
+		m.deps = deps
+	}
+	return models, nil
+}
+
+func (m *model) render(database string, models map[string]*model, incremental bool) (string, error) {
+	m.deps = nil
+	m.config = map[string]string{}
+	sql, err := execTemplate(m.source, map[string]interface{}{
+		"ref": func(name string) (string, error) {
+			if _, ok := models[name]; !ok {
+				return "", fmt.Errorf("unknown model %q", name)
+			}
+			m.deps = append(m.deps, name)
+			return quoteIdent(database) + "." + quoteIdent(name), nil
+		},
+		"config": func(key, value string) string {
+			m.config[key] = value
+			return ""
+		},
+		"this": func() string {
+			return quoteIdent(database) + "." + quoteIdent(m.name)
+		},
+		"Incremental": func() bool {
+			return incremental
+		},
+	}, nil)
+	return strings.TrimRight(strings.TrimSpace(sql), ";"), err
+}
+
+// statements returns the statements that build the model. Tables are built
+// into the temporary table, which replaceModel swaps into place, with their
+// data in the location of version.
+func (m *model) statements(database string, models map[string]*model, incremental bool, version string) ([]string, error) {
+	sql, err := m.render(database, models, incremental)
+	if err != nil {
+		return nil, err
+	}
+	target := quoteIdent(database) + "." + quoteIdent(m.name)
+	switch m.materialized() {
+	case "view":
+		return []string{"CREATE OR REPLACE VIEW " + target + " AS\n" + sql}, nil
+	case "table", "incremental":
+		if !m.rebuilt(incremental) {
+			return []string{"INSERT INTO " + target + "\n" + sql}, nil
+		}
+		config := map[string]string{}
+		for k, v := range m.config {
+			config[k] = v
+		}
+		if location := m.location(version); location != "" {
+			config["external_location"] = location
+		}
+		return []string{
+			// a failed earlier build may have left the temporary table behind
+			"DROP TABLE IF EXISTS " + quoteDDLIdent(database) + "." + quoteDDLIdent(m.tmpName()),
+			ctas(quoteIdent(database)+"."+quoteIdent(m.tmpName()), config, sql),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown materialization %q", m.materialized())
+	}
+}
+
+var unquotedProperty = regexp.MustCompile(`^(ARRAY\[.*\]|[0-9.]+|true|false)$`)
+
+func ctas(target string, config map[string]string, sql string) string {
+	var keys []string
+	for k := range config {
+		if k != "materialized" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return "CREATE TABLE " + target + " AS\n" + sql
+	}
+	sort.Strings(keys)
+	props := make([]string, len(keys))
+	for i, k := range keys {
+		v := config[k]
+		if !unquotedProperty.MatchString(v) {
+			v = "'" + strings.Replace(v, "'", "''", -1) + "'"
+		}
+		props[i] = k + " = " + v
+	}
+	return "CREATE TABLE " + target + " WITH (" + strings.Join(props, ", ") + ") AS\n" + sql
+}
+
+// sortModels orders the models so that every model comes after its refs.
+func sortModels(models map[string]*model) ([]string, error) {
+	var names []string
+	for name := range models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var (
+		order []string
+		state = map[string]int{}
+		visit func(name string, path []string) error
+	)
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("cyclic model refs: %s", strings.Join(append(path, name), " -> "))
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		deps := append([]string(nil), models[name].deps...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		order = append(order, name)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+func (awsCli *awsCli) tableExists(ctx context.Context, database, table string) (bool, error) {
+	var buf bytes.Buffer
+	_, err := awsCli.execQuery(ctx, fmt.Sprintf("SHOW TABLES IN %s '%s'", quoteDDLIdent(database), table), &buf)
+	if err != nil {
+		return false, err
+	}
+	s := bufio.NewScanner(&buf)
+	for s.Scan() {
+		if strings.EqualFold(strings.TrimSpace(s.Text()), table) {
+			return true, nil
+		}
+	}
+	return false, s.Err()
+}
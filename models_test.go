@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSortModels(t *testing.T) {
+	for _, tt := range []struct {
+		deps  map[string][]string
+		order []string
+		err   string
+	}{
+		{map[string][]string{"a": nil}, []string{"a"}, ""},
+		{map[string][]string{"a": {"b"}, "b": {"c"}, "c": nil}, []string{"c", "b", "a"}, ""},
+		{map[string][]string{"report": {"events", "users"}, "users": nil, "events": {"raw"}, "raw": nil}, []string{"raw", "events", "users", "report"}, ""},
+		{map[string][]string{"a": {"b", "b"}, "b": nil}, []string{"b", "a"}, ""},
+		{map[string][]string{"a": {"b"}, "b": {"a"}}, nil, "cyclic model refs: a -> b -> a"},
+		{map[string][]string{"a": {"a"}}, nil, "cyclic model refs: a -> a"},
+		{map[string][]string{"x": nil, "a": {"b"}, "b": {"c"}, "c": {"a"}}, nil, "cyclic model refs: a -> b -> c -> a"},
+	} {
+		models := map[string]*model{}
+		for name, deps := range tt.deps {
+			models[name] = &model{name: name, deps: deps}
+		}
+		order, err := sortModels(models)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("sortModels(%v) = %v, want error %q", tt.deps, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(order, tt.order) {
+			t.Errorf("sortModels(%v) = %v, %v, want %v", tt.deps, order, err, tt.order)
+		}
+	}
+}
+
+func TestLoadModels(t *testing.T) {
+	for _, tt := range []struct {
+		files map[string]string
+		deps  map[string][]string
+		err   string
+	}{
+		{
+			files: map[string]string{
+				"raw.sql":    "select 1 as id",
+				"clean.sql":  `select * from {{ ref "raw" }}`,
+				"report.sql": `{{ config "materialized" "incremental" }}select * from {{ ref "clean" }}{{ if Incremental }} where id > (select max(id) from {{ ref "raw" }}){{ end }}`,
+			},
+			deps: map[string][]string{"raw": nil, "clean": {"raw", "raw"}, "report": {"clean", "raw", "clean"}},
+		},
+		{
+			files: map[string]string{"a.sql": `select * from {{ ref "missing" }}`},
+			err:   `could not render model "a"`,
+		},
+	} {
+		dir, err := ioutil.TempDir("", "athenaq-models")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		for name, source := range tt.files {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(source), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		models, err := loadModels(dir, "analytics")
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) || !strings.Contains(err.Error(), `unknown model "missing"`) {
+				t.Errorf("loadModels(%v) = %v, want error %q", tt.files, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		deps := map[string][]string{}
+		for name, m := range models {
+			deps[name] = m.deps
+		}
+		if !reflect.DeepEqual(deps, tt.deps) {
+			t.Errorf("deps = %v, want %v", deps, tt.deps)
+		}
+	}
+}
+
+func TestModelStatements(t *testing.T) {
+	models := map[string]*model{"raw": {name: "raw", source: "select 1"}}
+	for _, tt := range []struct {
+		source      string
+		incremental bool
+		want        []string
+	}{
+		{
+			`select * from {{ ref "raw" }};`,
+			false,
+			[]string{"CREATE OR REPLACE VIEW \"analytics\".\"m\" AS\nselect * from \"analytics\".\"raw\""},
+		},
+		{
+			`{{ config "materialized" "table" }}select 1`,
+			false,
+			[]string{"DROP TABLE IF EXISTS `analytics`.`m__athenaq_tmp`", "CREATE TABLE \"analytics\".\"m__athenaq_tmp\" AS\nselect 1"},
+		},
+		{
+			`{{ config "materialized" "table" }}{{ config "format" "PARQUET" }}{{ config "partitioned_by" "ARRAY['dt']" }}{{ config "bucket_count" "8" }}{{ config "external_location" "s3://b/it's" }}select 1`,
+			false,
+			[]string{"DROP TABLE IF EXISTS `analytics`.`m__athenaq_tmp`", "CREATE TABLE \"analytics\".\"m__athenaq_tmp\" WITH (bucket_count = 8, external_location = 's3://b/it''s/v1/', format = 'PARQUET', partitioned_by = ARRAY['dt']) AS\nselect 1"},
+		},
+		{
+			`{{ config "materialized" "incremental" }}select * from {{ ref "raw" }}{{ if Incremental }} where x > (select max(x) from {{ this }}){{ end }}`,
+			true,
+			[]string{"INSERT INTO \"analytics\".\"m\"\nselect * from \"analytics\".\"raw\" where x > (select max(x) from \"analytics\".\"m\")"},
+		},
+		{
+			`{{ config "materialized" "incremental" }}select * from {{ ref "raw" }}{{ if Incremental }} where x > 0{{ end }}`,
+			false,
+			[]string{"DROP TABLE IF EXISTS `analytics`.`m__athenaq_tmp`", "CREATE TABLE \"analytics\".\"m__athenaq_tmp\" AS\nselect * from \"analytics\".\"raw\""},
+		},
+	} {
+		m := &model{name: "m", source: tt.source}
+		got, err := m.statements("analytics", models, tt.incremental, "v1")
+		if err != nil {
+			t.Errorf("statements(%q) failed: %v", tt.source, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("statements(%q) = %q, want %q", tt.source, got, tt.want)
+		}
+	}
+
+	m := &model{name: "m", source: `{{ config "materialized" "snapshot" }}select 1`}
+	if _, err := m.statements("analytics", models, false, "v1"); err == nil || err.Error() != `unknown materialization "snapshot"` {
+		t.Errorf("unknown materialization: got %v", err)
+	}
+}
+
+func TestModelRebuild(t *testing.T) {
+	f := newFakeAWS(t)
+	defer f.Close()
+	awsCli := f.client()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	m := &model{name: "m", source: `{{ config "materialized" "table" }}{{ config "external_location" "s3://data/m" }}select 1`}
+	models := map[string]*model{"m": m}
+	build := func(version string) error {
+		statements, err := m.statements("analytics", models, false, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return awsCli.materialize(ctx, "analytics", m, statements, false)
+	}
+	objects := func(prefix string) int {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		n := 0
+		for key := range f.objects {
+			if strings.HasPrefix(key, prefix) {
+				n++
+			}
+		}
+		return n
+	}
+	location := func() string {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.tables["analytics.m"]
+	}
+
+	if err := build("v1"); err != nil {
+		t.Fatalf("first build failed: %v", err)
+	}
+	if loc := location(); loc != "s3://data/m/v1/" {
+		t.Errorf("location after the first build = %q", loc)
+	}
+	// the rerun must not fail on the data of the first build
+	if err := build("v2"); err != nil {
+		t.Fatalf("rebuild failed: %v", err)
+	}
+	if loc := location(); loc != "s3://data/m/v2/" {
+		t.Errorf("location after the rebuild = %q", loc)
+	}
+	if n := objects("data/m/v1/"); n != 0 {
+		t.Errorf("%d objects of the previous version left", n)
+	}
+	// a failing build keeps the model table and its data
+	if err := build("v2"); err == nil {
+		t.Fatal("no error for a build into a non-empty location")
+	}
+	if loc := location(); loc != "s3://data/m/v2/" {
+		t.Errorf("location after a failed build = %q", loc)
+	}
+	if n := objects("data/m/v2/"); n != 1 {
+		t.Errorf("%d objects of the model table, want 1", n)
+	}
+	f.mu.Lock()
+	_, tmp := f.tables["analytics.m__athenaq_tmp"]
+	f.mu.Unlock()
+	if tmp {
+		t.Error("temporary table left after the swap")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+)
+
+// namedQueryID matches the ids athena gives saved queries.
+var namedQueryID = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// namedQuery is a query saved in athena. The vendored sdk does not know
+// the workgroup of named queries yet.
+type namedQuery struct {
+	NamedQueryId *string
+	Name         *string
+	Database     *string
+	QueryString  *string
+	WorkGroup    *string
+}
+
+// namedQuery returns the saved query with the id or name in the workgroup.
+func (awsCli *awsCli) namedQuery(ctx context.Context, nameOrID string) (*namedQuery, error) {
+	if namedQueryID.MatchString(nameOrID) {
+		out := struct{ NamedQuery *namedQuery }{}
+		if err := sendAthena(ctx, awsCli.athena, "GetNamedQuery", &struct{ NamedQueryId *string }{&nameOrID}, &out); err != nil {
+			return nil, err
+		}
+		return out.NamedQuery, nil
+	}
+
+	var workGroup *string
+	name := "primary"
+	if awsCli.workGroup != "" {
+		workGroup, name = aws.String(awsCli.workGroup), awsCli.workGroup
+	}
+	var found []*namedQuery
+	var next *string
+	for {
+		list := struct {
+			NamedQueryIds []*string
+			NextToken     *string
+		}{}
+		err := sendAthena(ctx, awsCli.athena, "ListNamedQueries", &struct {
+			WorkGroup  *string
+			NextToken  *string
+			MaxResults *int64
+		}{workGroup, next, aws.Int64(50)}, &list)
+		if err != nil {
+			return nil, err
+		}
+		if len(list.NamedQueryIds) > 0 {
+			batch := struct{ NamedQueries []*namedQuery }{}
+			if err := sendAthena(ctx, awsCli.athena, "BatchGetNamedQuery", &struct{ NamedQueryIds []*string }{list.NamedQueryIds}, &batch); err != nil {
+				return nil, err
+			}
+			for _, q := range batch.NamedQueries {
+				if aws.StringValue(q.Name) == nameOrID {
+					found = append(found, q)
+				}
+			}
+		}
+		if list.NextToken == nil {
+			break
+		}
+		next = list.NextToken
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no saved query %q in workgroup %q", nameOrID, name)
+	case 1:
+		return found[0], nil
+	}
+	var ids []string
+	for _, q := range found {
+		ids = append(ids, aws.StringValue(q.NamedQueryId))
+	}
+	return nil, fmt.Errorf("%d saved queries are named %q, use the id of one of them: %s", len(found), nameOrID, strings.Join(ids, ", "))
+}
+
+// readNamedQuery reads the queries of a saved query, in its database.
+func (awsCli *awsCli) readNamedQuery(ctx context.Context, nameOrID string, t *templater) ([]string, error) {
+	q, err := awsCli.namedQuery(ctx, nameOrID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not get saved query %q", nameOrID)
+	}
+	sql := aws.StringValue(q.QueryString)
+	if database := aws.StringValue(q.Database); database != "" {
+		sql = "SET athenaq.database = '" + strings.Replace(database, "'", "''", -1) + "';\n" + sql
+	}
+	return readQueries(strings.NewReader(sql), t)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestReadNamedQuery(t *testing.T) {
+	f := newFakeAWS(t)
+	defer f.Close()
+	const reportID = "0b5a3c2e-1111-4d2b-9a53-6f1e2d3c4b5a"
+	f.named[reportID] = &namedQuery{NamedQueryId: aws.String(reportID), Name: aws.String("daily_report"), Database: aws.String("analytics"), QueryString: aws.String("SELECT * FROM events")}
+	f.named["b"] = &namedQuery{NamedQueryId: aws.String("b"), Name: aws.String("dup"), QueryString: aws.String("SELECT 1")}
+	f.named["c"] = &namedQuery{NamedQueryId: aws.String("c"), Name: aws.String("dup"), QueryString: aws.String("SELECT 2")}
+	awsCli := f.client()
+	ctx := context.Background()
+
+	want := []string{"SET athenaq.database = 'analytics'", "SELECT * FROM events"}
+	for _, nameOrID := range []string{"daily_report", reportID} {
+		got, err := awsCli.readNamedQuery(ctx, nameOrID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("readNamedQuery(%q) = %q, want %q", nameOrID, got, want)
+		}
+	}
+	if _, err := awsCli.readNamedQuery(ctx, "dup", nil); err == nil || !strings.Contains(err.Error(), "use the id of one of them: b, c") {
+		t.Errorf("got error %v, want an ambiguous name", err)
+	}
+	if _, err := awsCli.readNamedQuery(ctx, "missing", nil); err == nil || !strings.Contains(err.Error(), `no saved query "missing" in workgroup "primary"`) {
+		t.Errorf("got error %v, want a missing query", err)
+	}
+}
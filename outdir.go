@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	nameDirective = regexp.MustCompile(`(?m)^\s*--\s*name:\s*(\S+)\s*$`)
+	validName     = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+)
+
+// queryName is the name a "-- name: <name>" line gives a query, "" if it
+// has none.
+func queryName(query string) string {
+	if m := nameDirective.FindStringSubmatch(query); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// queryLabel refers to query i in messages, by its name if it has one.
+func queryLabel(i int, query string) string {
+	if name := queryName(query); name != "" {
+		return "query " + name
+	}
+	return fmt.Sprintf("query %d", i+1)
+}
+
+// outputNames names the output of each query in -out-dir: after its
+// "-- name: <name>" directive, else after the input file, numbered if the
+// file holds several queries.
+func outputNames(queries []string, inputFile string) ([]string, error) {
+	base := "query"
+	if inputFile != "" {
+		base = strings.TrimSuffix(path.Base(inputFile), path.Ext(inputFile))
+	}
+	names := make([]string, len(queries))
+	seen := map[string]int{}
+	for i, query := range queries {
+		name := queryName(query)
+		switch {
+		case name != "":
+		case len(queries) > 1:
+			name = fmt.Sprintf("%s_%d", base, i+1)
+		default:
+			name = base
+		}
+		if !validName.MatchString(name) || name == "." || name == ".." {
+			return nil, fmt.Errorf("query %d: invalid output name %q", i+1, name)
+		}
+		if j, ok := seen[name]; ok {
+			return nil, fmt.Errorf("queries %d and %d: duplicate output name %q", j+1, i+1, name)
+		}
+		seen[name] = i
+		names[i] = name
+	}
+	return names, nil
+}
+
+func outDirPath(dir, name, ext string) string {
+	return strings.TrimRight(dir, "/") + "/" + name + "." + ext
+}
+
+// resultExt is the file extension of query results in format, or of query
+// plans with -explain.
+func resultExt(explain, planFormat, format string) string {
+	switch {
+	case explain == "":
+		return format
+	case planFormat == "json":
+		return "json"
+	}
+	return "txt"
+}
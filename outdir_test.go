@@ -0,0 +1,42 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOutputNames(t *testing.T) {
+	for _, tt := range []struct {
+		queries   []string
+		inputFile string
+		want      []string
+	}{
+		{[]string{"select 1"}, "", []string{"query"}},
+		{[]string{"select 1"}, "sql/daily.sql", []string{"daily"}},
+		{[]string{"select 1", "select 2"}, "daily.sql", []string{"daily_1", "daily_2"}},
+		{[]string{"-- name: users\nselect 1", "select 2"}, "", []string{"users", "query_2"}},
+		{[]string{"select 1 -- name: inline"}, "", []string{"query"}},
+		{[]string{"-- name: a\nselect 1", "--name: a\nselect 2"}, "", nil},
+		{[]string{"-- name: ../a\nselect 1"}, "", nil},
+	} {
+		got, err := outputNames(tt.queries, tt.inputFile)
+		if (err != nil) != (tt.want == nil) || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("outputNames(%q, %q) = %q, %v, want %q", tt.queries, tt.inputFile, got, err, tt.want)
+		}
+	}
+}
+
+func TestQueryLabel(t *testing.T) {
+	for _, tt := range []struct {
+		query string
+		want  string
+	}{
+		{"select 1", "query 3"},
+		{"-- name: daily_revenue\nselect 1", "query daily_revenue"},
+		{"select 1 -- name: inline", "query 3"},
+	} {
+		if got := queryLabel(2, tt.query); got != tt.want {
+			t.Errorf("queryLabel(2, %q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
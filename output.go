@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// outputWriter writes outputs to the urls of one or more schemes.
+type outputWriter interface {
+	writeOutput(awsCli *awsCli, r io.ReadSeeker, u *url.URL) error
+}
+
+// outputWriterFunc is a function used as an outputWriter.
+type outputWriterFunc func(awsCli *awsCli, r io.ReadSeeker, u *url.URL) error
+
+func (f outputWriterFunc) writeOutput(awsCli *awsCli, r io.ReadSeeker, u *url.URL) error {
+	return f(awsCli, r, u)
+}
+
+// outputWriters are the output destinations by url scheme, a new
+// destination only needs to register here.
+var outputWriters = map[string]outputWriter{}
+
+func registerOutputWriter(w outputWriter, schemes ...string) {
+	for _, scheme := range schemes {
+		outputWriters[scheme] = w
+	}
+}
+
+func init() {
+	registerOutputWriter(outputWriterFunc(writeFile), "", "file")
+	registerOutputWriter(outputWriterFunc(writeS3), "s3")
+	registerOutputWriter(outputWriterFunc(writeHTTP), "http", "https")
+}
+
+func (awsCli *awsCli) writeOut(r io.ReadSeeker, outPath string) error {
+	p, err := url.Parse(outPath)
+	if err != nil {
+		return errors.Wrapf(err, "invalid output %q", outPath)
+	}
+	w, ok := outputWriters[p.Scheme]
+	if !ok {
+		return fmt.Errorf("UNKNOWN: schema %q", outPath)
+	}
+	return w.writeOutput(awsCli, r, p)
+}
+
+func writeFile(awsCli *awsCli, r io.ReadSeeker, u *url.URL) error {
+	fileName := path.Join(u.Host, u.Path)
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(fileName), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, data, 0644)
+}
+
+func writeS3(awsCli *awsCli, r io.ReadSeeker, u *url.URL) error {
+	bucket := u.Host
+	key := strings.TrimLeft(u.Path, "/")
+	if bucket == "" || key == "" {
+		return fmt.Errorf("s3 bucket or key empty in %q", u)
+	}
+	input := &s3.PutObjectInput{
+		Body:   r,
+		Bucket: &bucket,
+		Key:    &key,
+	}
+	if len(awsCli.tags) > 0 {
+		tagging := url.Values{}
+		for k, v := range awsCli.tags {
+			tagging.Set(k, v)
+		}
+		input.Tagging = aws.String(tagging.Encode())
+	}
+	req, _ := awsCli.s3For(bucket).PutObjectRequest(input)
+	if awsCli.putChecksums {
+		sum, err := sha256Base64(r)
+		if err != nil {
+			return err
+		}
+		req.HTTPRequest.Header.Set("X-Amz-Checksum-Sha256", sum)
+	}
+	if err := req.Send(); err != nil {
+		return errors.Wrap(err, "could not upload result to s3")
+	}
+	return nil
+}
+
+func writeHTTP(awsCli *awsCli, r io.ReadSeeker, u *url.URL) error {
+	if awsCli.http == nil {
+		return fmt.Errorf("http outputs are not supported here: %q", u)
+	}
+	if err := awsCli.http.send(r, u.String()); err != nil {
+		return errors.Wrapf(err, "could not send result to %s", u.Host)
+	}
+	return nil
+}
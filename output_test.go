@@ -0,0 +1,30 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestOutputWriterRegistry(t *testing.T) {
+	written := map[string]string{}
+	registerOutputWriter(outputWriterFunc(func(awsCli *awsCli, r io.ReadSeeker, u *url.URL) error {
+		data, err := ioutil.ReadAll(r)
+		written[u.Host+u.Path] = string(data)
+		return err
+	}), "mem")
+	defer delete(outputWriters, "mem")
+
+	awsCli := &awsCli{}
+	if err := awsCli.writeOut(strings.NewReader("a,b\n"), "mem://results/out.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if got := written["results/out.csv"]; got != "a,b\n" {
+		t.Errorf("got %q", got)
+	}
+	if err := awsCli.writeOut(strings.NewReader(""), "ftp://host/out.csv"); err == nil {
+		t.Error("no error for an unknown scheme")
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// pager shows the results written to it full screen like less -S, with
+// horizontal scrolling for wide rows. Lines are only read as far as the
+// user scrolls, so writing blocks until they are looked at.
+type pager struct {
+	mu       sync.Mutex
+	more     *sync.Cond
+	pr       *io.PipeReader
+	pw       *io.PipeWriter
+	tty      *os.File
+	sttyMode string
+	rows     int
+	cols     int
+	lines    []string
+	eof      bool
+	want     int
+	top      int
+	left     int
+	end      bool
+	quit     bool
+	exited   chan struct{}
+	done     chan struct{}
+}
+
+func newPager() (*pager, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open terminal")
+	}
+	mode, err := stty(tty, "-g")
+	if err != nil {
+		tty.Close()
+		return nil, errors.Wrap(err, "could not read terminal mode")
+	}
+	if _, err = stty(tty, "cbreak", "-echo"); err != nil {
+		tty.Close()
+		return nil, errors.Wrap(err, "could not set terminal mode")
+	}
+	p := &pager{tty: tty, sttyMode: strings.TrimSpace(mode), rows: 24, cols: 80,
+		exited: make(chan struct{}), done: make(chan struct{})}
+	if size, err := stty(tty, "size"); err == nil {
+		fmt.Sscan(size, &p.rows, &p.cols)
+	}
+	p.more = sync.NewCond(&p.mu)
+	p.pr, p.pw = io.Pipe()
+	p.want = p.page()
+	fmt.Fprint(p.tty, "\033[?1049h\033[?25l")
+	p.render()
+	go p.read()
+	go p.readKeys()
+	return p, nil
+}
+
+func (p *pager) Write(b []byte) (int, error) {
+	return p.pw.Write(b)
+}
+
+// page is the number of lines shown at once, below them is the status line.
+func (p *pager) page() int {
+	if p.rows < 2 {
+		return 1
+	}
+	return p.rows - 1
+}
+
+// read reads lines from the writer while the screen wants more, and
+// discards the rest once the user quit.
+func (p *pager) read() {
+	defer close(p.done)
+	r := bufio.NewReader(p.pr)
+	for {
+		p.mu.Lock()
+		for !p.quit && len(p.lines) >= p.want {
+			p.more.Wait()
+		}
+		quit := p.quit
+		p.mu.Unlock()
+		if quit {
+			io.Copy(ioutil.Discard, r)
+			return
+		}
+		line, err := r.ReadString('\n')
+		p.mu.Lock()
+		if line != "" {
+			p.lines = append(p.lines, strings.TrimRight(line, "\r\n"))
+		}
+		p.eof = err != nil
+		// render once a screen is full and at the end, not every line
+		if p.eof || len(p.lines) == p.want {
+			p.render()
+		}
+		p.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (p *pager) readKeys() {
+	buf := make([]byte, 3)
+	for {
+		n, err := p.tty.Read(buf)
+		if err != nil {
+			return
+		}
+		p.mu.Lock()
+		if p.quit {
+			p.mu.Unlock()
+			return
+		}
+		switch key := string(buf[:n]); key {
+		case "j", "\n", "\033[B":
+			p.scroll(1)
+		case "k", "\033[A":
+			p.scroll(-1)
+		case " ", "f", "\033[6~":
+			p.scroll(p.page())
+		case "b", "\033[5~":
+			p.scroll(-p.page())
+		case "l", "\033[C":
+			p.left += p.cols / 2
+		case "h", "\033[D":
+			if p.left -= p.cols / 2; p.left < 0 {
+				p.left = 0
+			}
+		case "g":
+			p.end = false
+			p.top = 0
+		case "G":
+			p.end = true
+			p.want = math.MaxInt32
+		case "q":
+			p.quit = true
+			close(p.exited)
+		}
+		p.more.Signal()
+		p.render()
+		p.mu.Unlock()
+	}
+}
+
+func (p *pager) scroll(n int) {
+	if p.end {
+		p.end = false
+		p.top = p.last()
+	}
+	p.top += n
+	if p.eof && p.top > p.last() {
+		p.top = p.last()
+	}
+	if p.top < 0 {
+		p.top = 0
+	}
+	p.want = p.top + p.page()
+}
+
+// last is the top line showing the end of the lines read.
+func (p *pager) last() int {
+	if len(p.lines) < p.page() {
+		return 0
+	}
+	return len(p.lines) - p.page()
+}
+
+func (p *pager) render() {
+	if p.quit {
+		return
+	}
+	top := p.top
+	if p.end {
+		top = p.last()
+	}
+	var b strings.Builder
+	b.WriteString("\033[H")
+	for i := top; i < top+p.page(); i++ {
+		if i < len(p.lines) {
+			b.WriteString(window(p.lines[i], p.left, p.cols))
+		}
+		b.WriteString("\033[K\r\n")
+	}
+	bottom := top + p.page()
+	var status string
+	switch {
+	case p.eof && bottom >= len(p.lines):
+		status = fmt.Sprintf("lines %d-%d (END)", top+1, len(p.lines))
+	case bottom > len(p.lines):
+		status = fmt.Sprintf("lines %d-%d (reading)", top+1, bottom)
+	default:
+		status = fmt.Sprintf("lines %d-%d", top+1, bottom)
+	}
+	if p.left > 0 {
+		status += fmt.Sprintf(" column %d", p.left+1)
+	}
+	fmt.Fprintf(&b, "\033[7m%s   (j/k scroll, space/b page, h/l left/right, g/G top/end, q quit)\033[0m\033[K", status)
+	fmt.Fprint(p.tty, b.String())
+}
+
+// window returns the part of a line from column left that fits in width.
+func window(line string, left, width int) string {
+	r := []rune(strings.Replace(line, "\t", "    ", -1))
+	if left >= len(r) {
+		return ""
+	}
+	r = r[left:]
+	if len(r) > width {
+		r = r[:width]
+	}
+	return string(r)
+}
+
+// close ends the results and waits until the user quits the pager.
+func (p *pager) close() {
+	if p == nil {
+		return
+	}
+	p.pw.Close()
+	<-p.exited
+	<-p.done
+	p.restore()
+}
+
+func (p *pager) restore() {
+	if p == nil {
+		return
+	}
+	fmt.Fprint(p.tty, "\033[?25h\033[?1049l")
+	stty(p.tty, p.sttyMode)
+	p.tty.Close()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// policy is a set of rules every statement of a run is checked against
+// before the first one is submitted.
+type policy struct {
+	// Deny refuses statements by their first keyword, optionally only on
+	// some tables.
+	Deny []denyRule `json:"deny"`
+	// ProtectedTables may be read but not written, altered or dropped.
+	ProtectedTables []string `json:"protected_tables"`
+	// MaxUnlimitedResultBytes caps the result of queries without a LIMIT.
+	MaxUnlimitedResultBytes int64 `json:"max_unlimited_result_bytes"`
+}
+
+type denyRule struct {
+	Statements []string `json:"statements"`
+	// Tables are patterns like "prod.*", matched against the last parts of
+	// the table names the statement reads or writes ("" == any table).
+	Tables []string `json:"tables"`
+}
+
+func (awsCli *awsCli) loadPolicy(ctx context.Context, policyPath string) (*policy, error) {
+	data, err := awsCli.readFrom(ctx, policyPath)
+	if err != nil {
+		return nil, err
+	}
+	p := &policy{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(p); err != nil {
+		return nil, errors.Wrap(err, "could not parse policy")
+	}
+	patterns := append([]string{}, p.ProtectedTables...)
+	for _, r := range p.Deny {
+		patterns = append(patterns, r.Tables...)
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid table pattern %q", pattern)
+		}
+	}
+	return p, nil
+}
+
+// check returns the policy violations of the queries.
+func (p *policy) check(queries []string) []string {
+	var violations []string
+	for i, query := range queries {
+		toks := tokenize(query)
+		keyword := firstKeyword(toks)
+		targets := targetTables(toks)
+		tables := append(sourceTables(toks), targets...)
+		for _, r := range p.Deny {
+			if !containsFold(r.Statements, keyword) {
+				continue
+			}
+			if len(r.Tables) == 0 {
+				violations = append(violations, fmt.Sprintf("query %d: %s statements are denied", i+1, keyword))
+				continue
+			}
+			for _, ref := range tables {
+				if matchTable(r.Tables, ref.parts) {
+					violations = append(violations, fmt.Sprintf("query %d: %s on %s is denied", i+1, keyword, ref.name()))
+				}
+			}
+		}
+		for _, ref := range targets {
+			if matchTable(p.ProtectedTables, ref.parts) {
+				violations = append(violations, fmt.Sprintf("query %d: %s is protected", i+1, ref.name()))
+			}
+		}
+	}
+	return violations
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTable matches the last parts of a table name against patterns, so
+// that "prod.*" matches both prod.users and awsdatacatalog.prod.users.
+func matchTable(patterns []string, parts []string) bool {
+	for _, pattern := range patterns {
+		n := strings.Count(pattern, ".") + 1
+		if n > len(parts) {
+			continue
+		}
+		name := strings.ToLower(strings.Join(parts[len(parts)-n:], "."))
+		if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// limitWriter fails writes past max bytes.
+type limitWriter struct {
+	w       io.Writer
+	written int64
+	max     int64
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if w.written+int64(len(p)) > w.max {
+		return 0, fmt.Errorf("result of a query without LIMIT exceeds the policy maximum of %d bytes", w.max)
+	}
+	n, err := w.w.Write(p)
+	w.written += int64(n)
+	return n, err
+}
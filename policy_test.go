@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	p := &policy{
+		Deny: []denyRule{
+			{Statements: []string{"drop", "delete"}, Tables: []string{"prod.*"}},
+			{Statements: []string{"MSCK"}},
+		},
+		ProtectedTables: []string{"users"},
+	}
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"select * from prod.events", nil},
+		{"drop table staging.events", nil},
+		{"drop table if exists prod.events", []string{"query 1: DROP on prod.events is denied"}},
+		{"delete from AwsDataCatalog.PROD.events where dt < '2020'", []string{"query 1: DELETE on awsdatacatalog.prod.events is denied"}},
+		{"msck repair table staging.events", []string{"query 1: MSCK statements are denied"}},
+		{"insert into staging.users select * from staging.new_users", []string{"query 1: staging.users is protected"}},
+		{"insert into staging.report select * from users", nil},
+	} {
+		if got := p.check([]string{tt.query}); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("check(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestLimitWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &limitWriter{w: &buf, max: 4}
+	if _, err := w.Write([]byte("a,b\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("1")); err == nil {
+		t.Error("expected the write past the maximum to fail")
+	}
+	if buf.String() != "a,b\n" {
+		t.Errorf("written %q", buf.String())
+	}
+}
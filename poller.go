@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+const (
+	pollInterval = 500 * time.Millisecond
+	// pollBatchSize is the most ids BatchGetQueryExecution accepts.
+	pollBatchSize = 50
+)
+
+type queryStatus struct {
+	queryExecution *athena.QueryExecution
+	err            error
+}
+
+// statusPoller polls the state of all running queries with one
+// BatchGetQueryExecution call per tick instead of a GetQueryExecution call
+// per query.
+type statusPoller struct {
+	athena *athena.Athena
+	// interval is pollInterval, or the fallback interval when state
+	// changes are received as events, see listenEvents.
+	interval time.Duration
+	mu       sync.Mutex
+	waiting  map[string]chan queryStatus
+	running  bool
+	// types holds the statement types of succeeded queries until they are
+	// taken with statementType.
+	types map[string]string
+}
+
+func newStatusPoller(svc *athena.Athena) *statusPoller {
+	return &statusPoller{athena: svc, interval: pollInterval, waiting: map[string]chan queryStatus{}, types: map[string]string{}}
+}
+
+// statementType returns the type athena determined for a succeeded query:
+// DDL, DML or UTILITY, or "" if the query was not polled.
+func (p *statusPoller) statementType(id string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stmtType := p.types[id]
+	delete(p.types, id)
+	return stmtType
+}
+
+// watch returns a channel receiving the state of the query on every tick
+// until the returned func is called.
+func (p *statusPoller) watch(id string) (<-chan queryStatus, func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c := make(chan queryStatus, 1)
+	p.waiting[id] = c
+	if !p.running {
+		p.running = true
+		go p.poll()
+	}
+	return c, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.waiting, id)
+	}
+}
+
+func (p *statusPoller) poll() {
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+	for range t.C {
+		p.mu.Lock()
+		if len(p.waiting) == 0 {
+			p.running = false
+			p.mu.Unlock()
+			return
+		}
+		var ids []*string
+		for id := range p.waiting {
+			ids = append(ids, aws.String(id))
+		}
+		p.mu.Unlock()
+		for len(ids) > 0 {
+			n := len(ids)
+			if n > pollBatchSize {
+				n = pollBatchSize
+			}
+			p.fetch(ids[:n])
+			ids = ids[n:]
+		}
+	}
+}
+
+func (p *statusPoller) fetch(ids []*string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	var types statementTypes
+	out, err := p.athena.BatchGetQueryExecutionWithContext(ctx, &athena.BatchGetQueryExecutionInput{QueryExecutionIds: ids}, types.decode)
+	if err != nil {
+		for _, id := range ids {
+			p.send(*id, queryStatus{err: err})
+		}
+		return
+	}
+	for _, qe := range out.QueryExecutions {
+		id := aws.StringValue(qe.QueryExecutionId)
+		if aws.StringValue(qe.Status.State) == athena.QueryExecutionStateSucceeded {
+			p.mu.Lock()
+			if _, ok := p.waiting[id]; ok {
+				p.types[id] = types[id]
+			}
+			p.mu.Unlock()
+		}
+		p.send(id, queryStatus{queryExecution: qe})
+	}
+	for _, u := range out.UnprocessedQueryExecutionIds {
+		// retried on the next tick.
+		debugf("could not get the state of %s: %s", aws.StringValue(u.QueryExecutionId), aws.StringValue(u.ErrorMessage))
+	}
+}
+
+// send replaces a state the query has not received yet.
+func (p *statusPoller) send(id string, s queryStatus) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c, ok := p.waiting[id]
+	if !ok {
+		return
+	}
+	select {
+	case <-c:
+	default:
+	}
+	c <- s
+}
+
+// statementTypes are the statement types of a BatchGetQueryExecution
+// response by query id. The vendored sdk does not know the field yet, so
+// decode reads them from the response body before the sdk unmarshals it.
+type statementTypes map[string]string
+
+func (types *statementTypes) decode(r *request.Request) {
+	r.Handlers.Unmarshal.PushFront(func(r *request.Request) {
+		body, err := ioutil.ReadAll(r.HTTPResponse.Body)
+		r.HTTPResponse.Body.Close()
+		r.HTTPResponse.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		out := struct {
+			QueryExecutions []struct{ QueryExecutionId, StatementType string }
+		}{}
+		if json.Unmarshal(body, &out) != nil {
+			return
+		}
+		*types = statementTypes{}
+		for _, qe := range out.QueryExecutions {
+			(*types)[qe.QueryExecutionId] = qe.StatementType
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+func TestStatusPollerBatches(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target := r.Header.Get("X-Amz-Target"); !strings.HasSuffix(target, ".BatchGetQueryExecution") {
+			t.Errorf("unexpected call %s", target)
+		}
+		atomic.AddInt32(&calls, 1)
+		in := struct{ QueryExecutionIds []string }{}
+		json.NewDecoder(r.Body).Decode(&in)
+		var executions []string
+		for _, id := range in.QueryExecutionIds {
+			executions = append(executions, fmt.Sprintf(`{"QueryExecutionId": %q, "StatementType": "DDL", "Status": {"State": "SUCCEEDED"}}`, id))
+		}
+		fmt.Fprintf(w, `{"QueryExecutions": [%s]}`, strings.Join(executions, ","))
+	}))
+	defer srv.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("eu-central-1"),
+		Endpoint:    aws.String(srv.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	p := newStatusPoller(athena.New(sess))
+	var updates []<-chan queryStatus
+	for _, id := range []string{"a", "b", "c"} {
+		c, unwatch := p.watch(id)
+		defer unwatch()
+		updates = append(updates, c)
+	}
+	for i, c := range updates {
+		select {
+		case s := <-c:
+			if s.err != nil || aws.StringValue(s.queryExecution.Status.State) != "SUCCEEDED" {
+				t.Errorf("query %d: %v %v", i, s.queryExecution, s.err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("query %d: no update", i)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("%d BatchGetQueryExecution calls, want 1", n)
+	}
+	if got := p.statementType("a"); got != "DDL" {
+		t.Errorf("got statement type %q, want DDL", got)
+	}
+	if got := p.statementType("a"); got != "" {
+		t.Errorf("statement type %q kept after it was taken", got)
+	}
+}
+
+func TestStatementTypeFromPoller(t *testing.T) {
+	f := newFakeAWS(t)
+	defer f.Close()
+	f.result("select 1", "\"_col0\"\n\"1\"\n")
+	awsCli := f.client()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var buf bytes.Buffer
+	if _, err := awsCli.execQuery(ctx, "select 1", &buf); err != nil || buf.String() != "\"_col0\"\n\"1\"\n" {
+		t.Errorf("select: %v, result %q", err, buf.String())
+	}
+	buf.Reset()
+	if _, err := awsCli.execQuery(ctx, "create table t (id int)", &buf); err != nil || buf.Len() > 0 {
+		t.Errorf("ddl: %v, result %q", err, buf.String())
+	}
+	if n := f.count("GetQueryExecution"); n != 0 {
+		t.Errorf("%d GetQueryExecution calls, want 0", n)
+	}
+	if n := f.count("GETObject"); n != 1 {
+		t.Errorf("%d GetObject calls, want 1", n)
+	}
+}
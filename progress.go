@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+const progressBarWidth = 20
+
+// queryWatcher is notified about the queries of a batch while they run.
+// Calls are serialized, also when queries run in parallel.
+type queryWatcher interface {
+	start(index int, query string)
+	update(index int, queryExecution *athena.QueryExecution)
+	done(index int, queryExecution *athena.QueryExecution, err error)
+}
+
+type queryIndexKey struct{}
+
+// withQueryIndex tags ctx with the position of the query in the batch, so
+// that watchers can tell parallel queries apart.
+func withQueryIndex(ctx context.Context, index int) context.Context {
+	return context.WithValue(ctx, queryIndexKey{}, index)
+}
+
+type unwatchedKey struct{}
+
+// unwatched hides the queries run with ctx, e.g. of hooks, from watchers.
+func unwatched(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unwatchedKey{}, true)
+}
+
+func isUnwatched(ctx context.Context) bool {
+	v, _ := ctx.Value(unwatchedKey{}).(bool)
+	return v
+}
+
+func queryIndex(ctx context.Context) int {
+	index, _ := ctx.Value(queryIndexKey{}).(int)
+	return index
+}
+
+func (awsCli *awsCli) watch(w queryWatcher) {
+	awsCli.watchers = append(awsCli.watchers, w)
+}
+
+func (awsCli *awsCli) startQuery(ctx context.Context, query string) {
+	if isUnwatched(ctx) {
+		return
+	}
+	awsCli.watchMu.Lock()
+	defer awsCli.watchMu.Unlock()
+	for _, w := range awsCli.watchers {
+		w.start(queryIndex(ctx), query)
+	}
+}
+
+func (awsCli *awsCli) updateQuery(ctx context.Context, queryExecution *athena.QueryExecution) {
+	if isUnwatched(ctx) {
+		return
+	}
+	awsCli.watchMu.Lock()
+	defer awsCli.watchMu.Unlock()
+	for _, w := range awsCli.watchers {
+		w.update(queryIndex(ctx), queryExecution)
+	}
+}
+
+func (awsCli *awsCli) queryDone(ctx context.Context, queryExecution *athena.QueryExecution, err error) {
+	if isUnwatched(ctx) {
+		return
+	}
+	awsCli.watchMu.Lock()
+	defer awsCli.watchMu.Unlock()
+	for _, w := range awsCli.watchers {
+		w.done(queryIndex(ctx), queryExecution, err)
+	}
+}
+
+// progress renders a single status line for the running query and the
+// position in the batch.
+type progress struct {
+	w       io.Writer
+	total   int
+	names   []string
+	current int
+	begin   time.Time
+	queued  time.Duration
+	state   string
+	changed time.Time
+}
+
+func newProgress(w io.Writer, queries []string) *progress {
+	p := &progress{w: w, total: len(queries)}
+	for _, query := range queries {
+		p.names = append(p.names, queryName(query))
+	}
+	return p
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *progress) start(index int, query string) {
+	p.current = index + 1
+	p.begin = time.Now()
+	p.changed = p.begin
+	p.queued = 0
+	p.state = "SUBMITTED"
+	p.render(nil)
+}
+
+func (p *progress) update(index int, queryExecution *athena.QueryExecution) {
+	now := time.Now()
+	state := aws.StringValue(queryExecution.Status.State)
+	if p.state == "QUEUED" && state != "QUEUED" {
+		p.queued += now.Sub(p.changed)
+	}
+	if state != p.state {
+		p.state, p.changed = state, now
+	}
+	p.render(queryExecution)
+}
+
+func (p *progress) render(queryExecution *athena.QueryExecution) {
+	done := p.current - 1
+	filled := done * progressBarWidth / p.total
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+
+	queued := p.queued
+	if p.state == "QUEUED" {
+		queued += time.Since(p.changed)
+	}
+	elapsed := time.Since(p.begin)
+	line := fmt.Sprintf("[%s] %d/%d ", bar, p.current, p.total)
+	if name := p.names[p.current-1]; name != "" {
+		line += name + " "
+	}
+	line += fmt.Sprintf("%-9s %v (queued %v, running %v)", p.state,
+		elapsed.Truncate(time.Second), queued.Truncate(time.Second), (elapsed - queued).Truncate(time.Second))
+	if queryExecution != nil && queryExecution.Statistics != nil && queryExecution.Statistics.DataScannedInBytes != nil {
+		line += ", scanned " + formatBytes(*queryExecution.Statistics.DataScannedInBytes)
+	}
+	fmt.Fprintf(p.w, "\r%s\033[K", line)
+}
+
+func (p *progress) done(index int, queryExecution *athena.QueryExecution, err error) {
+	fmt.Fprint(p.w, "\r\033[K")
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+)
+
+// topQueries returns the n most expensive query fingerprints of a report
+// by query that ran more than once.
+func topQueries(rows []*costRow, n int) []*costRow {
+	var top []*costRow
+	for _, r := range rows {
+		if len(top) == n {
+			break
+		}
+		if r.Queries > 1 {
+			top = append(top, r)
+		}
+	}
+	return top
+}
+
+// advisor recommends how to make queries cheaper, with the lint rules and
+// the formats of the tables they read in the glue catalog.
+type advisor struct {
+	glue    *glueClient
+	keys    map[string]*partitionKeys
+	formats map[string]string
+}
+
+func newAdvisor(glue *glueClient) *advisor {
+	return &advisor{glue: glue, keys: map[string]*partitionKeys{}, formats: map[string]string{}}
+}
+
+func (a *advisor) recommend(ctx context.Context, e *historyExecution) ([]string, error) {
+	query := aws.StringValue(e.Query)
+	database, catalog := "default", ""
+	if c := e.QueryExecutionContext; c != nil {
+		if aws.StringValue(c.Database) != "" {
+			database = aws.StringValue(c.Database)
+		}
+		catalog = aws.StringValue(c.Catalog)
+	}
+	var keys *partitionKeys
+	if isGlueCatalog(catalog) {
+		if keys = a.keys[database]; keys == nil {
+			keys = newPartitionKeys(a.glue, database)
+			a.keys[database] = keys
+		}
+	}
+	findings, err := lintQuery(ctx, 0, query, delimsFlag{}, keys)
+	if err != nil {
+		return nil, err
+	}
+	var recommendations []string
+	for _, f := range findings {
+		recommendations = append(recommendations, f.rule+": "+f.message)
+	}
+	if keys == nil {
+		return recommendations, nil
+	}
+	for _, ref := range sourceTables(tokenize(query)) {
+		if len(ref.parts) > 2 && !isGlueCatalog(ref.parts[len(ref.parts)-3]) {
+			continue
+		}
+		db, table := database, ref.parts[len(ref.parts)-1]
+		if len(ref.parts) > 1 {
+			db = ref.parts[len(ref.parts)-2]
+		}
+		format, err := a.format(ctx, db, table)
+		if err != nil {
+			return nil, err
+		}
+		if format != "" {
+			recommendations = append(recommendations, fmt.Sprintf("row-format: %s is stored as %s, convert it to parquet or orc", ref.name(), format))
+		}
+	}
+	return recommendations, nil
+}
+
+// format returns the input format of a table that is not columnar, "" for
+// columnar tables, iceberg tables, views and unknown tables.
+func (a *advisor) format(ctx context.Context, database, table string) (string, error) {
+	name := strings.ToLower(database + "." + table)
+	if format, ok := a.formats[name]; ok {
+		return format, nil
+	}
+	t, err := a.glue.getTable(ctx, database, table)
+	if err != nil && !isNotFound(err) {
+		return "", errors.Wrapf(err, "could not get table %q", name)
+	}
+	format := ""
+	if t != nil && t.StorageDescriptor != nil {
+		format = rowFormat(aws.StringValue(t.StorageDescriptor.InputFormat))
+		if strings.EqualFold(aws.StringValue(t.Parameters["table_type"]), "ICEBERG") {
+			format = ""
+		}
+	}
+	a.formats[name] = format
+	return format, nil
+}
+
+// rowFormat returns the short name of a glue input format unless it is
+// columnar.
+func rowFormat(inputFormat string) string {
+	lower := strings.ToLower(inputFormat)
+	if inputFormat == "" || strings.Contains(lower, "parquet") || strings.Contains(lower, "orc") {
+		return ""
+	}
+	return inputFormat[strings.LastIndex(inputFormat, ".")+1:]
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// secrets are the values of secret template variables and tokens. They are
+// sent to athena but redacted from logs, errors, audit records and reports.
+var secrets = &redactor{}
+
+// minSecretLength keeps short values, which would redact common words and
+// paths all over the logs, from being registered as secrets.
+const minSecretLength = 4
+
+type redactor struct {
+	mu       sync.Mutex
+	values   map[string]bool
+	replacer *strings.Replacer
+}
+
+func (r *redactor) add(value string) {
+	if len(strings.TrimSpace(value)) < minSecretLength {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.values[value] {
+		return
+	}
+	if r.values == nil {
+		r.values = map[string]bool{}
+	}
+	r.values[value] = true
+	// longer values first, so that a secret containing another one is
+	// redacted as a whole.
+	values := make([]string, 0, len(r.values))
+	for v := range r.values {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	var pairs []string
+	for _, v := range values {
+		pairs = append(pairs, v, "[REDACTED]")
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+}
+
+func (r *redactor) redact(s string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.replacer == nil {
+		return s
+	}
+	return r.replacer.Replace(s)
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestRedactor(t *testing.T) {
+	r := &redactor{}
+	if got := r.redact("select 1"); got != "select 1" {
+		t.Errorf("got %q without secrets", got)
+	}
+	r.add("s3cret")
+	r.add("s3cret-admin")
+	r.add(" ")
+	r.add("a/")
+	got := r.redact("select * from t where token = 's3cret' or admin = 's3cret-admin' and a/b")
+	want := "select * from t where token = '[REDACTED]' or admin = '[REDACTED]' and a/b"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTemplaterSecrets(t *testing.T) {
+	defer func() { secrets = &redactor{} }()
+	tmpl := &templater{engine: &choiceFlag{value: "gotemplate"}, vars: tagsFlag{"DB_PASSWORD": "hunter2", "DB": "analytics"}}
+	tmpl.secret.Set("*_PASSWORD")
+	query, err := tmpl.render("select '{{ .DB_PASSWORD }}' from {{ .DB }}.t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query != "select 'hunter2' from analytics.t" {
+		t.Errorf("rendered %q", query)
+	}
+	if got := secrets.redact(query); got != "select '[REDACTED]' from analytics.t" {
+		t.Errorf("redacted %q", got)
+	}
+}
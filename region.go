@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/pkg/errors"
+)
+
+const regionFlagUsage = `aws region ("" == AWS_REGION, AWS_DEFAULT_REGION, shared config or ecs/ec2 metadata)`
+
+var metadataClient = &http.Client{Timeout: time.Second}
+
+// resolveRegion returns region if it is set and otherwise looks it up in the
+// environment, the shared config and the ecs task or ec2 instance metadata.
+func resolveRegion(region string) (string, error) {
+	if region != "" {
+		return region, nil
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: sharedConfigState()})
+	if err != nil {
+		return "", err
+	}
+	if region := aws.StringValue(sess.Config.Region); region != "" {
+		debugf("using region %s from environment or shared config", region)
+		return region, nil
+	}
+	if region := profileRegion(); region != "" {
+		debugf("using region %s from shared config", region)
+		return region, nil
+	}
+	if region := ecsRegion(); region != "" {
+		debugf("using region %s from ecs task metadata", region)
+		return region, nil
+	}
+	metadata := ec2metadata.New(sess, aws.NewConfig().WithHTTPClient(metadataClient).WithMaxRetries(0))
+	if region, err := metadata.Region(); err == nil {
+		debugf("using region %s from ec2 instance metadata", region)
+		return region, nil
+	}
+	return "", errors.New("could not determine aws region, set -region or AWS_REGION")
+}
+
+// ecsRegion returns the region of the ecs task athenaq runs in, taken from the
+// task arn in the task metadata.
+func ecsRegion() string {
+	endpoint := os.Getenv("ECS_CONTAINER_METADATA_URI_V4")
+	if endpoint == "" {
+		endpoint = os.Getenv("ECS_CONTAINER_METADATA_URI")
+	}
+	if endpoint == "" {
+		return ""
+	}
+	resp, err := metadataClient.Get(endpoint + "/task")
+	if err != nil {
+		debugf("could not get ecs task metadata: %v", err)
+		return ""
+	}
+	defer resp.Body.Close()
+	task := struct{ TaskARN string }{}
+	if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
+		debugf("could not decode ecs task metadata: %v", err)
+		return ""
+	}
+	if parts := strings.Split(task.TaskARN, ":"); len(parts) > 3 {
+		return parts[3]
+	}
+	return ""
+}
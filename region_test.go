@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func setenv(env map[string]string) func() {
+	old := map[string]*string{}
+	for k, v := range env {
+		if prev, ok := os.LookupEnv(k); ok {
+			old[k] = &prev
+		} else {
+			old[k] = nil
+		}
+		if v == "" {
+			os.Unsetenv(k)
+		} else {
+			os.Setenv(k, v)
+		}
+	}
+	return func() {
+		for k, v := range old {
+			if v == nil {
+				os.Unsetenv(k)
+			} else {
+				os.Setenv(k, *v)
+			}
+		}
+	}
+}
+
+func TestResolveRegion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq-region")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(config, []byte("[default]\nregion = ap-south-1\n[profile other]\nregion = sa-east-1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "empty")
+	if err := ioutil.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ecs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v4/task" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"TaskARN": "arn:aws:ecs:ca-central-1:123456789012:task/cluster/abc"}`))
+	}))
+	defer ecs.Close()
+
+	for _, tt := range []struct {
+		flag string
+		env  map[string]string
+		want string
+	}{
+		{"eu-west-1", map[string]string{"AWS_REGION": "us-east-1"}, "eu-west-1"},
+		{"", map[string]string{"AWS_REGION": "us-east-1", "AWS_CONFIG_FILE": config}, "us-east-1"},
+		{"", map[string]string{"AWS_DEFAULT_REGION": "us-west-2", "AWS_CONFIG_FILE": empty}, "us-west-2"},
+		{"", map[string]string{"AWS_CONFIG_FILE": config}, "ap-south-1"},
+		{"", map[string]string{"AWS_CONFIG_FILE": config, "AWS_PROFILE": "other"}, "sa-east-1"},
+		{"", map[string]string{"AWS_CONFIG_FILE": empty, "ECS_CONTAINER_METADATA_URI_V4": ecs.URL + "/v4"}, "ca-central-1"},
+	} {
+		env := map[string]string{
+			"AWS_REGION": "", "AWS_DEFAULT_REGION": "", "AWS_PROFILE": "", "AWS_DEFAULT_PROFILE": "",
+			"AWS_CONFIG_FILE": "", "AWS_SHARED_CREDENTIALS_FILE": empty,
+			"ECS_CONTAINER_METADATA_URI": "", "ECS_CONTAINER_METADATA_URI_V4": "",
+		}
+		for k, v := range tt.env {
+			env[k] = v
+		}
+		restore := setenv(env)
+		region, err := resolveRegion(tt.flag)
+		restore()
+		if err != nil || region != tt.want {
+			t.Errorf("resolveRegion(%q) with %v = %q, %v, want %q", tt.flag, tt.env, region, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// renderCmd prints the queries of the input after templating, without
+// contacting aws unless the input is a saved query.
+func renderCmd(args []string) error {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: athenaq render [flags]")
+		fs.PrintDefaults()
+	}
+	var (
+		inputFile  = fs.String("f", "", `input file (""== STDIN | file://... | s3://... | https://... | git+https://repo.git//path?ref=...)`)
+		inline     = queriesFlag{}
+		namedQuery = fs.String("named-query", "", "render the query saved in athena with this name in the workgroup, or id, in its database")
+		awsFlags   = addAWSFlags(fs)
+		templates  = addTemplateFlags(fs)
+		redact     = fs.Bool("redact", false, "replace the values of -secret variables with [REDACTED], e.g. to share the output")
+	)
+	fs.Var(&inline, "query", "render this query instead of the queries of -f or STDIN, split at ; like them (repeatable)")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if len(inline) > 0 && *inputFile != "" {
+		return errors.New("-query and -f are mutually exclusive")
+	}
+	if *namedQuery != "" && (len(inline) > 0 || *inputFile != "") {
+		return errors.New("-named-query, -query and -f are mutually exclusive")
+	}
+
+	// aws is only contacted to read a saved query or an s3 input.
+	var awsCli *awsCli
+	var err error
+	if *namedQuery != "" || strings.HasPrefix(*inputFile, "s3://") {
+		if awsCli, err = newAWS(awsFlags); err != nil {
+			return errors.Wrap(err, "could not initialize aws client")
+		}
+	}
+	var queries []string
+	switch {
+	case len(inline) > 0:
+		queries, err = inline.read(templates)
+	case *namedQuery != "":
+		queries, err = awsCli.readNamedQuery(context.Background(), *namedQuery, templates)
+	default:
+		queries, err = awsCli.readInput(*inputFile, templates)
+	}
+	if err != nil {
+		return err
+	}
+	if len(queries) > 0 {
+		// the rendered SQL is printed as athena gets it, -redact hides the
+		// secrets like in logs.
+		out := strings.Join(queries, ";\n\n") + ";"
+		if *redact {
+			out = secrets.redact(out)
+		}
+		fmt.Println(out)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadQueriesVars(t *testing.T) {
+	os.Setenv("ATHENAQ_TEST_TABLE", "users")
+	os.Setenv("ATHENAQ_TEST_LIMIT", "10")
+	defer os.Unsetenv("ATHENAQ_TEST_TABLE")
+	defer os.Unsetenv("ATHENAQ_TEST_LIMIT")
+
+	in := "select * from {{ .ATHENAQ_TEST_TABLE }} limit {{ .ATHENAQ_TEST_LIMIT }};\n select '{{ .DAY }}'"
+	queries, err := readQueries(strings.NewReader(in), &templater{
+		engine: &choiceFlag{value: "gotemplate"},
+		vars:   tagsFlag{"ATHENAQ_TEST_LIMIT": "5", "DAY": "2024-01-31"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"select * from users limit 5", "select '2024-01-31'"}
+	if !reflect.DeepEqual(queries, want) {
+		t.Errorf("got %q, want %q", queries, want)
+	}
+}
+
+func TestRenderCmdQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	defer func(out *os.File) { os.Stdout = out }(os.Stdout)
+	os.Stdout = f
+
+	err = renderCmd([]string{"-query", "select 'x' as s from t where day = '{{ .DAY }}'; select 1", "-query", "select 2", "-var", "DAY=2024-01-31"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(f.Name())
+	if want := "select 'x' as s from t where day = '2024-01-31';\n\nselect 1;\n\nselect 2;\n"; string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+	if err := renderCmd([]string{"-query", "select 1", "-f", "q.sql"}); err == nil {
+		t.Error("no error for -query with -f")
+	}
+}
+
+func TestRenderCmdRedact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(out *os.File) { os.Stdout = out }(os.Stdout)
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "select 'hunter2';\n"},
+		{[]string{"-redact"}, "select '[REDACTED]';\n"},
+	} {
+		f, err := os.Create(filepath.Join(dir, "stdout"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Stdout = f
+		err = renderCmd(append([]string{"-secret", "*_TOKEN", "-var", "API_TOKEN=hunter2", "-query", "select '{{ .API_TOKEN }}'"}, tt.args...))
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := ioutil.ReadFile(f.Name()); string(data) != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.args, data, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/pkg/errors"
+)
+
+// staleMetadata matches the state change reasons of queries failing on
+// partitions missing from the catalog or removed from s3.
+var staleMetadata = regexp.MustCompile(`HIVE_CURSOR_ERROR|HIVE_PARTITION_SCHEMA_MISMATCH|HIVE_INVALID_METADATA|HIVE_CANNOT_OPEN_SPLIT.*(does not exist|NoSuchKey)|HIVE_UNKNOWN_ERROR.*[Pp]artition`)
+
+// needsRepair reports whether a query failed on stale partition metadata,
+// see -auto-repair.
+func needsRepair(queryExecution *athena.QueryExecution) bool {
+	if queryExecution == nil || queryExecution.Status == nil || aws.StringValue(queryExecution.Status.State) != "FAILED" {
+		return false
+	}
+	return staleMetadata.MatchString(aws.StringValue(queryExecution.Status.StateChangeReason))
+}
+
+// repairStatements returns the MSCK REPAIR TABLE statements for the glue
+// tables read by query: the ones the failure reason names, or all of them if
+// it names none. Unqualified tables are in database.
+func repairStatements(query, reason, database string) []string {
+	var all, named []string
+	seen := map[string]bool{}
+	for _, ref := range sourceTables(tokenize(query)) {
+		if len(ref.parts) > 2 && !isGlueCatalog(ref.parts[len(ref.parts)-3]) {
+			continue
+		}
+		db, table := database, ref.parts[len(ref.parts)-1]
+		if len(ref.parts) > 1 {
+			db = ref.parts[len(ref.parts)-2]
+		}
+		if seen[db+"."+table] {
+			continue
+		}
+		seen[db+"."+table] = true
+		stmt := "MSCK REPAIR TABLE " + quoteDDLIdent(db) + "." + quoteDDLIdent(table)
+		all = append(all, stmt)
+		if strings.Contains(strings.ToLower(reason), strings.ToLower(table)) {
+			named = append(named, stmt)
+		}
+	}
+	if len(named) > 0 {
+		return named
+	}
+	return all
+}
+
+// repair runs the repair statements of a query that failed on stale
+// partition metadata.
+func (awsCli *awsCli) repair(ctx context.Context, query string, queryExecution *athena.QueryExecution, pol *policy) error {
+	if !isGlueCatalog(awsCli.catalog) {
+		return errors.Errorf("catalog %s is not the glue catalog", awsCli.catalog)
+	}
+	database := queryDatabase(ctx)
+	if c := queryExecution.QueryExecutionContext; database == "" && c != nil {
+		database = aws.StringValue(c.Database)
+	}
+	if database == "" {
+		database = "default"
+	}
+	statements := repairStatements(query, aws.StringValue(queryExecution.Status.StateChangeReason), database)
+	if len(statements) == 0 {
+		return errors.New("the query reads no glue tables")
+	}
+	if pol != nil {
+		if violations := pol.check(statements); len(violations) > 0 {
+			return errors.Errorf("the policy denies the repair: %s", strings.Join(violations, ", "))
+		}
+	}
+	for _, stmt := range statements {
+		infof("%s", stmt)
+		if _, err := awsCli.execQuery(unwatched(ctx), stmt, nil); err != nil {
+			return errors.Wrapf(err, "could not run %s", stmt)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+func TestNeedsRepair(t *testing.T) {
+	for _, tt := range []struct {
+		state, reason string
+		want          bool
+	}{
+		{"FAILED", "HIVE_CURSOR_ERROR: Please reduce your request rate.", true},
+		{"FAILED", "HIVE_CANNOT_OPEN_SPLIT: Error opening Hive split s3://b/logs/dt=2024-01-01/a.gz: The specified key does not exist.", true},
+		{"FAILED", "HIVE_PARTITION_SCHEMA_MISMATCH: There is a mismatch between the table and partition schemas.", true},
+		{"FAILED", "SYNTAX_ERROR: line 1:8: Column 'x' cannot be resolved", false},
+		{"CANCELLED", "HIVE_CURSOR_ERROR", false},
+	} {
+		queryExecution := &athena.QueryExecution{Status: &athena.QueryExecutionStatus{State: aws.String(tt.state), StateChangeReason: aws.String(tt.reason)}}
+		if got := needsRepair(queryExecution); got != tt.want {
+			t.Errorf("needsRepair(%s, %q) = %v, want %v", tt.state, tt.reason, got, tt.want)
+		}
+	}
+}
+
+func TestRepairStatements(t *testing.T) {
+	for _, tt := range []struct {
+		query, reason string
+		want          []string
+	}{
+		{"select * from logs l join analytics.users u on l.uid = u.id", "HIVE_CURSOR_ERROR",
+			[]string{"MSCK REPAIR TABLE `web`.`logs`", "MSCK REPAIR TABLE `analytics`.`users`"}},
+		{"select * from logs l join analytics.users u on l.uid = u.id", "HIVE_PARTITION_SCHEMA_MISMATCH: table analytics.users partition dt=2024-01-01",
+			[]string{"MSCK REPAIR TABLE `analytics`.`users`"}},
+		{"with x as (select 1) select * from x, awsdatacatalog.web.logs, mysql.app.users", "HIVE_CURSOR_ERROR",
+			[]string{"MSCK REPAIR TABLE `web`.`logs`"}},
+		{"select 1", "HIVE_CURSOR_ERROR", nil},
+	} {
+		if got := repairStatements(tt.query, tt.reason, "web"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("repairStatements(%q, %q) = %q, want %q", tt.query, tt.reason, got, tt.want)
+		}
+	}
+}
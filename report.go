@@ -0,0 +1,129 @@
+package main
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+type reportQuery struct {
+	Index                 int        `json:"index"`
+	Name                  string     `json:"name,omitempty"`
+	QueryExecutionID      string     `json:"query_execution_id,omitempty"`
+	State                 string     `json:"state"`
+	Started               *time.Time `json:"started,omitempty"`
+	DurationSeconds       float64    `json:"duration_seconds"`
+	QueuedSeconds         float64    `json:"queued_seconds"`
+	EngineExecutionMillis int64      `json:"engine_execution_ms"`
+	DataScannedBytes      int64      `json:"data_scanned_bytes"`
+	// OutputLocation is where the result was written to with -out or
+	// -out-dir, AthenaOutputLocation the csv athena wrote it to first.
+	OutputLocation       string `json:"output_location,omitempty"`
+	AthenaOutputLocation string `json:"athena_output_location,omitempty"`
+	Error                string `json:"error,omitempty"`
+	ErrorClass           string `json:"error_class,omitempty"`
+	Query                string `json:"query"`
+	// changed is when State last changed, to measure the time QUEUED.
+	changed time.Time
+}
+
+// runReport is a machine readable summary of a run, written to -report.
+type runReport struct {
+	RunID    string            `json:"run_id"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Started  time.Time         `json:"started"`
+	Finished time.Time         `json:"finished"`
+	State    string            `json:"state"`
+	Error    string            `json:"error,omitempty"`
+	// ErrorClass is the class of Error, see classifyError.
+	ErrorClass string         `json:"error_class,omitempty"`
+	Queries    []*reportQuery `json:"queries"`
+}
+
+func newRunReport(runID string, tags map[string]string, queries []string) *runReport {
+	r := &runReport{RunID: runID, Tags: tags, Started: time.Now()}
+	for i, q := range queries {
+		r.Queries = append(r.Queries, &reportQuery{Index: i + 1, Name: queryName(q), State: "PENDING", Query: secrets.redact(q)})
+	}
+	return r
+}
+
+func (r *runReport) start(index int, query string) {
+	q := r.Queries[index]
+	q.State = "SUBMITTED"
+	now := time.Now()
+	q.Started = &now
+	q.changed = now
+}
+
+func (r *runReport) update(index int, queryExecution *athena.QueryExecution) {
+	q := r.Queries[index]
+	q.QueryExecutionID = aws.StringValue(queryExecution.QueryExecutionId)
+	now, state := time.Now(), aws.StringValue(queryExecution.Status.State)
+	if q.State == "QUEUED" && state != "QUEUED" {
+		q.QueuedSeconds += now.Sub(q.changed).Seconds()
+	}
+	if state != q.State {
+		q.State, q.changed = state, now
+	}
+	if s := queryExecution.Statistics; s != nil {
+		q.DataScannedBytes = aws.Int64Value(s.DataScannedInBytes)
+		q.EngineExecutionMillis = aws.Int64Value(s.EngineExecutionTimeInMillis)
+	}
+	if c := queryExecution.ResultConfiguration; c != nil {
+		q.AthenaOutputLocation = aws.StringValue(c.OutputLocation)
+	}
+}
+
+// wrote records that the result of query index was written to path. A nil
+// report records nothing.
+func (r *runReport) wrote(index int, path string) {
+	if r != nil {
+		r.Queries[index].OutputLocation = path
+	}
+}
+
+// wroteAll records path as the output of every query that succeeded, for
+// -out, which holds the results of all of them.
+func (r *runReport) wroteAll(path string) {
+	if r == nil {
+		return
+	}
+	for _, q := range r.Queries {
+		if q.State == athena.QueryExecutionStateSucceeded {
+			q.OutputLocation = path
+		}
+	}
+}
+
+func (r *runReport) done(index int, queryExecution *athena.QueryExecution, err error) {
+	if queryExecution != nil {
+		r.update(index, queryExecution)
+	}
+	q := r.Queries[index]
+	q.DurationSeconds = time.Since(*q.Started).Seconds()
+	if err != nil {
+		q.Error = secrets.redact(err.Error())
+		q.ErrorClass = classifyError(err)
+		if q.State != "CANCELLED" {
+			q.State = "FAILED"
+		}
+	}
+}
+
+func (r *runReport) write(awsCli *awsCli, path string, runErr error) error {
+	r.finish(runErr)
+	return awsCli.writeJSON(r, path)
+}
+
+// finish records the outcome of the run.
+func (r *runReport) finish(runErr error) {
+	r.Finished = time.Now()
+	r.State = "SUCCEEDED"
+	if runErr != nil {
+		r.State = "FAILED"
+		r.Error = secrets.redact(runErr.Error())
+		r.ErrorClass = classifyError(runErr)
+	}
+}
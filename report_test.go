@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+func TestRunReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := newRunReport("run-1", map[string]string{"team": "data"}, []string{"select 1", "select x", "select 3"})
+	r.start(0, "select 1")
+	r.update(0, &athena.QueryExecution{
+		QueryExecutionId: aws.String("q-1"),
+		Status:           &athena.QueryExecutionStatus{State: aws.String("RUNNING")},
+	})
+	r.done(0, &athena.QueryExecution{
+		QueryExecutionId:    aws.String("q-1"),
+		Status:              &athena.QueryExecutionStatus{State: aws.String("SUCCEEDED")},
+		Statistics:          &athena.QueryExecutionStatistics{DataScannedInBytes: aws.Int64(42), EngineExecutionTimeInMillis: aws.Int64(7)},
+		ResultConfiguration: &athena.ResultConfiguration{OutputLocation: aws.String("s3://b/q-1.csv")},
+	}, nil)
+	r.start(1, "select x")
+	r.done(1, nil, errors.New("SYNTAX_ERROR"))
+
+	path := filepath.Join(dir, "report.json")
+	if err := r.write(&awsCli{}, path, errors.New("could not execute athena query")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got runReport
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.RunID != "run-1" || got.Tags["team"] != "data" || got.State != "FAILED" || got.Error != "could not execute athena query" || got.Finished.Before(got.Started) {
+		t.Errorf("got run %s %q %v-%v", got.State, got.Error, got.Started, got.Finished)
+	}
+	if len(got.Queries) != 3 {
+		t.Fatalf("got %d queries, want 3", len(got.Queries))
+	}
+	q := got.Queries[0]
+	if q.Index != 1 || q.State != "SUCCEEDED" || q.QueryExecutionID != "q-1" || q.DataScannedBytes != 42 ||
+		q.EngineExecutionMillis != 7 || q.AthenaOutputLocation != "s3://b/q-1.csv" || q.Started == nil || q.Error != "" {
+		t.Errorf("query 1: got %+v", q)
+	}
+	if q := got.Queries[1]; q.State != "FAILED" || q.Error != "SYNTAX_ERROR" {
+		t.Errorf("query 2: got %+v", q)
+	}
+	if q := got.Queries[2]; q.Index != 3 || q.State != "PENDING" || q.Started != nil || q.Query != "select 3" {
+		t.Errorf("query 3: got %+v", q)
+	}
+}
+
+func TestRunReportOutputLocation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fakeDir := filepath.Join(dir, "fake")
+	if err := os.Mkdir(fakeDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(fakeDir, "users.sql"), []byte("select id from users"), 0644)
+	ioutil.WriteFile(filepath.Join(fakeDir, "users.csv"), []byte("\"id\"\n\"1\"\n"), 0644)
+	defer func(args []string, fs *flag.FlagSet) { os.Args, flag.CommandLine = args, fs }(os.Args, flag.CommandLine)
+
+	for _, out := range [][]string{
+		{"-out", "file://" + filepath.Join(dir, "out.csv")},
+		{"-out-dir", "file://" + filepath.Join(dir, "results")},
+	} {
+		reportPath := filepath.Join(dir, "report.json")
+		os.Args = append([]string{"athenaq", "-fake", fakeDir, "-history=false", "-progress=false",
+			"-query", "-- name: users\nselect id from users", "-report", "file://" + reportPath}, out...)
+		flag.CommandLine = flag.NewFlagSet("athenaq", flag.ContinueOnError)
+		if err := run(); err != nil {
+			t.Fatal(err)
+		}
+		var got runReport
+		data, _ := ioutil.ReadFile(reportPath)
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		want := out[1]
+		if out[0] == "-out-dir" {
+			want += "/users.csv"
+		}
+		if q := got.Queries[0]; q.OutputLocation != want || !strings.HasPrefix(q.AthenaOutputLocation, "s3://") {
+			t.Errorf("%s: got output location %q, athena output location %q, want %q", out[0], q.OutputLocation, q.AthenaOutputLocation, want)
+		}
+	}
+}
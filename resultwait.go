@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/advincze/s3path"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pkg/errors"
+)
+
+// resultExtensions are the result objects athena writes when a query
+// succeeds, csv for queries and txt for DDL statements.
+var resultExtensions = []string{".csv", ".txt"}
+
+// resultEvents wakes up the queries waiting for their result object when an
+// s3 event notification reports that it was created.
+type resultEvents struct {
+	mu      sync.Mutex
+	waiting map[string]chan struct{}
+}
+
+func listenResultEvents(svc *sqs.SQS, queueURL string) *resultEvents {
+	e := &resultEvents{waiting: map[string]chan struct{}{}}
+	listenQueue(func() error {
+		return receiveMessages(svc, queueURL, e.notify)
+	})
+	return e
+}
+
+func (e *resultEvents) watch(id string) (<-chan struct{}, func()) {
+	if e == nil {
+		return nil, func() {}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c := make(chan struct{}, 1)
+	e.waiting[id] = c
+	return c, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		delete(e.waiting, id)
+	}
+}
+
+// notify handles an s3 event notification, accepting it if it's of the
+// result object of a waiting query.
+func (e *resultEvents) notify(body string) bool {
+	var n struct {
+		Records []struct {
+			S3 struct {
+				Object struct{ Key string } `json:"object"`
+			} `json:"s3"`
+		}
+	}
+	if err := json.Unmarshal([]byte(body), &n); err != nil {
+		return false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	handled := false
+	for _, r := range n.Records {
+		name := path.Base(r.S3.Object.Key)
+		for _, ext := range resultExtensions {
+			if c, ok := e.waiting[strings.TrimSuffix(name, ext)]; ok && strings.HasSuffix(name, ext) {
+				select {
+				case c <- struct{}{}:
+				default:
+				}
+				handled = true
+			}
+		}
+	}
+	return handled
+}
+
+// waitResultObject waits for a query by waiting for the result object
+// athena writes when it succeeds, for roles that may not call
+// GetQueryExecution. The object is looked up with HeadObject, backing off up
+// to waitInterval, and right away on an s3 event of it. A query that fails
+// writes no result and is waited for until the run times out.
+func (awsCli *awsCli) waitResultObject(ctx context.Context, id string) (*athena.QueryExecution, error) {
+	athenaPath, err := awsCli.resultPath()
+	if err != nil {
+		return nil, err
+	}
+	notified, unwatch := awsCli.resultEvents.watch(id)
+	defer unwatch()
+	for backoff := time.Second; ; backoff *= 2 {
+		for _, ext := range resultExtensions {
+			location := strings.TrimRight(athenaPath, "/") + "/" + id + ext
+			ok, err := awsCli.objectExists(ctx, location)
+			if err != nil {
+				return nil, errors.Wrap(err, "could not wait for the result")
+			}
+			if ok {
+				now := time.Now()
+				queryExecution := &athena.QueryExecution{
+					QueryExecutionId:    aws.String(id),
+					ResultConfiguration: &athena.ResultConfiguration{OutputLocation: aws.String(location)},
+					Status:              &athena.QueryExecutionStatus{State: aws.String("SUCCEEDED"), CompletionDateTime: &now},
+				}
+				awsCli.updateQuery(ctx, queryExecution)
+				return queryExecution, nil
+			}
+		}
+		if backoff > awsCli.waitInterval {
+			backoff = awsCli.waitInterval
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("query got cancelled")
+		case <-notified:
+		case <-time.After(backoff):
+		}
+	}
+}
+
+func (awsCli *awsCli) objectExists(ctx context.Context, location string) (bool, error) {
+	p, err := s3path.Parse(location)
+	if err != nil {
+		return false, err
+	}
+	_, err = awsCli.s3For(p.Bucket).HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: &p.Bucket, Key: &p.Key})
+	if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == "NotFound" || aerr.Code() == "NoSuchKey") {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// resultStatementType is the statement type of a query waited for by its
+// result object, in place of the one GetQueryExecution returns.
+func resultStatementType(queryExecution *athena.QueryExecution) string {
+	if strings.HasSuffix(aws.StringValue(queryExecution.ResultConfiguration.OutputLocation), ".txt") {
+		return "DDL"
+	}
+	return "DML"
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestWaitResultObject(t *testing.T) {
+	f := newFakeAWS(t)
+	defer f.Close()
+	f.result("select id from users", "\"id\"\n\"1\"\n")
+	awsCli := f.client()
+	awsCli.wait, awsCli.waitInterval = "s3", 10*time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// athena runs the queries in the background, nobody asks for their state.
+	go func() {
+		for ctx.Err() == nil {
+			f.mu.Lock()
+			for _, q := range f.queries {
+				f.advance(q)
+			}
+			f.mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	var buf bytes.Buffer
+	queryExecution, err := awsCli.execQuery(ctx, "SELECT id FROM users", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "\"id\"\n\"1\"\n" {
+		t.Errorf("got result %q", got)
+	}
+	if state := aws.StringValue(queryExecution.Status.State); state != "SUCCEEDED" {
+		t.Errorf("got state %s", state)
+	}
+	buf.Reset()
+	if _, err := awsCli.execQuery(ctx, "create table t (id int)", &buf); err != nil || buf.Len() > 0 {
+		t.Errorf("ddl: %v, result %q", err, buf.String())
+	}
+	for _, op := range []string{"GetQueryExecution", "BatchGetQueryExecution"} {
+		if n := f.count(op); n != 0 {
+			t.Errorf("%d %s calls, want 0", n, op)
+		}
+	}
+	if n := f.count("HEADObject"); n < 2 {
+		t.Errorf("%d HeadObject calls, want at least 2", n)
+	}
+}
+
+func TestResultEventsNotify(t *testing.T) {
+	e := &resultEvents{waiting: map[string]chan struct{}{}}
+	c, unwatch := e.watch("q-1")
+	defer unwatch()
+	for body, want := range map[string]bool{
+		`{"Records":[{"eventName":"ObjectCreated:Put","s3":{"object":{"key":"Unsaved/2024/01/01/q-1.csv.metadata"}}}]}`: false,
+		`{"Records":[{"eventName":"ObjectCreated:Put","s3":{"object":{"key":"Unsaved/2024/01/01/q-2.csv"}}}]}`:          false,
+		`{"Service":"Amazon S3","Event":"s3:TestEvent"}`:                                                                false,
+		`{"Records":[{"eventName":"ObjectCreated:Put","s3":{"object":{"key":"Unsaved/2024/01/01/q-1.txt"}}}]}`:          true,
+	} {
+		if got := e.notify(body); got != want {
+			t.Errorf("notify(%s) = %v, want %v", body, got, want)
+		}
+	}
+	select {
+	case <-c:
+	default:
+		t.Error("q-1 not notified")
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/user"
+	"regexp"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/go-ini/ini"
+	"github.com/pkg/errors"
+)
+
+// roleSessionRunID is the run id role session names are rendered with, set
+// by the first client of the run.
+var roleSessionRunID string
+
+const defaultRoleSessionName = "athenaq-{{ .RunID }}"
+
+var invalidRoleSessionChars = regexp.MustCompile(`[^\w+=,.@-]`)
+
+// roleSessionData are the fields of role_session_name templates.
+type roleSessionData struct {
+	RunID   string
+	Profile string
+	User    string
+}
+
+// profileAssumesRole returns whether the profile of the shared config
+// assumes a role, which athenaq does itself instead of the sdk: the sdk
+// fails on chains of more than one role and ignores credential_source.
+//
+// Credentials in the environment take precedence over the profile, unless
+// they are the credential_source of its role chain.
+func profileAssumesRole() bool {
+	cfg, err := loadSharedConfig()
+	if err != nil {
+		return false
+	}
+	s, err := cfg.profile(awsProfile())
+	if err != nil || s.Key("role_arn").String() == "" {
+		return false
+	}
+	if os.Getenv("AWS_ACCESS_KEY_ID") == "" {
+		return true
+	}
+	for i := 0; s != nil && i < 10; i++ {
+		if s.Key("credential_source").String() == "Environment" {
+			return true
+		}
+		if s, err = cfg.profile(s.Key("source_profile").String()); err != nil {
+			return false
+		}
+	}
+	return false
+}
+
+// sharedConfigState is the shared config state of sessions, disabled for
+// profiles that assume roles.
+func sharedConfigState() session.SharedConfigState {
+	if profileAssumesRole() {
+		return session.SharedConfigDisable
+	}
+	return session.SharedConfigEnable
+}
+
+// profileRegion returns the region of the profile, which the sdk doesn't
+// read for profiles that assume roles.
+func profileRegion() string {
+	cfg, err := loadSharedConfig()
+	if err != nil {
+		return ""
+	}
+	s, err := cfg.profile(awsProfile())
+	if err != nil {
+		return ""
+	}
+	return s.Key("region").String()
+}
+
+// roleChainCredentials returns the credentials of the role chain of the
+// profile, from the credentials of the first profile without role_arn or
+// with credential_source through the role_arn of every source_profile, or
+// nil if the profile assumes no role. cfg configures the sts calls.
+func roleChainCredentials(cfg *aws.Config) (*credentials.Credentials, error) {
+	if !profileAssumesRole() {
+		return nil, nil
+	}
+	shared, err := loadSharedConfig()
+	if err != nil {
+		return nil, err
+	}
+	var hops []*ini.Section
+	var names []string
+	name := awsProfile()
+	for {
+		s, err := shared.profile(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range names {
+			if n == name {
+				return nil, fmt.Errorf("the source_profiles of profile %q form a loop", name)
+			}
+		}
+		names = append(names, name)
+		if s.Key("role_arn").String() == "" {
+			break
+		}
+		hops = append([]*ini.Section{s}, hops...)
+		source := s.Key("source_profile").String()
+		if s.Key("credential_source").String() != "" || source == "" || source == name {
+			break
+		}
+		name = source
+	}
+
+	// the first hop is the profile with the base credentials, unless they
+	// are in a profile without role.
+	base := hops[0]
+	if len(names) > len(hops) {
+		if base, err = shared.profile(names[len(names)-1]); err != nil {
+			return nil, err
+		}
+	}
+	creds, err := baseCredentials(shared, names[len(names)-1], base, cfg)
+	if err != nil {
+		return nil, err
+	}
+	for i, s := range hops {
+		creds, err = assumeRoleCredentials(cfg, creds, s, names[len(hops)-1-i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	debugf("assuming %d roles from profile %s", len(hops), names[len(names)-1])
+	return creds, nil
+}
+
+// baseCredentials are the credentials the first role of a chain is assumed
+// with: those of its credential_source, static keys or an sso role of the
+// profile, or those of the shared credentials file.
+func baseCredentials(shared *sharedConfig, name string, s *ini.Section, cfg *aws.Config) (*credentials.Credentials, error) {
+	switch source := s.Key("credential_source").String(); source {
+	case "":
+	case "Environment":
+		return credentials.NewEnvCredentials(), nil
+	case "Ec2InstanceMetadata", "EcsContainer":
+		remote := defaults.Config()
+		remote.MergeIn(cfg)
+		return credentials.NewCredentials(defaults.RemoteCredProvider(*remote, defaults.Handlers())), nil
+	default:
+		return nil, fmt.Errorf("unsupported credential_source %q in profile %q", source, name)
+	}
+	if key := s.Key("aws_access_key_id").String(); key != "" {
+		secret := s.Key("aws_secret_access_key").String()
+		secrets.add(secret)
+		return credentials.NewStaticCredentials(key, secret, s.Key("aws_session_token").String()), nil
+	}
+	if s.Key("sso_account_id").String() != "" {
+		sess, err := shared.profileSSOSession(name)
+		if err != nil {
+			return nil, err
+		}
+		return credentials.NewCredentials(&ssoProvider{session: sess, accountID: s.Key("sso_account_id").String(), roleName: s.Key("sso_role_name").String()}), nil
+	}
+	return credentials.NewSharedCredentials("", name), nil
+}
+
+func assumeRoleCredentials(cfg *aws.Config, creds *credentials.Credentials, s *ini.Section, profile string) (*credentials.Credentials, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *cfg.Copy().WithCredentials(creds),
+		SharedConfigState: session.SharedConfigDisable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	nameTmpl := s.Key("role_session_name").String()
+	if nameTmpl == "" {
+		nameTmpl = defaultRoleSessionName
+	}
+	tmpl, err := template.New(profile).Option("missingkey=error").Parse(nameTmpl)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse role_session_name of profile %q", profile)
+	}
+	p := &roleSessionProvider{
+		AssumeRoleProvider: stscreds.AssumeRoleProvider{
+			Client:       sts.New(sess),
+			RoleARN:      s.Key("role_arn").String(),
+			Duration:     stscreds.DefaultDuration,
+			ExpiryWindow: time.Minute,
+		},
+		name:    tmpl,
+		profile: profile,
+	}
+	if v := s.Key("duration_seconds").String(); v != "" {
+		seconds, err := s.Key("duration_seconds").Int()
+		if err != nil || seconds < 900 {
+			return nil, fmt.Errorf("invalid duration_seconds %q in profile %q", v, profile)
+		}
+		p.Duration = time.Duration(seconds) * time.Second
+	}
+	if id := s.Key("external_id").String(); id != "" {
+		p.ExternalID = aws.String(id)
+	}
+	if serial := s.Key("mfa_serial").String(); serial != "" {
+		p.SerialNumber = aws.String(serial)
+		p.TokenProvider = stscreds.StdinTokenProvider
+	}
+	return credentials.NewCredentials(p), nil
+}
+
+// roleSessionProvider assumes a role with a session name rendered with the
+// run id, so that CloudTrail events of the role can be traced to the run.
+type roleSessionProvider struct {
+	stscreds.AssumeRoleProvider
+	name    *template.Template
+	profile string
+}
+
+func (p *roleSessionProvider) Retrieve() (credentials.Value, error) {
+	name, err := p.sessionName()
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	p.RoleSessionName = name
+	v, err := p.AssumeRoleProvider.Retrieve()
+	if err != nil {
+		return v, errors.Wrapf(err, "could not assume role %s of profile %q", p.RoleARN, p.profile)
+	}
+	return v, nil
+}
+
+// sessionName renders the role session name, with the characters sts
+// rejects replaced and at most 64 characters long.
+func (p *roleSessionProvider) sessionName() (string, error) {
+	d := roleSessionData{RunID: roleSessionRunID, Profile: p.profile}
+	if d.RunID == "" {
+		d.RunID = newRunID(time.Now())
+	}
+	if u, err := user.Current(); err == nil {
+		d.User = u.Username
+	}
+	var b bytes.Buffer
+	if err := p.name.Execute(&b, d); err != nil {
+		return "", errors.Wrapf(err, "could not render role_session_name of profile %q", p.profile)
+	}
+	name := invalidRoleSessionChars.ReplaceAllString(b.String(), "-")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	if len(name) < 2 {
+		return "", fmt.Errorf("role_session_name of profile %q is empty", p.profile)
+	}
+	return name, nil
+}
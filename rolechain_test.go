@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const testRoleChainConfig = `[profile base]
+aws_access_key_id = AKIABASE
+aws_secret_access_key = base-secret
+
+[profile hub]
+role_arn = arn:aws:iam::111111111111:role/hub
+source_profile = base
+
+[profile target]
+role_arn = arn:aws:iam::222222222222:role/athena
+source_profile = hub
+role_session_name = {{ .Profile }}@{{ .RunID }}/x
+external_id = ext-1
+duration_seconds = 3600
+region = eu-west-1
+
+[profile env]
+role_arn = arn:aws:iam::333333333333:role/env
+credential_source = Environment
+
+[profile loop]
+role_arn = arn:aws:iam::444444444444:role/loop
+source_profile = loop2
+
+[profile loop2]
+role_arn = arn:aws:iam::444444444444:role/loop2
+source_profile = loop
+`
+
+func TestRoleChainCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := filepath.Join(dir, "config")
+	ioutil.WriteFile(config, []byte(testRoleChainConfig), 0600)
+	for _, k := range []string{"AWS_CONFIG_FILE", "AWS_PROFILE", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
+		defer os.Setenv(k, os.Getenv(k))
+	}
+	os.Setenv("AWS_CONFIG_FILE", config)
+	os.Setenv("AWS_ACCESS_KEY_ID", "")
+	defer func(runID string) { roleSessionRunID = runID }(roleSessionRunID)
+	roleSessionRunID = "20240101T000000Z-abcd"
+
+	var calls []string
+	signer := regexp.MustCompile(`Credential=(\w+)/`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		role := r.Form.Get("RoleArn")
+		calls = append(calls, fmt.Sprintf("%s %s %s %s %s %s", signer.FindStringSubmatch(r.Header.Get("Authorization"))[1],
+			role, r.Form.Get("RoleSessionName"), r.Form.Get("ExternalId"), r.Form.Get("DurationSeconds"), r.Form.Get("Action")))
+		fmt.Fprintf(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials><AccessKeyId>ASIA%s</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken><Expiration>%s</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>`,
+			strings.ToUpper(role[strings.LastIndex(role, "/")+1:]), time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	defer srv.Close()
+	cfg := aws.NewConfig().WithRegion("eu-west-1").WithEndpoint(srv.URL)
+
+	for _, tc := range []struct {
+		profile  string
+		expected []string
+		key      string
+		err      string
+	}{
+		{"base", nil, "", ""},
+		{"target", []string{
+			"AKIABASE arn:aws:iam::111111111111:role/hub athenaq-20240101T000000Z-abcd  900 AssumeRole",
+			"ASIAHUB arn:aws:iam::222222222222:role/athena target@20240101T000000Z-abcd-x ext-1 3600 AssumeRole",
+		}, "ASIAATHENA", ""},
+		{"env", []string{"AKIAENV arn:aws:iam::333333333333:role/env athenaq-20240101T000000Z-abcd  900 AssumeRole"}, "ASIAENV", ""},
+		{"loop", nil, "", `the source_profiles of profile "loop" form a loop`},
+	} {
+		calls = nil
+		os.Setenv("AWS_PROFILE", tc.profile)
+		os.Setenv("AWS_ACCESS_KEY_ID", "")
+		os.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+		if tc.profile == "env" {
+			os.Setenv("AWS_ACCESS_KEY_ID", "AKIAENV")
+		}
+		creds, err := roleChainCredentials(cfg)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("%s: error = %v, expected %q", tc.profile, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.profile, err)
+			continue
+		}
+		if tc.key == "" {
+			if creds != nil {
+				t.Errorf("%s: expected no role chain", tc.profile)
+			}
+			continue
+		}
+		v, err := creds.Get()
+		if err != nil {
+			t.Errorf("%s: %v", tc.profile, err)
+			continue
+		}
+		if v.AccessKeyID != tc.key || strings.Join(calls, "\n") != strings.Join(tc.expected, "\n") {
+			t.Errorf("%s: %s after calls\n%s\nexpected %s after\n%s", tc.profile, v.AccessKeyID, strings.Join(calls, "\n"), tc.key, strings.Join(tc.expected, "\n"))
+		}
+	}
+
+	os.Setenv("AWS_PROFILE", "target")
+	if region := profileRegion(); region != "eu-west-1" {
+		t.Errorf("profileRegion() = %q", region)
+	}
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKIAENV")
+	if profileAssumesRole() {
+		t.Error("credentials in the environment take precedence over the profile")
+	}
+}
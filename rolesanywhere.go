@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pkg/errors"
+)
+
+// rolesAnywhere is set by the -roles-anywhere.* flags.
+var rolesAnywhere rolesAnywhereConfig
+
+// rolesAnywhereEndpoint is the IAM Roles Anywhere api of a region, replaced
+// in tests.
+var rolesAnywhereEndpoint = func(region string) string {
+	return "https://rolesanywhere." + region + ".amazonaws.com"
+}
+
+type rolesAnywhereConfig struct {
+	certificate    string
+	privateKey     string
+	trustAnchorARN string
+	profileARN     string
+	roleARN        string
+	duration       time.Duration
+}
+
+func addRolesAnywhereFlags(fs *flag.FlagSet) {
+	fs.StringVar(&rolesAnywhere.certificate, "roles-anywhere.certificate", "", "x.509 certificate (pem file) to get credentials with from IAM Roles Anywhere instead of the default credential chain")
+	fs.StringVar(&rolesAnywhere.privateKey, "roles-anywhere.private-key", "", "private key (pem file) of -roles-anywhere.certificate")
+	fs.StringVar(&rolesAnywhere.trustAnchorARN, "roles-anywhere.trust-anchor-arn", "", "trust anchor of -roles-anywhere.certificate")
+	fs.StringVar(&rolesAnywhere.profileARN, "roles-anywhere.profile-arn", "", "IAM Roles Anywhere profile")
+	fs.StringVar(&rolesAnywhere.roleARN, "roles-anywhere.role-arn", "", "role to assume, one of the roles of -roles-anywhere.profile-arn")
+	fs.DurationVar(&rolesAnywhere.duration, "roles-anywhere.session-duration", time.Hour, "duration of the IAM Roles Anywhere credentials, refreshed when they expire")
+}
+
+// credentials returns the credentials of IAM Roles Anywhere, or nil for the
+// default credential chain.
+func (c rolesAnywhereConfig) credentials() (*credentials.Credentials, error) {
+	if c.certificate == "" {
+		return nil, nil
+	}
+	if c.privateKey == "" || c.trustAnchorARN == "" || c.profileARN == "" || c.roleARN == "" {
+		return nil, errors.New("-roles-anywhere.certificate needs -roles-anywhere.private-key, -roles-anywhere.trust-anchor-arn, -roles-anywhere.profile-arn and -roles-anywhere.role-arn")
+	}
+	// arn:aws:rolesanywhere:<region>:<account>:trust-anchor/<id>
+	parts := strings.Split(c.trustAnchorARN, ":")
+	if len(parts) != 6 || parts[0] != "arn" || parts[3] == "" {
+		return nil, fmt.Errorf("invalid -roles-anywhere.trust-anchor-arn %q", c.trustAnchorARN)
+	}
+	certPEM, err := ioutil.ReadFile(c.certificate)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read -roles-anywhere.certificate")
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no certificate in %s", c.certificate)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse %s", c.certificate)
+	}
+	keyPEM, err := ioutil.ReadFile(c.privateKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read -roles-anywhere.private-key")
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse %s", c.privateKey)
+	}
+	switch key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+	default:
+		return nil, fmt.Errorf("unsupported key type %T in %s, expected RSA or ECDSA", key, c.privateKey)
+	}
+	return credentials.NewCredentials(&rolesAnywhereProvider{config: c, region: parts[3], cert: cert, key: key}), nil
+}
+
+// parsePrivateKey parses a pkcs8, pkcs1 or ec private key.
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no pem block")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// rolesAnywhereProvider gets temporary credentials of a role from IAM Roles
+// Anywhere, authenticating with an x.509 certificate and its private key.
+type rolesAnywhereProvider struct {
+	credentials.Expiry
+	config rolesAnywhereConfig
+	region string
+	cert   *x509.Certificate
+	key    crypto.Signer
+}
+
+func (p *rolesAnywhereProvider) Retrieve() (credentials.Value, error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"durationSeconds": int(p.config.duration.Seconds()),
+		"profileArn":      p.config.profileARN,
+		"roleArn":         p.config.roleARN,
+		"trustAnchorArn":  p.config.trustAnchorARN,
+	})
+	req, err := http.NewRequest("POST", rolesAnywhereEndpoint(p.region)+"/sessions", bytes.NewReader(body))
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	if err := p.sign(req, body, time.Now()); err != nil {
+		return credentials.Value{}, err
+	}
+	resp, err := doAWS(req)
+	if err != nil {
+		return credentials.Value{}, errors.Wrap(err, "could not create IAM Roles Anywhere session")
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return credentials.Value{}, fmt.Errorf("could not create IAM Roles Anywhere session: %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	var out struct {
+		CredentialSet []struct {
+			Credentials struct {
+				AccessKeyID     string    `json:"accessKeyId"`
+				SecretAccessKey string    `json:"secretAccessKey"`
+				SessionToken    string    `json:"sessionToken"`
+				Expiration      time.Time `json:"expiration"`
+			} `json:"credentials"`
+		} `json:"credentialSet"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil || len(out.CredentialSet) == 0 {
+		return credentials.Value{}, fmt.Errorf("no credentials in the IAM Roles Anywhere session: %s", data)
+	}
+	creds := out.CredentialSet[0].Credentials
+	p.SetExpiration(creds.Expiration, time.Minute)
+	return credentials.Value{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+		ProviderName:    "RolesAnywhereProvider",
+	}, nil
+}
+
+// sign signs a request with the private key of the certificate, like
+// signature version 4 but with the key instead of a secret.
+func (p *rolesAnywhereProvider) sign(req *http.Request, body []byte, now time.Time) error {
+	algorithm := "AWS4-X509-RSA-SHA256"
+	if _, ok := p.key.(*ecdsa.PrivateKey); ok {
+		algorithm = "AWS4-X509-ECDSA-SHA256"
+	}
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-X509", base64.StdEncoding.EncodeToString(p.cert.Raw))
+
+	signedHeaders := "content-type;host;x-amz-date;x-amz-x509"
+	bodySum := sha256.Sum256(body)
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"content-type:application/json\nhost:" + req.URL.Host + "\nx-amz-date:" + amzDate + "\nx-amz-x509:" + req.Header.Get("X-Amz-X509") + "\n",
+		signedHeaders,
+		hex.EncodeToString(bodySum[:]),
+	}, "\n")
+	canonicalSum := sha256.Sum256([]byte(canonical))
+	scope := amzDate[:8] + "/" + p.region + "/rolesanywhere/aws4_request"
+	toSign := algorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalSum[:])
+
+	digest := sha256.Sum256([]byte(toSign))
+	sig, err := p.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, p.cert.SerialNumber.String(), scope, signedHeaders, hex.EncodeToString(sig)))
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRolesAnywhereCredentials(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaDER := x509.MarshalPKCS1PrivateKey(rsaKey)
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := regexp.MustCompile(`^(AWS4-X509-\w+-SHA256) Credential=(\d+)/(\d{8}/eu-west-1/rolesanywhere/aws4_request), SignedHeaders=([a-z0-9;-]+), Signature=([0-9a-f]+)$`)
+	for _, tc := range []struct {
+		name      string
+		key       crypto.Signer
+		keyPEM    []byte
+		algorithm string
+	}{
+		{"rsa", rsaKey, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: rsaDER}), "AWS4-X509-RSA-SHA256"},
+		{"ecdsa", ecKey, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}), "AWS4-X509-ECDSA-SHA256"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpl := &x509.Certificate{
+				SerialNumber: big.NewInt(4242),
+				Subject:      pkix.Name{CommonName: "scheduler"},
+				NotBefore:    time.Now().Add(-time.Hour),
+				NotAfter:     time.Now().Add(time.Hour),
+			}
+			certDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, tc.key.Public(), tc.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir, err := ioutil.TempDir("", "athenaq")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+			ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600)
+			ioutil.WriteFile(keyFile, tc.keyPEM, 0600)
+
+			var sessions []map[string]interface{}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				m := auth.FindStringSubmatch(r.Header.Get("Authorization"))
+				if r.URL.Path != "/sessions" || m == nil || m[1] != tc.algorithm || m[2] != "4242" {
+					w.WriteHeader(http.StatusForbidden)
+					fmt.Fprintf(w, "unexpected request %s %s", r.URL.Path, r.Header.Get("Authorization"))
+					return
+				}
+				var headers []string
+				for _, h := range strings.Split(m[4], ";") {
+					v := r.Header.Get(h)
+					if h == "host" {
+						v = r.Host
+					}
+					headers = append(headers, h+":"+v+"\n")
+				}
+				bodySum := sha256.Sum256(body)
+				canonicalSum := sha256.Sum256([]byte(strings.Join([]string{r.Method, r.URL.Path, "", strings.Join(headers, ""), m[4], hex.EncodeToString(bodySum[:])}, "\n")))
+				digest := sha256.Sum256([]byte(m[1] + "\n" + r.Header.Get("X-Amz-Date") + "\n" + m[3] + "\n" + hex.EncodeToString(canonicalSum[:])))
+				sig, _ := hex.DecodeString(m[5])
+				valid := false
+				switch key := tc.key.(type) {
+				case *rsa.PrivateKey:
+					valid = rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig) == nil
+				case *ecdsa.PrivateKey:
+					valid = ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig)
+				}
+				if !valid {
+					w.WriteHeader(http.StatusForbidden)
+					fmt.Fprint(w, `{"message":"invalid signature"}`)
+					return
+				}
+				var session map[string]interface{}
+				json.Unmarshal(body, &session)
+				sessions = append(sessions, session)
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprintf(w, `{"credentialSet":[{"credentials":{"accessKeyId":"AKIA%d","secretAccessKey":"secret","sessionToken":"token","expiration":%q}}]}`,
+					len(sessions), time.Now().Add(30*time.Second).UTC().Format(time.RFC3339))
+			}))
+			defer srv.Close()
+			defer func(endpoint func(string) string) { rolesAnywhereEndpoint = endpoint }(rolesAnywhereEndpoint)
+			rolesAnywhereEndpoint = func(region string) string { return srv.URL }
+
+			creds, err := rolesAnywhereConfig{
+				certificate:    certFile,
+				privateKey:     keyFile,
+				trustAnchorARN: "arn:aws:rolesanywhere:eu-west-1:123456789012:trust-anchor/a",
+				profileARN:     "arn:aws:rolesanywhere:eu-west-1:123456789012:profile/p",
+				roleARN:        "arn:aws:iam::123456789012:role/athenaq",
+				duration:       15 * time.Minute,
+			}.credentials()
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err := creds.Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.AccessKeyID != "AKIA1" || v.SessionToken != "token" {
+				t.Errorf("credentials = %+v", v)
+			}
+			if len(sessions) != 1 || sessions[0]["durationSeconds"] != float64(900) || sessions[0]["roleArn"] != "arn:aws:iam::123456789012:role/athenaq" {
+				t.Errorf("sessions = %v", sessions)
+			}
+			// the credentials expire within the minute of the expiry window.
+			if v, _ := creds.Get(); v.AccessKeyID != "AKIA2" {
+				t.Errorf("credentials were not refreshed: %+v", v)
+			}
+		})
+	}
+}
+
+func TestRolesAnywhereConfig(t *testing.T) {
+	for _, tc := range []struct {
+		config rolesAnywhereConfig
+		err    string
+	}{
+		{rolesAnywhereConfig{}, ""},
+		{rolesAnywhereConfig{certificate: "cert.pem"}, "-roles-anywhere.certificate needs"},
+		{rolesAnywhereConfig{certificate: "cert.pem", privateKey: "key.pem", trustAnchorARN: "anchor", profileARN: "p", roleARN: "r"}, `invalid -roles-anywhere.trust-anchor-arn "anchor"`},
+		{rolesAnywhereConfig{certificate: "missing.pem", privateKey: "key.pem", trustAnchorARN: "arn:aws:rolesanywhere:eu-west-1:1:trust-anchor/a", profileARN: "p", roleARN: "r"}, "could not read -roles-anywhere.certificate"},
+	} {
+		creds, err := tc.config.credentials()
+		if tc.err == "" {
+			if err != nil || creds != nil {
+				t.Errorf("%+v: credentials() = %v, %v, expected the default chain", tc.config, creds, err)
+			}
+			continue
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+			t.Errorf("%+v: error = %v, expected %q", tc.config, err, tc.err)
+		}
+	}
+}
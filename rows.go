@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// rowRange is the "N" or "N..M" number of data rows a query must return, M
+// may be left out for no upper bound.
+type rowRange struct {
+	min, max int
+	set      bool
+}
+
+func (r *rowRange) String() string {
+	switch {
+	case !r.set:
+		return ""
+	case r.min == r.max:
+		return strconv.Itoa(r.min)
+	case r.max < 0:
+		return fmt.Sprintf("%d..", r.min)
+	}
+	return fmt.Sprintf("%d..%d", r.min, r.max)
+}
+
+func (r *rowRange) Set(s string) error {
+	bounds := strings.SplitN(s, "..", 2)
+	min, err := strconv.Atoi(bounds[0])
+	if err != nil || min < 0 {
+		return fmt.Errorf("invalid row count %q, expected N or N..M", s)
+	}
+	max := min
+	if len(bounds) == 2 {
+		max = -1
+		if bounds[1] != "" {
+			if max, err = strconv.Atoi(bounds[1]); err != nil || max < min {
+				return fmt.Errorf("invalid row count %q, expected N or N..M", s)
+			}
+		}
+	}
+	r.min, r.max, r.set = min, max, true
+	return nil
+}
+
+func (r *rowRange) contains(n int) bool {
+	return !r.set || n >= r.min && (r.max < 0 || n <= r.max)
+}
+
+// rowCounter counts the data rows of the csv result written through it,
+// newlines in quoted fields don't end a row.
+type rowCounter struct {
+	w       io.Writer
+	quoted  bool
+	lines   int
+	partial bool
+}
+
+func (c *rowCounter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		switch {
+		case b == '"':
+			c.quoted = !c.quoted
+		case b == '\n' && !c.quoted:
+			c.lines++
+			c.partial = false
+			continue
+		}
+		c.partial = true
+	}
+	if c.w == nil {
+		return len(p), nil
+	}
+	return c.w.Write(p)
+}
+
+// rows returns the number of rows without the header.
+func (c *rowCounter) rows() int {
+	n := c.lines
+	if c.partial {
+		n++
+	}
+	if n > 0 {
+		n--
+	}
+	return n
+}
+
+func countRows(data []byte) int {
+	c := &rowCounter{}
+	c.Write(data)
+	return c.rows()
+}
+
+// checkRows fails queries whose result has no rows with -fail-on-empty or
+// a number of rows outside of -expect-rows.
+func checkRows(label string, n int, failOnEmpty bool, expect *rowRange) error {
+	if failOnEmpty && n == 0 {
+		return fmt.Errorf("%s returned no rows, refused by -fail-on-empty", label)
+	}
+	if !expect.contains(n) {
+		return fmt.Errorf("%s returned %d rows, expected %s", label, n, expect)
+	}
+	return nil
+}
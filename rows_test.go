@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+func TestRowRange(t *testing.T) {
+	tests := []struct {
+		value string
+		in    []int
+		out   []int
+		err   bool
+	}{
+		{value: "3", in: []int{3}, out: []int{2, 4}},
+		{value: "1..", in: []int{1, 1000}, out: []int{0}},
+		{value: "0..100", in: []int{0, 100}, out: []int{101}},
+		{value: "5..2", err: true},
+		{value: "-1", err: true},
+		{value: "a..b", err: true},
+	}
+	for _, tt := range tests {
+		r := &rowRange{}
+		err := r.Set(tt.value)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: got error %v", tt.value, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if r.String() != tt.value {
+			t.Errorf("%q: String() = %q", tt.value, r.String())
+		}
+		for _, n := range tt.in {
+			if !r.contains(n) {
+				t.Errorf("%q does not contain %d", tt.value, n)
+			}
+		}
+		for _, n := range tt.out {
+			if r.contains(n) {
+				t.Errorf("%q contains %d", tt.value, n)
+			}
+		}
+	}
+}
+
+func TestCountRows(t *testing.T) {
+	tests := []struct {
+		csv  string
+		rows int
+	}{
+		{"", 0},
+		{"\"id\"\n", 0},
+		{"\"id\"\n\"1\"\n\"2\"\n", 2},
+		{"\"id\"\n\"1\"", 1},
+		{"\"id\",\"note\"\n\"1\",\"two\nlines\"\n", 1},
+		{"\"q\"\n\"say \"\"hi\"\"\n\"\n", 1},
+	}
+	for _, tt := range tests {
+		c := &rowCounter{}
+		// split the writes to count across buffer boundaries
+		for i := 0; i < len(tt.csv); i += 3 {
+			end := i + 3
+			if end > len(tt.csv) {
+				end = len(tt.csv)
+			}
+			c.Write([]byte(tt.csv[i:end]))
+		}
+		if got := c.rows(); got != tt.rows {
+			t.Errorf("%q: got %d rows, want %d", tt.csv, got, tt.rows)
+		}
+	}
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+)
+
+// tableSchema is the declared, or the glue, schema of a table.
+type tableSchema struct {
+	Columns       []schemaColumn `json:"columns"`
+	PartitionKeys []schemaColumn `json:"partition_keys"`
+}
+
+type schemaColumn struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Comment string `json:"comment"`
+}
+
+func schemaCmd(args []string) error {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: athenaq schema [flags] sync db.table schema.json")
+		fmt.Fprintln(os.Stderr, "       athenaq schema [flags] diff <db|db.table> <db|db.table>")
+		fmt.Fprintln(os.Stderr, "       athenaq schema [flags] of")
+		fs.PrintDefaults()
+	}
+	var (
+		awsFlags  = addAWSFlags(fs)
+		dry       = fs.Bool("dry", false, "sync: print the ALTER statements instead of executing them")
+		fromRole  = fs.String("role.from", "", "diff: role arn to read the first schema with, e.g. in another account")
+		toRole    = fs.String("role.to", "", "diff: role arn to read the second schema with")
+		format    = fs.String("format", "text", "diff: output format (text|json)")
+		input     = fs.String("f", "", `of: input file with the query (""== STDIN | file://... | s3://... | https://... | git+https://repo.git//path?ref=...)`)
+		emit      = &choiceFlag{value: "go", choices: []string{"go", "jsonschema", "avro"}}
+		typeName  = fs.String("name", "Result", "of: name of the emitted type")
+		templates = addTemplateFlags(fs)
+	)
+	fs.Var(emit, "emit", "of: emit a go struct, a json schema or an avro record schema of the result (go|jsonschema|avro)")
+	fs.Parse(args)
+	switch {
+	case fs.Arg(0) == "of" && fs.NArg() == 1:
+	case (fs.Arg(0) == "sync" || fs.Arg(0) == "diff") && fs.NArg() == 3:
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	awsCli, err := newAWS(awsFlags)
+	if err != nil {
+		return errors.Wrap(err, "could not initialize aws client")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *awsFlags.timeout)
+	defer cancel()
+
+	switch fs.Arg(0) {
+	case "sync":
+		return awsCli.syncSchema(ctx, fs.Arg(1), fs.Arg(2), *dry)
+	case "of":
+		queries, err := awsCli.readInput(*input, templates)
+		if err != nil {
+			return err
+		}
+		if len(queries) != 1 {
+			return fmt.Errorf("expected one query, got %d", len(queries))
+		}
+		columns, err := awsCli.queryColumns(ctx, queries[0])
+		if err != nil {
+			return err
+		}
+		out, err := emitSchema(emit.value, *typeName, columns)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	from, to := awsCli, awsCli
+	if *fromRole != "" {
+		if from, err = awsCli.assumeRole(*fromRole, awsFlags); err != nil {
+			return errors.Wrapf(err, "could not assume %s", *fromRole)
+		}
+	}
+	if *toRole != "" {
+		if to, err = awsCli.assumeRole(*toRole, awsFlags); err != nil {
+			return errors.Wrapf(err, "could not assume %s", *toRole)
+		}
+	}
+	changes, err := diffSchemas(ctx, from, to, fs.Arg(1), fs.Arg(2))
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "text":
+		for _, c := range changes {
+			fmt.Println(c)
+		}
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if changes == nil {
+			changes = []schemaChange{}
+		}
+		if err := enc.Encode(changes); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	if len(changes) > 0 {
+		return fmt.Errorf("%s and %s differ in %d places", fs.Arg(1), fs.Arg(2), len(changes))
+	}
+	return nil
+}
+
+func (awsCli *awsCli) syncSchema(ctx context.Context, name, schemaPath string, dry bool) error {
+	database, table, err := splitTableName(name)
+	if err != nil {
+		return err
+	}
+	declared, err := awsCli.loadSchema(ctx, schemaPath)
+	if err != nil {
+		return err
+	}
+	t, err := awsCli.glue.getTable(ctx, database, table)
+	if err != nil {
+		return errors.Wrapf(err, "could not get table %s.%s", database, table)
+	}
+	if aws.StringValue(t.TableType) == "VIRTUAL_VIEW" {
+		return fmt.Errorf("%s.%s is a view", database, table)
+	}
+
+	alters, incompatible := diffSchema(database, table, declared, glueSchema(t))
+	if len(incompatible) > 0 {
+		for _, change := range incompatible {
+			errorf("%s.%s: %s", database, table, change)
+		}
+		return fmt.Errorf("%d incompatible schema changes, %s.%s was not altered", len(incompatible), database, table)
+	}
+	if len(alters) == 0 {
+		infof("%s.%s is up to date", database, table)
+		return nil
+	}
+	for _, statement := range alters {
+		if dry {
+			fmt.Println("execute query:", statement)
+			continue
+		}
+		infof("%s", statement)
+		if _, err := awsCli.execQuery(ctx, statement, nil); err != nil {
+			return errors.Wrapf(err, "could not alter %s.%s", database, table)
+		}
+	}
+	return nil
+}
+
+func splitTableName(name string) (database, table string, err error) {
+	parts := strings.Split(name, ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid table name %q, expected db.table", name)
+	}
+	return parts[0], parts[1], nil
+}
+
+func (awsCli *awsCli) loadSchema(ctx context.Context, schemaPath string) (*tableSchema, error) {
+	data, err := awsCli.readFrom(ctx, schemaPath)
+	if err != nil {
+		return nil, err
+	}
+	s := &tableSchema{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(s); err != nil {
+		return nil, errors.Wrap(err, "could not parse schema")
+	}
+	for _, c := range append(s.Columns, s.PartitionKeys...) {
+		if c.Name == "" || c.Type == "" {
+			return nil, fmt.Errorf("invalid schema, column %q needs a name and a type", c.Name)
+		}
+	}
+	return s, nil
+}
+
+func glueSchema(t *glueTable) *tableSchema {
+	columns := func(cs []*glueColumn) []schemaColumn {
+		var columns []schemaColumn
+		for _, c := range cs {
+			columns = append(columns, schemaColumn{
+				Name:    aws.StringValue(c.Name),
+				Type:    aws.StringValue(c.Type),
+				Comment: aws.StringValue(c.Comment),
+			})
+		}
+		return columns
+	}
+	s := &tableSchema{PartitionKeys: columns(t.PartitionKeys)}
+	if t.StorageDescriptor != nil {
+		s.Columns = columns(t.StorageDescriptor.Columns)
+	}
+	return s
+}
+
+// diffSchema returns the statements that alter the actual schema of a table
+// into the declared one. New columns are appended with ADD COLUMNS and
+// comments are changed with CHANGE COLUMN. Everything else, like dropped,
+// retyped or reordered columns and partition keys, would rewrite or break
+// the table and is returned as incompatible.
+func diffSchema(database, table string, declared, actual *tableSchema) (alters, incompatible []string) {
+	name := quoteDDLIdent(database) + "." + quoteDDLIdent(table)
+	existing := map[string]schemaColumn{}
+	for _, c := range actual.Columns {
+		existing[strings.ToLower(c.Name)] = c
+	}
+	isDeclared := map[string]bool{}
+	var added []string
+	var order []string
+	misplaced := false
+	for _, c := range declared.Columns {
+		key := strings.ToLower(c.Name)
+		isDeclared[key] = true
+		a, ok := existing[key]
+		if !ok {
+			added = append(added, fmt.Sprintf("%s %s COMMENT %s", quoteDDLIdent(c.Name), c.Type, quoteDDLString(c.Comment)))
+			continue
+		}
+		if len(added) > 0 && !misplaced {
+			incompatible = append(incompatible, fmt.Sprintf("new columns are declared before column %s, but can only be appended", a.Name))
+			misplaced = true
+		}
+		order = append(order, key)
+		switch {
+		case !sameType(a.Type, c.Type):
+			incompatible = append(incompatible, fmt.Sprintf("column %s has type %s, declared %s", a.Name, a.Type, c.Type))
+		case a.Comment != c.Comment:
+			alters = append(alters, fmt.Sprintf("ALTER TABLE %s CHANGE COLUMN %s %s %s COMMENT %s",
+				name, quoteDDLIdent(a.Name), quoteDDLIdent(a.Name), a.Type, quoteDDLString(c.Comment)))
+		}
+	}
+	var kept []string
+	for _, c := range actual.Columns {
+		if key := strings.ToLower(c.Name); isDeclared[key] {
+			kept = append(kept, key)
+		} else {
+			incompatible = append(incompatible, fmt.Sprintf("column %s is not declared, columns can't be dropped", c.Name))
+		}
+	}
+	if strings.Join(kept, ",") != strings.Join(order, ",") {
+		incompatible = append(incompatible, fmt.Sprintf("columns are declared in the order %s, not %s", strings.Join(order, ", "), strings.Join(kept, ", ")))
+	}
+	if columnsString(declared.PartitionKeys) != columnsString(actual.PartitionKeys) {
+		incompatible = append(incompatible, fmt.Sprintf("partition keys are declared as (%s), not (%s)",
+			columnsString(declared.PartitionKeys), columnsString(actual.PartitionKeys)))
+	}
+	if len(added) > 0 {
+		alters = append(alters, fmt.Sprintf("ALTER TABLE %s ADD COLUMNS (%s)", name, strings.Join(added, ", ")))
+	}
+	return alters, incompatible
+}
+
+func sameType(a, b string) bool {
+	return strings.EqualFold(strings.Replace(a, " ", "", -1), strings.Replace(b, " ", "", -1))
+}
+
+func columnsString(columns []schemaColumn) string {
+	var s []string
+	for _, c := range columns {
+		s = append(s, strings.ToLower(c.Name)+" "+strings.ToLower(strings.Replace(c.Type, " ", "", -1)))
+	}
+	return strings.Join(s, ", ")
+}
+
+// schemaChange is a difference between a table in two databases.
+type schemaChange struct {
+	Table string `json:"table"`
+	// Kind is table, column or partition_key.
+	Kind string `json:"kind"`
+	Name string `json:"name,omitempty"`
+	// Change is added, removed, type or comment.
+	Change string `json:"change"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+func (c schemaChange) String() string {
+	what := strings.Replace(c.Kind, "_", " ", -1)
+	if c.Name != "" {
+		what += " " + c.Name
+	}
+	switch c.Change {
+	case "added":
+		return strings.TrimSpace(fmt.Sprintf("%s: %s added %s", c.Table, what, c.To))
+	case "removed":
+		return strings.TrimSpace(fmt.Sprintf("%s: %s removed %s", c.Table, what, c.From))
+	default:
+		return fmt.Sprintf("%s: %s %s %q -> %q", c.Table, what, c.Change, c.From, c.To)
+	}
+}
+
+// diffSchemas compares two databases, or two tables when both names are
+// qualified, read with the glue clients of from and to.
+func diffSchemas(ctx context.Context, from, to *awsCli, fromName, toName string) ([]schemaChange, error) {
+	if strings.Contains(fromName, ".") != strings.Contains(toName, ".") {
+		return nil, fmt.Errorf("can't compare %s with %s, expected two databases or two tables", fromName, toName)
+	}
+	if !strings.Contains(fromName, ".") {
+		fromTables, err := from.databaseSchemas(ctx, fromName)
+		if err != nil {
+			return nil, err
+		}
+		toTables, err := to.databaseSchemas(ctx, toName)
+		if err != nil {
+			return nil, err
+		}
+		return diffDatabases(fromTables, toTables), nil
+	}
+	var schemas [2]*tableSchema
+	var table string
+	for i, side := range []struct {
+		cli  *awsCli
+		name string
+	}{{from, fromName}, {to, toName}} {
+		database, name, err := splitTableName(side.name)
+		if err != nil {
+			return nil, err
+		}
+		t, err := side.cli.glue.getTable(ctx, database, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not get table %s", side.name)
+		}
+		schemas[i] = glueSchema(t)
+		if table == "" {
+			table = name
+		}
+	}
+	return diffTables(table, schemas[0], schemas[1]), nil
+}
+
+func (awsCli *awsCli) databaseSchemas(ctx context.Context, database string) (map[string]*tableSchema, error) {
+	tables, err := awsCli.glue.getTables(ctx, database)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not get the tables of %s", database)
+	}
+	schemas := map[string]*tableSchema{}
+	for _, t := range tables {
+		schemas[strings.ToLower(aws.StringValue(t.Name))] = glueSchema(t)
+	}
+	return schemas, nil
+}
+
+func diffDatabases(from, to map[string]*tableSchema) []schemaChange {
+	names := map[string]bool{}
+	for name := range from {
+		names[name] = true
+	}
+	for name := range to {
+		names[name] = true
+	}
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	var changes []schemaChange
+	for _, name := range sorted {
+		switch {
+		case from[name] == nil:
+			changes = append(changes, schemaChange{Table: name, Kind: "table", Change: "added"})
+		case to[name] == nil:
+			changes = append(changes, schemaChange{Table: name, Kind: "table", Change: "removed"})
+		default:
+			changes = append(changes, diffTables(name, from[name], to[name])...)
+		}
+	}
+	return changes
+}
+
+func diffTables(table string, from, to *tableSchema) []schemaChange {
+	return append(diffColumns(table, "column", from.Columns, to.Columns),
+		diffColumns(table, "partition_key", from.PartitionKeys, to.PartitionKeys)...)
+}
+
+func diffColumns(table, kind string, from, to []schemaColumn) []schemaChange {
+	var changes []schemaChange
+	toColumns := map[string]schemaColumn{}
+	for _, c := range to {
+		toColumns[strings.ToLower(c.Name)] = c
+	}
+	fromColumns := map[string]bool{}
+	for _, f := range from {
+		key := strings.ToLower(f.Name)
+		fromColumns[key] = true
+		t, ok := toColumns[key]
+		switch {
+		case !ok:
+			changes = append(changes, schemaChange{Table: table, Kind: kind, Name: key, Change: "removed", From: f.Type})
+		case !sameType(f.Type, t.Type):
+			changes = append(changes, schemaChange{Table: table, Kind: kind, Name: key, Change: "type", From: f.Type, To: t.Type})
+		case f.Comment != t.Comment:
+			changes = append(changes, schemaChange{Table: table, Kind: kind, Name: key, Change: "comment", From: f.Comment, To: t.Comment})
+		}
+	}
+	for _, t := range to {
+		if key := strings.ToLower(t.Name); !fromColumns[key] {
+			changes = append(changes, schemaChange{Table: table, Kind: kind, Name: key, Change: "added", To: t.Type})
+		}
+	}
+	return changes
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffSchema(t *testing.T) {
+	actual := &tableSchema{
+		Columns: []schemaColumn{
+			{Name: "id", Type: "bigint"},
+			{Name: "name", Type: "string", Comment: "user name"},
+		},
+		PartitionKeys: []schemaColumn{{Name: "dt", Type: "string"}},
+	}
+	dt := []schemaColumn{{Name: "DT", Type: "STRING"}}
+	for _, tt := range []struct {
+		name         string
+		declared     *tableSchema
+		alters       []string
+		incompatible []string
+	}{
+		{
+			name: "up to date",
+			declared: &tableSchema{Columns: []schemaColumn{
+				{Name: "ID", Type: "BIGINT"},
+				{Name: "name", Type: "string", Comment: "user name"},
+			}, PartitionKeys: dt},
+		},
+		{
+			name: "add columns and change a comment",
+			declared: &tableSchema{Columns: []schemaColumn{
+				{Name: "id", Type: "bigint", Comment: "the user's id"},
+				{Name: "name", Type: "string", Comment: "user name"},
+				{Name: "tags", Type: "array<string>"},
+				{Name: "address", Type: "struct<city: string>", Comment: "home"},
+			}, PartitionKeys: dt},
+			alters: []string{
+				"ALTER TABLE `db`.`users` CHANGE COLUMN `id` `id` bigint COMMENT 'the user\\'s id'",
+				"ALTER TABLE `db`.`users` ADD COLUMNS (`tags` array<string> COMMENT '', `address` struct<city: string> COMMENT 'home')",
+			},
+		},
+		{
+			name: "incompatible",
+			declared: &tableSchema{Columns: []schemaColumn{
+				{Name: "email", Type: "string"},
+				{Name: "id", Type: "int"},
+			}},
+			alters: []string{"ALTER TABLE `db`.`users` ADD COLUMNS (`email` string COMMENT '')"},
+			incompatible: []string{
+				"new columns are declared before column id, but can only be appended",
+				"column id has type bigint, declared int",
+				"column name is not declared, columns can't be dropped",
+				"partition keys are declared as (), not (dt string)",
+			},
+		},
+		{
+			name: "reordered",
+			declared: &tableSchema{Columns: []schemaColumn{
+				{Name: "name", Type: "string", Comment: "user name"},
+				{Name: "id", Type: "bigint"},
+			}, PartitionKeys: dt},
+			incompatible: []string{"columns are declared in the order name, id, not id, name"},
+		},
+	} {
+		alters, incompatible := diffSchema("db", "users", tt.declared, actual)
+		if !reflect.DeepEqual(alters, tt.alters) || !reflect.DeepEqual(incompatible, tt.incompatible) {
+			t.Errorf("%s: diffSchema() = %q, %q, want %q, %q", tt.name, alters, incompatible, tt.alters, tt.incompatible)
+		}
+	}
+}
+
+func TestDiffDatabases(t *testing.T) {
+	from := map[string]*tableSchema{
+		"users": {
+			Columns: []schemaColumn{
+				{Name: "id", Type: "bigint"},
+				{Name: "name", Type: "string", Comment: "name"},
+				{Name: "legacy", Type: "string"},
+			},
+			PartitionKeys: []schemaColumn{{Name: "dt", Type: "string"}},
+		},
+		"orders": {Columns: []schemaColumn{{Name: "id", Type: "bigint"}}},
+	}
+	to := map[string]*tableSchema{
+		"users": {
+			Columns: []schemaColumn{
+				{Name: "ID", Type: "int"},
+				{Name: "name", Type: "string", Comment: "full name"},
+				{Name: "email", Type: "string"},
+			},
+			PartitionKeys: []schemaColumn{{Name: "dt", Type: "string"}, {Name: "region", Type: "string"}},
+		},
+		"events": {Columns: []schemaColumn{{Name: "id", Type: "bigint"}}},
+	}
+	want := []string{
+		"events: table added",
+		"orders: table removed",
+		"users: column id type \"bigint\" -> \"int\"",
+		"users: column name comment \"name\" -> \"full name\"",
+		"users: column legacy removed string",
+		"users: column email added string",
+		"users: partition key region added string",
+	}
+	var got []string
+	for _, c := range diffDatabases(from, to) {
+		got = append(got, c.String())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffDatabases() = %q, want %q", got, want)
+	}
+	if changes := diffDatabases(from, from); len(changes) != 0 {
+		t.Errorf("diffDatabases() of the same database = %v", changes)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var sectionMarker = regexp.MustCompile(`(?m)^[ \t]*--[ \t]*(setup|teardown|transaction|end)[ \t]*$`)
+
+// sections splits the queries of the input into the statements between a
+// "-- setup" or "-- teardown" line and the next section or "-- end" line, and
+// the main statements around them. Main statements between a
+// "-- transaction" line and the next section or "-- end" line are numbered
+// by their group in transactions, starting at 1 (0 == no group).
+func sections(queries []string) (setup, main, teardown []string, transactions []int, err error) {
+	section, group := "", 0
+	for i, query := range queries {
+		matches := sectionMarker.FindAllStringSubmatchIndex(query, -1)
+		if len(matches) > 0 {
+			last := matches[len(matches)-1]
+			if hasStatement(query[:last[0]]) {
+				return nil, nil, nil, nil, fmt.Errorf("query %d: -- %s must not be inside a statement", i+1, query[last[2]:last[3]])
+			}
+			for _, m := range matches {
+				if query[m[2]:m[3]] == "transaction" {
+					group++
+				}
+			}
+			section = query[last[2]:last[3]]
+			query = strings.TrimSpace(sectionMarker.ReplaceAllString(query, ""))
+		}
+		if !hasStatement(query) {
+			continue
+		}
+		switch section {
+		case "setup":
+			setup = append(setup, query)
+		case "teardown":
+			teardown = append(teardown, query)
+		case "transaction":
+			main = append(main, query)
+			transactions = append(transactions, group)
+		default:
+			main = append(main, query)
+			transactions = append(transactions, 0)
+		}
+	}
+	return setup, main, teardown, transactions, nil
+}
+
+// queryNumbers numbers the statements of the input like the report and the
+// logs do, before the statements are rendered: setup and teardown
+// statements and SET athenaq.<setting> statements are not queries of the
+// batch.
+type queryNumbers struct {
+	section string
+	n       int
+}
+
+// next returns the index statement gets among the queries of the batch.
+func (q *queryNumbers) next(statement string) int {
+	if matches := sectionMarker.FindAllStringSubmatch(statement, -1); len(matches) > 0 {
+		q.section = matches[len(matches)-1][1]
+		statement = sectionMarker.ReplaceAllString(statement, "")
+	}
+	i := q.n
+	if hasStatement(statement) && q.section != "setup" && q.section != "teardown" && !isSetting(statement) {
+		q.n++
+	}
+	return i
+}
+
+// hasStatement reports whether s has anything but comments and whitespace.
+func hasStatement(s string) bool {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "--") {
+			return true
+		}
+	}
+	return false
+}
+
+// runSection runs the setup or teardown statements in order and stops at the
+// first that fails. Like hooks, they are not shown to watchers.
+func (awsCli *awsCli) runSection(ctx context.Context, name string, queries []string) error {
+	for i, query := range queries {
+		debugf("%s statement %d: %s", name, i+1, query)
+		if _, err := awsCli.execQuery(unwatched(ctx), query, nil); err != nil {
+			return errors.Wrapf(err, "%s statement %d", name, i+1)
+		}
+	}
+	return nil
+}
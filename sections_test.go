@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSections(t *testing.T) {
+	tests := []struct {
+		input                 string
+		setup, main, teardown []string
+		transactions          []int
+		err                   bool
+	}{
+		{
+			input:        "select 1;select 2",
+			main:         []string{"select 1", "select 2"},
+			transactions: []int{0, 0},
+		},
+		{
+			input: `-- setup
+create table tmp as select * from logs;
+-- end
+select * from tmp;
+-- teardown
+drop table tmp;
+-- end`,
+			setup:        []string{"create table tmp as select * from logs"},
+			main:         []string{"select * from tmp"},
+			teardown:     []string{"drop table tmp"},
+			transactions: []int{0},
+		},
+		{
+			input:        "-- teardown\ndrop table a;\ndrop table b;\n-- setup\ncreate table a (id int);\n-- end\n-- name: a\nselect * from a",
+			setup:        []string{"create table a (id int)"},
+			main:         []string{"-- name: a\nselect * from a"},
+			teardown:     []string{"drop table a", "drop table b"},
+			transactions: []int{0},
+		},
+		{
+			input:        "select 1;\n-- transaction\ninsert into a select 1;\ninsert into b select 1;\n-- end\n-- transaction\ndelete from a;\n-- end\nselect 2",
+			main:         []string{"select 1", "insert into a select 1", "insert into b select 1", "delete from a", "select 2"},
+			transactions: []int{0, 1, 1, 2, 0},
+		},
+		{
+			input: "select 1\n-- teardown\nfrom t",
+			err:   true,
+		},
+	}
+	for _, tt := range tests {
+		queries, err := readQueries(strings.NewReader(tt.input), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		setup, main, teardown, transactions, err := sections(queries)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: got error %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(setup, tt.setup) || !reflect.DeepEqual(main, tt.main) || !reflect.DeepEqual(teardown, tt.teardown) || !reflect.DeepEqual(transactions, tt.transactions) {
+			t.Errorf("%q: got setup %q, main %q, teardown %q, transactions %v", tt.input, setup, main, teardown, transactions)
+		}
+	}
+}
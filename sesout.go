@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/private/protocol/query"
+	"github.com/pkg/errors"
+)
+
+// sesInlineRows is the default of the most rows of a result sent as a
+// table in the mail instead of an attachment.
+const sesInlineRows = 20
+
+func init() {
+	registerOutputWriter(outputWriterFunc(writeSES), "ses")
+}
+
+// sesClient is a minimal Amazon SES client for sending raw mails, built the
+// same way as glueClient with the query protocol.
+type sesClient struct {
+	*client.Client
+}
+
+func newSES(p client.ConfigProvider, cfgs ...*aws.Config) *sesClient {
+	c := p.ClientConfig("email", cfgs...)
+	signingName := c.SigningName
+	if signingName == "" {
+		signingName = "ses"
+	}
+	svc := &sesClient{
+		Client: client.New(
+			*c.Config,
+			metadata.ClientInfo{
+				ServiceName:   "email",
+				SigningName:   signingName,
+				SigningRegion: c.SigningRegion,
+				Endpoint:      c.Endpoint,
+				APIVersion:    "2010-12-01",
+			},
+			c.Handlers,
+		),
+	}
+	svc.Handlers.Sign.PushBackNamed(v4.SignRequestHandler)
+	svc.Handlers.Build.PushBackNamed(query.BuildHandler)
+	svc.Handlers.Unmarshal.PushBackNamed(query.UnmarshalHandler)
+	svc.Handlers.UnmarshalMeta.PushBackNamed(query.UnmarshalMetaHandler)
+	svc.Handlers.UnmarshalError.PushBackNamed(query.UnmarshalErrorHandler)
+	return svc
+}
+
+func (c *sesClient) sendRawEmail(ctx context.Context, raw []byte) (string, error) {
+	out := struct{ MessageId *string }{}
+	req := c.NewRequest(&request.Operation{Name: "SendRawEmail", HTTPMethod: "POST", HTTPPath: "/"}, &struct {
+		RawMessage *struct{ Data []byte }
+	}{&struct{ Data []byte }{raw}}, &out)
+	req.SetContext(ctx)
+	err := req.Send()
+	return aws.StringValue(out.MessageId), err
+}
+
+// writeSES mails an output to ses://to@example.com?subject=...&from=...,
+// more recipients in to parameters. Results of up to inline-rows rows are
+// sent as a table in the mail, others as a csv attachment.
+func writeSES(awsCli *awsCli, r io.ReadSeeker, u *url.URL) error {
+	if awsCli == nil {
+		return fmt.Errorf("ses outputs are not supported here: %q", u)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	raw, err := sesMessage(data, u, time.Now())
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	id, err := newSES(awsCli.session).sendRawEmail(ctx, raw)
+	if err != nil {
+		return errors.Wrap(err, "could not send result with ses")
+	}
+	debugf("sent result to %s, ses message id %s", u.Redacted(), id)
+	return nil
+}
+
+// mailAddress validates a sender or recipient, which would otherwise allow
+// injecting headers into the message.
+func mailAddress(s string) (string, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(s))
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid mail address %q", s)
+	}
+	if addr.Name == "" {
+		return addr.Address, nil
+	}
+	return addr.String(), nil
+}
+
+// sesMessage builds the mime message of a result.
+func sesMessage(data []byte, u *url.URL, now time.Time) ([]byte, error) {
+	params := u.Query()
+	var to []string
+	if u.User != nil && u.Host != "" {
+		to = append(to, u.User.Username()+"@"+u.Host)
+	}
+	for _, v := range params["to"] {
+		to = append(to, strings.Split(v, ",")...)
+	}
+	if len(to) == 0 {
+		return nil, fmt.Errorf("no recipient in %q", u)
+	}
+	for i := range to {
+		addr, err := mailAddress(to[i])
+		if err != nil {
+			return nil, err
+		}
+		to[i] = addr
+	}
+	from := to[0]
+	if v := params.Get("from"); v != "" {
+		addr, err := mailAddress(v)
+		if err != nil {
+			return nil, err
+		}
+		from = addr
+	}
+	subject := params.Get("subject")
+	if subject == "" {
+		subject = "athenaq result"
+	}
+	filename := params.Get("filename")
+	if filename == "" {
+		filename = "result.csv"
+	}
+	inlineRows := sesInlineRows
+	if v := params.Get("inline-rows"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid inline-rows %q in %q", v, u)
+		}
+		inlineRows = n
+	}
+
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%s\r\n\r\n",
+		from, strings.Join(to, ", "), mime.QEncoding.Encode("utf-8", subject), now.Format(time.RFC1123Z), w.Boundary())
+
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err == nil && len(records) <= inlineRows+1 {
+		part, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/html; charset=utf-8"}})
+		if err != nil {
+			return nil, err
+		}
+		io.WriteString(part, htmlTable(records))
+	} else {
+		part, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(part, "The result is attached as %s.\r\n", filename)
+		part, err = w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"text/csv; name=" + strconv.Quote(filename)},
+			"Content-Disposition":       {"attachment; filename=" + strconv.Quote(filename)},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(data)
+		for len(encoded) > 76 {
+			io.WriteString(part, encoded[:76]+"\r\n")
+			encoded = encoded[76:]
+		}
+		io.WriteString(part, encoded+"\r\n")
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// htmlTable renders csv records as a table, the first record as its header.
+func htmlTable(records [][]string) string {
+	var b strings.Builder
+	b.WriteString(`<table border="1" cellpadding="4" style="border-collapse: collapse">` + "\r\n")
+	for i, record := range records {
+		cell := "td"
+		if i == 0 {
+			cell = "th"
+		}
+		b.WriteString("<tr>")
+		for _, v := range record {
+			b.WriteString("<" + cell + ">" + html.EscapeString(v) + "</" + cell + ">")
+		}
+		b.WriteString("</tr>\r\n")
+	}
+	b.WriteString("</table>\r\n")
+	return b.String()
+}
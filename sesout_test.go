@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteSES(t *testing.T) {
+	f := newFakeAWS(t)
+	defer f.Close()
+	awsCli := f.client()
+
+	for _, tt := range []struct {
+		data, out string
+		subject   string
+		to        string
+		parts     []string
+	}{
+		{"\"id\",\"name\"\n\"1\",\"<b>\"\n", "ses://reports@example.com?subject=Daily%20users", "Daily users", "reports@example.com",
+			[]string{"text/html; charset=utf-8:<table border=\"1\" cellpadding=\"4\" style=\"border-collapse: collapse\">\r\n<tr><th>id</th><th>name</th></tr>\r\n<tr><td>1</td><td>&lt;b&gt;</td></tr>"}},
+		{"\"id\"\n\"1\"\n\"2\"\n", "ses://reports@example.com?to=ops@example.com&inline-rows=1&filename=users.csv&from=athena@example.com", "athenaq result", "reports@example.com, ops@example.com",
+			[]string{"text/plain; charset=utf-8:The result is attached as users.csv.", `text/csv; name="users.csv":"id"` + "\n\"1\"\n\"2\"\n"}},
+	} {
+		if err := awsCli.writeOut(strings.NewReader(tt.data), tt.out); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(f.mails[len(f.mails)-1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+		if subject != tt.subject || msg.Header.Get("To") != tt.to {
+			t.Errorf("%s: got subject %q to %q", tt.out, subject, msg.Header.Get("To"))
+		}
+		_, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		r := multipart.NewReader(msg.Body, params["boundary"])
+		for i := 0; ; i++ {
+			p, err := r.NextPart()
+			if err != nil {
+				if i != len(tt.parts) {
+					t.Errorf("%s: %d parts, want %d", tt.out, i, len(tt.parts))
+				}
+				break
+			}
+			body, _ := ioutil.ReadAll(p)
+			if p.Header.Get("Content-Transfer-Encoding") == "base64" {
+				body, _ = base64.StdEncoding.DecodeString(strings.Replace(string(body), "\r\n", "", -1))
+			}
+			got := p.Header.Get("Content-Type") + ":" + string(body)
+			if i < len(tt.parts) && !strings.Contains(got, tt.parts[i]) {
+				t.Errorf("%s: part %d is %q, want %q", tt.out, i, got, tt.parts[i])
+			}
+		}
+	}
+}
+
+func TestSESMessageRejectsHeaderInjection(t *testing.T) {
+	for _, out := range []string{
+		"ses://reports@example.com?from=athena@example.com%0D%0ABcc:%20all@example.com",
+		"ses://reports@example.com?to=ops@example.com%0ABcc:%20all@example.com",
+		"ses://reports@example.com?to=not%20an%20address",
+	} {
+		u, err := url.Parse(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if raw, err := sesMessage([]byte("\"id\"\n\"1\"\n"), u, time.Now()); err == nil {
+			t.Errorf("%s: no error, message %q", out, raw)
+		}
+	}
+	u, _ := url.Parse("ses://reports@example.com?from=Athena%20Reports%20%3Cathena@example.com%3E")
+	raw, err := sesMessage([]byte("\"id\"\n\"1\"\n"), u, time.Now())
+	if err != nil || !bytes.Contains(raw, []byte("From: \"Athena Reports\" <athena@example.com>\r\n")) {
+		t.Errorf("named sender: %v, message %q", err, raw)
+	}
+}
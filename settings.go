@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// querySettings are the settings a query of the batch runs with. They start
+// from the flags and SET athenaq.<setting> statements in the input change
+// them for the statements after them.
+type querySettings struct {
+	database   string
+	limit      int
+	head       int
+	tail       int
+	columns    []string
+	maxOutRows int
+	// outputFormat is csv or json, see -output-format.
+	outputFormat string
+}
+
+var setStatement = regexp.MustCompile(`(?is)^SET\s+(SESSION\s+)?([\w.]+)\s*=\s*(.*?)\s*;?$`)
+
+// isSetting reports whether query is a SET athenaq.<setting> statement,
+// which applySettings removes from the batch.
+func isSetting(query string) bool {
+	m := setStatement.FindStringSubmatch(stripComments(query))
+	return m != nil && strings.HasPrefix(strings.ToLower(m[2]), "athenaq.")
+}
+
+// applySettings removes the SET athenaq.<setting> = <value> and SET SESSION
+// athenaq.<setting> = <value> statements from the queries and returns the
+// settings of each remaining query. DEFAULT resets a setting to its flag.
+func applySettings(queries []string, transactions []int, defaults querySettings) ([]string, []int, []querySettings, error) {
+	var kept []string
+	var keptTransactions []int
+	var settings []querySettings
+	current := defaults
+	for i, query := range queries {
+		m := setStatement.FindStringSubmatch(stripComments(query))
+		if m == nil {
+			kept = append(kept, query)
+			keptTransactions = append(keptTransactions, transactions[i])
+			settings = append(settings, current)
+			continue
+		}
+		name := strings.ToLower(m[2])
+		if !strings.HasPrefix(name, "athenaq.") {
+			if m[1] != "" {
+				return nil, nil, nil, fmt.Errorf("query %d: athena doesn't support SET SESSION %s, only SET athenaq.<setting>", i+1, m[2])
+			}
+			kept = append(kept, query)
+			keptTransactions = append(keptTransactions, transactions[i])
+			settings = append(settings, current)
+			continue
+		}
+		if err := current.set(strings.TrimPrefix(name, "athenaq."), unquoteSetting(m[3]), defaults); err != nil {
+			return nil, nil, nil, fmt.Errorf("query %d: %v", i+1, err)
+		}
+	}
+	return kept, keptTransactions, settings, nil
+}
+
+func (s *querySettings) set(name, value string, defaults querySettings) error {
+	reset := strings.EqualFold(value, "default")
+	switch name {
+	case "database":
+		s.database = value
+		if reset {
+			s.database = defaults.database
+		}
+	case "columns":
+		s.columns = columnList(value)
+		if reset {
+			s.columns = defaults.columns
+		}
+	case "output_format":
+		s.outputFormat = strings.ToLower(value)
+		if reset {
+			s.outputFormat = defaults.outputFormat
+			break
+		}
+		if s.outputFormat != "csv" && s.outputFormat != "json" {
+			return fmt.Errorf("invalid athenaq.output_format %q, expected %s", value, strings.Join(outputFormats, " or "))
+		}
+	case "limit", "head", "tail", "max_output_rows":
+		field, def := &s.limit, defaults.limit
+		switch name {
+		case "head":
+			field, def = &s.head, defaults.head
+		case "tail":
+			field, def = &s.tail, defaults.tail
+		case "max_output_rows":
+			field, def = &s.maxOutRows, defaults.maxOutRows
+		}
+		if reset {
+			*field = def
+			break
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid athenaq.%s %q, expected a number", name, value)
+		}
+		*field = n
+	default:
+		return fmt.Errorf("unknown setting athenaq.%s, expected database, limit, head, tail, columns, max_output_rows or output_format", name)
+	}
+	if s.head > 0 && s.tail > 0 {
+		return fmt.Errorf("athenaq.head and athenaq.tail are mutually exclusive, SET the other to 0 first")
+	}
+	return nil
+}
+
+// key describes the settings that change the result of a query, for the
+// result cache and duplicate detection. The limit is part of the query.
+func (s querySettings) key() string {
+	return fmt.Sprintf("database=%s/head=%d/tail=%d/columns=%s/max-output-rows=%d/output-format=%s", s.database, s.head, s.tail, strings.Join(s.columns, ","), s.maxOutRows, s.outputFormat)
+}
+
+// settingsKeys returns the keys of the settings that differ from the
+// defaults, "" for queries running with the flags.
+func settingsKeys(settings []querySettings, defaults querySettings) []string {
+	keys := make([]string, len(settings))
+	for i, s := range settings {
+		if k := s.key(); k != defaults.key() {
+			keys[i] = k
+		}
+	}
+	return keys
+}
+
+func unquoteSetting(value string) string {
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return strings.Replace(value[1:len(value)-1], "''", "'", -1)
+	}
+	return value
+}
+
+// stripComments drops the comment lines of a statement.
+func stripComments(query string) string {
+	var lines []string
+	for _, line := range strings.Split(query, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "--") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+type databaseKey struct{}
+
+// withDatabase runs the queries of ctx in a database instead of the default.
+func withDatabase(ctx context.Context, database string) context.Context {
+	return context.WithValue(ctx, databaseKey{}, database)
+}
+
+func queryDatabase(ctx context.Context) string {
+	database, _ := ctx.Value(databaseKey{}).(string)
+	return database
+}
+
+// queryContext runs query i in the database set for it, if any.
+func queryContext(ctx context.Context, settings []querySettings, i int) context.Context {
+	if i < len(settings) && settings[i].database != "" {
+		return withDatabase(ctx, settings[i].database)
+	}
+	return ctx
+}
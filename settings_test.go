@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestApplySettings(t *testing.T) {
+	queries := []string{
+		"select 1",
+		"-- sample the events\nSET athenaq.head = 10;",
+		"SET SESSION athenaq.database = 'web_logs'",
+		"select * from events",
+		"set athenaq.head = DEFAULT",
+		"set athenaq.columns = 'id,name'",
+		"SET athenaq.output_format = json",
+		"select * from users",
+	}
+	defaults := querySettings{limit: 100, outputFormat: "csv"}
+	kept, transactions, settings, err := applySettings(queries, make([]int, len(queries)), defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"select 1", "select * from events", "select * from users"}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("got queries %q, want %q", kept, want)
+	}
+	if len(transactions) != 3 {
+		t.Errorf("got %d transaction groups, want 3", len(transactions))
+	}
+	want := []querySettings{
+		{limit: 100, outputFormat: "csv"},
+		{limit: 100, head: 10, database: "web_logs", outputFormat: "csv"},
+		{limit: 100, database: "web_logs", columns: []string{"id", "name"}, outputFormat: "json"},
+	}
+	if !reflect.DeepEqual(settings, want) {
+		t.Errorf("got settings %+v, want %+v", settings, want)
+	}
+	if keys := settingsKeys(settings, defaults); keys[0] != "" || keys[1] == "" {
+		t.Errorf("got keys %q", keys)
+	}
+
+	for _, queries := range [][]string{
+		{"SET SESSION query_max_run_time = '1h'"},
+		{"SET athenaq.head = -1"},
+		{"SET athenaq.format = json"},
+		{"SET athenaq.output_format = xml"},
+		{"SET athenaq.tail = 5", "SET athenaq.head = 5"},
+	} {
+		if _, _, _, err := applySettings(queries, make([]int, len(queries)), defaults); err == nil {
+			t.Errorf("%q: got no error", queries)
+		}
+	}
+}
+
+func TestRunOutputFormatSetting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq-settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fakeDir := filepath.Join(dir, "fake")
+	if err := os.Mkdir(fakeDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(fakeDir, "users.sql"), []byte("select id from users"), 0644)
+	ioutil.WriteFile(filepath.Join(fakeDir, "users.csv"), []byte("\"id\"\n\"1\"\n"), 0644)
+	defer func(args []string, fs *flag.FlagSet) { os.Args, flag.CommandLine = args, fs }(os.Args, flag.CommandLine)
+
+	os.Args = []string{"athenaq", "-fake", fakeDir, "-history=false", "-progress=false", "-out-dir", "file://" + dir,
+		"-query", "-- name: csv\nselect id from users; SET athenaq.output_format = json; -- name: json\nselect id from users"}
+	flag.CommandLine = flag.NewFlagSet("athenaq", flag.ContinueOnError)
+	if err := run(); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"csv.csv": "\"id\"\n\"1\"\n", "json.json": `{"id":"1"}` + "\n"} {
+		if data, err := ioutil.ReadFile(filepath.Join(dir, name)); err != nil || string(data) != want {
+			t.Errorf("%s: got %q, %v, want %q", name, data, err, want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// sftpCommand is the sftp client outputs are uploaded with, replaced in
+// tests.
+var sftpCommand = "sftp"
+
+func init() {
+	registerOutputWriter(outputWriterFunc(writeSFTP), "sftp")
+}
+
+// writeSFTP uploads an output to sftp://user@host[:port]/path with the
+// sftp client of openssh, authenticated with the key of -out.ssh-key or of
+// the ssh agent and config. The path is absolute, /~/path is relative to
+// the home directory.
+func writeSFTP(awsCli *awsCli, r io.ReadSeeker, u *url.URL) error {
+	remote := strings.TrimPrefix(u.Path, "/~/")
+	if u.Host == "" || remote == "" || strings.HasSuffix(remote, "/") {
+		return fmt.Errorf("sftp host or path empty in %q", u)
+	}
+	f, err := ioutil.TempFile("", "athenaq-sftp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	// the parent directories are created, "-" ignores those that exist.
+	var batch []string
+	for dir := path.Dir(remote); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		batch = append([]string{"-mkdir " + sftpQuote(dir)}, batch...)
+	}
+	batch = append(batch, "put "+sftpQuote(f.Name())+" "+sftpQuote(remote))
+
+	args := []string{"-b", "-", "-o", "BatchMode=yes"}
+	if awsCli != nil && awsCli.sshKey != "" {
+		args = append(args, "-i", awsCli.sshKey)
+	}
+	if u.Port() != "" {
+		args = append(args, "-P", u.Port())
+	}
+	dest := u.Hostname()
+	if u.User != nil {
+		dest = u.User.Username() + "@" + dest
+	}
+	// a destination starting with "-" would be taken as an option, e.g.
+	// -oProxyCommand=... running a command of the url's choosing.
+	if strings.HasPrefix(dest, "-") {
+		return fmt.Errorf("invalid sftp host %q", dest)
+	}
+	cmd := exec.Command(sftpCommand, append(args, "--", dest)...)
+	cmd.Stdin = strings.NewReader(strings.Join(batch, "\n") + "\n")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out))), "could not upload result to %s", u.Redacted())
+	}
+	return nil
+}
+
+// sftpQuote quotes a path of an sftp batch command.
+func sftpQuote(p string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(p) + `"`
+}
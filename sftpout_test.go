@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestWriteSFTP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// the fake sftp records its arguments and batch, and the uploaded file.
+	script := "#!/bin/sh\necho \"$@\" > " + dir + "/args\ncat > " + dir + "/batch\n" +
+		"sed -n 's/^put \"\\([^\"]*\\)\".*/\\1/p' " + dir + "/batch | xargs cat > " + dir + "/uploaded\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "sftp"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(command string) { sftpCommand = command }(sftpCommand)
+	sftpCommand = filepath.Join(dir, "sftp")
+
+	awsCli := &awsCli{sshKey: "/keys/partner"}
+	if err := awsCli.writeOut(strings.NewReader("a,b\n"), "sftp://acme@sftp.example.com:2222/~/drop/daily.csv"); err != nil {
+		t.Fatal(err)
+	}
+	read := func(name string) string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	if got, want := read("args"), "-b - -o BatchMode=yes -i /keys/partner -P 2222 -- acme@sftp.example.com\n"; got != want {
+		t.Errorf("got args %q, want %q", got, want)
+	}
+	if batch := read("batch"); !regexp.MustCompile(`^-mkdir "drop"\nput ".*athenaq-sftp\d+" "drop/daily.csv"\n$`).MatchString(batch) {
+		t.Errorf("got batch %q", batch)
+	}
+	if got := read("uploaded"); got != "a,b\n" {
+		t.Errorf("uploaded %q, want %q", got, "a,b\n")
+	}
+
+	os.Remove(filepath.Join(dir, "args"))
+	for _, out := range []string{"sftp://-oProxyCommand=touch%20pwned@host/daily.csv", "sftp://-oProxyCommand=x/daily.csv"} {
+		if err := awsCli.writeOut(strings.NewReader("a,b\n"), out); err == nil {
+			t.Errorf("no error for %s", out)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "args")); err == nil {
+		t.Error("sftp ran for a host starting with -")
+	}
+}
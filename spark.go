@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/pkg/errors"
+)
+
+// Athena for Apache Spark is newer than the vendored athena client; the
+// types below mirror the athena API.
+
+type sparkEngineConfiguration struct {
+	MaxConcurrentDpus      *int64
+	CoordinatorDpuSize     *int64
+	DefaultExecutorDpuSize *int64
+}
+
+type sparkStatus struct {
+	State             *string
+	StateChangeReason *string
+}
+
+func (s *sparkStatus) state() (string, string) {
+	if s == nil {
+		return "", ""
+	}
+	return aws.StringValue(s.State), aws.StringValue(s.StateChangeReason)
+}
+
+type calculationResult struct {
+	StdOutS3Uri   *string
+	StdErrorS3Uri *string
+	ResultS3Uri   *string
+	ResultType    *string
+}
+
+type calculationExecution struct {
+	CalculationExecutionId *string
+	SessionId              *string
+	Status                 *sparkStatus
+	Result                 *calculationResult
+}
+
+func sparkCmd(args []string) error {
+	fs := flag.NewFlagSet("spark", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, `Usage: athenaq spark [flags] start
+       athenaq spark [flags] run <file.py|notebook.ipynb|->
+       athenaq spark [flags] stop <session id>`)
+		fs.PrintDefaults()
+	}
+	var (
+		awsRegion   = fs.String("region", "", regionFlagUsage)
+		workGroup   = fs.String("workgroup", "", "spark enabled athena workgroup")
+		session     = fs.String("session", "", `session to run calculations in ("" == start a session for the run and terminate it afterwards)`)
+		maxDPUs     = fs.Int64("max-dpus", 20, "maximum DPUs of a started session")
+		idleTimeout = fs.Int64("idle-timeout", 15, "minutes a started session may idle before athena terminates it")
+		timeout     = fs.Duration("timeout", time.Minute*60, "spark calculation timeout")
+	)
+	addLogFlags(fs)
+	fs.Parse(args)
+	switch {
+	case fs.Arg(0) == "start" && fs.NArg() == 1:
+	case fs.Arg(0) == "run" && fs.NArg() == 2:
+	case fs.Arg(0) == "stop" && fs.NArg() == 2:
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	sess, err := newSession(*awsRegion)
+	if err != nil {
+		return err
+	}
+	awsCli := newBareAWS(sess)
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	switch fs.Arg(0) {
+	case "stop":
+		return terminateSession(ctx, awsCli.athena, fs.Arg(1))
+	case "start":
+		if *workGroup == "" {
+			return errors.New("-workgroup is required to start a session")
+		}
+		id, err := startSession(ctx, awsCli.athena, *workGroup, *maxDPUs, *idleTimeout)
+		if err != nil {
+			return err
+		}
+		fmt.Println(id)
+		return nil
+	}
+
+	blocks, err := readCodeBlocks(fs.Arg(1))
+	if err != nil {
+		return errors.Wrapf(err, "could not read %q", fs.Arg(1))
+	}
+	sessionID := *session
+	if sessionID == "" {
+		if *workGroup == "" {
+			return errors.New("-workgroup or -session is required")
+		}
+		sessionID, err = startSession(ctx, awsCli.athena, *workGroup, *maxDPUs, *idleTimeout)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if terr := terminateSession(context.Background(), awsCli.athena, sessionID); terr != nil {
+				errorf("could not terminate session %s: %v", sessionID, terr)
+			}
+		}()
+	}
+	for i, code := range blocks {
+		if err := awsCli.runCalculation(ctx, sessionID, code); err != nil {
+			return errors.Wrapf(err, "calculation %d", i+1)
+		}
+	}
+	return nil
+}
+
+// readCodeBlocks returns the code of a python file as one block, or the code
+// cells of a jupyter notebook as one block each.
+func readCodeBlocks(path string) ([]string, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".ipynb") {
+		return []string{string(data)}, nil
+	}
+	notebook := struct {
+		Cells []struct {
+			CellType string          `json:"cell_type"`
+			Source   json.RawMessage `json:"source"`
+		} `json:"cells"`
+	}{}
+	if err := json.Unmarshal(data, &notebook); err != nil {
+		return nil, errors.Wrap(err, "could not parse notebook")
+	}
+	var blocks []string
+	for _, cell := range notebook.Cells {
+		if cell.CellType != "code" {
+			continue
+		}
+		// the source of a cell is either a string or a list of lines.
+		var lines []string
+		if err := json.Unmarshal(cell.Source, &lines); err != nil {
+			var source string
+			if err := json.Unmarshal(cell.Source, &source); err != nil {
+				return nil, errors.Wrap(err, "could not parse notebook cell")
+			}
+			lines = []string{source}
+		}
+		if code := strings.Join(lines, ""); strings.TrimSpace(code) != "" {
+			blocks = append(blocks, code)
+		}
+	}
+	return blocks, nil
+}
+
+func startSession(ctx context.Context, svc *athena.Athena, workGroup string, maxDPUs, idleTimeout int64) (string, error) {
+	out := struct{ SessionId *string }{}
+	err := sendAthena(ctx, svc, "StartSession", &struct {
+		ClientRequestToken          *string `idempotencyToken:"true"`
+		WorkGroup                   *string
+		EngineConfiguration         *sparkEngineConfiguration
+		SessionIdleTimeoutInMinutes *int64
+	}{
+		WorkGroup:                   &workGroup,
+		EngineConfiguration:         &sparkEngineConfiguration{MaxConcurrentDpus: &maxDPUs},
+		SessionIdleTimeoutInMinutes: &idleTimeout,
+	}, &out)
+	if err != nil {
+		return "", errors.Wrap(err, "could not start session")
+	}
+	id := aws.StringValue(out.SessionId)
+	infof("started session %s", id)
+
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return id, fmt.Errorf("session %s did not become idle", id)
+		case <-t.C:
+		}
+		status := struct{ Status *sparkStatus }{}
+		err := sendAthena(ctx, svc, "GetSessionStatus", &struct{ SessionId *string }{&id}, &status)
+		if err != nil {
+			return id, errors.Wrap(err, "could not get session status")
+		}
+		state, reason := status.Status.state()
+		debugf("session %s %s", id, state)
+		switch state {
+		case "IDLE":
+			return id, nil
+		case "TERMINATING", "TERMINATED", "DEGRADED", "FAILED":
+			return id, fmt.Errorf("session %s %s: %s", id, state, reason)
+		}
+	}
+}
+
+func terminateSession(ctx context.Context, svc *athena.Athena, id string) error {
+	err := sendAthena(ctx, svc, "TerminateSession", &struct{ SessionId *string }{&id}, &struct{ State *string }{})
+	if err != nil {
+		return errors.Wrap(err, "could not terminate session")
+	}
+	infof("terminated session %s", id)
+	return nil
+}
+
+// runCalculation submits code to the session, waits for it and prints its
+// stdout, or its stderr if it failed.
+func (awsCli *awsCli) runCalculation(ctx context.Context, sessionID, code string) error {
+	start := struct{ CalculationExecutionId *string }{}
+	err := sendAthena(ctx, awsCli.athena, "StartCalculationExecution", &struct {
+		ClientRequestToken *string `idempotencyToken:"true"`
+		SessionId          *string
+		CodeBlock          *string
+	}{SessionId: &sessionID, CodeBlock: &code}, &start)
+	if err != nil {
+		return errors.Wrap(err, "could not start calculation")
+	}
+	id := aws.StringValue(start.CalculationExecutionId)
+
+	t := time.NewTicker(time.Millisecond * 500)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			sendAthena(context.Background(), awsCli.athena, "StopCalculationExecution", &struct{ CalculationExecutionId *string }{&id}, &struct{}{})
+			return fmt.Errorf("calculation %s got cancelled", id)
+		case <-t.C:
+		}
+		calc := &calculationExecution{}
+		err := sendAthena(ctx, awsCli.athena, "GetCalculationExecution", &struct{ CalculationExecutionId *string }{&id}, calc)
+		if err != nil {
+			return errors.Wrap(err, "could not get calculation status")
+		}
+		state, reason := calc.Status.state()
+		debugf("calculation %s %s", id, state)
+		switch state {
+		case "COMPLETED":
+			if calc.Result != nil && calc.Result.StdOutS3Uri != nil {
+				if err := awsCli.copyS3Contents(ctx, *calc.Result.StdOutS3Uri, os.Stdout); err != nil {
+					return errors.Wrap(err, "could not get stdout")
+				}
+			}
+			if calc.Result != nil && calc.Result.ResultS3Uri != nil {
+				infof("calculation %s result: %s", id, *calc.Result.ResultS3Uri)
+			}
+			return nil
+		case "FAILED", "CANCELED":
+			if calc.Result != nil && calc.Result.StdErrorS3Uri != nil {
+				awsCli.copyS3Contents(ctx, *calc.Result.StdErrorS3Uri, os.Stderr)
+			}
+			return fmt.Errorf("calculation %s %s: %s", id, state, reason)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadCodeBlocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	notebook := filepath.Join(dir, "job.ipynb")
+	ioutil.WriteFile(notebook, []byte(`{"cells": [
+		{"cell_type": "markdown", "source": ["# job"]},
+		{"cell_type": "code", "source": ["df = spark.sql('select 1')\n", "df.show()"]},
+		{"cell_type": "code", "source": ""},
+		{"cell_type": "code", "source": "print(1)"}
+	]}`), 0644)
+	blocks, err := readCodeBlocks(notebook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"df = spark.sql('select 1')\ndf.show()", "print(1)"}; !reflect.DeepEqual(blocks, want) {
+		t.Errorf("readCodeBlocks(notebook) = %q, want %q", blocks, want)
+	}
+
+	script := filepath.Join(dir, "job.py")
+	ioutil.WriteFile(script, []byte("print(1)\n"), 0644)
+	blocks, err = readCodeBlocks(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"print(1)\n"}; !reflect.DeepEqual(blocks, want) {
+		t.Errorf("readCodeBlocks(script) = %q, want %q", blocks, want)
+	}
+}
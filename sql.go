@@ -0,0 +1,447 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenSpace tokenKind = iota
+	tokenComment
+	tokenWord
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenSymbol
+	tokenTemplate
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func (t token) is(keywords ...string) bool {
+	if t.kind != tokenWord {
+		return false
+	}
+	for _, k := range keywords {
+		if strings.EqualFold(t.text, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t token) significant() bool {
+	return t.kind != tokenSpace && t.kind != tokenComment
+}
+
+// tokenize splits sql into tokens, keeping whitespace and comments so that
+// joinTokens(tokenize(s)) == s.
+func tokenize(sql string) []token {
+	return tokenizeDelims(sql, delimsFlag{})
+}
+
+// tokenizeDelims is tokenize for templates with the -template-delims delims,
+// whose actions are kept as template tokens too.
+func tokenizeDelims(sql string, delims delimsFlag) []token {
+	var toks []token
+	rs := []rune(sql)
+	left := []rune(delims[0])
+	for i := 0; i < len(rs); {
+		start := i
+		kind := tokenSymbol
+		switch r := rs[i]; {
+		case len(left) > 0 && strings.HasPrefix(string(rs[i:]), delims[0]):
+			kind = tokenTemplate
+			i = skipPast(rs, i+len(left), delims[1])
+		case unicode.IsSpace(r):
+			kind = tokenSpace
+			for i < len(rs) && unicode.IsSpace(rs[i]) {
+				i++
+			}
+		case r == '-' && i+1 < len(rs) && rs[i+1] == '-':
+			kind = tokenComment
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			kind = tokenComment
+			i += 2
+			for i < len(rs) && !(rs[i] == '*' && i+1 < len(rs) && rs[i+1] == '/') {
+				i++
+			}
+			i += 2
+			if i > len(rs) {
+				i = len(rs)
+			}
+		case r == '{' && i+1 < len(rs) && strings.ContainsRune("{%#", rs[i+1]):
+			// go template actions and jinja expressions, tags and comments.
+			kind = tokenTemplate
+			i = skipPast(rs, i+2, map[rune]string{'{': "}}", '%': "%}", '#': "#}"}[rs[i+1]])
+		case r == '\'' || r == '"' || r == '`':
+			kind = tokenIdent
+			if r == '\'' {
+				kind = tokenString
+			}
+			i++
+			for i < len(rs) {
+				if rs[i] == r {
+					if i+1 < len(rs) && rs[i+1] == r {
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				i++
+			}
+		case unicode.IsDigit(r):
+			kind = tokenNumber
+			for i < len(rs) && (unicode.IsDigit(rs[i]) || rs[i] == '.' || rs[i] == 'e' || rs[i] == 'E') {
+				i++
+			}
+		case isWordRune(r):
+			kind = tokenWord
+			for i < len(rs) && (isWordRune(rs[i]) || unicode.IsDigit(rs[i]) || rs[i] == '$') {
+				i++
+			}
+		default:
+			i++
+		}
+		toks = append(toks, token{kind: kind, text: string(rs[start:i])})
+	}
+	return toks
+}
+
+// skipPast returns the index after the first closing at or after i, or the
+// end of rs.
+func skipPast(rs []rune, i int, closing string) int {
+	if end := strings.Index(string(rs[i:]), closing); end >= 0 {
+		return i + len([]rune(string(rs[i:])[:end])) + len([]rune(closing))
+	}
+	return len(rs)
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func joinTokens(toks []token) string {
+	var b strings.Builder
+	for _, t := range toks {
+		b.WriteString(t.text)
+	}
+	return b.String()
+}
+
+// nextToken returns the index of the first significant token at or after i.
+func nextToken(toks []token, i int) int {
+	for i < len(toks) && !toks[i].significant() {
+		i++
+	}
+	return i
+}
+
+func unquoteIdent(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '`') {
+		q := s[:1]
+		return strings.Replace(s[1:len(s)-1], q+q, q, -1)
+	}
+	return strings.ToLower(s)
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
+
+// quoteDDLIdent quotes an identifier for hive DDL statements (DROP, ALTER, ...),
+// which do not accept double quotes.
+func quoteDDLIdent(s string) string {
+	return "`" + strings.Replace(s, "`", "``", -1) + "`"
+}
+
+// quoteDDLString quotes a string literal, e.g. a comment, for hive DDL
+// statements, which escape with backslashes.
+func quoteDDLString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// tableRef is a (possibly qualified) table name referenced in a statement,
+// spanning toks[start:end].
+type tableRef struct {
+	parts      []string
+	start, end int
+	// implicit is set for tables joined with a comma in the FROM clause.
+	implicit bool
+	// scope is the select the table is read by, see selectScopes.
+	scope int
+}
+
+func (r tableRef) name() string {
+	return strings.Join(r.parts, ".")
+}
+
+func parseQualifiedName(toks []token, i int) (tableRef, bool) {
+	ref := tableRef{start: i}
+	for {
+		if i >= len(toks) || (toks[i].kind != tokenWord && toks[i].kind != tokenIdent) {
+			return ref, false
+		}
+		ref.parts = append(ref.parts, unquoteIdent(toks[i].text))
+		i++
+		ref.end = i
+		if i >= len(toks) || toks[i].text != "." {
+			return ref, true
+		}
+		i++
+	}
+}
+
+var clauseKeywords = []string{
+	"WHERE", "GROUP", "ORDER", "HAVING", "LIMIT", "OFFSET", "FETCH", "WINDOW",
+	"UNION", "INTERSECT", "EXCEPT", "ON", "USING", "SELECT", "VALUES",
+}
+
+// sourceTables returns the tables read by the statement, i.e. the ones named
+// in FROM and JOIN clauses. CTE names are skipped.
+func sourceTables(toks []token) []tableRef {
+	ctes := cteNames(toks)
+	scopes := selectScopes(toks)
+	var (
+		refs     []tableRef
+		depth    int
+		inSelect = map[int]bool{}
+		inFrom   = map[int]bool{}
+		expect   bool
+		implicit bool
+	)
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		if !t.significant() {
+			continue
+		}
+		if expect {
+			expect = false
+			if ref, ok := parseQualifiedName(toks, i); ok {
+				if j := nextToken(toks, ref.end); j >= len(toks) || toks[j].text != "(" {
+					if len(ref.parts) > 1 || !ctes[ref.parts[0]] {
+						ref.implicit = implicit
+						ref.scope = scopes[ref.start]
+						refs = append(refs, ref)
+					}
+					i = ref.end - 1
+					continue
+				}
+			}
+		}
+		switch {
+		case t.text == "(":
+			depth++
+		case t.text == ")":
+			delete(inSelect, depth)
+			delete(inFrom, depth)
+			depth--
+		case t.text == ",":
+			expect = inFrom[depth]
+			implicit = expect
+		case t.is("SELECT"):
+			inSelect[depth] = true
+			inFrom[depth] = false
+		case t.is("FROM") && inSelect[depth]:
+			inFrom[depth] = true
+			expect = true
+			implicit = false
+		case t.is("JOIN"):
+			inFrom[depth] = true
+			expect = true
+			implicit = false
+		case t.is("USING") && depth == 0 && firstKeyword(toks) == "MERGE":
+			expect = true
+			implicit = false
+		case t.is(clauseKeywords...):
+			inFrom[depth] = false
+		}
+	}
+	return refs
+}
+
+// selectScopes numbers the SELECTs of the statement and returns the one each
+// token belongs to. A SELECT lasts until the next one at the same depth or the
+// end of its parentheses; parentheses without a SELECT, e.g. in a condition,
+// stay in the enclosing one. Tokens outside any SELECT are in scope 0.
+func selectScopes(toks []token) []int {
+	scopes := make([]int, len(toks))
+	stack := []int{0}
+	n := 0
+	for i, t := range toks {
+		switch {
+		case t.text == "(":
+			stack = append(stack, stack[len(stack)-1])
+		case t.text == ")" && len(stack) > 1:
+			stack = stack[:len(stack)-1]
+		case t.is("SELECT"):
+			n++
+			stack[len(stack)-1] = n
+		}
+		scopes[i] = stack[len(stack)-1]
+	}
+	return scopes
+}
+
+// predicateColumns returns the lower cased names of the columns used in
+// WHERE and HAVING conditions (and the ON condition of MERGE) by select scope.
+// Join conditions do not filter the tables they join and are left out.
+func predicateColumns(toks []token) map[int]map[string]bool {
+	scopes := selectScopes(toks)
+	merge := firstKeyword(toks) == "MERGE"
+	cols := map[int]map[string]bool{}
+	inPredicate := map[int]bool{}
+	for i, t := range toks {
+		s := scopes[i]
+		switch {
+		case t.is("WHERE", "HAVING"), t.is("ON") && merge && s == 0:
+			inPredicate[s] = true
+		case t.is(clauseKeywords...), t.is("FROM", "JOIN"):
+			inPredicate[s] = false
+		case inPredicate[s] && (t.kind == tokenWord || t.kind == tokenIdent):
+			if cols[s] == nil {
+				cols[s] = map[string]bool{}
+			}
+			cols[s][unquoteIdent(t.text)] = true
+		}
+	}
+	return cols
+}
+
+// cteNames collects the names declared as common table expressions
+// (`name AS (` or `name (cols) AS (`).
+func cteNames(toks []token) map[string]bool {
+	names := map[string]bool{}
+	for i, t := range toks {
+		if t.kind != tokenWord && t.kind != tokenIdent {
+			continue
+		}
+		j := nextToken(toks, i+1)
+		if j < len(toks) && toks[j].text == "(" {
+			for depth := 0; j < len(toks); j++ {
+				if toks[j].text == "(" {
+					depth++
+				} else if toks[j].text == ")" {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+			j = nextToken(toks, j+1)
+		}
+		if j < len(toks) && toks[j].is("AS") {
+			if k := nextToken(toks, j+1); k < len(toks) && toks[k].text == "(" {
+				names[unquoteIdent(t.text)] = true
+			}
+		}
+	}
+	return names
+}
+
+// firstKeyword returns the upper cased first word of the statement.
+func firstKeyword(toks []token) string {
+	if i := nextToken(toks, 0); i < len(toks) && toks[i].kind == tokenWord {
+		return strings.ToUpper(toks[i].text)
+	}
+	return ""
+}
+
+// targetTables returns the tables written, created, altered or dropped by
+// the statement.
+func targetTables(toks []token) []tableRef {
+	var words []int
+	for i, t := range toks {
+		if t.significant() {
+			words = append(words, i)
+		}
+		if len(words) == 8 {
+			break
+		}
+	}
+	// skip the leading keywords up to the first one that is followed by the
+	// target table, e.g. CREATE OR REPLACE VIEW <target>
+	for n, i := range words {
+		t := toks[i]
+		var target bool
+		switch {
+		case n == 0:
+			if !t.is("INSERT", "CREATE", "DROP", "ALTER", "DELETE", "UPDATE", "MERGE", "MSCK", "OPTIMIZE", "VACUUM") {
+				return nil
+			}
+			target = t.is("UPDATE", "OPTIMIZE", "VACUUM")
+		case t.is("OR", "REPLACE", "EXTERNAL", "IF", "NOT", "EXISTS", "REPAIR"):
+		case t.is("TABLE", "VIEW", "INTO", "FROM"):
+			target = true
+		default:
+			return nil
+		}
+		if !target {
+			continue
+		}
+		j := i + 1
+		for k := n + 1; k < len(words); k++ {
+			if !toks[words[k]].is("IF", "NOT", "EXISTS") {
+				j = words[k]
+				break
+			}
+		}
+		if ref, ok := parseQualifiedName(toks, j); ok {
+			return []tableRef{ref}
+		}
+		return nil
+	}
+	return nil
+}
+
+// injectLimit appends a LIMIT to queries (SELECT, WITH ... SELECT, VALUES)
+// that don't have a top level LIMIT or FETCH yet. Other statements are
+// returned as they are.
+func injectLimit(query string, limit int) string {
+	toks := tokenize(query)
+	if !unlimited(toks) {
+		return query
+	}
+	last := -1
+	for i, t := range toks {
+		if t.significant() && t.text != ";" {
+			last = i
+		}
+	}
+	if last < 0 {
+		return query
+	}
+	return joinTokens(toks[:last+1]) + fmt.Sprintf(" LIMIT %d", limit) + joinTokens(toks[last+1:])
+}
+
+// unlimited reports whether the statement is a query (SELECT, WITH ...
+// SELECT, VALUES) without a top level LIMIT or FETCH.
+func unlimited(toks []token) bool {
+	switch firstKeyword(toks) {
+	case "SELECT", "WITH", "VALUES":
+	default:
+		return false
+	}
+	depth := 0
+	for _, t := range toks {
+		switch {
+		case t.text == "(":
+			depth++
+		case t.text == ")":
+			depth--
+		case depth == 0 && t.is("LIMIT", "FETCH"):
+			return false
+		}
+	}
+	return true
+}
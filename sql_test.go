@@ -0,0 +1,184 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenizeRoundTrip(t *testing.T) {
+	for _, sql := range []string{
+		"",
+		"select 1",
+		"SELECT a, \"b\"\"c\", `d` FROM t -- comment\nWHERE x = 'it''s' /* block */ AND y > 1.5e3",
+		"select * from {{ .TABLE }} limit {{ .LIM }}",
+		"{% for d in days %}select '{{ d }}'{% endfor %} {# note #} {% if x",
+		"select 'unterminated",
+		"/* unterminated",
+		"select ü, n$1 from \"schema\".\"tbl\"",
+	} {
+		if got := joinTokens(tokenize(sql)); got != sql {
+			t.Errorf("joinTokens(tokenize(%q)) = %q", sql, got)
+		}
+	}
+}
+
+func TestTokenizeJinja(t *testing.T) {
+	toks := tokenize("{% for d in days %}select {# why #}{{ d }}{% endfor %}")
+	want := []token{
+		{tokenTemplate, "{% for d in days %}"}, {tokenWord, "select"}, {tokenSpace, " "}, {tokenTemplate, "{# why #}"},
+		{tokenTemplate, "{{ d }}"}, {tokenTemplate, "{% endfor %}"},
+	}
+	if !reflect.DeepEqual(toks, want) {
+		t.Errorf("tokenize = %v, want %v", toks, want)
+	}
+}
+
+func TestTokenizeKinds(t *testing.T) {
+	toks := tokenize(`select "a b", 'c', 1.5, x$y -- d`)
+	want := []token{
+		{tokenWord, "select"}, {tokenSpace, " "}, {tokenIdent, `"a b"`}, {tokenSymbol, ","}, {tokenSpace, " "},
+		{tokenString, "'c'"}, {tokenSymbol, ","}, {tokenSpace, " "}, {tokenNumber, "1.5"}, {tokenSymbol, ","},
+		{tokenSpace, " "}, {tokenWord, "x$y"}, {tokenSpace, " "}, {tokenComment, "-- d"},
+	}
+	if !reflect.DeepEqual(toks, want) {
+		t.Errorf("tokenize = %v, want %v", toks, want)
+	}
+}
+
+func TestSourceTables(t *testing.T) {
+	for _, tt := range []struct {
+		sql      string
+		tables   []string
+		implicit []string
+	}{
+		{"select 1", []string{}, nil},
+		{"select * from db.events", []string{"db.events"}, nil},
+		{`select * from "DB"."Events" e join dates d on e.dt = d.dt`, []string{"DB.Events", "dates"}, nil},
+		{"select * from a, b where a.id = b.id", []string{"a", "b"}, []string{"b"}},
+		{"select * from a left outer join b using (id) cross join c", []string{"a", "b", "c"}, nil},
+		{"with x as (select * from events) select * from x join users on true", []string{"events", "users"}, nil},
+		{"select * from (select * from inner_t) sub", []string{"inner_t"}, nil},
+		{"select * from unnest(array[1, 2]) as t(n)", []string{}, nil},
+		{"select extract(year from ts) from events", []string{"events"}, nil},
+		{"insert into target select * from source", []string{"source"}, nil},
+		{"merge into t using s on t.id = s.id when matched then delete", []string{"s"}, nil},
+		{"select * from a union all select * from b", []string{"a", "b"}, nil},
+	} {
+		refs := sourceTables(tokenize(tt.sql))
+		if got := refNames(refs); !reflect.DeepEqual(got, tt.tables) {
+			t.Errorf("sourceTables(%q) = %v, want %v", tt.sql, got, tt.tables)
+		}
+		var implicit []string
+		for _, ref := range refs {
+			if ref.implicit {
+				implicit = append(implicit, ref.name())
+			}
+		}
+		if !reflect.DeepEqual(implicit, tt.implicit) {
+			t.Errorf("implicit tables of %q = %v, want %v", tt.sql, implicit, tt.implicit)
+		}
+	}
+}
+
+func TestTargetTables(t *testing.T) {
+	for _, tt := range []struct {
+		sql    string
+		target []string
+	}{
+		{"select * from a", []string{}},
+		{"insert into db.t select * from s", []string{"db.t"}},
+		{"create table if not exists t as select 1", []string{"t"}},
+		{"create or replace view v as select * from t", []string{"v"}},
+		{"create external table `db`.`t` (a int)", []string{"db.t"}},
+		{"drop table if exists t", []string{"t"}},
+		{"delete from t where x = 1", []string{"t"}},
+		{"update t set x = 1", []string{"t"}},
+		{"merge into t using s on t.id = s.id when matched then delete", []string{"t"}},
+		{"msck repair table t", []string{"t"}},
+		{"optimize t rewrite data using bin_pack", []string{"t"}},
+	} {
+		if got := refNames(targetTables(tokenize(tt.sql))); !reflect.DeepEqual(got, tt.target) {
+			t.Errorf("targetTables(%q) = %v, want %v", tt.sql, got, tt.target)
+		}
+	}
+}
+
+func TestPredicateColumns(t *testing.T) {
+	for _, tt := range []struct {
+		sql      string
+		filtered map[string]bool
+	}{
+		{"select * from events where dt = '1'", map[string]bool{"events": true}},
+		{"select * from events where (dt = '1' or x)", map[string]bool{"events": true}},
+		{"select * from events e join dates d on e.dt = d.dt", map[string]bool{"events": false, "dates": false}},
+		{"select * from (select * from events) where dt = '1'", map[string]bool{"events": false}},
+		{"select * from dates where dt in (select dt from events)", map[string]bool{"dates": true, "events": false}},
+		{"select * from events where dt in (select x from dates)", map[string]bool{"events": true, "dates": false}},
+		{"select * from a where dt = '1' union all select * from b", map[string]bool{"a": true, "b": false}},
+		{"select dt, count(*) from events group by dt having dt > '1'", map[string]bool{"events": true}},
+		{"merge into t using events e on e.dt = t.dt when matched then delete", map[string]bool{"events": true}},
+	} {
+		toks := tokenize(tt.sql)
+		predicates := predicateColumns(toks)
+		for _, ref := range sourceTables(toks) {
+			want, ok := tt.filtered[ref.name()]
+			if !ok {
+				t.Errorf("%q: unexpected table %s", tt.sql, ref.name())
+				continue
+			}
+			if got := predicates[ref.scope]["dt"]; got != want {
+				t.Errorf("%q: %s filtered on dt = %v, want %v", tt.sql, ref.name(), got, want)
+			}
+		}
+	}
+}
+
+func TestCTENames(t *testing.T) {
+	got := cteNames(tokenize(`with a as (select 1), "B" (x) as (select 2) select * from a, b`))
+	want := map[string]bool{"a": true, "B": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cteNames = %v, want %v", got, want)
+	}
+}
+
+func TestQuoteIdent(t *testing.T) {
+	for _, tt := range []struct {
+		in, quoted, ddl string
+	}{
+		{"events", `"events"`, "`events`"},
+		{`a"b`, `"a""b"`, "`a\"b`"},
+		{"a`b", "\"a`b\"", "`a``b`"},
+	} {
+		if got := quoteIdent(tt.in); got != tt.quoted {
+			t.Errorf("quoteIdent(%q) = %s, want %s", tt.in, got, tt.quoted)
+		}
+		if got := quoteDDLIdent(tt.in); got != tt.ddl {
+			t.Errorf("quoteDDLIdent(%q) = %s, want %s", tt.in, got, tt.ddl)
+		}
+		if got := unquoteIdent(tt.quoted); got != tt.in {
+			t.Errorf("unquoteIdent(%s) = %q, want %q", tt.quoted, got, tt.in)
+		}
+	}
+}
+
+func TestInjectLimit(t *testing.T) {
+	for _, tt := range []struct {
+		sql, want string
+	}{
+		{"select * from t", "select * from t LIMIT 10"},
+		{"select * from t;", "select * from t LIMIT 10;"},
+		{"select * from t -- all\n", "select * from t LIMIT 10 -- all\n"},
+		{"select * from t limit 5", "select * from t limit 5"},
+		{"select * from t offset 2 fetch first 5 rows only", "select * from t offset 2 fetch first 5 rows only"},
+		{"select * from (select * from t limit 5)", "select * from (select * from t limit 5) LIMIT 10"},
+		{"with x as (select 1) select * from x", "with x as (select 1) select * from x LIMIT 10"},
+		{"values 1, 2", "values 1, 2 LIMIT 10"},
+		{"insert into t select * from s", "insert into t select * from s"},
+		{"create table t as select 1", "create table t as select 1"},
+		{"show tables", "show tables"},
+	} {
+		if got := injectLimit(tt.sql, 10); got != tt.want {
+			t.Errorf("injectLimit(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
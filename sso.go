@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/go-ini/ini"
+	"github.com/pkg/errors"
+)
+
+// ssoOIDCEndpoint and ssoPortalEndpoint are the IAM Identity Center apis of
+// a region, replaced in tests.
+var (
+	ssoOIDCEndpoint = func(region string) string {
+		return "https://oidc." + region + ".amazonaws.com"
+	}
+	ssoPortalEndpoint = func(region string) string {
+		return "https://portal.sso." + region + ".amazonaws.com"
+	}
+)
+
+// ssoSession is an [sso-session name] section of the shared config, or the
+// sso_start_url and sso_region of a legacy profile without a name.
+type ssoSession struct {
+	name     string
+	startURL string
+	region   string
+	scopes   []string
+}
+
+// ssoToken is a cached token in the format of the aws cli, which reads it
+// from the same cache.
+type ssoToken struct {
+	StartURL              string    `json:"startUrl"`
+	Region                string    `json:"region"`
+	AccessToken           string    `json:"accessToken"`
+	ExpiresAt             time.Time `json:"expiresAt"`
+	ClientID              string    `json:"clientId,omitempty"`
+	ClientSecret          string    `json:"clientSecret,omitempty"`
+	RegistrationExpiresAt time.Time `json:"registrationExpiresAt,omitempty"`
+	RefreshToken          string    `json:"refreshToken,omitempty"`
+}
+
+func loginCmd(args []string) error {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: athenaq login [flags]")
+		fs.PrintDefaults()
+	}
+	var (
+		sessionName = fs.String("sso-session", "", `[sso-session name] of the shared config to log in to ("" == the sso_session of AWS_PROFILE)`)
+		timeout     = fs.Duration("timeout", 10*time.Minute, "how long to wait for the login to be approved in the browser")
+	)
+	addLogFlags(fs)
+	addHTTPTransportFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := loadSharedConfig()
+	if err != nil {
+		return err
+	}
+	var sess *ssoSession
+	if *sessionName != "" {
+		sess, err = cfg.ssoSession(*sessionName)
+	} else {
+		sess, err = cfg.profileSSOSession(awsProfile())
+	}
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	tok, err := ssoLogin(ctx, sess, os.Stderr)
+	if err != nil {
+		return err
+	}
+	if err := writeSSOToken(sess, tok); err != nil {
+		return errors.Wrap(err, "could not cache sso token")
+	}
+	fmt.Fprintf(os.Stderr, "logged in to %s until %s\n", sess.startURL, tok.ExpiresAt.Local().Format(time.RFC1123))
+	return nil
+}
+
+// sharedConfig is the shared aws config file, AWS_CONFIG_FILE or
+// ~/.aws/config.
+type sharedConfig struct {
+	path string
+	file *ini.File
+}
+
+func loadSharedConfig() (*sharedConfig, error) {
+	path := os.Getenv("AWS_CONFIG_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, ".aws", "config")
+	}
+	f, err := ini.Load(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read %s", path)
+	}
+	return &sharedConfig{path: path, file: f}, nil
+}
+
+func awsProfile() string {
+	for _, env := range []string{"AWS_PROFILE", "AWS_DEFAULT_PROFILE"} {
+		if p := os.Getenv(env); p != "" {
+			return p
+		}
+	}
+	return "default"
+}
+
+func (c *sharedConfig) profile(name string) (*ini.Section, error) {
+	section := "profile " + name
+	if name == "default" {
+		section = name
+	}
+	s, err := c.file.GetSection(section)
+	if err != nil {
+		return nil, fmt.Errorf("no profile %q in %s", name, c.path)
+	}
+	return s, nil
+}
+
+func (c *sharedConfig) ssoSession(name string) (*ssoSession, error) {
+	s, err := c.file.GetSection("sso-session " + name)
+	if err != nil {
+		return nil, fmt.Errorf("no [sso-session %s] in %s", name, c.path)
+	}
+	sess := &ssoSession{name: name, startURL: s.Key("sso_start_url").String(), region: s.Key("sso_region").String()}
+	if sess.startURL == "" || sess.region == "" {
+		return nil, fmt.Errorf("[sso-session %s] in %s needs sso_start_url and sso_region", name, c.path)
+	}
+	for _, scope := range strings.Split(s.Key("sso_registration_scopes").String(), ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			sess.scopes = append(sess.scopes, scope)
+		}
+	}
+	return sess, nil
+}
+
+// profileSSOSession returns the sso session of a profile, either its
+// sso_session or its own sso_start_url and sso_region.
+func (c *sharedConfig) profileSSOSession(profile string) (*ssoSession, error) {
+	s, err := c.profile(profile)
+	if err != nil {
+		return nil, err
+	}
+	if name := s.Key("sso_session").String(); name != "" {
+		return c.ssoSession(name)
+	}
+	sess := &ssoSession{startURL: s.Key("sso_start_url").String(), region: s.Key("sso_region").String()}
+	if sess.startURL == "" || sess.region == "" {
+		return nil, fmt.Errorf("profile %q in %s has no sso_session or sso_start_url and sso_region", profile, c.path)
+	}
+	return sess, nil
+}
+
+// ssoTokenPath is the cache file of the token of a session, named after the
+// sha1 of the session name, or of the start url of legacy profiles.
+func ssoTokenPath(sess *ssoSession) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	key := sess.name
+	if key == "" {
+		key = sess.startURL
+	}
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(home, ".aws", "sso", "cache", hex.EncodeToString(sum[:])+".json"), nil
+}
+
+func readSSOToken(sess *ssoSession) (*ssoToken, error) {
+	path, err := ssoTokenPath(sess)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tok := &ssoToken{}
+	if err := json.Unmarshal(data, tok); err != nil {
+		return nil, errors.Wrapf(err, "could not parse %s", path)
+	}
+	return tok, nil
+}
+
+func writeSSOToken(sess *ssoSession, tok *ssoToken) error {
+	path, err := ssoTokenPath(sess)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(tok, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// ssoLogin registers a client, unless the cached token has a registration
+// that is still valid, and drives the device authorization: it prints the
+// url and code to approve in the browser to w and waits for the token.
+func ssoLogin(ctx context.Context, sess *ssoSession, w io.Writer) (*ssoToken, error) {
+	tok := &ssoToken{StartURL: sess.startURL, Region: sess.region}
+	if cached, err := readSSOToken(sess); err == nil && cached.ClientID != "" && time.Now().Add(time.Hour).Before(cached.RegistrationExpiresAt) {
+		tok.ClientID, tok.ClientSecret, tok.RegistrationExpiresAt = cached.ClientID, cached.ClientSecret, cached.RegistrationExpiresAt
+	} else {
+		in := map[string]interface{}{"clientName": "athenaq", "clientType": "public"}
+		if len(sess.scopes) > 0 {
+			in["scopes"] = sess.scopes
+		}
+		var reg struct {
+			ClientID              string `json:"clientId"`
+			ClientSecret          string `json:"clientSecret"`
+			ClientSecretExpiresAt int64  `json:"clientSecretExpiresAt"`
+		}
+		if err := ssoPost(ctx, ssoOIDCEndpoint(sess.region)+"/client/register", in, &reg); err != nil {
+			return nil, errors.Wrap(err, "could not register sso client")
+		}
+		tok.ClientID, tok.ClientSecret, tok.RegistrationExpiresAt = reg.ClientID, reg.ClientSecret, time.Unix(reg.ClientSecretExpiresAt, 0).UTC()
+	}
+	secrets.add(tok.ClientSecret)
+
+	var auth struct {
+		DeviceCode              string `json:"deviceCode"`
+		UserCode                string `json:"userCode"`
+		VerificationURIComplete string `json:"verificationUriComplete"`
+		Interval                int    `json:"interval"`
+	}
+	err := ssoPost(ctx, ssoOIDCEndpoint(sess.region)+"/device_authorization", map[string]string{
+		"clientId":     tok.ClientID,
+		"clientSecret": tok.ClientSecret,
+		"startUrl":     sess.startURL,
+	}, &auth)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not start sso device authorization")
+	}
+	fmt.Fprintf(w, "open %s in a browser and approve the code %s\n", auth.VerificationURIComplete, auth.UserCode)
+
+	interval := time.Duration(auth.Interval) * time.Second
+	for {
+		err := tok.create(ctx, "urn:ietf:params:oauth:grant-type:device_code", "deviceCode", auth.DeviceCode)
+		switch oidcError(err) {
+		case "":
+			return tok, nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, errors.Wrap(err, "could not create sso token")
+		}
+		select {
+		case <-ctx.Done():
+			return nil, errors.New("sso login was not approved in time")
+		case <-time.After(interval):
+		}
+	}
+}
+
+// create creates an access token with a device code or a refresh token.
+func (tok *ssoToken) create(ctx context.Context, grantType, key, value string) error {
+	var out struct {
+		AccessToken  string `json:"accessToken"`
+		ExpiresIn    int    `json:"expiresIn"`
+		RefreshToken string `json:"refreshToken"`
+	}
+	err := ssoPost(ctx, ssoOIDCEndpoint(tok.Region)+"/token", map[string]string{
+		"clientId":     tok.ClientID,
+		"clientSecret": tok.ClientSecret,
+		"grantType":    grantType,
+		key:            value,
+	}, &out)
+	if err != nil {
+		return err
+	}
+	tok.AccessToken, tok.ExpiresAt = out.AccessToken, time.Now().Add(time.Duration(out.ExpiresIn)*time.Second).UTC().Truncate(time.Second)
+	if out.RefreshToken != "" {
+		tok.RefreshToken = out.RefreshToken
+	}
+	return nil
+}
+
+// oidcStatusError is an error response of the sso oidc api.
+type oidcStatusError struct {
+	status      string
+	code        string
+	description string
+}
+
+func (e *oidcStatusError) Error() string {
+	return fmt.Sprintf("%s: %s %s", e.status, e.code, e.description)
+}
+
+func oidcError(err error) string {
+	if e, ok := errors.Cause(err).(*oidcStatusError); ok {
+		return e.code
+	}
+	if err != nil {
+		return "error"
+	}
+	return ""
+}
+
+func ssoPost(ctx context.Context, endpoint string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return ssoDo(req.WithContext(ctx), out)
+}
+
+func ssoDo(req *http.Request, out interface{}) error {
+	resp, err := doAWS(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		e := struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+			Message     string `json:"message"`
+		}{}
+		json.Unmarshal(data, &e)
+		if e.Description == "" {
+			e.Description = e.Message
+		}
+		if e.Error == "" {
+			e.Error = resp.Header.Get("X-Amzn-Errortype")
+		}
+		return &oidcStatusError{status: resp.Status, code: e.Error, description: e.Description}
+	}
+	return json.Unmarshal(data, out)
+}
+
+// ssoCredentials returns the credentials of the sso_account_id and
+// sso_role_name of the profile, or nil for other profiles and when
+// credentials are set in the environment.
+func ssoCredentials() (*credentials.Credentials, error) {
+	if os.Getenv("AWS_ACCESS_KEY_ID") != "" {
+		return nil, nil
+	}
+	cfg, err := loadSharedConfig()
+	if err != nil {
+		return nil, nil
+	}
+	s, err := cfg.profile(awsProfile())
+	if err != nil {
+		return nil, nil
+	}
+	account, role := s.Key("sso_account_id").String(), s.Key("sso_role_name").String()
+	if account == "" || role == "" {
+		return nil, nil
+	}
+	sess, err := cfg.profileSSOSession(awsProfile())
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewCredentials(&ssoProvider{session: sess, accountID: account, roleName: role}), nil
+}
+
+// ssoProvider gets the credentials of a role with the cached token of
+// athenaq login or aws sso login, refreshing an expired token if it can.
+type ssoProvider struct {
+	credentials.Expiry
+	session   *ssoSession
+	accountID string
+	roleName  string
+}
+
+func (p *ssoProvider) Retrieve() (credentials.Value, error) {
+	login := "athenaq login"
+	if p.session.name != "" {
+		login += " -sso-session " + p.session.name
+	}
+	tok, err := readSSOToken(p.session)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("no sso token, run %s", login)
+	}
+	if time.Now().Add(time.Minute).After(tok.ExpiresAt) {
+		if tok.RefreshToken == "" || time.Now().After(tok.RegistrationExpiresAt) {
+			return credentials.Value{}, fmt.Errorf("sso token expired, run %s", login)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := tok.create(ctx, "refresh_token", "refreshToken", tok.RefreshToken); err != nil {
+			return credentials.Value{}, errors.Wrapf(err, "could not refresh sso token, run %s", login)
+		}
+		if err := writeSSOToken(p.session, tok); err != nil {
+			debugf("could not cache refreshed sso token: %v", err)
+		}
+	}
+	secrets.add(tok.AccessToken)
+
+	q := url.Values{"account_id": {p.accountID}, "role_name": {p.roleName}}
+	req, err := http.NewRequest("GET", ssoPortalEndpoint(tok.Region)+"/federation/credentials?"+q.Encode(), nil)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	req.Header.Set("X-Amz-Sso_bearer_token", tok.AccessToken)
+	var out struct {
+		RoleCredentials struct {
+			AccessKeyID     string `json:"accessKeyId"`
+			SecretAccessKey string `json:"secretAccessKey"`
+			SessionToken    string `json:"sessionToken"`
+			Expiration      int64  `json:"expiration"`
+		} `json:"roleCredentials"`
+	}
+	if err := ssoDo(req, &out); err != nil {
+		return credentials.Value{}, errors.Wrapf(err, "could not get sso credentials of role %s in account %s", p.roleName, p.accountID)
+	}
+	creds := out.RoleCredentials
+	p.SetExpiration(time.Unix(0, creds.Expiration*int64(time.Millisecond)), time.Minute)
+	return credentials.Value{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+		ProviderName:    "SSOProvider",
+	}, nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSSOConfig = `[default]
+region = eu-west-1
+
+[profile analyst]
+sso_session = prod
+sso_account_id = 123456789012
+sso_role_name = AthenaAnalyst
+
+[profile legacy]
+sso_start_url = https://legacy.awsapps.com/start
+sso_region = us-east-1
+
+[profile broken]
+sso_session = missing
+
+[sso-session prod]
+sso_start_url = https://prod.awsapps.com/start
+sso_region = eu-west-1
+sso_registration_scopes = sso:account:access
+`
+
+func withSSOHome(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "athenaq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "config"), []byte(testSSOConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"HOME": dir, "AWS_CONFIG_FILE": filepath.Join(dir, "config"), "AWS_PROFILE": "analyst", "AWS_ACCESS_KEY_ID": ""}
+	old := map[string]string{}
+	for k, v := range env {
+		old[k] = os.Getenv(k)
+		os.Setenv(k, v)
+	}
+	return func() {
+		for k, v := range old {
+			os.Setenv(k, v)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSharedConfigSSOSession(t *testing.T) {
+	defer withSSOHome(t)()
+	cfg, err := loadSharedConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		profile  string
+		expected string
+		err      string
+	}{
+		{"analyst", "prod https://prod.awsapps.com/start eu-west-1 [sso:account:access]", ""},
+		{"legacy", " https://legacy.awsapps.com/start us-east-1 []", ""},
+		{"default", "", `profile "default" in ` + cfg.path + " has no sso_session"},
+		{"broken", "", "no [sso-session missing] in " + cfg.path},
+		{"nope", "", `no profile "nope" in ` + cfg.path},
+	} {
+		sess, err := cfg.profileSSOSession(tc.profile)
+		if tc.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+				t.Errorf("%s: error = %v, expected %q", tc.profile, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.profile, err)
+			continue
+		}
+		if actual := fmt.Sprintf("%s %s %s %v", sess.name, sess.startURL, sess.region, sess.scopes); actual != tc.expected {
+			t.Errorf("%s: session = %q, expected %q", tc.profile, actual, tc.expected)
+		}
+	}
+}
+
+func TestSSOLogin(t *testing.T) {
+	defer withSSOHome(t)()
+	var calls []string
+	pending := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		in := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&in)
+		calls = append(calls, r.URL.Path)
+		switch r.URL.Path {
+		case "/client/register":
+			fmt.Fprintf(w, `{"clientId":"client-1","clientSecret":"client-secret","clientSecretExpiresAt":%d}`, time.Now().Add(90*24*time.Hour).Unix())
+		case "/device_authorization":
+			if in["startUrl"] != "https://prod.awsapps.com/start" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"deviceCode":"device-1","userCode":"ABCD-EFGH","verificationUriComplete":"https://device.sso/?user_code=ABCD-EFGH","interval":0}`)
+		case "/token":
+			switch {
+			case in["grantType"] == "refresh_token" && in["refreshToken"] == "refresh-1":
+				fmt.Fprint(w, `{"accessToken":"token-2","expiresIn":3600}`)
+			case in["deviceCode"] != "device-1":
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error":"invalid_grant"}`)
+			case pending > 0:
+				pending--
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error":"authorization_pending"}`)
+			default:
+				fmt.Fprint(w, `{"accessToken":"token-1","expiresIn":3600,"refreshToken":"refresh-1"}`)
+			}
+		case "/federation/credentials":
+			if r.Header.Get("X-Amz-Sso_bearer_token") != "token-2" || r.URL.Query().Get("role_name") != "AthenaAnalyst" {
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, `{"message":"Session token not found or invalid"}`)
+				return
+			}
+			fmt.Fprintf(w, `{"roleCredentials":{"accessKeyId":"ASIA1","secretAccessKey":"secret","sessionToken":"session","expiration":%d}}`,
+				time.Now().Add(time.Hour).UnixNano()/int64(time.Millisecond))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	defer func(oidc, portal func(string) string) { ssoOIDCEndpoint, ssoPortalEndpoint = oidc, portal }(ssoOIDCEndpoint, ssoPortalEndpoint)
+	ssoOIDCEndpoint = func(string) string { return srv.URL }
+	ssoPortalEndpoint = func(string) string { return srv.URL }
+
+	if err := loginCmd([]string{"-sso-session", "prod"}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "/client/register /device_authorization /token /token"; strings.Join(calls, " ") != expected {
+		t.Errorf("calls = %v, expected %s", calls, expected)
+	}
+	sess := &ssoSession{name: "prod"}
+	tok, err := readSSOToken(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "token-1" || tok.ClientID != "client-1" || tok.Region != "eu-west-1" || tok.ExpiresAt.Before(time.Now()) {
+		t.Errorf("cached token = %+v", tok)
+	}
+
+	// a second login reuses the client registration.
+	calls, pending = nil, 0
+	var out bytes.Buffer
+	if _, err := ssoLogin(context.Background(), &ssoSession{name: "prod", startURL: "https://prod.awsapps.com/start", region: "eu-west-1"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "/device_authorization /token"; strings.Join(calls, " ") != expected {
+		t.Errorf("calls = %v, expected %s", calls, expected)
+	}
+	if !strings.Contains(out.String(), "https://device.sso/?user_code=ABCD-EFGH") || !strings.Contains(out.String(), "ABCD-EFGH") {
+		t.Errorf("login output = %q", out.String())
+	}
+
+	// an expired token is refreshed before getting the role credentials.
+	tok.ExpiresAt = time.Now().Add(-time.Minute)
+	if err := writeSSOToken(sess, tok); err != nil {
+		t.Fatal(err)
+	}
+	creds, err := ssoCredentials()
+	if err != nil || creds == nil {
+		t.Fatalf("ssoCredentials() = %v, %v", creds, err)
+	}
+	v, err := creds.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.AccessKeyID != "ASIA1" || v.SessionToken != "session" {
+		t.Errorf("credentials = %+v", v)
+	}
+	if tok, _ := readSSOToken(sess); tok.AccessToken != "token-2" || tok.RefreshToken != "refresh-1" {
+		t.Errorf("refreshed token = %+v", tok)
+	}
+
+	os.Setenv("AWS_PROFILE", "legacy")
+	if _, err := ssoCredentials(); err != nil {
+		t.Errorf("profile without sso role: %v", err)
+	}
+	os.Setenv("AWS_PROFILE", "analyst")
+	os.RemoveAll(filepath.Join(os.Getenv("HOME"), ".aws", "sso"))
+	creds, _ = ssoCredentials()
+	if _, err := creds.Get(); err == nil || err.Error() != "no sso token, run athenaq login -sso-session prod" {
+		t.Errorf("error without token = %v", err)
+	}
+}
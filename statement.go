@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// hasResult reports whether a statement of the given athena statement type
+// returns rows. athena counts SELECT as DML and SHOW as DDL, so the first
+// keyword of the query decides within a type.
+func hasResult(stmtType, query string) bool {
+	keyword := firstKeyword(tokenize(query))
+	switch stmtType {
+	case "DDL":
+		switch keyword {
+		case "SHOW", "DESCRIBE", "DESC", "EXPLAIN":
+			return true
+		}
+		return false
+	case "DML":
+		switch keyword {
+		case "INSERT", "UPDATE", "DELETE", "MERGE", "CREATE", "UNLOAD":
+			return false
+		}
+	}
+	return true
+}
+
+var statementClasses = []string{"ddl", "dml", "select"}
+
+// statementClass classifies a query before it is submitted: "select" for
+// statements that only read, "dml" for statements that write data and "ddl"
+// for everything else, including CREATE TABLE AS.
+func statementClass(toks []token) string {
+	i := nextToken(toks, 0)
+	if i == len(toks) || toks[i].text == "(" {
+		return "select"
+	}
+	switch strings.ToUpper(toks[i].text) {
+	case "SELECT", "WITH", "VALUES", "TABLE", "SHOW", "DESCRIBE", "DESC":
+		return "select"
+	case "EXPLAIN":
+		// only EXPLAIN ANALYZE runs the statement.
+		for i = nextToken(toks, i+1); i < len(toks); i = nextToken(toks, i+1) {
+			switch {
+			case toks[i].is("ANALYZE"):
+				if j := nextToken(toks, i+1); j < len(toks) && toks[j].is("VERBOSE") {
+					i = j
+				}
+				return statementClass(toks[i+1:])
+			case toks[i].is("VERBOSE"):
+			case toks[i].text == "(":
+				for depth := 0; i < len(toks); i++ {
+					if toks[i].text == "(" {
+						depth++
+					} else if toks[i].text == ")" {
+						if depth--; depth == 0 {
+							break
+						}
+					}
+				}
+			default:
+				return "select"
+			}
+		}
+		return "select"
+	case "INSERT", "UPDATE", "DELETE", "MERGE", "UNLOAD", "OPTIMIZE", "VACUUM":
+		return "dml"
+	}
+	return "ddl"
+}
+
+// classesFlag is a comma separated subset of statementClasses.
+type classesFlag map[string]bool
+
+func (f classesFlag) String() string {
+	var classes []string
+	for _, c := range statementClasses {
+		if f[c] {
+			classes = append(classes, c)
+		}
+	}
+	return strings.Join(classes, ",")
+}
+
+func (f classesFlag) Set(s string) error {
+	for _, c := range strings.Split(s, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		valid := false
+		for _, sc := range statementClasses {
+			valid = valid || c == sc
+		}
+		if !valid {
+			return fmt.Errorf("invalid statement type %q, expected %s", c, strings.Join(statementClasses, ", "))
+		}
+		f[c] = true
+	}
+	return nil
+}
+
+// checkAllowed refuses the whole batch if any statement is of a class that is
+// not allowed.
+func checkAllowed(queries []string, allowed classesFlag) error {
+	for i, query := range queries {
+		if class := statementClass(tokenize(query)); !allowed[class] {
+			return fmt.Errorf("query %d is a %s statement, refused by -allow %s", i+1, strings.ToUpper(class), allowed)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+func TestHasResult(t *testing.T) {
+	for _, tt := range []struct {
+		stmtType, query string
+		want            bool
+	}{
+		{"DML", "select * from t", true},
+		{"DML", "with x as (select 1) select * from x", true},
+		{"DML", "insert into t select * from s", false},
+		{"DML", "create table t as select 1", false},
+		{"DML", "-- backfill\nunload (select 1) to 's3://b/p' with (format = 'PARQUET')", false},
+		{"DDL", "show partitions t", true},
+		{"DDL", "describe t", true},
+		{"DDL", "drop table t", false},
+		{"DDL", "msck repair table t", false},
+		{"UTILITY", "show create table t", true},
+		{"", "drop table t", true},
+	} {
+		if got := hasResult(tt.stmtType, tt.query); got != tt.want {
+			t.Errorf("hasResult(%q, %q) = %v, want %v", tt.stmtType, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestStatementClass(t *testing.T) {
+	for _, tt := range []struct {
+		query, want string
+	}{
+		{"select 1", "select"},
+		{"-- x\n(select 1) union (select 2)", "select"},
+		{"with x as (select 1) select * from x", "select"},
+		{"show partitions t", "select"},
+		{"explain insert into t select 1", "select"},
+		{"explain (format json) drop table t", "select"},
+		{"explain analyze verbose insert into t select 1", "dml"},
+		{"explain (type distributed) analyze delete from t", "dml"},
+		{"insert into t select 1", "dml"},
+		{"merge into t using s on t.id = s.id when matched then delete", "dml"},
+		{"unload (select 1) to 's3://b/p'", "dml"},
+		{"create table t as select 1", "ddl"},
+		{"drop table t", "ddl"},
+		{"msck repair table t", "ddl"},
+	} {
+		if got := statementClass(tokenize(tt.query)); got != tt.want {
+			t.Errorf("statementClass(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestCheckAllowed(t *testing.T) {
+	allow := classesFlag{}
+	if err := allow.Set("select, DML"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkAllowed([]string{"select 1", "insert into t select 1"}, allow); err != nil {
+		t.Error(err)
+	}
+	if err := checkAllowed([]string{"select 1", "drop table t"}, allow); err == nil {
+		t.Error("expected drop table to be refused")
+	}
+	if err := allow.Set("select,write"); err == nil {
+		t.Error("expected an invalid statement type to be rejected")
+	}
+}
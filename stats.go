@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+// The GetQueryRuntimeStatistics types mirror the athena API; the operation
+// is sent through the regular athena client.
+
+type queryTimeline struct {
+	QueryQueueTimeInMillis        *int64 `json:"queue_ms,omitempty"`
+	QueryPlanningTimeInMillis     *int64 `json:"planning_ms,omitempty"`
+	EngineExecutionTimeInMillis   *int64 `json:"engine_execution_ms,omitempty"`
+	ServiceProcessingTimeInMillis *int64 `json:"service_processing_ms,omitempty"`
+	TotalExecutionTimeInMillis    *int64 `json:"total_execution_ms,omitempty"`
+}
+
+type queryRows struct {
+	InputRows   *int64 `json:"input_rows,omitempty"`
+	InputBytes  *int64 `json:"input_bytes,omitempty"`
+	OutputBytes *int64 `json:"output_bytes,omitempty"`
+	OutputRows  *int64 `json:"output_rows,omitempty"`
+}
+
+type queryStage struct {
+	StageId       *int64        `json:"stage_id,omitempty"`
+	State         *string       `json:"state,omitempty"`
+	OutputBytes   *int64        `json:"output_bytes,omitempty"`
+	OutputRows    *int64        `json:"output_rows,omitempty"`
+	InputBytes    *int64        `json:"input_bytes,omitempty"`
+	InputRows     *int64        `json:"input_rows,omitempty"`
+	ExecutionTime *int64        `json:"execution_ms,omitempty"`
+	SubStages     []*queryStage `json:"sub_stages,omitempty"`
+}
+
+type queryRuntimeStatistics struct {
+	Timeline    *queryTimeline `json:"timeline,omitempty"`
+	Rows        *queryRows     `json:"rows,omitempty"`
+	OutputStage *queryStage    `json:"output_stage,omitempty"`
+}
+
+type queryStats struct {
+	QueryExecutionId            string                  `json:"query_execution_id"`
+	QueryName                   string                  `json:"query_name,omitempty"`
+	DataScannedInBytes          int64                   `json:"data_scanned_bytes"`
+	EngineExecutionTimeInMillis int64                   `json:"engine_execution_ms"`
+	RuntimeStatistics           *queryRuntimeStatistics `json:"runtime_statistics,omitempty"`
+}
+
+func (awsCli *awsCli) queryRuntimeStatistics(ctx context.Context, queryExecutionID string) (*queryRuntimeStatistics, error) {
+	out := struct{ QueryRuntimeStatistics *queryRuntimeStatistics }{}
+	req := awsCli.athena.NewRequest(&request.Operation{Name: "GetQueryRuntimeStatistics", HTTPMethod: "POST", HTTPPath: "/"},
+		&struct{ QueryExecutionId *string }{&queryExecutionID}, &out)
+	req.SetContext(ctx)
+	if err := req.Send(); err != nil {
+		return nil, err
+	}
+	return out.QueryRuntimeStatistics, nil
+}
+
+func (awsCli *awsCli) queryStats(ctx context.Context, queryExecution *athena.QueryExecution) (*queryStats, error) {
+	stats := &queryStats{QueryExecutionId: aws.StringValue(queryExecution.QueryExecutionId)}
+	if s := queryExecution.Statistics; s != nil {
+		stats.DataScannedInBytes = aws.Int64Value(s.DataScannedInBytes)
+		stats.EngineExecutionTimeInMillis = aws.Int64Value(s.EngineExecutionTimeInMillis)
+	}
+	runtime, err := awsCli.queryRuntimeStatistics(ctx, stats.QueryExecutionId)
+	if err != nil {
+		return stats, err
+	}
+	stats.RuntimeStatistics = runtime
+	return stats, nil
+}
+
+func (s *queryStats) print(w io.Writer) {
+	id := s.QueryExecutionId
+	if s.QueryName != "" {
+		id = s.QueryName + " " + id
+	}
+	fmt.Fprintf(w, "query %s: scanned %s, engine time %v\n", id, formatBytes(s.DataScannedInBytes), millis(&s.EngineExecutionTimeInMillis))
+	r := s.RuntimeStatistics
+	if r == nil {
+		return
+	}
+	if t := r.Timeline; t != nil {
+		fmt.Fprintf(w, "  queued %v, planning %v, execution %v, service %v, total %v\n",
+			millis(t.QueryQueueTimeInMillis), millis(t.QueryPlanningTimeInMillis), millis(t.EngineExecutionTimeInMillis),
+			millis(t.ServiceProcessingTimeInMillis), millis(t.TotalExecutionTimeInMillis))
+	}
+	if rows := r.Rows; rows != nil {
+		fmt.Fprintf(w, "  input %d rows / %s, output %d rows / %s\n",
+			aws.Int64Value(rows.InputRows), formatBytes(aws.Int64Value(rows.InputBytes)),
+			aws.Int64Value(rows.OutputRows), formatBytes(aws.Int64Value(rows.OutputBytes)))
+	}
+	var printStage func(stage *queryStage, depth int)
+	printStage = func(stage *queryStage, depth int) {
+		fmt.Fprintf(w, "  %sstage %d %s: input %d rows / %s, output %d rows / %s, %v\n", strings.Repeat("  ", depth),
+			aws.Int64Value(stage.StageId), aws.StringValue(stage.State),
+			aws.Int64Value(stage.InputRows), formatBytes(aws.Int64Value(stage.InputBytes)),
+			aws.Int64Value(stage.OutputRows), formatBytes(aws.Int64Value(stage.OutputBytes)),
+			millis(stage.ExecutionTime))
+		for _, sub := range stage.SubStages {
+			printStage(sub, depth+1)
+		}
+	}
+	if r.OutputStage != nil {
+		printStage(r.OutputStage, 0)
+	}
+}
+
+func millis(ms *int64) time.Duration {
+	return time.Duration(aws.Int64Value(ms)) * time.Millisecond
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:             "0 B",
+		1023:          "1023 B",
+		1024:          "1.0 KiB",
+		1536:          "1.5 KiB",
+		5 << 20:       "5.0 MiB",
+		3 << 40:       "3.0 TiB",
+		1<<30 + 1<<29: "1.5 GiB",
+	} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestQueryStatsPrint(t *testing.T) {
+	s := &queryStats{
+		QueryExecutionId:            "q-1",
+		DataScannedInBytes:          2048,
+		EngineExecutionTimeInMillis: 1500,
+		RuntimeStatistics: &queryRuntimeStatistics{
+			Timeline: &queryTimeline{
+				QueryQueueTimeInMillis:        aws.Int64(10),
+				QueryPlanningTimeInMillis:     aws.Int64(20),
+				EngineExecutionTimeInMillis:   aws.Int64(1500),
+				ServiceProcessingTimeInMillis: aws.Int64(5),
+				TotalExecutionTimeInMillis:    aws.Int64(1535),
+			},
+			Rows: &queryRows{InputRows: aws.Int64(100), InputBytes: aws.Int64(2048), OutputRows: aws.Int64(1), OutputBytes: aws.Int64(8)},
+			OutputStage: &queryStage{
+				StageId: aws.Int64(0), State: aws.String("FINISHED"), OutputRows: aws.Int64(1), ExecutionTime: aws.Int64(30),
+				SubStages: []*queryStage{{StageId: aws.Int64(1), State: aws.String("FINISHED"), InputRows: aws.Int64(100)}},
+			},
+		},
+	}
+	var buf bytes.Buffer
+	s.print(&buf)
+	want := "query q-1: scanned 2.0 KiB, engine time 1.5s\n" +
+		"  queued 10ms, planning 20ms, execution 1.5s, service 5ms, total 1.535s\n" +
+		"  input 100 rows / 2.0 KiB, output 1 rows / 8 B\n" +
+		"  stage 0 FINISHED: input 0 rows / 0 B, output 1 rows / 0 B, 30ms\n" +
+		"    stage 1 FINISHED: input 100 rows / 0 B, output 0 rows / 0 B, 0s\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	(&queryStats{QueryExecutionId: "q-2"}).print(&buf)
+	if want := "query q-2: scanned 0 B, engine time 0s\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestWriteStatsJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq-stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.csv.stats.json")
+	if err := (&awsCli{}).writeJSON([]*queryStats{{QueryExecutionId: "q-1", DataScannedInBytes: 10}}, path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0]["query_execution_id"] != "q-1" || got[0]["data_scanned_bytes"] != 10.0 {
+		t.Errorf("got %s", data)
+	}
+	if _, ok := got[0]["runtime_statistics"]; ok {
+		t.Errorf("empty runtime statistics should be omitted: %s", data)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template/parse"
+)
+
+var (
+	templateDirective = regexp.MustCompile(`(?m)^\s*--\s*template:\s*(\S+)\s*$`)
+)
+
+// delimsFlag is a "left,right" pair of template action delimiters.
+type delimsFlag [2]string
+
+func (f *delimsFlag) String() string {
+	if f[0] == "" {
+		return ""
+	}
+	return f[0] + "," + f[1]
+}
+
+func (f *delimsFlag) Set(s string) error {
+	pair := strings.Split(s, ",")
+	if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" || strings.TrimSpace(pair[1]) == "" {
+		return fmt.Errorf("invalid delimiters %q, expected left,right", s)
+	}
+	f[0], f[1] = strings.TrimSpace(pair[0]), strings.TrimSpace(pair[1])
+	return nil
+}
+
+// patternsFlag collects comma separated glob patterns.
+type patternsFlag []string
+
+func (f *patternsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *patternsFlag) Set(s string) error {
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if _, err := path.Match(p, ""); err != nil || p == "" {
+			return fmt.Errorf("invalid pattern %q", p)
+		}
+		*f = append(*f, p)
+	}
+	return nil
+}
+
+// templater renders the queries of the input with the selected engine.
+type templater struct {
+	engine *choiceFlag
+	delims delimsFlag
+	vars   tagsFlag
+	// envAllow restricts the environment variables templates may reference
+	// (empty == all).
+	envAllow patternsFlag
+	// secret are the variables whose values are redacted from logs.
+	secret patternsFlag
+	// builtins are the values athenaq provides, see builtinValues.
+	builtins map[string]string
+	// the calendar of the calendar functions is loaded from calendarPath
+	// when the first query is rendered.
+	calendarPath string
+	fiscalStart  int
+	calendar     *calendar
+	calendarErr  error
+	calendarOnce sync.Once
+}
+
+func addTemplateFlags(fs *flag.FlagSet) *templater {
+	t := &templater{
+		engine: &choiceFlag{value: "gotemplate", choices: []string{"gotemplate", "jinja-subset", "none"}},
+		vars:   tagsFlag{},
+	}
+	fs.Var(t.engine, "template-engine", `template engine of the queries: go text/template ("gotemplate"), a subset of jinja ("jinja-subset", see the README for what it supports) or no templating ("none")`)
+	fs.Var(&t.delims, "template-delims", `left,right delimiters of template expressions, e.g. "[[,]]" for SQL with literal braces ("" == "{{,}}")`)
+	fs.Var(t.vars, "var", "key=value template variable, overrides an environment variable of the same name (repeatable)")
+	fs.Var(&t.secret, "secret", `comma separated patterns (e.g. "*_PASSWORD") of template variables whose values are redacted from logs, errors, audit records and reports`)
+	fs.Var(&t.envAllow, "env-allow", `comma separated patterns (e.g. "ATHENAQ_*") of environment variables templates may reference, referencing others fails ("" == all)`)
+	fs.StringVar(&t.calendarPath, "calendar", "", "holidays and weekend days of the business day functions of go templates (file://... | s3://... | https://...)")
+	fs.IntVar(&t.fiscalStart, "fiscal-year-start", 1, "first month (1-12) of the fiscal year of the fiscal period functions of go templates")
+	return t
+}
+
+// render renders query, unless it has a "-- template: raw" directive. A nil
+// templater renders go templates with the environment variables.
+func (t *templater) render(query string) (string, error) {
+	return t.renderWith(query, nil)
+}
+
+// renderStatement renders statement i of the input, which also gets its
+// position and name as QueryIndex and QueryName.
+func (t *templater) renderStatement(i int, query string) (string, error) {
+	return t.renderWith(query, map[string]string{"QueryIndex": strconv.Itoa(i + 1), "QueryName": queryName(query)})
+}
+
+func (t *templater) renderWith(query string, statement map[string]string) (string, error) {
+	if m := templateDirective.FindStringSubmatch(query); m != nil {
+		if m[1] != "raw" {
+			return "", fmt.Errorf("invalid template directive %q, expected raw", m[1])
+		}
+		return query, nil
+	}
+	if t == nil {
+		return execTemplate(query, nil, nil)
+	}
+	values, denied := t.values()
+	for _, builtins := range []map[string]string{t.builtins, statement} {
+		for k, v := range builtins {
+			if _, ok := t.vars[k]; !ok {
+				values[k] = v
+			}
+		}
+	}
+	switch t.engine.value {
+	case "none":
+		return query, nil
+	case "jinja-subset":
+		return execJinja(query, values, denied, t.delims[0], t.delims[1])
+	}
+	t.calendarOnce.Do(func() {
+		t.calendar, t.calendarErr = loadCalendar(t.calendarPath, t.fiscalStart)
+	})
+	if t.calendarErr != nil {
+		return "", t.calendarErr
+	}
+	return execTemplateDelims(query, t.calendar.funcs(), values, t.delims[0], t.delims[1], denied)
+}
+
+// values returns the template values and, with -env-allow, the environment
+// variables that were left out.
+func (t *templater) values() (map[string]string, map[string]bool) {
+	values := templateValues(t.vars)
+	for k, v := range values {
+		if matchAny(t.secret, k) {
+			secrets.add(v)
+		}
+	}
+	if len(t.envAllow) == 0 {
+		return values, nil
+	}
+	denied := map[string]bool{}
+	for k := range values {
+		if _, ok := t.vars[k]; ok || matchAny(t.envAllow, k) {
+			continue
+		}
+		delete(values, k)
+		denied[k] = true
+	}
+	return values, denied
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// deniedReference returns the first denied key a go template references as
+// .KEY or $.KEY, or "". Other missing keys render like without -env-allow,
+// so optional values keep working.
+func deniedReference(node parse.Node, denied map[string]bool) string {
+	if len(denied) == 0 || node == nil {
+		return ""
+	}
+	var children []parse.Node
+	switch n := node.(type) {
+	case *parse.FieldNode:
+		if denied[n.Ident[0]] {
+			return n.Ident[0]
+		}
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "$" && denied[n.Ident[1]] {
+			return n.Ident[1]
+		}
+	case *parse.ListNode:
+		children = n.Nodes
+	case *parse.ActionNode:
+		children = []parse.Node{n.Pipe}
+	case *parse.IfNode:
+		children = []parse.Node{n.Pipe, n.List, n.ElseList}
+	case *parse.RangeNode:
+		children = []parse.Node{n.Pipe, n.List, n.ElseList}
+	case *parse.WithNode:
+		children = []parse.Node{n.Pipe, n.List, n.ElseList}
+	case *parse.TemplateNode:
+		children = []parse.Node{n.Pipe}
+	case *parse.PipeNode:
+		for _, cmd := range n.Cmds {
+			children = append(children, cmd)
+		}
+	case *parse.CommandNode:
+		children = n.Args
+	case *parse.ChainNode:
+		children = []parse.Node{n.Node}
+	}
+	for _, child := range children {
+		// typed nil lists and pipes of missing else branches
+		if l, ok := child.(*parse.ListNode); ok && l == nil {
+			continue
+		}
+		if p, ok := child.(*parse.PipeNode); ok && p == nil {
+			continue
+		}
+		if key := deniedReference(child, denied); key != "" {
+			return key
+		}
+	}
+	return ""
+}
+
+func deniedEnvError(name string) error {
+	return fmt.Errorf("template references environment variable %s, which is not allowed by -env-allow", name)
+}
+
+// builtinValues are the template values of a run besides the environment
+// and -var: RunID, Hostname and, for input files in a git working tree,
+// GitSHA. -var overrides them.
+func builtinValues(runID, inputFile string) map[string]string {
+	values := map[string]string{"RunID": runID}
+	if host, err := os.Hostname(); err == nil {
+		values["Hostname"] = host
+	}
+	if sha := gitSHA(inputFile); sha != "" {
+		values["GitSHA"] = sha
+	}
+	return values
+}
+
+// gitSHA returns the commit checked out in the repository of a local input
+// file, "" if there is none.
+func gitSHA(inputFile string) string {
+	if inputFile == "" || strings.Contains(inputFile, "://") && !strings.HasPrefix(inputFile, "file://") {
+		return ""
+	}
+	dir := filepath.Dir(strings.TrimPrefix(inputFile, "file://"))
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		debugf("no git commit for %s: %v", inputFile, err)
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
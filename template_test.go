@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTemplaterRender(t *testing.T) {
+	os.Setenv("ATHENAQ_TEST_DB", "analytics")
+	os.Setenv("SECRET_TEST_TOKEN", "hunter2")
+	defer os.Unsetenv("ATHENAQ_TEST_DB")
+	defer os.Unsetenv("SECRET_TEST_TOKEN")
+
+	tests := []struct {
+		engine   string
+		delims   string
+		envAllow string
+		query    string
+		want     string
+		err      bool
+	}{
+		{engine: "gotemplate", query: "select '{{ .X }}'", want: "select 'x'"},
+		{engine: "gotemplate", delims: "[[,]]", query: "select transform(a, v -> {{v}}), '[[ .X ]]'", want: "select transform(a, v -> {{v}}), 'x'"},
+		{engine: "jinja-subset", delims: "[[,]]", query: "select '{{ }}', '[[ X | upper ]]'", want: "select '{{ }}', 'X'"},
+		{engine: "gotemplate", query: "-- template: raw\nselect '{{ .X }}'", want: "-- template: raw\nselect '{{ .X }}'"},
+		{engine: "jinja-subset", query: "-- template: raw\nselect '{{'", want: "-- template: raw\nselect '{{'"},
+		{engine: "jinja-subset", query: "{% macro d() %}{{ X }}{% endmacro %}select '{{ d() }}'", want: "select 'x'"},
+		{engine: "gotemplate", query: "-- template: jinja\nselect 1", err: true},
+		{engine: "none", query: "select '{{ .X }}'", want: "select '{{ .X }}'"},
+		{engine: "gotemplate", envAllow: "ATHENAQ_TEST_*", query: "select * from {{ .ATHENAQ_TEST_DB }}.t where x = '{{ .X }}'", want: "select * from analytics.t where x = 'x'"},
+		{engine: "gotemplate", envAllow: "ATHENAQ_TEST_*", query: "select '{{ .SECRET_TEST_TOKEN }}'", err: true},
+		{engine: "gotemplate", envAllow: "ATHENAQ_TEST_*", query: "select 1 {{ if .LIMIT }}limit {{ .LIMIT }}{{ end }}", want: "select 1 "},
+		{engine: "gotemplate", envAllow: "ATHENAQ_TEST_*", query: "select '{{ or .SUFFIX \"_v1\" }}'", want: "select '_v1'"},
+		{engine: "gotemplate", envAllow: "ATHENAQ_TEST_*", query: "select 1{{ if .X }}{{ else }}{{ $.SECRET_TEST_TOKEN }}{{ end }}", err: true},
+		{engine: "jinja-subset", envAllow: "ATHENAQ_TEST_*", query: "select * from {{ ATHENAQ_TEST_DB }}.t", want: "select * from analytics.t"},
+		{engine: "jinja-subset", envAllow: "ATHENAQ_TEST_*", query: "select '{{ SECRET_TEST_TOKEN | default('') }}'", err: true},
+	}
+	for _, test := range tests {
+		tmpl := &templater{engine: &choiceFlag{value: test.engine}, vars: tagsFlag{"X": "x"}}
+		if test.delims != "" {
+			if err := tmpl.delims.Set(test.delims); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if test.envAllow != "" {
+			if err := tmpl.envAllow.Set(test.envAllow); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := tmpl.render(test.query)
+		if (err != nil) != test.err {
+			t.Errorf("%q: unexpected error %v", test.query, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.query, got, test.want)
+		}
+	}
+}
+
+func TestTemplaterBuiltins(t *testing.T) {
+	tmpl := &templater{
+		engine:   &choiceFlag{value: "gotemplate"},
+		vars:     tagsFlag{"Hostname": "override"},
+		envAllow: patternsFlag{"ATHENAQ_TEST_*"},
+		builtins: map[string]string{"RunID": "run-1", "Hostname": "host"},
+	}
+	got, err := tmpl.renderStatement(2, "-- name: daily\nselect '{{ .RunID }}', {{ .QueryIndex }}, '{{ .QueryName }}', '{{ .Hostname }}'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "-- name: daily\nselect 'run-1', 3, 'daily', 'override'"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// the index counts the queries of the batch, like the report and the logs.
+	in := "-- setup\ncreate table tmp as select {{ .QueryIndex }} as i;\n-- end\n" +
+		"SET athenaq.head = 10;\nselect {{ .QueryIndex }};\n-- teardown\ndrop table tmp;\n-- end\nselect {{ .QueryIndex }}"
+	queries, err := readQueries(strings.NewReader(in), tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, main, _, transactions, err := sections(queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	main, _, _, err = applySettings(main, transactions, querySettings{})
+	if want := []string{"select 1", "select 2"}; err != nil || !reflect.DeepEqual(main, want) {
+		t.Errorf("got queries %q, %v, want %q", main, err, want)
+	}
+	// outside of a statement QueryIndex is missing like any optional key.
+	if got, err := tmpl.render("select {{ .QueryIndex }}"); err != nil || got != "select <no value>" {
+		t.Errorf("QueryIndex outside of a statement: got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+// queryThresholds are the data scanned and duration above which a single
+// query is warned about, also when it succeeds (0 == no threshold).
+type queryThresholds struct {
+	scannedBytes int64
+	duration     time.Duration
+}
+
+// check returns the thresholds the query exceeded. Its duration is the
+// time from submission to completion, falling back to the engine time.
+func (t *queryThresholds) check(queryExecution *athena.QueryExecution) []string {
+	if queryExecution == nil {
+		return nil
+	}
+	var exceeded []string
+	var scanned int64
+	var duration time.Duration
+	if s := queryExecution.Statistics; s != nil {
+		scanned = aws.Int64Value(s.DataScannedInBytes)
+		duration = millis(s.EngineExecutionTimeInMillis)
+	}
+	if s := queryExecution.Status; s != nil && s.SubmissionDateTime != nil && s.CompletionDateTime != nil {
+		duration = s.CompletionDateTime.Sub(*s.SubmissionDateTime)
+	}
+	if t.scannedBytes > 0 && scanned > t.scannedBytes {
+		exceeded = append(exceeded, fmt.Sprintf("scanned %s, more than -warn-scanned-bytes %s", formatBytes(scanned), formatBytes(t.scannedBytes)))
+	}
+	if t.duration > 0 && duration > t.duration {
+		exceeded = append(exceeded, fmt.Sprintf("took %s, longer than -warn-duration %s", duration.Round(time.Second), t.duration))
+	}
+	return exceeded
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+func TestQueryThresholds(t *testing.T) {
+	submitted := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	qe := func(scanned, engineMillis int64, took time.Duration) *athena.QueryExecution {
+		q := &athena.QueryExecution{
+			Statistics: &athena.QueryExecutionStatistics{DataScannedInBytes: aws.Int64(scanned), EngineExecutionTimeInMillis: aws.Int64(engineMillis)},
+			Status:     &athena.QueryExecutionStatus{State: aws.String("SUCCEEDED")},
+		}
+		if took > 0 {
+			q.Status.SubmissionDateTime, q.Status.CompletionDateTime = aws.Time(submitted), aws.Time(submitted.Add(took))
+		}
+		return q
+	}
+	thresholds := &queryThresholds{scannedBytes: 1 << 30, duration: time.Minute}
+	for _, tt := range []struct {
+		thresholds *queryThresholds
+		qe         *athena.QueryExecution
+		want       []string
+	}{
+		{thresholds, qe(1<<30, 1000, 2*time.Second), nil},
+		{thresholds, qe(3<<30, 1000, 2*time.Second), []string{"scanned 3.0 GiB, more than -warn-scanned-bytes 1.0 GiB"}},
+		{thresholds, qe(0, 1000, 90*time.Second), []string{"took 1m30s, longer than -warn-duration 1m0s"}},
+		{thresholds, qe(0, 120000, 0), []string{"took 2m0s, longer than -warn-duration 1m0s"}},
+		{&queryThresholds{}, qe(3<<30, 120000, 0), nil},
+		{thresholds, nil, nil},
+	} {
+		if got := tt.thresholds.check(tt.qe); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("check(%v) = %q, want %q", tt.qe, got, tt.want)
+		}
+	}
+}
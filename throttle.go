@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+const (
+	// submitRate is the initial rate of StartQueryExecution calls per second.
+	submitRate = 5.0
+	// maxThrottleBackoff caps the wait before a rejected submission is retried.
+	maxThrottleBackoff = time.Minute
+)
+
+// throttle queues query submissions. At most limit queries run at once and
+// submissions take tokens from a bucket refilled at rate. When athena rejects
+// a submission with TooManyRequestsException, the limit and the rate are
+// halved; every query that is accepted raises the limit by one again, up to
+// the configured concurrency.
+type throttle struct {
+	mu      sync.Mutex
+	running int
+	limit   int
+	max     int
+	rate    float64
+	tokens  float64
+	last    time.Time
+}
+
+func newThrottle(concurrency int) *throttle {
+	return &throttle{limit: concurrency, max: concurrency, rate: submitRate, tokens: float64(concurrency), last: time.Now()}
+}
+
+// acquire waits until a query may be submitted.
+func (t *throttle) acquire(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	for {
+		wait := t.tryAcquire(time.Now())
+		if wait == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (t *throttle) tryAcquire(now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	if burst := float64(t.max); t.tokens > burst {
+		t.tokens = burst
+	}
+	t.last = now
+	switch {
+	case t.running >= t.limit:
+		return 100 * time.Millisecond
+	case t.tokens < 1:
+		return time.Duration((1 - t.tokens) / t.rate * float64(time.Second))
+	}
+	t.tokens--
+	t.running++
+	return 0
+}
+
+// release frees the slot of a query once it finished.
+func (t *throttle) release() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running--
+}
+
+// throttled shrinks the limit and the rate after a rejected submission.
+func (t *throttle) throttled() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.limit > 1 {
+		t.limit /= 2
+	}
+	if t.rate > 0.1 {
+		t.rate /= 2
+	}
+	debugf("athena is throttling, running at most %d queries, %.1f submissions/s", t.limit, t.rate)
+}
+
+// accepted grows the limit and the rate after a successful submission.
+func (t *throttle) accepted() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.limit < t.max {
+		t.limit++
+	}
+	if t.rate < submitRate {
+		t.rate = math.Min(t.rate*1.25, submitRate)
+	}
+}
+
+func isTooManyRequests(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == "TooManyRequestsException"
+}
+
+type queryResult struct {
+	queryExecution *athena.QueryExecution
+	err            error
+	buf            bytes.Buffer
+}
+
+var priorityDirective = regexp.MustCompile(`(?m)^\s*--\s*priority:\s*(\S+)\s*$`)
+
+const (
+	priorityHigh = iota
+	priorityNormal
+	priorityLow
+)
+
+// queryPriority reads the "-- priority: high|normal|low" directive of a query.
+func queryPriority(query string) (int, error) {
+	m := priorityDirective.FindStringSubmatch(query)
+	if m == nil {
+		return priorityNormal, nil
+	}
+	switch strings.ToLower(m[1]) {
+	case "high":
+		return priorityHigh, nil
+	case "normal":
+		return priorityNormal, nil
+	case "low":
+		return priorityLow, nil
+	}
+	return 0, fmt.Errorf("invalid priority %q, expected high, normal or low", m[1])
+}
+
+// relaxed reports whether athena has not throttled submissions recently.
+func (t *throttle) relaxed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.limit == t.max
+}
+
+// executeParallel runs up to concurrency queries at once, high priority ones
+// first, and returns a channel per query that receives its result, or nil if
+// it was skipped. Low priority queries are held back while athena throttles.
+func (awsCli *awsCli) executeParallel(ctx context.Context, priorities []int, concurrency int, download bool, skip func(int) bool, execute func(int, io.Writer) (*athena.QueryExecution, error)) []chan *queryResult {
+	results := make([]chan *queryResult, len(priorities))
+	order := make([]int, len(priorities))
+	for i := range results {
+		results[i] = make(chan *queryResult, 1)
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return priorities[order[a]] < priorities[order[b]] })
+	go func() {
+		sem := make(chan struct{}, concurrency)
+		for n, i := range order {
+			for priorities[i] == priorityLow && !awsCli.throttle.relaxed() && ctx.Err() == nil {
+				time.Sleep(time.Second)
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				for _, i := range order[n:] {
+					results[i] <- &queryResult{err: ctx.Err()}
+				}
+				return
+			}
+			if skip(i) {
+				<-sem
+				results[i] <- nil
+				continue
+			}
+			go func(i int) {
+				defer func() { <-sem }()
+				r := &queryResult{}
+				var w io.Writer
+				if download {
+					w = &r.buf
+				}
+				r.queryExecution, r.err = execute(i, w)
+				results[i] <- r
+			}(i)
+		}
+	}()
+	return results
+}
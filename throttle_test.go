@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	now := time.Now()
+	th := &throttle{limit: 4, max: 4, rate: 2, tokens: 2, last: now}
+	for i := 0; i < 2; i++ {
+		if wait := th.tryAcquire(now); wait != 0 {
+			t.Fatalf("acquire %d: wait %v", i, wait)
+		}
+	}
+	if wait := th.tryAcquire(now); wait != 500*time.Millisecond {
+		t.Errorf("acquire without tokens: wait %v, want 500ms", wait)
+	}
+	if wait := th.tryAcquire(now.Add(500 * time.Millisecond)); wait != 0 {
+		t.Errorf("acquire after refill: wait %v", wait)
+	}
+
+	th.throttled()
+	if th.limit != 2 || th.rate != 1 {
+		t.Errorf("after throttling: limit %d, rate %v", th.limit, th.rate)
+	}
+	if wait := th.tryAcquire(now.Add(10 * time.Second)); wait == 0 {
+		t.Error("acquired a slot beyond the reduced limit")
+	}
+	th.release()
+	th.release()
+	th.accepted()
+	th.accepted()
+	th.accepted()
+	if th.limit != 4 || th.rate != 1.953125 {
+		t.Errorf("after recovering: limit %d, rate %v", th.limit, th.rate)
+	}
+}
+
+func TestQueryPriority(t *testing.T) {
+	for _, tt := range []struct {
+		query string
+		want  int
+		err   bool
+	}{
+		{"select 1", priorityNormal, false},
+		{"-- priority: high\nselect 1", priorityHigh, false},
+		{"--priority: LOW\nselect 1", priorityLow, false},
+		{"-- priority: urgent\nselect 1", 0, true},
+	} {
+		got, err := queryPriority(tt.query)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("queryPriority(%q) = %d, %v", tt.query, got, err)
+		}
+	}
+}
+
+// TestRunParallel runs a batch with -parallel against -fake, run it with
+// -race to catch unsynchronized state shared by the queries.
+func TestRunParallel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq-parallel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fakeDir := filepath.Join(dir, "fake")
+	if err := os.Mkdir(fakeDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var input, want strings.Builder
+	for i := 1; i <= 12; i++ {
+		sql := fmt.Sprintf("select %d", i)
+		csv := fmt.Sprintf("\"_col0\"\n\"%d\"\n", i)
+		ioutil.WriteFile(filepath.Join(fakeDir, fmt.Sprintf("q%d.sql", i)), []byte(sql), 0644)
+		ioutil.WriteFile(filepath.Join(fakeDir, fmt.Sprintf("q%d.csv", i)), []byte(csv), 0644)
+		fmt.Fprintf(&input, "%s;\n", sql)
+		want.WriteString(csv)
+	}
+	inputFile := filepath.Join(dir, "batch.sql")
+	if err := ioutil.WriteFile(inputFile, []byte(input.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.csv")
+
+	defer func(args []string, fs *flag.FlagSet) {
+		os.Args, flag.CommandLine = args, fs
+	}(os.Args, flag.CommandLine)
+	os.Args = []string{"athenaq", "-fake", fakeDir, "-parallel", "4", "-history=false", "-progress=false",
+		"-f", inputFile, "-out", "file://" + out}
+	flag.CommandLine = flag.NewFlagSet("athenaq", flag.ContinueOnError)
+	if err := run(); err != nil {
+		t.Fatalf("parallel run failed: %v", err)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want.String() {
+		t.Errorf("got %q, want the results in the order of the batch %q", data, want.String())
+	}
+}
+
+func TestS3ForConcurrent(t *testing.T) {
+	f := newFakeAWS(t)
+	defer f.Close()
+	awsCli := f.client()
+	done := make(chan struct{})
+	for i := 0; i < 20; i++ {
+		go func(i int) {
+			defer func() { done <- struct{}{} }()
+			awsCli.s3For(fmt.Sprintf("bucket-%d", i%3))
+			awsCli.AccountID()
+		}(i)
+	}
+	for i := 0; i < 20; i++ {
+		<-done
+	}
+	if n := f.count("GetCallerIdentity"); n != 1 {
+		t.Errorf("%d GetCallerIdentity calls, want 1", n)
+	}
+}
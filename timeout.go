@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var timeoutDirective = regexp.MustCompile(`(?m)^\s*--\s*timeout:\s*(\S+)\s*$`)
+
+// timeoutClasses are the statement classes of -statement-timeout, with
+// CREATE TABLE AS apart from the other ddl.
+var timeoutClasses = []string{"select", "dml", "ddl", "ctas"}
+
+// timeoutsFlag is a comma separated list of class=duration.
+type timeoutsFlag map[string]time.Duration
+
+func (f timeoutsFlag) String() string {
+	var pairs []string
+	for c, d := range f {
+		pairs = append(pairs, c+"="+d.String())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f timeoutsFlag) Set(s string) error {
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid value %q, expected class=duration", pair)
+		}
+		class := strings.ToLower(kv[0])
+		valid := false
+		for _, c := range timeoutClasses {
+			valid = valid || class == c
+		}
+		if !valid {
+			return fmt.Errorf("invalid statement type %q, expected %s", kv[0], strings.Join(timeoutClasses, ", "))
+		}
+		d, err := time.ParseDuration(kv[1])
+		if err != nil {
+			return fmt.Errorf("invalid timeout of %s: %v", class, err)
+		}
+		f[class] = d
+	}
+	return nil
+}
+
+// queryTimeout returns the timeout of a query: its "-- timeout: <duration>"
+// directive, or else the one of its statement class. CREATE TABLE AS without
+// a ctas timeout gets the ddl one. 0 is no timeout.
+func queryTimeout(query string, timeouts timeoutsFlag) (time.Duration, error) {
+	if m := timeoutDirective.FindStringSubmatch(query); m != nil {
+		d, err := time.ParseDuration(m[1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid timeout %q: %v", m[1], err)
+		}
+		return d, nil
+	}
+	toks := tokenize(query)
+	if d, ok := timeouts["ctas"]; ok && isCTAS(toks) {
+		return d, nil
+	}
+	return timeouts[statementClass(toks)], nil
+}
+
+// isCTAS reports whether a statement is a CREATE TABLE AS, whose table
+// properties may come in a WITH (...) before the AS.
+func isCTAS(toks []token) bool {
+	if firstKeyword(toks) != "CREATE" {
+		return false
+	}
+	table, depth, prev := false, 0, -1
+	for i := nextToken(toks, 0); i < len(toks); prev, i = i, nextToken(toks, i+1) {
+		switch {
+		case toks[i].text == "(":
+			// the column list of a plain CREATE TABLE.
+			if depth == 0 && (prev < 0 || !toks[prev].is("WITH")) {
+				return false
+			}
+			depth++
+		case toks[i].text == ")":
+			depth--
+		case depth > 0:
+		case toks[i].is("TABLE"):
+			table = true
+		case toks[i].is("VIEW"):
+			return false
+		case toks[i].is("AS"):
+			return table
+		}
+	}
+	return false
+}
+
+type queryTimeoutKey struct{}
+
+// withQueryTimeout sets the time a query run with ctx may take from its
+// submission before it is cancelled.
+func withQueryTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, queryTimeoutKey{}, timeout)
+}
+
+func queryTimeoutOf(ctx context.Context) time.Duration {
+	timeout, _ := ctx.Value(queryTimeoutKey{}).(time.Duration)
+	return timeout
+}
+
+// errQueryTimeout is the error of a query cancelled after its timeout.
+func errQueryTimeout(timeout time.Duration) error {
+	return fmt.Errorf("query timeout of %v exceeded, cancelled the query", timeout)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryTimeout(t *testing.T) {
+	timeouts := timeoutsFlag{}
+	if err := timeouts.Set("select=10m,ddl=1m,ctas=2h"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		query string
+		want  time.Duration
+	}{
+		{"SELECT * FROM t", 10 * time.Minute},
+		{"-- timeout: 30s\nSELECT * FROM t", 30 * time.Second},
+		{"CREATE EXTERNAL TABLE t (id int) LOCATION 's3://b/t/'", time.Minute},
+		{"CREATE TABLE t WITH (format = 'PARQUET') AS SELECT * FROM u", 2 * time.Hour},
+		{"CREATE TABLE IF NOT EXISTS db.t AS (SELECT 1)", 2 * time.Hour},
+		{"CREATE VIEW v AS SELECT * FROM t", time.Minute},
+		{"INSERT INTO t SELECT * FROM u", 0},
+	} {
+		if got, err := queryTimeout(tt.query, timeouts); err != nil || got != tt.want {
+			t.Errorf("queryTimeout(%q) = %v, %v, want %v", tt.query, got, err, tt.want)
+		}
+	}
+	if _, err := queryTimeout("-- timeout: soon\nSELECT 1", timeouts); err == nil {
+		t.Error("want an error for an invalid timeout directive")
+	}
+	if err := (timeoutsFlag{}).Set("insert=1m"); err == nil {
+		t.Error("want an error for an unknown statement type")
+	}
+}
+
+func TestStatementTimeout(t *testing.T) {
+	f := newFakeAWS(t)
+	defer f.Close()
+	f.result("select 1", "\"_col0\"\n\"1\"\n")
+	f.queue("select 1", 1)
+	awsCli := f.client()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var buf bytes.Buffer
+	_, err := awsCli.execQuery(withQueryTimeout(ctx, 50*time.Millisecond), "select 1", &buf)
+	if err == nil || !strings.Contains(err.Error(), "query timeout") {
+		t.Errorf("got error %v, want a query timeout", err)
+	}
+	if class := classifyError(err); class != errorResource {
+		t.Errorf("error class %q, want %q", class, errorResource)
+	}
+	if n := f.count("StopQueryExecution"); n != 1 {
+		t.Errorf("%d StopQueryExecution calls, want 1", n)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+)
+
+func hasTransactions(transactions []int) bool {
+	for _, group := range transactions {
+		if group != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// txTable is an iceberg table written by a "-- transaction" group, with its
+// snapshot before the group ran ("" == the table had none).
+type txTable struct {
+	database, table string
+	snapshot        string
+}
+
+func (t *txTable) name() string {
+	return quoteIdent(t.database) + "." + quoteIdent(t.table)
+}
+
+func currentSnapshotQuery(database, table string) string {
+	return fmt.Sprintf("SELECT snapshot_id FROM %s.%s WHERE is_current_ancestor ORDER BY made_current_at DESC LIMIT 1",
+		quoteIdent(database), quoteIdent(table+"$history"))
+}
+
+// rollbackStatements restore the contents of the table at its snapshot
+// before the group. athena can't roll back iceberg tables, so this replaces
+// the rows with the ones time travel reads from the snapshot.
+func rollbackStatements(t *txTable) []string {
+	statements := []string{"DELETE FROM " + t.name()}
+	if t.snapshot != "" {
+		statements = append(statements, fmt.Sprintf("INSERT INTO %s SELECT * FROM %s FOR VERSION AS OF %s", t.name(), t.name(), t.snapshot))
+	}
+	return statements
+}
+
+// beginTransaction records the snapshots of the tables the statements of a
+// group write. It refuses groups that write anything but iceberg tables, as
+// they couldn't be rolled back.
+func (awsCli *awsCli) beginTransaction(ctx context.Context, queries []string, settings []querySettings) ([]*txTable, error) {
+	var tables []*txTable
+	seen := map[string]bool{}
+	for i, query := range queries {
+		toks := tokenize(query)
+		if statementClass(toks) == "select" {
+			continue
+		}
+		switch keyword := firstKeyword(toks); keyword {
+		case "INSERT", "MERGE", "UPDATE", "DELETE":
+		default:
+			return nil, fmt.Errorf("%s statements can't be rolled back in a transaction", keyword)
+		}
+		for _, ref := range targetTables(toks) {
+			database, table, ok := glueTableName(queryContext(ctx, settings, i), ref, awsCli.catalog)
+			if !ok {
+				return nil, fmt.Errorf("transaction writes %s, which is not in the glue catalog", ref.name())
+			}
+			if seen[database+"."+table] {
+				continue
+			}
+			seen[database+"."+table] = true
+			t, err := awsCli.glue.getTable(ctx, database, table)
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not get table %s.%s", database, table)
+			}
+			if !strings.EqualFold(aws.StringValue(t.Parameters["table_type"]), "ICEBERG") {
+				return nil, fmt.Errorf("transaction writes %s.%s, which is not an iceberg table", database, table)
+			}
+			tx := &txTable{database: database, table: table}
+			if tx.snapshot, err = awsCli.currentSnapshot(ctx, tx); err != nil {
+				return nil, err
+			}
+			tables = append(tables, tx)
+		}
+	}
+	return tables, nil
+}
+
+func (awsCli *awsCli) currentSnapshot(ctx context.Context, t *txTable) (string, error) {
+	var buf bytes.Buffer
+	if _, err := awsCli.execQuery(unwatched(ctx), currentSnapshotQuery(t.database, t.table), &buf); err != nil {
+		return "", errors.Wrapf(err, "could not get the snapshot of %s.%s", t.database, t.table)
+	}
+	rows := strings.SplitN(buf.String(), "\n", 3)
+	if len(rows) < 2 || strings.TrimSpace(rows[1]) == "" {
+		return "", nil
+	}
+	return unquoteField(csvFields([]byte(rows[1]))[0]), nil
+}
+
+// rollback restores the tables of a failed group that changed since it
+// began. It continues with the other tables when one fails.
+func (awsCli *awsCli) rollback(ctx context.Context, tables []*txTable) error {
+	var failed []string
+	for _, t := range tables {
+		current, err := awsCli.currentSnapshot(ctx, t)
+		if err == nil && current == t.snapshot {
+			continue
+		}
+		if err == nil {
+			infof("rolling back %s.%s to snapshot %s", t.database, t.table, t.snapshot)
+			err = awsCli.runSection(ctx, "rollback", rollbackStatements(t))
+		}
+		if err != nil {
+			errorf("could not roll back %s.%s: %v", t.database, t.table, err)
+			failed = append(failed, t.database+"."+t.table)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("could not roll back %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestRollbackStatements(t *testing.T) {
+	tests := []struct {
+		table *txTable
+		want  []string
+	}{
+		{
+			&txTable{database: "db", table: "events", snapshot: "123"},
+			[]string{`DELETE FROM "db"."events"`, `INSERT INTO "db"."events" SELECT * FROM "db"."events" FOR VERSION AS OF 123`},
+		},
+		{
+			&txTable{database: "db", table: "new"},
+			[]string{`DELETE FROM "db"."new"`},
+		},
+	}
+	for _, tt := range tests {
+		if got := rollbackStatements(tt.table); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%+v: got %q", tt.table, got)
+		}
+	}
+	if got, want := currentSnapshotQuery("db", "events"), `SELECT snapshot_id FROM "db"."events$history" WHERE is_current_ancestor ORDER BY made_current_at DESC LIMIT 1`; got != want {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestBeginTransactionRefusesDDL(t *testing.T) {
+	awsCli := &awsCli{}
+	for _, query := range []string{"create table t (id int)", "drop table t", "alter table t add columns (x int)"} {
+		if _, err := awsCli.beginTransaction(context.Background(), []string{"select 1", query}, nil); err == nil {
+			t.Errorf("%q: no error", query)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAWSHTTPClient(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	srv.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	srv.StartTLS()
+	defer srv.Close()
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.Method+" "+r.URL.String()+" "+r.Header.Get("Proxy-Authorization"))
+		fmt.Fprint(w, "proxied")
+	}))
+	defer proxy.Close()
+
+	dir, err := ioutil.TempDir("", "athenaq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bundle := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600)
+	empty := filepath.Join(dir, "empty.pem")
+	ioutil.WriteFile(empty, []byte("nothing"), 0600)
+
+	defer func(old httpOptions) { awsHTTP = old }(awsHTTP)
+	defer os.Setenv("AWS_CA_BUNDLE", os.Getenv("AWS_CA_BUNDLE"))
+	os.Setenv("AWS_CA_BUNDLE", "")
+	for _, tc := range []struct {
+		options  httpOptions
+		url      string
+		expected string
+	}{
+		{httpOptions{tlsMinVersion: "1.2"}, srv.URL, "default client"},
+		{httpOptions{tlsMinVersion: "1.0"}, srv.URL, "certificate"},
+		{httpOptions{caBundle: bundle, tlsMinVersion: "1.2"}, srv.URL, "ok"},
+		{httpOptions{caBundle: bundle, tlsMinVersion: "1.3"}, srv.URL, "protocol version"},
+		{httpOptions{caBundle: empty, tlsMinVersion: "1.2"}, srv.URL, "no certificates in " + empty},
+		{httpOptions{caBundle: filepath.Join(dir, "missing.pem"), tlsMinVersion: "1.2"}, srv.URL, "could not read ca bundle"},
+		{httpOptions{proxy: strings.Replace(proxy.URL, "http://", "http://user:pw@", 1), tlsMinVersion: "1.2"}, "http://athena.eu-west-1.amazonaws.com/", "proxied"},
+		{httpOptions{proxy: "proxy:3128", tlsMinVersion: "1.2"}, srv.URL, `invalid -proxy "proxy:3128"`},
+	} {
+		awsHTTP = tc.options
+		client, err := awsHTTPClient(time.Minute)
+		actual := ""
+		switch {
+		case err != nil:
+			actual = err.Error()
+		case client == nil:
+			actual = "default client"
+		default:
+			resp, err := client.Get(tc.url)
+			if err != nil {
+				actual = err.Error()
+				break
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			actual = string(body)
+		}
+		if !strings.Contains(actual, tc.expected) {
+			t.Errorf("%+v: %q, expected %q", tc.options, actual, tc.expected)
+		}
+	}
+	if len(proxied) != 1 || proxied[0] != "GET http://athena.eu-west-1.amazonaws.com/ Basic dXNlcjpwdw==" {
+		t.Errorf("proxied = %q", proxied)
+	}
+
+	// AWS_CA_BUNDLE replaces the system roots, also without flags.
+	os.Setenv("AWS_CA_BUNDLE", bundle)
+	awsHTTP = httpOptions{tlsMinVersion: "1.2"}
+	client, err := awsHTTPClient(time.Minute)
+	if err != nil || client == nil {
+		t.Fatalf("awsHTTPClient() = %v, %v", client, err)
+	}
+	if resp, err := client.Get(srv.URL); err != nil {
+		t.Error(err)
+	} else {
+		resp.Body.Close()
+	}
+}
+
+func TestTLSVersionFlag(t *testing.T) {
+	var v tlsVersionFlag
+	if err := v.Set("1.3"); err != nil || v != "1.3" {
+		t.Errorf("Set(1.3) = %v, %q", err, v)
+	}
+	if err := v.Set("1.4"); err == nil || v != "1.3" {
+		t.Errorf("Set(1.4) = %v, %q", err, v)
+	}
+}
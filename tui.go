@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/pkg/errors"
+)
+
+type dashboardQuery struct {
+	sql       string
+	name      string
+	state     string
+	id        string
+	start     time.Time
+	end       time.Time
+	scanned   int64
+	err       string
+	cancelled bool
+	stopSent  bool
+}
+
+// dashboard is a full screen terminal UI listing every query of the batch.
+// Keys are read from the controlling terminal, so it works while the queries
+// themselves are read from STDIN.
+type dashboard struct {
+	mu       sync.Mutex
+	athena   *athena.Athena
+	w        io.Writer
+	tty      *os.File
+	sttyMode string
+	queries  []*dashboardQuery
+	selected int
+	closed   bool
+	stop     chan struct{}
+}
+
+func newDashboard(athenaCli *athena.Athena, queries []string) (*dashboard, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open terminal")
+	}
+	mode, err := stty(tty, "-g")
+	if err != nil {
+		tty.Close()
+		return nil, errors.Wrap(err, "could not read terminal mode")
+	}
+	if _, err = stty(tty, "cbreak", "-echo"); err != nil {
+		tty.Close()
+		return nil, errors.Wrap(err, "could not set terminal mode")
+	}
+
+	d := &dashboard{athena: athenaCli, w: tty, tty: tty, sttyMode: strings.TrimSpace(mode), stop: make(chan struct{})}
+	for _, q := range queries {
+		d.queries = append(d.queries, &dashboardQuery{sql: secrets.redact(q), name: queryName(q), state: "PENDING"})
+	}
+	fmt.Fprint(d.w, "\033[?25l")
+	d.render()
+	go d.readKeys()
+	go d.tick()
+	return d, nil
+}
+
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+func (d *dashboard) tick() {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-t.C:
+			d.mu.Lock()
+			d.render()
+			d.mu.Unlock()
+		}
+	}
+}
+
+func (d *dashboard) readKeys() {
+	buf := make([]byte, 3)
+	for {
+		n, err := d.tty.Read(buf)
+		if err != nil {
+			return
+		}
+		d.mu.Lock()
+		if d.closed {
+			d.mu.Unlock()
+			return
+		}
+		d.handleKey(string(buf[:n]))
+		d.render()
+		d.mu.Unlock()
+	}
+}
+
+func (d *dashboard) handleKey(key string) {
+	switch key {
+	case "k", "\033[A":
+		if d.selected > 0 {
+			d.selected--
+		}
+	case "j", "\033[B":
+		if d.selected < len(d.queries)-1 {
+			d.selected++
+		}
+	case "c":
+		if len(d.queries) > 0 {
+			d.cancel(d.queries[d.selected])
+		}
+	case "q":
+		for _, q := range d.queries {
+			d.cancel(q)
+		}
+	}
+}
+
+func (d *dashboard) cancel(q *dashboardQuery) {
+	switch q.state {
+	case "PENDING":
+		q.cancelled = true
+		q.state = "SKIPPED"
+	case "SUCCEEDED", "FAILED", "CANCELLED", "SKIPPED":
+	default:
+		q.cancelled = true
+		d.stopQuery(q)
+	}
+}
+
+// stopQuery sends StopQueryExecution once the query id is known, and only
+// once per query.
+func (d *dashboard) stopQuery(q *dashboardQuery) {
+	if q.id == "" || q.stopSent {
+		return
+	}
+	q.stopSent = true
+	go d.athena.StopQueryExecution(&athena.StopQueryExecutionInput{QueryExecutionId: aws.String(q.id)})
+}
+
+func (d *dashboard) cancelled(index int) bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.queries[index].cancelled
+}
+
+func (d *dashboard) start(index int, query string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	q := d.queries[index]
+	q.state = "SUBMITTED"
+	q.start = time.Now()
+	d.render()
+}
+
+func (d *dashboard) update(index int, queryExecution *athena.QueryExecution) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	q := d.queries[index]
+	q.id = aws.StringValue(queryExecution.QueryExecutionId)
+	q.state = aws.StringValue(queryExecution.Status.State)
+	if s := queryExecution.Statistics; s != nil {
+		q.scanned = aws.Int64Value(s.DataScannedInBytes)
+	}
+	if q.cancelled && q.state != "CANCELLED" {
+		d.stopQuery(q)
+	}
+	d.render()
+}
+
+func (d *dashboard) done(index int, queryExecution *athena.QueryExecution, err error) {
+	if queryExecution != nil {
+		d.update(index, queryExecution)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	q := d.queries[index]
+	q.end = time.Now()
+	if err != nil {
+		q.err = secrets.redact(err.Error())
+		if q.state != "CANCELLED" {
+			q.state = "FAILED"
+		}
+	}
+	d.render()
+}
+
+func (d *dashboard) render() {
+	if d.closed {
+		return
+	}
+	var b strings.Builder
+	b.WriteString("\033[H\033[2J")
+	finished := 0
+	for _, q := range d.queries {
+		if !q.end.IsZero() || q.state == "SKIPPED" {
+			finished++
+		}
+	}
+	fmt.Fprintf(&b, "athenaq: %d/%d queries done   (j/k select, c cancel, q cancel all)\r\n\r\n", finished, len(d.queries))
+	for i, q := range d.queries {
+		cursor := " "
+		if i == d.selected {
+			cursor = ">"
+		}
+		elapsed := ""
+		if !q.start.IsZero() {
+			end := q.end
+			if end.IsZero() {
+				end = time.Now()
+			}
+			elapsed = end.Sub(q.start).Truncate(time.Second).String()
+		}
+		sql := strings.Join(strings.Fields(q.sql), " ")
+		if q.name != "" {
+			sql = q.name + ": " + strings.Join(strings.Fields(stripComments(q.sql)), " ")
+		}
+		if r := []rune(sql); len(r) > 60 {
+			sql = string(r[:57]) + "..."
+		}
+		fmt.Fprintf(&b, "%s %3d  %-10s %8s %10s  %s\r\n", cursor, i+1, q.state, elapsed, formatBytes(q.scanned), sql)
+		if q.err != "" {
+			lines := strings.Split(strings.TrimSpace(q.err), "\n")
+			fmt.Fprintf(&b, "        \033[31m%s\033[0m\r\n", lines[len(lines)-1])
+		}
+	}
+	fmt.Fprint(d.w, b.String())
+}
+
+func (d *dashboard) close() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	d.render()
+	d.closed = true
+	close(d.stop)
+	fmt.Fprint(d.w, "\033[?25h")
+	stty(d.tty, d.sttyMode)
+	d.tty.Close()
+}
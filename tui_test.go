@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+func TestDashboardRender(t *testing.T) {
+	var buf bytes.Buffer
+	d := &dashboard{w: &buf, queries: []*dashboardQuery{
+		{sql: "select\n  1", state: "PENDING"},
+		{sql: "select '" + strings.Repeat("ü", 70) + "'", state: "FAILED", err: "could not execute\nSYNTAX_ERROR: line 1:8"},
+	}}
+	d.render()
+	out := buf.String()
+	if !strings.Contains(out, ">   1  PENDING") || !strings.Contains(out, "select 1\r\n") {
+		t.Errorf("missing first query in %q", out)
+	}
+	if want := "select '" + strings.Repeat("ü", 49) + "..."; !strings.Contains(out, want+"\r\n") {
+		t.Errorf("long query not truncated on runes in %q", out)
+	}
+	if !strings.Contains(out, "SYNTAX_ERROR: line 1:8") || strings.Contains(out, "could not execute") {
+		t.Errorf("expected last error line only in %q", out)
+	}
+}
+
+func TestDashboardKeys(t *testing.T) {
+	d := &dashboard{w: &bytes.Buffer{}}
+	for _, key := range []string{"j", "k", "c", "q"} {
+		d.handleKey(key)
+	}
+
+	d.queries = []*dashboardQuery{{state: "SUCCEEDED"}, {state: "PENDING"}, {state: "PENDING"}}
+	d.handleKey("j")
+	d.handleKey("\033[B")
+	d.handleKey("j")
+	if d.selected != 2 {
+		t.Errorf("selected = %d, want 2", d.selected)
+	}
+	d.handleKey("k")
+	d.handleKey("c")
+	if q := d.queries[1]; !q.cancelled || q.state != "SKIPPED" {
+		t.Errorf("got %+v, want skipped query", q)
+	}
+	d.handleKey("q")
+	if q := d.queries[0]; q.cancelled {
+		t.Error("finished query must not be cancelled")
+	}
+	if q := d.queries[2]; !q.cancelled || q.state != "SKIPPED" {
+		t.Errorf("got %+v, want skipped query", q)
+	}
+}
+
+func TestDashboardStopsOnce(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		stops int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") == "AmazonAthena.StopQueryExecution" {
+			mu.Lock()
+			stops++
+			mu.Unlock()
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Endpoint:    aws.String(srv.URL),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+
+	d := &dashboard{athena: athena.New(sess), w: &bytes.Buffer{}, queries: []*dashboardQuery{{sql: "select 1", state: "PENDING"}}}
+	d.start(0, "select 1")
+	running := &athena.QueryExecution{
+		QueryExecutionId: aws.String("q-1"),
+		Status:           &athena.QueryExecutionStatus{State: aws.String("RUNNING")},
+	}
+	d.update(0, running)
+	d.handleKey("c")
+	for i := 0; i < 5; i++ {
+		d.update(0, running)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := stops
+		mu.Unlock()
+		if n > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if stops != 1 {
+		t.Errorf("got %d StopQueryExecution calls, want 1", stops)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+)
+
+func viewsCmd(args []string) error {
+	fs := flag.NewFlagSet("views", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: athenaq views [flags]")
+		fs.PrintDefaults()
+	}
+	var (
+		awsFlags = addAWSFlags(fs)
+		dir      = fs.String("dir", "views", "directory of view.sql files with the SELECT of each view")
+		database = fs.String("database", "", "target database")
+		dry      = fs.Bool("dry", false, "print the statements of changed views instead of executing them")
+	)
+	fs.Parse(args)
+	if *database == "" {
+		return errors.New("-database is required")
+	}
+
+	views, err := loadViews(*dir, *database)
+	if err != nil {
+		return err
+	}
+	order, err := sortModels(views)
+	if err != nil {
+		return err
+	}
+
+	awsCli, err := newAWS(awsFlags)
+	if err != nil {
+		return errors.Wrap(err, "could not initialize aws client")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *awsFlags.timeout)
+	defer cancel()
+
+	for _, name := range order {
+		t, err := awsCli.glue.getTable(ctx, *database, name)
+		if err != nil && !isNotFound(err) {
+			return errors.Wrapf(err, "could not get view %q", name)
+		}
+		statement, err := viewStatement(*database, name, views[name].source, t)
+		if err != nil {
+			return errors.Wrapf(err, "view %q", name)
+		}
+		if statement == "" {
+			infof("view %s is up to date", name)
+			continue
+		}
+		if *dry {
+			fmt.Println("execute query:", statement)
+			continue
+		}
+		infof("view %s", name)
+		if _, err := awsCli.execQuery(ctx, statement, nil); err != nil {
+			return errors.Wrapf(err, "could not replace view %q", name)
+		}
+	}
+	return nil
+}
+
+// loadViews reads every *.sql file in dir as a view named after the file.
+// A view depends on the other views of the directory it reads, so they are
+// replaced first.
+func loadViews(dir, database string) (map[string]*model, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+	views := map[string]*model{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read view")
+		}
+		// glue lower cases table names
+		name := strings.ToLower(strings.TrimSuffix(filepath.Base(file), ".sql"))
+		views[name] = &model{name: name, source: strings.TrimRight(strings.TrimSpace(string(data)), ";")}
+	}
+	viewDeps(views, database)
+	return views, nil
+}
+
+// viewDeps sets the deps of every view to the other views of database it
+// reads.
+func viewDeps(views map[string]*model, database string) {
+	for _, v := range views {
+		for _, ref := range sourceTables(tokenize(v.source)) {
+			n := len(ref.parts)
+			if n > 1 && !strings.EqualFold(ref.parts[n-2], database) || n > 2 && !isGlueCatalog(ref.parts[n-3]) {
+				continue
+			}
+			if dep := strings.ToLower(ref.parts[n-1]); views[dep] != nil && dep != v.name {
+				v.deps = append(v.deps, dep)
+			}
+		}
+	}
+}
+
+// viewStatement returns the statement that replaces the view t with sql, or
+// "" if its definition doesn't differ apart from formatting.
+func viewStatement(database, name, sql string, t *glueTable) (string, error) {
+	statement := "CREATE OR REPLACE VIEW " + quoteIdent(database) + "." + quoteIdent(name) + " AS\n" + sql
+	if t == nil {
+		return statement, nil
+	}
+	if aws.StringValue(t.TableType) != "VIRTUAL_VIEW" {
+		return "", fmt.Errorf("%s.%s is a table, not a view", database, name)
+	}
+	current, err := viewSQL(t)
+	if err != nil {
+		return "", err
+	}
+	if fingerprint(current) == fingerprint(sql) {
+		return "", nil
+	}
+	return statement, nil
+}
+
+var prestoView = regexp.MustCompile(`^/\* Presto View: ([A-Za-z0-9+/=]+) \*/$`)
+
+// viewSQL returns the SELECT athena stores, base64 encoded, in the original
+// text of a view.
+func viewSQL(t *glueTable) (string, error) {
+	m := prestoView.FindStringSubmatch(strings.TrimSpace(aws.StringValue(t.ViewOriginalText)))
+	if m == nil {
+		return "", errors.New("not an athena view")
+	}
+	data, err := base64.StdEncoding.DecodeString(m[1])
+	if err != nil {
+		return "", errors.Wrap(err, "could not decode view")
+	}
+	var view struct {
+		OriginalSQL string `json:"originalSql"`
+	}
+	if err := json.Unmarshal(data, &view); err != nil {
+		return "", errors.Wrap(err, "could not decode view")
+	}
+	return view.OriginalSQL, nil
+}
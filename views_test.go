@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestViewStatement(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte(`{"originalSql":"SELECT id, name FROM users WHERE active","catalog":"awsdatacatalog","schema":"analytics"}`))
+	view := &glueTable{TableType: aws.String("VIRTUAL_VIEW"), ViewOriginalText: aws.String("/* Presto View: " + encoded + " */")}
+	for _, tt := range []struct {
+		sql     string
+		t       *glueTable
+		want    string
+		wantErr bool
+	}{
+		{"select id, name\nfrom users -- only active\nwhere active", view, "", false},
+		{"select id from users", view, "CREATE OR REPLACE VIEW \"analytics\".\"active_users\" AS\nselect id from users", false},
+		{"select id from users", nil, "CREATE OR REPLACE VIEW \"analytics\".\"active_users\" AS\nselect id from users", false},
+		{"select id from users", &glueTable{TableType: aws.String("EXTERNAL_TABLE")}, "", true},
+		{"select id from users", &glueTable{TableType: aws.String("VIRTUAL_VIEW"), ViewOriginalText: aws.String("select 1")}, "", true},
+	} {
+		got, err := viewStatement("analytics", "active_users", tt.sql, tt.t)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("viewStatement(%q) = %q, %v, want %q", tt.sql, got, err, tt.want)
+		}
+	}
+}
+
+func TestLoadViews(t *testing.T) {
+	dir, err := ioutil.TempDir("", "athenaq-views")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, sql := range map[string]string{
+		"Active_Users.sql": "select * from users where active;\n",
+		"report.sql":       "select count(*) from analytics.active_users join other.report using (id)",
+		"summary.sql":      "with report as (select 1) select * from report, awsdatacatalog.analytics.active_users",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(sql), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	views, err := loadViews(dir, "analytics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := views["active_users"].source; got != "select * from users where active" {
+		t.Errorf("source = %q", got)
+	}
+	for name, want := range map[string][]string{
+		"active_users": nil,
+		"report":       {"active_users"},
+		"summary":      {"active_users"},
+	} {
+		if got := views[name].deps; !reflect.DeepEqual(got, want) {
+			t.Errorf("deps of %s = %q, want %q", name, got, want)
+		}
+	}
+	order, err := sortModels(views)
+	if err != nil || order[0] != "active_users" {
+		t.Errorf("sortModels() = %q, %v", order, err)
+	}
+}
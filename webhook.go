@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// webhookPayload renders the json bodies webhook hooks are sent, by default
+// the hookData itself.
+type webhookPayload struct {
+	path string
+	tmpl *template.Template
+	// run is the summary of the run so far.
+	run *runReport
+}
+
+// hookData are the fields of the hook environment and the run summary,
+// which webhook payload templates render.
+type hookData struct {
+	Hook             string     `json:"hook"`
+	RunID            string     `json:"run_id"`
+	QueryIndex       int        `json:"query_index,omitempty"`
+	QueryName        string     `json:"query_name,omitempty"`
+	QueryExecutionID string     `json:"query_execution_id,omitempty"`
+	QueryState       string     `json:"query_state,omitempty"`
+	Error            string     `json:"error,omitempty"`
+	Warning          string     `json:"warning,omitempty"`
+	Run              *runReport `json:"run,omitempty"`
+}
+
+var webhookFuncs = template.FuncMap{
+	// json quotes a value for a json payload, e.g. "text": {{ json .Error }}.
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"bytes": formatBytes,
+}
+
+func (h *hooks) hasWebhooks() bool {
+	for _, hs := range []hooksFlag{h.beforeBatch, h.afterBatch, h.beforeQuery, h.afterQuery, h.onWarning} {
+		for _, hk := range hs {
+			if hk.webhook != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *webhookPayload) load(ctx context.Context, awsCli *awsCli) error {
+	if p.path == "" {
+		return nil
+	}
+	data, err := awsCli.readFrom(ctx, p.path)
+	if err != nil {
+		return errors.Wrap(err, "could not read webhook payload template")
+	}
+	p.tmpl, err = template.New(p.path).Funcs(webhookFuncs).Option("missingkey=error").Parse(string(data))
+	return errors.Wrap(err, "could not parse webhook payload template")
+}
+
+// render renders the payload of a hook with the environment env.
+func (p *webhookPayload) render(env []string) ([]byte, error) {
+	vars := map[string]string{}
+	for _, e := range env {
+		pair := strings.SplitN(e, "=", 2)
+		vars[pair[0]] = pair[1]
+	}
+	d := &hookData{
+		Hook:             vars["ATHENAQ_HOOK"],
+		RunID:            vars["ATHENAQ_RUN_ID"],
+		QueryName:        vars["ATHENAQ_QUERY_NAME"],
+		QueryExecutionID: vars["ATHENAQ_QUERY_EXECUTION_ID"],
+		QueryState:       vars["ATHENAQ_QUERY_STATE"],
+		Error:            vars["ATHENAQ_ERROR"],
+		Warning:          vars["ATHENAQ_WARNING"],
+	}
+	d.QueryIndex, _ = strconv.Atoi(vars["ATHENAQ_QUERY_INDEX"])
+	if p != nil && p.run != nil {
+		run := *p.run
+		if d.Hook == "after-batch" {
+			var runErr error
+			if d.Error != "" {
+				runErr = errors.New(d.Error)
+			}
+			run.finish(runErr)
+		}
+		d.Run = &run
+	}
+	if p == nil || p.tmpl == nil {
+		return json.Marshal(d)
+	}
+	var b bytes.Buffer
+	if err := p.tmpl.Execute(&b, d); err != nil {
+		return nil, errors.Wrap(err, "could not render webhook payload")
+	}
+	return b.Bytes(), nil
+}
+
+// sendWebhook posts the payload of a hook to a webhook url.
+func (awsCli *awsCli) sendWebhook(url string, env []string) error {
+	// the run summary is updated by the watchers of queries still running.
+	awsCli.watchMu.Lock()
+	payload, err := awsCli.webhook.render(env)
+	awsCli.watchMu.Unlock()
+	if err != nil {
+		return err
+	}
+	t := &httpTarget{
+		method:  "POST",
+		header:  http.Header{"Content-Type": {"application/json"}},
+		retries: 3,
+		backoff: time.Second,
+		client:  &http.Client{Timeout: time.Minute},
+	}
+	return t.send(bytes.NewReader(payload), url)
+}
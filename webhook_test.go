@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+func TestWebhookHooks(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, r.Header.Get("Content-Type")+" "+string(body))
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "athenaq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tmpl := filepath.Join(dir, "slack.tmpl")
+	slack := `{"text": {{ json (printf "%s %s: %d queries, %s scanned" .Hook .Run.State (len .Run.Queries) (bytes (index .Run.Queries 0).DataScannedBytes)) }}` +
+		`{{ if .Error }}, "error": {{ json .Error }}{{ end }}}`
+	if err := ioutil.WriteFile(tmpl, []byte(slack), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var hs hooksFlag
+	if err := hs.Set(srv.URL + "/services/T000/B000/secret"); err != nil {
+		t.Fatal(err)
+	}
+	h := &hooks{afterBatch: hs, payload: webhookPayload{path: tmpl}}
+	if !h.hasWebhooks() {
+		t.Fatal("hasWebhooks() = false")
+	}
+	awsCli := &awsCli{}
+	if err := h.payload.load(context.Background(), awsCli); err != nil {
+		t.Fatal(err)
+	}
+	h.payload.run = newRunReport("run-1", nil, []string{"SELECT 1"})
+	h.payload.run.start(0, "SELECT 1")
+	h.payload.run.done(0, &athena.QueryExecution{
+		QueryExecutionId: aws.String("q1"),
+		Status:           &athena.QueryExecutionStatus{State: aws.String("SUCCEEDED")},
+		Statistics:       &athena.QueryExecutionStatistics{DataScannedInBytes: aws.Int64(2048)},
+	}, nil)
+	awsCli.webhook = &h.payload
+
+	if err := awsCli.runHooks(context.Background(), h.afterBatch, hookEnv("after-batch", "run-1", -1, "", nil, errors.New(`could not write "out"`))); err != nil {
+		t.Fatal(err)
+	}
+	awsCli.webhook.tmpl = nil
+	if err := awsCli.runHooks(context.Background(), h.afterBatch, hookEnv("after-query", "run-1", 0, "daily", nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`application/json {"text": "after-batch FAILED: 1 queries, 2.0 KiB scanned", "error": "could not write \"out\""}`,
+		`application/json {"hook":"after-query","run_id":"run-1","query_index":1,"query_name":"daily","run":`,
+	}
+	if len(bodies) != 2 || bodies[0] != want[0] || len(bodies[1]) < len(want[1]) || bodies[1][:len(want[1])] != want[1] {
+		t.Errorf("got payloads\n%q\nwant\n%q", bodies, want)
+	}
+	if got := secrets.redact("hook " + srv.URL + "/services/T000/B000/secret failed"); got != "hook [REDACTED] failed" {
+		t.Errorf("webhook url not redacted: %q", got)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/athena"
+	"github.com/pkg/errors"
+)
+
+// Workgroups and capacity reservations are newer than the vendored athena
+// client; the types below mirror the athena API and the operations are sent
+// through the regular athena client.
+
+type engineVersion struct {
+	SelectedEngineVersion  *string
+	EffectiveEngineVersion *string
+}
+
+type workGroup struct {
+	Name          *string
+	State         *string
+	Configuration *struct {
+		EngineVersion *engineVersion
+	}
+}
+
+type capacityAllocation struct {
+	Status                *string
+	StatusMessage         *string
+	RequestTime           *time.Time
+	RequestCompletionTime *time.Time
+}
+
+type capacityReservation struct {
+	Name                         *string
+	Status                       *string
+	TargetDpus                   *int64
+	AllocatedDpus                *int64
+	LastAllocation               *capacityAllocation
+	LastSuccessfulAllocationTime *time.Time
+	CreationTime                 *time.Time
+}
+
+func sendAthena(ctx context.Context, svc *athena.Athena, op string, input, output interface{}) error {
+	req := svc.NewRequest(&request.Operation{Name: op, HTTPMethod: "POST", HTTPPath: "/"}, input, output)
+	req.SetContext(ctx)
+	return req.Send()
+}
+
+func getWorkGroup(ctx context.Context, svc *athena.Athena, name string) (*workGroup, error) {
+	out := struct{ WorkGroup *workGroup }{}
+	err := sendAthena(ctx, svc, "GetWorkGroup", &struct{ WorkGroup *string }{&name}, &out)
+	return out.WorkGroup, err
+}
+
+func getCapacityReservation(ctx context.Context, svc *athena.Athena, name string) (*capacityReservation, error) {
+	out := struct{ CapacityReservation *capacityReservation }{}
+	err := sendAthena(ctx, svc, "GetCapacityReservation", &struct{ Name *string }{&name}, &out)
+	return out.CapacityReservation, err
+}
+
+func listCapacityReservations(ctx context.Context, svc *athena.Athena) ([]*capacityReservation, error) {
+	var reservations []*capacityReservation
+	var next *string
+	for {
+		out := struct {
+			CapacityReservations []*capacityReservation
+			NextToken            *string
+		}{}
+		err := sendAthena(ctx, svc, "ListCapacityReservations", &struct{ NextToken *string }{next}, &out)
+		if err != nil {
+			return nil, err
+		}
+		reservations = append(reservations, out.CapacityReservations...)
+		if out.NextToken == nil {
+			return reservations, nil
+		}
+		next = out.NextToken
+	}
+}
+
+// capacityWorkGroups returns the workgroups assigned to a capacity reservation.
+func capacityWorkGroups(ctx context.Context, svc *athena.Athena, reservation string) ([]string, error) {
+	out := struct {
+		CapacityAssignmentConfiguration *struct {
+			CapacityAssignments []*struct{ WorkGroupNames []*string }
+		}
+	}{}
+	err := sendAthena(ctx, svc, "GetCapacityAssignmentConfiguration", &struct{ CapacityReservationName *string }{&reservation}, &out)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	if c := out.CapacityAssignmentConfiguration; c != nil {
+		for _, a := range c.CapacityAssignments {
+			names = append(names, aws.StringValueSlice(a.WorkGroupNames)...)
+		}
+	}
+	return names, nil
+}
+
+// engineVersionName expands a bare engine version number like 3 to the name
+// athena uses for it.
+func engineVersionName(v string) string {
+	if _, err := strconv.Atoi(v); err == nil {
+		return "Athena engine version " + v
+	}
+	return v
+}
+
+// selectWorkGroup picks the workgroup assigned to the capacity reservation and
+// checks that the workgroup runs the requested engine version.
+func (awsCli *awsCli) selectWorkGroup(ctx context.Context, reservation, version string) error {
+	if reservation != "" {
+		names, err := capacityWorkGroups(ctx, awsCli.athena, reservation)
+		if err != nil {
+			return errors.Wrapf(err, "could not get capacity assignments of %q", reservation)
+		}
+		switch {
+		case awsCli.workGroup == "" && len(names) == 1:
+			awsCli.workGroup = names[0]
+		case awsCli.workGroup == "" && len(names) == 0:
+			return fmt.Errorf("no workgroup is assigned to capacity reservation %q", reservation)
+		case awsCli.workGroup == "":
+			return fmt.Errorf("capacity reservation %q is assigned to several workgroups (%s), select one with -workgroup", reservation, strings.Join(names, ", "))
+		default:
+			assigned := false
+			for _, name := range names {
+				assigned = assigned || name == awsCli.workGroup
+			}
+			if !assigned {
+				return fmt.Errorf("workgroup %q is not assigned to capacity reservation %q", awsCli.workGroup, reservation)
+			}
+		}
+		debugf("running queries in workgroup %s on capacity reservation %s", awsCli.workGroup, reservation)
+	}
+
+	if version != "" {
+		name := awsCli.workGroup
+		if name == "" {
+			name = "primary"
+		}
+		wg, err := getWorkGroup(ctx, awsCli.athena, name)
+		if err != nil {
+			return errors.Wrapf(err, "could not get workgroup %q", name)
+		}
+		// the workgroup has to be pinned to the version, a workgroup that
+		// upgrades automatically may run another one tomorrow.
+		var selected, effective string
+		if wg.Configuration != nil && wg.Configuration.EngineVersion != nil {
+			selected = aws.StringValue(wg.Configuration.EngineVersion.SelectedEngineVersion)
+			effective = aws.StringValue(wg.Configuration.EngineVersion.EffectiveEngineVersion)
+		}
+		want := engineVersionName(version)
+		if !strings.EqualFold(selected, want) {
+			return fmt.Errorf("workgroup %q is set to %q, not pinned to %q", name, selected, want)
+		}
+		if !strings.EqualFold(effective, want) {
+			return fmt.Errorf("workgroup %q runs %q, not %q", name, effective, want)
+		}
+	}
+	return nil
+}
+
+func capacityCmd(args []string) error {
+	fs := flag.NewFlagSet("capacity", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: athenaq capacity [flags] [reservation ...]")
+		fs.PrintDefaults()
+	}
+	awsRegion := fs.String("region", "", regionFlagUsage)
+	addLogFlags(fs)
+	fs.Parse(args)
+
+	sess, err := newSession(*awsRegion)
+	if err != nil {
+		return err
+	}
+	svc := athena.New(sess)
+	ctx := context.Background()
+
+	var reservations []*capacityReservation
+	if fs.NArg() == 0 {
+		var err error
+		reservations, err = listCapacityReservations(ctx, svc)
+		if err != nil {
+			return errors.Wrap(err, "could not list capacity reservations")
+		}
+	}
+	for _, name := range fs.Args() {
+		r, err := getCapacityReservation(ctx, svc, name)
+		if err != nil {
+			return errors.Wrapf(err, "could not get capacity reservation %q", name)
+		}
+		reservations = append(reservations, r)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tALLOCATED\tTARGET\tUTILIZATION\tLAST ALLOCATION\tWORKGROUPS")
+	for _, r := range reservations {
+		name := aws.StringValue(r.Name)
+		workGroups, err := capacityWorkGroups(ctx, svc, name)
+		if err != nil {
+			return errors.Wrapf(err, "could not get capacity assignments of %q", name)
+		}
+		allocated, target := aws.Int64Value(r.AllocatedDpus), aws.Int64Value(r.TargetDpus)
+		utilization := "-"
+		if target > 0 {
+			utilization = fmt.Sprintf("%.0f%%", float64(allocated)*100/float64(target))
+		}
+		last := "-"
+		if a := r.LastAllocation; a != nil {
+			last = aws.StringValue(a.Status)
+			if msg := aws.StringValue(a.StatusMessage); msg != "" {
+				last += " (" + msg + ")"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d DPU\t%d DPU\t%s\t%s\t%s\n", name, aws.StringValue(r.Status), allocated, target,
+			utilization, last, strings.Join(workGroups, ","))
+	}
+	return w.Flush()
+}
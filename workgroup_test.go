@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/athena"
+)
+
+func TestEngineVersionName(t *testing.T) {
+	for v, want := range map[string]string{
+		"3":                        "Athena engine version 3",
+		"Athena engine version 2":  "Athena engine version 2",
+		"PySpark engine version 3": "PySpark engine version 3",
+	} {
+		if got := engineVersionName(v); got != want {
+			t.Errorf("engineVersionName(%q) = %q, want %q", v, got, want)
+		}
+	}
+}
+
+func TestSelectWorkGroup(t *testing.T) {
+	assignments := map[string][]string{
+		"one":  {"etl"},
+		"none": nil,
+		"many": {"etl", "adhoc"},
+	}
+	versions := map[string][2]string{
+		"primary": {"AUTO", "Athena engine version 3"},
+		"etl":     {"Athena engine version 3", "Athena engine version 3"},
+		"adhoc":   {"Athena engine version 3", "Athena engine version 2"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in struct{ CapacityReservationName, WorkGroup string }
+		json.NewDecoder(r.Body).Decode(&in)
+		var out interface{}
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonAthena.GetCapacityAssignmentConfiguration":
+			out = map[string]interface{}{"CapacityAssignmentConfiguration": map[string]interface{}{
+				"CapacityAssignments": []interface{}{map[string]interface{}{"WorkGroupNames": assignments[in.CapacityReservationName]}},
+			}}
+		case "AmazonAthena.GetWorkGroup":
+			v := versions[in.WorkGroup]
+			out = map[string]interface{}{"WorkGroup": map[string]interface{}{"Name": in.WorkGroup, "Configuration": map[string]interface{}{
+				"EngineVersion": map[string]string{"SelectedEngineVersion": v[0], "EffectiveEngineVersion": v[1]},
+			}}}
+		default:
+			t.Errorf("unexpected call %s", r.Header.Get("X-Amz-Target"))
+		}
+		json.NewEncoder(w).Encode(out)
+	}))
+	defer srv.Close()
+	sess := session.Must(session.NewSession(&aws.Config{
+		Endpoint:    aws.String(srv.URL),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+
+	for _, tt := range []struct {
+		workGroup, reservation, version string
+		want, err                       string
+	}{
+		{"", "", "", "", ""},
+		{"", "one", "", "etl", ""},
+		{"etl", "many", "", "etl", ""},
+		{"", "none", "", "", "no workgroup is assigned"},
+		{"", "many", "", "", "several workgroups (etl, adhoc)"},
+		{"other", "one", "", "", `workgroup "other" is not assigned`},
+		{"", "one", "3", "etl", ""},
+		{"", "", "3", "", `workgroup "primary" is set to "AUTO"`},
+		{"adhoc", "", "3", "", `workgroup "adhoc" runs "Athena engine version 2"`},
+	} {
+		awsCli := &awsCli{athena: athena.New(sess), workGroup: tt.workGroup}
+		err := awsCli.selectWorkGroup(context.Background(), tt.reservation, tt.version)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("selectWorkGroup(%q, %q, %q) = %v, want error %q", tt.workGroup, tt.reservation, tt.version, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || awsCli.workGroup != tt.want {
+			t.Errorf("selectWorkGroup(%q, %q, %q) = %q, %v, want %q", tt.workGroup, tt.reservation, tt.version, awsCli.workGroup, err, tt.want)
+		}
+	}
+}